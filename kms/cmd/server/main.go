@@ -11,6 +11,8 @@ import (
 
 	"github.com/atprof/license-server/kms/internal/api"
 	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/notify"
+	"github.com/atprof/license-server/kms/internal/scheduler"
 	"github.com/atprof/license-server/kms/internal/storage"
 )
 
@@ -28,8 +30,45 @@ func main() {
 	}
 	defer store.Close()
 
+	// Start the background key rotation scheduler
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	schedulerDone := make(chan struct{})
+	go func() {
+		defer close(schedulerDone)
+		scheduler.New(store, cfg.MasterKey, cfg.SchedulerInterval, cfg.CascadeRevocation).Run(schedulerCtx)
+	}()
+
+	// Start the expiry notifier if a webhook or SMTP server is configured
+	notifierCtx, stopNotifier := context.WithCancel(context.Background())
+	notifierDone := make(chan struct{})
+	if channels := notify.Channels(cfg.Notifications); len(channels) > 0 {
+		notifier := notify.New(store, channels, cfg.Notifications.ThresholdDays, cfg.Notifications.Interval)
+		go func() {
+			defer close(notifierDone)
+			notifier.Run(notifierCtx)
+		}()
+	} else {
+		log.Println("No notification channels configured, expiry warnings are disabled")
+		close(notifierDone)
+	}
+
 	// Initialize API handler
-	handler := api.NewHandler(store, cfg.MasterKey)
+	handler := api.NewHandler(store, cfg)
+
+	// Record check values of keys stored before they were computed
+	if updated, err := handler.BackfillKeyCheckValues(); err != nil {
+		log.Printf("Failed to backfill key check values: %v", err)
+	} else if updated > 0 {
+		log.Printf("Recorded check values for %d keys", updated)
+	}
+
+	// Write buffered key usage counters in batches
+	usageCtx, stopUsage := context.WithCancel(context.Background())
+	usageDone := make(chan struct{})
+	go func() {
+		defer close(usageDone)
+		handler.UsageTracker().Run(usageCtx, cfg.UsageFlushInterval)
+	}()
 
 	// Setup router with CORS configuration
 	router := api.SetupRouter(handler, cfg.CORSAllowedOrigins, cfg.CORSAllowAll)
@@ -58,14 +97,25 @@ func main() {
 
 	log.Println("Shutting down server...")
 
+	// Stop the background jobs before the database is closed
+	stopScheduler()
+	stopNotifier()
+	<-schedulerDone
+	<-notifierDone
+
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// A failed shutdown still falls through to the final usage flush and database close
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// Flush the usage counted by the last requests
+	stopUsage()
+	<-usageDone
+
 	log.Println("Server exited")
 }
 
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// maxDerivationContextLength bounds the context a key is derived with
+const maxDerivationContextLength = 256
+
+// DeriveKeyRequest represents a request to derive a key from a parent symmetric key
+type DeriveKeyRequest struct {
+	Context          string            `json:"context" binding:"required"` // For example a site_id or plant_id
+	ExpiresInSeconds int64             `json:"expires_in_seconds"`         // Optional, default and maximum is the parent's expiry
+	Name             string            `json:"name,omitempty"`
+	Description      string            `json:"description,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"`
+}
+
+// DerivedKeyResponse represents a derived key record
+type DerivedKeyResponse struct {
+	KeyID         string    `json:"key_id"`
+	ParentKeyID   string    `json:"parent_key_id"`
+	ParentVersion int       `json:"parent_version"` // Parent material version the key is derived from
+	Context       string    `json:"context"`
+	KeyType       string    `json:"key_type"`
+	Algorithm     string    `json:"algorithm"`
+	KCV           string    `json:"kcv"` // Key check value of the derived material
+	ExpiresAt     time.Time `json:"expires_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	Status        string    `json:"status"`
+}
+
+// derivationParent returns the parent of a derived key and the material version it is derived from
+// Fails unless the parent is active and unexpired and the version is neither retired nor destroyed
+func (h *Handler) derivationParent(key *storage.Key) (*storage.Key, *storage.KeyVersion, error) {
+	parent, err := h.store.GetKey(key.DerivedFrom)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parent.IsExpired() {
+		return nil, nil, errors.ErrKeyExpired
+	}
+	if parent.IsRevoked() {
+		return nil, nil, errors.ErrKeyRevoked
+	}
+	if !parent.IsValid() {
+		return nil, nil, errors.ErrInvalidKeyState
+	}
+
+	material := parent.MaterialVersion(key.DerivedFromVersion)
+	if material == nil || material.IsRetired() || len(material.EncryptedPrivateKey) == 0 {
+		return nil, nil, errors.ErrKeyVersionNotFound
+	}
+	return parent, material, nil
+}
+
+// requireDerivationParent writes an error response and returns false unless a derived key's parent is usable
+// Keys that are not derived always pass
+func (h *Handler) requireDerivationParent(c *gin.Context, key *storage.Key) bool {
+	if !key.IsDerived() {
+		return true
+	}
+
+	_, _, err := h.derivationParent(key)
+	switch err {
+	case nil:
+		return true
+	case errors.ErrKeyNotFound:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "parent key not found"})
+	case errors.ErrKeyExpired:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "parent key is expired"})
+	case errors.ErrKeyRevoked:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "parent key is revoked"})
+	case errors.ErrInvalidKeyState:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "parent key is not active"})
+	case errors.ErrKeyVersionNotFound:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "parent key version is retired or destroyed"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve parent key"})
+	}
+	return false
+}
+
+// requireStoredMaterial writes an error response and returns false for derived keys,
+// which have no material of their own to rotate or download
+func requireStoredMaterial(c *gin.Context, key *storage.Key) bool {
+	if key.IsDerived() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "derived keys have no key material of their own"})
+		return false
+	}
+	return true
+}
+
+// keySecret returns the secret material of a key version
+// Derived keys are recomputed from their parent with HKDF-SHA256
+// The caller must zero the returned bytes after use
+func (h *Handler) keySecret(key *storage.Key, material *storage.KeyVersion) ([]byte, error) {
+	if !key.IsDerived() {
+		return h.decryptKeyMaterial(material)
+	}
+
+	_, parentMaterial, err := h.derivationParent(key)
+	if err != nil {
+		return nil, err
+	}
+	parentSecret, err := h.decryptKeyMaterial(parentMaterial)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for i := range parentSecret {
+			parentSecret[i] = 0
+		}
+	}()
+
+	return crypto.DeriveKey(parentSecret, key.DerivationContext)
+}
+
+// DeriveKey handles POST /keys/:id/derive - Register a key derived from a symmetric parent key
+// Only the parent, its material version and the context are stored; the material is recomputed on use
+func (h *Handler) DeriveKey(c *gin.Context) {
+	var req DeriveKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Context) > maxDerivationContextLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "context must be at most 256 characters"})
+		return
+	}
+	if req.ExpiresInSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_seconds must not be negative"})
+		return
+	}
+	if err := validateKeyLabels(req.Name, req.Description, req.Tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	parent, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if parent.KeyType != storage.KeyTypeSymmetric || parent.IsDerived() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keys can only be derived from symmetric keys that are not derived themselves"})
+		return
+	}
+	if !requireUsableKey(c, parent) {
+		return
+	}
+
+	now := time.Now().UTC()
+	expiresAt := parent.ExpiresAt
+	if req.ExpiresInSeconds > 0 {
+		expiresAt = now.Add(time.Duration(req.ExpiresInSeconds) * time.Second)
+		if expiresAt.After(parent.ExpiresAt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "derived key cannot expire after its parent"})
+			return
+		}
+	}
+
+	key := &storage.Key{
+		ID:                 uuid.New().String(),
+		KeyType:            storage.KeyTypeSymmetric,
+		Algorithm:          parent.KeyAlgorithm(),
+		ExpiresAt:          expiresAt,
+		CreatedAt:          now,
+		Status:             storage.KeyStatusActive,
+		Version:            1,
+		UsagePolicy:        parent.UsagePolicy,
+		Name:               req.Name,
+		Description:        req.Description,
+		Tags:               req.Tags,
+		DerivedFrom:        parent.ID,
+		DerivedFromVersion: parent.PrimaryVersionNumber(),
+		DerivationContext:  req.Context,
+	}
+
+	// The check value is the only trace of the derived material that is stored
+	secret, err := h.keySecret(key, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to derive key"})
+		return
+	}
+	key.CheckValue, err = crypto.KeyCheckValue(key.Algorithm, secret)
+	for i := range secret {
+		secret[i] = 0
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute key check value"})
+		return
+	}
+
+	if err := h.store.StoreDerivedKey(key); err != nil {
+		switch err {
+		case errors.ErrDerivedKeyExists:
+			resp := gin.H{"error": "a key was already derived from this parent with the same context"}
+			if existing, err := h.store.FindDerivedKey(parent.ID, req.Context); err == nil {
+				resp["key_id"] = existing.ID
+			}
+			c.JSON(http.StatusConflict, resp)
+		case errors.ErrKeyNotFound, errors.ErrInvalidKeyState:
+			c.JSON(http.StatusConflict, gin.H{"error": "parent key changed state during derivation"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store derived key"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, DerivedKeyResponse{
+		KeyID:         key.ID,
+		ParentKeyID:   key.DerivedFrom,
+		ParentVersion: key.DerivedFromVersion,
+		Context:       key.DerivationContext,
+		KeyType:       string(key.KeyType),
+		Algorithm:     key.Algorithm,
+		KCV:           key.CheckValue,
+		ExpiresAt:     key.ExpiresAt,
+		CreatedAt:     key.CreatedAt,
+		Status:        string(key.Status),
+	})
+}
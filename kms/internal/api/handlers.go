@@ -2,49 +2,75 @@ package api
 
 import (
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"github.com/atprof/license-server/kms/internal/config"
 	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/keys"
 	"github.com/atprof/license-server/kms/internal/licenses"
 	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/internal/usage"
 	"github.com/atprof/license-server/kms/pkg/errors"
+	"github.com/atprof/license-server/kms/pkg/signedresponse"
 )
 
 // Handler holds dependencies for API handlers
 type Handler struct {
 	store     *storage.BoltStore
 	masterKey []byte
+	cfg       *config.Config
+	usage     *usage.Tracker
 }
 
 // NewHandler creates a new API handler instance
-func NewHandler(store *storage.BoltStore, masterKey []byte) *Handler {
+func NewHandler(store *storage.BoltStore, cfg *config.Config) *Handler {
 	return &Handler{
 		store:     store,
-		masterKey: masterKey,
+		masterKey: cfg.MasterKey,
+		cfg:       cfg,
+		usage:     usage.NewTracker(store),
 	}
 }
 
 // RegisterKeyRequest represents a request to register a key
 type RegisterKeyRequest struct {
-	KeyType          string `json:"key_type" binding:"required,oneof=symmetric asymmetric"`
+	KeyType          string `json:"key_type" binding:"omitempty,oneof=symmetric asymmetric"` // Required unless algorithm is set
+	Algorithm        string `json:"algorithm,omitempty"` // Optional, default aes-256-gcm (symmetric) or ed25519 (asymmetric)
 	ExpiresInSeconds int64  `json:"expires_in_seconds"` // Optional, default 1 year
-	KeyMaterial      string `json:"key_material,omitempty"` // Optional base64 encoded key for external keys
+	KeyMaterial      string `json:"key_material,omitempty"` // Optional base64 encoded key for external keys, only with AllowPlaintextImport
+	RotationPolicy   *storage.RotationPolicy `json:"rotation_policy,omitempty"` // Optional automatic rotation
+	ActivateAt       *time.Time `json:"activate_at,omitempty"` // Optional, the key stays pending activation until then
+	UsagePolicy      *storage.UsagePolicy `json:"usage_policy,omitempty"` // Optional, default allows every operation
+	Level            string `json:"level,omitempty"`         // Optional hub, enterprise or site
+	ParentKeyID      string `json:"parent_key_id,omitempty"` // Required for enterprise and site keys
+	Owner            string `json:"owner,omitempty"`         // Optional organisation, enterprise or site ID
+	SiteMode         string `json:"site_mode,omitempty"`     // Required for site keys: dev or prod
+	Name             string `json:"name,omitempty"`          // Optional display name
+	Description      string `json:"description,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"` // Optional labels such as customer, site_id or env
 }
 
 // RegisterKeyResponse represents a response from registering a key
 type RegisterKeyResponse struct {
 	KeyID     string    `json:"key_id"`
 	KeyType   string    `json:"key_type"`
+	Algorithm string    `json:"algorithm"`
 	PublicKey string    `json:"public_key,omitempty"` // Base64 encoded, only for asymmetric
 	ExpiresAt time.Time `json:"expires_at"`
 	CreatedAt time.Time `json:"created_at"`
+	Status    string    `json:"status"`
+	NextRotationAt *time.Time `json:"next_rotation_at,omitempty"`
+	Level       string `json:"level,omitempty"`
+	ParentKeyID string `json:"parent_key_id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
 }
 
 // RegisterKey handles POST /keys - Register or generate a key
@@ -55,118 +81,146 @@ func (h *Handler) RegisterKey(c *gin.Context) {
 		return
 	}
 
-	var key *storage.Key
-	var err error
-	var publicKeyBase64 string
-
-	now := time.Now().UTC()
-	expiresIn := req.ExpiresInSeconds
-	if expiresIn == 0 {
-		expiresIn = 365 * 24 * 60 * 60 // Default: 1 year
+	algorithm, keyType, ok := validateRegisterKeyRequest(c, &req)
+	if !ok || !h.validateKeyHierarchy(c, &req) {
+		return
 	}
-	expiresAt := now.Add(time.Duration(expiresIn) * time.Second)
-
-	keyID := uuid.New().String()
-	version := 1
 
-	if req.KeyType == "symmetric" {
-		var keyMaterial []byte
-		if req.KeyMaterial != "" {
-			keyMaterial, err = base64.StdEncoding.DecodeString(req.KeyMaterial)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key_material: must be base64 encoded"})
-				return
-			}
-			if len(keyMaterial) != crypto.SymmetricKeySize {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "symmetric key must be 32 bytes (256 bits)"})
-				return
-			}
-		} else {
-			keyMaterial, err = crypto.GenerateSymmetricKey()
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate key"})
-				return
-			}
+	var keyMaterial []byte
+	if req.KeyMaterial != "" {
+		if !h.cfg.AllowPlaintextImport {
+			c.JSON(http.StatusForbidden, gin.H{"error": "plaintext import is disabled, use POST /keys/import-token and POST /keys/import"})
+			return
 		}
 
-		// Encrypt the key material
-		encryptedKey, err := crypto.EncryptKey(h.masterKey, keyMaterial)
+		var err error
+		keyMaterial, err = base64.StdEncoding.DecodeString(req.KeyMaterial)
 		if err != nil {
-			// Zero out key material before returning error
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key_material: must be base64 encoded"})
+			return
+		}
+		defer func() {
 			for i := range keyMaterial {
 				keyMaterial[i] = 0
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt key"})
-			return
-		}
+		}()
+	}
 
-		// Zero out plaintext key material
-		for i := range keyMaterial {
-			keyMaterial[i] = 0
-		}
+	h.createKey(c, &req, algorithm, keyType, keyMaterial)
+}
 
-		key = &storage.Key{
-			ID:                 keyID,
-			KeyType:            storage.KeyTypeSymmetric,
-			PublicKey:          nil,
-			EncryptedPrivateKey: encryptedKey,
-			ExpiresAt:          expiresAt,
-			CreatedAt:          now,
-			Status:             storage.KeyStatusActive,
-			Version:            version,
+// validateRegisterKeyRequest checks the policies and algorithm of a new key
+// Writes the error response and returns false on failure
+func validateRegisterKeyRequest(c *gin.Context, req *RegisterKeyRequest) (string, storage.KeyType, bool) {
+	if req.RotationPolicy != nil {
+		if err := validateRotationPolicy(req.RotationPolicy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return "", "", false
 		}
-
-	} else if req.KeyType == "asymmetric" {
-		var publicKey, privateKey []byte
-		if req.KeyMaterial != "" {
-			// For asymmetric, key_material would be the private key
-			privateKey, err = base64.StdEncoding.DecodeString(req.KeyMaterial)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key_material: must be base64 encoded"})
-				return
-			}
-			// Extract public key from private key (Ed25519)
-			if len(privateKey) != crypto.Ed25519PrivateKeySize {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "asymmetric private key must be 64 bytes (Ed25519)"})
-				return
-			}
-			publicKey = privateKey[32:] // Last 32 bytes are the public key for Ed25519
-		} else {
-			publicKey, privateKey, err = crypto.GenerateAsymmetricKeyPair()
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate key pair"})
-				return
-			}
+	}
+	if req.UsagePolicy != nil {
+		if err := validateUsagePolicy(req.UsagePolicy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return "", "", false
 		}
+	}
+	if err := validateKeyLabels(req.Name, req.Description, req.Tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", "", false
+	}
 
-		// Encrypt the private key
-		encryptedPrivateKey, err := crypto.EncryptKey(h.masterKey, privateKey)
+	algorithm, keyType, err := resolveAlgorithm(req.KeyType, req.Algorithm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", "", false
+	}
+
+	return algorithm, keyType, true
+}
+
+// createKey stores a new key described by a validated request and writes the response
+// The key imports keyMaterial when it is not nil and is generated otherwise
+func (h *Handler) createKey(c *gin.Context, req *RegisterKeyRequest, algorithm string, keyType storage.KeyType, keyMaterial []byte) {
+	now := time.Now().UTC()
+	expiresIn := req.ExpiresInSeconds
+	if expiresIn == 0 {
+		expiresIn = 365 * 24 * 60 * 60 // Default: 1 year
+	}
+	expiresAt := now.Add(time.Duration(expiresIn) * time.Second)
+
+	var publicKey, privateKey []byte
+	var err error
+	if keyMaterial != nil {
+		publicKey, privateKey, err = crypto.ImportKeyMaterial(algorithm, keyMaterial)
 		if err != nil {
-			// Zero out keys before returning error
-			for i := range privateKey {
-				privateKey[i] = 0
-			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt private key"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+	} else {
+		publicKey, privateKey, err = crypto.GenerateKeyMaterial(algorithm)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate key"})
+			return
+		}
+	}
 
-		publicKeyBase64 = base64.StdEncoding.EncodeToString(publicKey)
-
-		// Zero out plaintext private key
+	// The check value identifies symmetric material without revealing it
+	checkValue, err := keys.CheckValue(algorithm, privateKey)
+	if err != nil {
 		for i := range privateKey {
 			privateKey[i] = 0
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute key check value"})
+		return
+	}
 
-		key = &storage.Key{
-			ID:                 keyID,
-			KeyType:            storage.KeyTypeAsymmetric,
-			PublicKey:          publicKey,
-			EncryptedPrivateKey: encryptedPrivateKey,
-			ExpiresAt:          expiresAt,
-			CreatedAt:          now,
-			Status:             storage.KeyStatusActive,
-			Version:            version,
-		}
+	// Encrypt the key material
+	encryptedPrivateKey, err := crypto.EncryptKey(h.masterKey, privateKey)
+
+	// Zero out plaintext key material
+	for i := range privateKey {
+		privateKey[i] = 0
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt key"})
+		return
+	}
+
+	key := &storage.Key{
+		ID:                 uuid.New().String(),
+		KeyType:            keyType,
+		Algorithm:          algorithm,
+		PublicKey:          publicKey,
+		EncryptedPrivateKey: encryptedPrivateKey,
+		CheckValue:         checkValue,
+		ExpiresAt:          expiresAt,
+		CreatedAt:          now,
+		Status:             storage.KeyStatusActive,
+		Version:            1,
+	}
+
+	// Keys with a future activation time stay pending until the scheduler activates them
+	if req.ActivateAt != nil && req.ActivateAt.After(now) {
+		activateAt := req.ActivateAt.UTC()
+		key.Status = storage.KeyStatusPendingActivation
+		key.ActivateAt = &activateAt
+	}
+
+	key.UsagePolicy = req.UsagePolicy
+	key.Level = storage.KeyLevel(req.Level)
+	key.ParentKeyID = req.ParentKeyID
+	key.Owner = req.Owner
+	key.SiteMode = storage.SiteMode(req.SiteMode)
+	key.Name = req.Name
+	key.Description = req.Description
+	key.Tags = req.Tags
+
+	// Schedule the first automatic rotation
+	if req.RotationPolicy != nil {
+		nextRotation := req.RotationPolicy.NextRotation(key, now)
+		key.RotationPolicy = req.RotationPolicy
+		key.NextRotationAt = &nextRotation
 	}
 
 	// Store the key
@@ -178,23 +232,53 @@ func (h *Handler) RegisterKey(c *gin.Context) {
 	resp := RegisterKeyResponse{
 		KeyID:     key.ID,
 		KeyType:   string(key.KeyType),
+		Algorithm: key.Algorithm,
 		ExpiresAt: key.ExpiresAt,
 		CreatedAt: key.CreatedAt,
+		Status:    string(key.Status),
+		NextRotationAt: key.NextRotationAt,
+		Level:       string(key.Level),
+		ParentKeyID: key.ParentKeyID,
+		Name:        key.Name,
+		Tags:        key.Tags,
 	}
 
 	if key.KeyType == storage.KeyTypeAsymmetric {
-		resp.PublicKey = publicKeyBase64
+		resp.PublicKey = base64.StdEncoding.EncodeToString(key.PublicKey)
 	}
 
 	c.JSON(http.StatusOK, resp)
 }
 
+// resolveAlgorithm determines the algorithm and key type of a new key
+// Either may be omitted, but when both are given they must agree
+func resolveAlgorithm(keyType, algorithm string) (string, storage.KeyType, error) {
+	if algorithm == "" {
+		if keyType == "" {
+			return "", "", fmt.Errorf("key_type or algorithm is required")
+		}
+		return storage.KeyType(keyType).DefaultAlgorithm(), storage.KeyType(keyType), nil
+	}
+
+	if !crypto.IsSupportedAlgorithm(algorithm) {
+		return "", "", fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	algorithmType := storage.AlgorithmKeyType(algorithm)
+	if keyType != "" && storage.KeyType(keyType) != algorithmType {
+		return "", "", fmt.Errorf("algorithm %s requires key_type %s", algorithm, algorithmType)
+	}
+	return algorithm, algorithmType, nil
+}
+
 // ValidateKeyRequest represents a request to validate a key
 type ValidateKeyRequest struct {
 	KeyID      string `json:"key_id" binding:"required"`
 	KeyMaterial string `json:"key_material,omitempty"`       // For symmetric keys
 	Message     string `json:"message,omitempty"`             // For asymmetric signature validation
 	Signature   string `json:"signature,omitempty"`           // Base64 encoded signature
+	Digest      string `json:"digest,omitempty"`              // Base64 SHA-512 digest, for Ed25519ph signatures
+	Nonce       string `json:"nonce,omitempty"`               // Echoed in the signed response
+	KeyVersion  int    `json:"key_version,omitempty"`         // Material version to validate against, default primary
 }
 
 // ValidateKeyResponse represents a response from validating a key
@@ -202,6 +286,9 @@ type ValidateKeyResponse struct {
 	Valid   bool `json:"valid"`
 	Expired bool `json:"expired"`
 	Revoked bool `json:"revoked"`
+	Status  string `json:"status"`
+
+	ResponseSignature *signedresponse.Envelope `json:"response_signature,omitempty"`
 }
 
 // ValidateKey handles POST /keys/validate - Validate a key or signature
@@ -223,25 +310,54 @@ func (h *Handler) ValidateKey(c *gin.Context) {
 		return
 	}
 
+	if !requireOperation(c, key, storage.OperationVerify) {
+		return
+	}
+
 	resp := ValidateKeyResponse{
 		Expired: key.IsExpired(),
 		Revoked: key.IsRevoked(),
+		Status:  string(key.Status),
 		Valid:   false,
 	}
 
-	// Check if key is expired or revoked
-	if resp.Expired || resp.Revoked {
-		c.JSON(http.StatusOK, resp)
+	// Check if key is expired, revoked or otherwise not active
+	if resp.Expired || resp.Revoked || key.Status != storage.KeyStatusActive {
+		h.writeValidationResponse(c, req.Nonce, &resp)
 		return
 	}
 
-	// Validate based on key type
-	if key.KeyType == storage.KeyTypeSymmetric {
-		if req.KeyMaterial == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "key_material is required for symmetric keys"})
+	version := req.KeyVersion
+	if version == 0 {
+		version = key.PrimaryVersionNumber()
+	}
+	material := key.MaterialVersion(version)
+	if material == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key version not found"})
+		return
+	}
+
+	// Retired versions never validate
+	if material.IsRetired() {
+		h.writeValidationResponse(c, req.Nonce, &resp)
+		return
+	}
+
+	// Derived keys stop validating once their parent is unusable
+	if key.IsDerived() {
+		switch _, _, err := h.derivationParent(key); err {
+		case nil:
+		case errors.ErrKeyNotFound, errors.ErrKeyExpired, errors.ErrKeyRevoked, errors.ErrInvalidKeyState, errors.ErrKeyVersionNotFound:
+			h.writeValidationResponse(c, req.Nonce, &resp)
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve parent key"})
 			return
 		}
+	}
 
+	// Validate based on key type
+	if key.KeyType == storage.KeyTypeSymmetric && req.KeyMaterial != "" {
 		providedKey, err := base64.StdEncoding.DecodeString(req.KeyMaterial)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key_material: must be base64 encoded"})
@@ -253,17 +369,36 @@ func (h *Handler) ValidateKey(c *gin.Context) {
 			}
 		}()
 
-		valid, err := crypto.ValidateSymmetricKey(h.masterKey, key.EncryptedPrivateKey, providedKey)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to validate key"})
-			return
+		var valid bool
+		if key.IsDerived() {
+			// Derived material is recomputed rather than stored
+			secret, err := h.keySecret(key, material)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to validate key"})
+				return
+			}
+			valid = crypto.CompareKeys(secret, providedKey)
+			for i := range secret {
+				secret[i] = 0
+			}
+		} else {
+			valid, err = crypto.ValidateSymmetricKey(h.masterKey, material.EncryptedPrivateKey, providedKey)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to validate key"})
+				return
+			}
 		}
 
 		resp.Valid = valid
 
-	} else if key.KeyType == storage.KeyTypeAsymmetric {
-		if req.Message == "" || req.Signature == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "message and signature are required for asymmetric keys"})
+	} else if key.KeyType == storage.KeyTypeSymmetric && key.KeyAlgorithm() != crypto.AlgorithmHMACSHA256 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key_material is required for symmetric keys"})
+		return
+
+	} else {
+		// Asymmetric signatures and HMAC tags
+		if (req.Message == "" && req.Digest == "") || req.Signature == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message (or digest) and signature are required for signing keys"})
 			return
 		}
 
@@ -273,7 +408,33 @@ func (h *Handler) ValidateKey(c *gin.Context) {
 			return
 		}
 
-		valid, err := crypto.ValidateSignature(key.PublicKey, []byte(req.Message), signature)
+		algorithm := key.KeyAlgorithm()
+		var valid bool
+		if req.Digest != "" {
+			digest, err := base64.StdEncoding.DecodeString(req.Digest)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid digest: must be base64 encoded"})
+				return
+			}
+			if len(digest) != crypto.DigestSize(algorithm) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("digest must be %d bytes for %s keys", crypto.DigestSize(algorithm), algorithm)})
+				return
+			}
+			valid, err = crypto.VerifyPrehashed(algorithm, material.PublicKey, digest, signature)
+		} else if key.KeyType == storage.KeyTypeSymmetric {
+			// HMAC tags are checked with the secret, which never leaves the service
+			secret, decryptErr := h.keySecret(key, material)
+			if decryptErr != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decrypt key material"})
+				return
+			}
+			valid, err = crypto.Verify(algorithm, secret, []byte(req.Message), signature)
+			for i := range secret {
+				secret[i] = 0
+			}
+		} else {
+			valid, err = crypto.Verify(algorithm, material.PublicKey, []byte(req.Message), signature)
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to validate signature"})
 			return
@@ -282,21 +443,29 @@ func (h *Handler) ValidateKey(c *gin.Context) {
 		resp.Valid = valid
 	}
 
-	c.JSON(http.StatusOK, resp)
+	h.recordUsage(key.ID, storage.OperationVerify)
+	h.writeValidationResponse(c, req.Nonce, &resp)
 }
 
 // RefreshKeyRequest represents a request to refresh a key's expiry
+// The proof answers a challenge from POST /keys/:id/refresh/challenge
 type RefreshKeyRequest struct {
-	ExpiresInSeconds int64 `json:"expires_in_seconds" binding:"required"`
+	ExpiresInSeconds int64  `json:"expires_in_seconds" binding:"required,min=1"`
+	ChallengeID      string `json:"challenge_id" binding:"required"`
+	Proof            string `json:"proof" binding:"required"` // Base64 encoded signature or HMAC-SHA256 of RefreshProofMessage
+	RollKeyMaterial  bool   `json:"roll_key_material"`        // Also rotate to new key material
 }
 
 // RefreshKeyResponse represents a response from refreshing a key
 type RefreshKeyResponse struct {
 	KeyID       string    `json:"key_id"`
 	NewExpiresAt time.Time `json:"new_expires_at"`
+	PrimaryVersion int    `json:"primary_version"`
+	Rolled      bool      `json:"rolled"`
 }
 
 // RefreshKey handles POST /keys/:id/refresh - Refresh key expiry
+// Requires proof of possession of the key's current material
 func (h *Handler) RefreshKey(c *gin.Context) {
 	keyID := c.Param("id")
 	if keyID == "" {
@@ -310,6 +479,12 @@ func (h *Handler) RefreshKey(c *gin.Context) {
 		return
 	}
 
+	proof, err := base64.StdEncoding.DecodeString(req.Proof)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid proof encoding"})
+		return
+	}
+
 	// Get the key
 	key, err := h.store.GetKey(keyID)
 	if err != nil {
@@ -321,17 +496,60 @@ func (h *Handler) RefreshKey(c *gin.Context) {
 		return
 	}
 
-	// Check if key is revoked
-	if key.IsRevoked() {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot refresh revoked key"})
+	if !h.requireRefreshableKey(c, key) || !h.requireDerivationParent(c, key) {
+		return
+	}
+	if req.RollKeyMaterial && !requireStoredMaterial(c, key) {
+		return
+	}
+
+	// The challenge is consumed before the proof is checked, so a wrong answer cannot be retried
+	challenge, err := h.store.ConsumeRefreshChallenge(req.ChallengeID, time.Now().UTC())
+	if err != nil {
+		switch err {
+		case errors.ErrRefreshChallengeNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "refresh challenge not found"})
+		case errors.ErrRefreshChallengeExpired:
+			c.JSON(http.StatusGone, gin.H{"error": "refresh challenge has expired"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve refresh challenge"})
+		}
+		return
+	}
+	if challenge.KeyID != key.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh challenge was issued for another key"})
+		return
+	}
+
+	valid, err := h.verifyKeyPossession(key, challenge.Challenge, proof)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify proof"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "proof of possession failed"})
 		return
 	}
 
+	var publicKey, encryptedPrivateKey []byte
+	var checkValue string
+	if req.RollKeyMaterial {
+		publicKey, encryptedPrivateKey, checkValue, err = keys.NewMaterial(h.masterKey, key.KeyAlgorithm())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate key material"})
+			return
+		}
+	}
+
 	// Calculate new expiry
 	newExpiresAt := time.Now().UTC().Add(time.Duration(req.ExpiresInSeconds) * time.Second)
 
-	// Update expiry
-	if err := h.store.UpdateKeyExpiry(keyID, newExpiresAt); err != nil {
+	refreshed, err := h.store.RefreshKey(keyID, newExpiresAt, publicKey, encryptedPrivateKey, checkValue)
+	if err != nil {
+		if err == errors.ErrKeyRevoked || err == errors.ErrInvalidKeyState {
+			c.JSON(http.StatusConflict, gin.H{"error": "key changed state during refresh"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update expiry"})
 		return
 	}
@@ -339,6 +557,8 @@ func (h *Handler) RefreshKey(c *gin.Context) {
 	c.JSON(http.StatusOK, RefreshKeyResponse{
 		KeyID:       keyID,
 		NewExpiresAt: newExpiresAt,
+		PrimaryVersion: refreshed.PrimaryVersionNumber(),
+		Rolled:      req.RollKeyMaterial,
 	})
 }
 
@@ -346,6 +566,7 @@ func (h *Handler) RefreshKey(c *gin.Context) {
 type RemoveKeyResponse struct {
 	Success bool   `json:"success"`
 	KeyID   string `json:"key_id"`
+	RevokedChildren []string `json:"revoked_children,omitempty"` // Descendants revoked by a cascading revocation
 }
 
 // RemoveKey handles DELETE /keys/:id - Revoke a key
@@ -357,7 +578,7 @@ func (h *Handler) RemoveKey(c *gin.Context) {
 	}
 
 	// Check if key exists
-	_, err := h.store.GetKey(keyID)
+	key, err := h.store.GetKey(keyID)
 	if err != nil {
 		if err == errors.ErrKeyNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
@@ -367,8 +588,17 @@ func (h *Handler) RemoveKey(c *gin.Context) {
 		return
 	}
 
-	// Revoke the key
-	if err := h.store.RevokeKey(keyID); err != nil {
+	// Revoke the key, and its child keys if the revocation policy cascades
+	revokedChildren, err := h.store.RevokeKeyTree(keyID, h.cfg.CascadeRevocation)
+	if err != nil {
+		if err == errors.ErrInvalidKeyState {
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot revoke key that is " + describeKeyStatus(key.Status)})
+			return
+		}
+		if err == errors.ErrKeyHasLiveChildren {
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot revoke key with live child keys, revoke them first"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke key"})
 		return
 	}
@@ -376,18 +606,21 @@ func (h *Handler) RemoveKey(c *gin.Context) {
 	c.JSON(http.StatusOK, RemoveKeyResponse{
 		Success: true,
 		KeyID:   keyID,
+		RevokedChildren: revokedChildren,
 	})
 }
 
-// ListKeysResponse represents a response from listing keys
+// ListKeysResponse represents a page of keys
 type ListKeysResponse struct {
-	Keys []KeyInfo `json:"keys"`
+	Keys       []KeyInfo `json:"keys"`
+	NextCursor string    `json:"next_cursor,omitempty"` // Pass as cursor to get the next page, empty on the last page
 }
 
 // KeyInfo represents key information without private key material
 type KeyInfo struct {
 	KeyID     string    `json:"key_id"`
 	KeyType   string    `json:"key_type"`
+	Algorithm string    `json:"algorithm"`
 	PublicKey string    `json:"public_key,omitempty"` // Base64 encoded, only for asymmetric keys
 	ExpiresAt time.Time `json:"expires_at"`
 	CreatedAt time.Time `json:"created_at"`
@@ -395,41 +628,121 @@ type KeyInfo struct {
 	Version   int       `json:"version"`
 	Expired   bool      `json:"expired"`
 	Revoked   bool      `json:"revoked"`
+
+	KCV         string `json:"kcv,omitempty"`         // Key check value of the primary version, only for symmetric keys
+	Fingerprint string `json:"fingerprint,omitempty"` // SHA-256 fingerprint of the primary public key, only for asymmetric keys
+
+	PrimaryVersion int              `json:"primary_version"`
+	Versions       []KeyVersionInfo `json:"versions"`
+
+	RotationPolicy *storage.RotationPolicy `json:"rotation_policy,omitempty"`
+	NextRotationAt *time.Time              `json:"next_rotation_at,omitempty"`
+	ActivateAt     *time.Time              `json:"activate_at,omitempty"`
+	DeletionDate   *time.Time              `json:"deletion_date,omitempty"`
+	DestroyedAt    *time.Time              `json:"destroyed_at,omitempty"`
+	UsagePolicy    *storage.UsagePolicy    `json:"usage_policy,omitempty"`
+
+	Level       string `json:"level,omitempty"`
+	ParentKeyID string `json:"parent_key_id,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	SiteMode    string `json:"site_mode,omitempty"`
+
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+
+	DerivedFrom        string `json:"derived_from,omitempty"`
+	DerivedFromVersion int    `json:"derived_from_version,omitempty"`
+	DerivationContext  string `json:"derivation_context,omitempty"`
+
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Idle       bool       `json:"idle"` // Active but unused for the idle period
+}
+
+// newKeyInfo converts a stored key to its API form
+func newKeyInfo(key *storage.Key) KeyInfo {
+	keyInfo := KeyInfo{
+		KeyID:     key.ID,
+		KeyType:   string(key.KeyType),
+		Algorithm: key.KeyAlgorithm(),
+		ExpiresAt: key.ExpiresAt,
+		CreatedAt: key.CreatedAt,
+		Status:    string(key.Status),
+		Version:   key.Version,
+		Expired:   key.IsExpired(),
+		Revoked:   key.IsRevoked(),
+
+		PrimaryVersion: key.PrimaryVersionNumber(),
+		Versions:       newKeyVersionInfos(key),
+
+		RotationPolicy: key.RotationPolicy,
+		NextRotationAt: key.NextRotationAt,
+		ActivateAt:     key.ActivateAt,
+		DeletionDate:   key.DeletionDate,
+		DestroyedAt:    key.DestroyedAt,
+		UsagePolicy:    key.UsagePolicy,
+
+		Level:       string(key.Level),
+		ParentKeyID: key.ParentKeyID,
+		Owner:       key.Owner,
+		SiteMode:    string(key.SiteMode),
+
+		Name:        key.Name,
+		Description: key.Description,
+		Tags:        key.Tags,
+
+		DerivedFrom:        key.DerivedFrom,
+		DerivedFromVersion: key.DerivedFromVersion,
+		DerivationContext:  key.DerivationContext,
+	}
+
+	// Include public key for asymmetric keys
+	if key.KeyType == storage.KeyTypeAsymmetric && key.PublicKey != nil {
+		keyInfo.PublicKey = base64.StdEncoding.EncodeToString(key.PublicKey)
+	}
+
+	if primary := key.PrimaryMaterial(); primary != nil {
+		keyInfo.KCV = primary.CheckValue
+		keyInfo.Fingerprint = versionFingerprint(key, primary)
+	}
+
+	return keyInfo
 }
 
-// ListKeys handles GET /keys - List all keys
+// ListKeys handles GET /keys - List keys, filtered, sorted and a page at a time
 func (h *Handler) ListKeys(c *gin.Context) {
-	// Get all keys from storage (without private key material)
-	keys, err := h.store.ListKeys()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list keys"})
+	query, ok := parseKeyQuery(c)
+	if !ok {
 		return
 	}
 
-	// Convert storage.Key to KeyInfo
-	keyInfos := make([]KeyInfo, 0, len(keys))
-	for _, key := range keys {
-		keyInfo := KeyInfo{
-			KeyID:     key.ID,
-			KeyType:   string(key.KeyType),
-			ExpiresAt: key.ExpiresAt,
-			CreatedAt: key.CreatedAt,
-			Status:    string(key.Status),
-			Version:   key.Version,
-			Expired:   key.IsExpired(),
-			Revoked:   key.IsRevoked(),
-		}
+	// kcv and fingerprint look a key up without its ID
+	identifies, ok := keyIdentifierMatch(c)
+	if !ok {
+		return
+	}
 
-		// Include public key for asymmetric keys
-		if key.KeyType == storage.KeyTypeAsymmetric && key.PublicKey != nil {
-			keyInfo.PublicKey = base64.StdEncoding.EncodeToString(key.PublicKey)
-		}
+	query.Match = identifies
+
+	// Usage is read with each key the page walks, not for every key in the store
+	// idle=true lists only idle keys
+	idle := c.Query("idle") == "true"
+	now := time.Now().UTC()
+	query.IncludeUsage = true
+	query.MatchUsage = func(key *storage.Key, keyUsage *storage.KeyUsage) bool {
+		h.usage.AddPending(keyUsage)
+		return !idle || h.isIdle(key, keyUsage, now)
+	}
 
-		keyInfos = append(keyInfos, keyInfo)
+	// Get a page of keys from storage (without private key material)
+	page, ok := h.queryKeys(c, query)
+	if !ok {
+		return
 	}
 
 	c.JSON(http.StatusOK, ListKeysResponse{
-		Keys: keyInfos,
+		Keys:       h.newKeyInfos(page.Keys, page.Usage),
+		NextCursor: page.NextCursor,
 	})
 }
 
@@ -437,9 +750,12 @@ func (h *Handler) ListKeys(c *gin.Context) {
 type DownloadKeyResponse struct {
 	KeyID       string `json:"key_id"`
 	KeyType     string `json:"key_type"`
-	PublicKey   string `json:"public_key,omitempty"`   // Base64 encoded, only for asymmetric keys
-	PrivateKey  string `json:"private_key,omitempty"` // Base64 encoded decrypted key material
+	Algorithm   string `json:"algorithm"`
+	PublicKey   string `json:"public_key,omitempty"`   // Base64 encoded, only for asymmetric keys (PKIX DER for ECDSA and RSA)
+	PrivateKey  string `json:"private_key,omitempty"` // Base64 encoded decrypted key material (PKCS#8 DER for ECDSA and RSA)
 	SymmetricKey string `json:"symmetric_key,omitempty"` // Base64 encoded decrypted key (for symmetric keys)
+	KCV         string `json:"kcv,omitempty"`          // Key check value, only for symmetric keys
+	Fingerprint string `json:"fingerprint,omitempty"`  // SHA-256 public key fingerprint, only for asymmetric keys
 	CreatedAt   string `json:"created_at"`             // ISO 8601 timestamp
 	ExpiresAt   string `json:"expires_at"`             // ISO 8601 timestamp
 	Status      string `json:"status"`
@@ -447,7 +763,8 @@ type DownloadKeyResponse struct {
 	Warning     string `json:"warning,omitempty"`       // Security warning
 }
 
-// DownloadKey handles GET /keys/:id/download - Download key material
+// DownloadKey handles GET /keys/:id/download - Download key material in plaintext
+// Disabled unless AllowPlaintextExport is set; POST /keys/:id/export returns wrapped material instead
 func (h *Handler) DownloadKey(c *gin.Context) {
 	keyID := c.Param("id")
 	if keyID == "" {
@@ -466,14 +783,37 @@ func (h *Handler) DownloadKey(c *gin.Context) {
 		return
 	}
 
+	if key.IsDestroyed() {
+		c.JSON(http.StatusGone, gin.H{"error": "key material has been destroyed"})
+		return
+	}
+
+	// Disabled, revoked and pending deletion keys never leave the KMS
+	if !requireUsableKey(c, key) || !requireStoredMaterial(c, key) {
+		return
+	}
+
+	if !key.PermitsDownload() {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s: key %s does not allow download", errors.ErrOperationNotPermitted, key.ID)})
+		return
+	}
+
+	if !h.cfg.AllowPlaintextExport {
+		c.JSON(http.StatusForbidden, gin.H{"error": "plaintext export is disabled, use POST /keys/:id/export"})
+		return
+	}
+
 	// Build response structure
 	response := DownloadKeyResponse{
 		KeyID:     key.ID,
 		KeyType:   string(key.KeyType),
+		Algorithm: key.KeyAlgorithm(),
 		CreatedAt: key.CreatedAt.Format(time.RFC3339),
 		ExpiresAt: key.ExpiresAt.Format(time.RFC3339),
 		Status:    string(key.Status),
 		Version:   key.Version,
+		KCV:       key.CheckValue,
+		Fingerprint: versionFingerprint(key, key.PrimaryMaterial()),
 		Warning:   "⚠️ SECURITY WARNING: This file contains sensitive key material. Keep it secure and never share publicly!",
 	}
 
@@ -507,6 +847,14 @@ func (h *Handler) DownloadKey(c *gin.Context) {
 		response.PrivateKey = base64.StdEncoding.EncodeToString(decryptedKey)
 	}
 
+	if !h.auditExport(c, key, map[string]string{
+		"key_version": strconv.Itoa(key.PrimaryVersionNumber()),
+		"plaintext":   "true",
+		"client_ip":   c.ClientIP(),
+	}) {
+		return
+	}
+
 	// Set headers for file download
 	c.Header("Content-Type", "application/json")
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"key_%s.json\"", key.ID))
@@ -548,39 +896,43 @@ func (h *Handler) GenerateLicense(c *gin.Context) {
 		return
 	}
 
-	// Generate license file
-	_, licenseBytes, err := licenses.GenerateLicense(key, req.LicenseType, req.Metadata, h.masterKey)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Enforce the key's usage policy: license types and maximum validity
+	expiresAt, ok := licenseExpiry(c, key, req.LicenseType, req.ExpiresInSeconds, time.Now().UTC())
+	if !ok {
 		return
 	}
 
-	// Determine filename based on license type
-	filename := req.LicenseType + ".lic"
-	if filename == ".lic" {
-		filename = "license.lic"
+	opts, ok := h.resolveSigningOptions(c, req.Signers, req.SignaturePolicy)
+	if !ok {
+		return
 	}
+	opts.Detached = req.Detached
+	opts.Fingerprint = req.Fingerprint
+	opts.ExpiresAt = expiresAt
 
-	// Encode license file content to base64
-	licenseBase64 := base64.StdEncoding.EncodeToString(licenseBytes)
-
-	resp := licenses.GenerateLicenseResponse{
-		LicenseFile: licenseBase64,
-		Filename:    filename,
+	// Generate license file
+	generated, err := licenses.GenerateSignedLicense(key, req.LicenseType, req.Metadata, h.masterKey, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Parse the generated license to get license ID
-	var licenseFile licenses.LicenseFile
-	if err := json.Unmarshal(licenseBytes, &licenseFile); err == nil {
-		resp.LicenseID = licenseFile.LicenseID
+	// Keep a record of the issued license for revocation and transfers
+	if err := h.store.StoreLicense(newLicenseRecord(generated, opts)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store license record"})
+		return
 	}
+	h.recordLicenseIssue(key, opts)
 
-	c.JSON(http.StatusOK, resp)
+	c.JSON(http.StatusOK, newGenerateLicenseResponse(generated))
 }
 
 // ValidateLicense handles POST /licenses/validate - Validate a license file
 func (h *Handler) ValidateLicense(c *gin.Context) {
 	var fileContent []byte
+	var signatureContent []byte
+	var fingerprint string
+	var nonce string
 	var err error
 
 	// Support two input methods: multipart file upload or JSON body
@@ -598,6 +950,17 @@ func (h *Handler) ValidateLicense(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "license_content is required"})
 			return
 		}
+		nonce = req.Nonce
+		fingerprint = req.Fingerprint
+
+		// Decode optional detached signature file
+		if req.SignatureContent != "" {
+			signatureContent, err = base64.StdEncoding.DecodeString(req.SignatureContent)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid signature_content: must be base64 encoded"})
+				return
+			}
+		}
 
 		// Decode base64 content
 		fileContent, err = base64.StdEncoding.DecodeString(req.LicenseContent)
@@ -606,7 +969,9 @@ func (h *Handler) ValidateLicense(c *gin.Context) {
 			return
 		}
 	} else {
-		// Multipart form data: expect file field
+		// Multipart form data: expect file field and optional nonce and fingerprint fields
+		nonce = c.PostForm("nonce")
+		fingerprint = c.PostForm("fingerprint")
 		file, err := c.FormFile("file")
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "file is required in multipart form data"})
@@ -627,10 +992,30 @@ func (h *Handler) ValidateLicense(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file content"})
 			return
 		}
+
+		// Optional detached signature file
+		if sigFile, err := c.FormFile("signature"); err == nil {
+			sigSrc, err := sigFile.Open()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to open signature file"})
+				return
+			}
+			defer sigSrc.Close()
+
+			signatureContent, err = io.ReadAll(sigSrc)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read signature file"})
+				return
+			}
+		}
 	}
 
 	// Validate license file
-	result, err := licenses.ValidateLicense(fileContent, h.store, h.masterKey)
+	opts := licenses.ValidateOptions{
+		SignatureContent: signatureContent,
+		Fingerprint:      fingerprint,
+	}
+	result, err := licenses.ValidateLicense(fileContent, opts, h.store, h.masterKey)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -646,14 +1031,13 @@ func (h *Handler) ValidateLicense(c *gin.Context) {
 		Expired:     result.Expired,
 		Revoked:     result.Revoked,
 		Metadata:    result.Metadata,
+		SignedBy:    result.SignedBy,
 		Error:       result.Error,
 	}
 
-	if !resp.Valid {
-		c.JSON(http.StatusOK, resp)
-		return
+	if result.KeyID != "" {
+		h.recordUsage(result.KeyID, storage.OperationVerify)
 	}
-
-	c.JSON(http.StatusOK, resp)
+	h.writeValidationResponse(c, nonce, &resp)
 }
 
@@ -0,0 +1,485 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+const (
+	// keyBackupFormat identifies the layout of a decrypted key backup
+	keyBackupFormat = "kms-key-backup-v1"
+	// maxBackupCustodians bounds the number of shares a key is split into
+	maxBackupCustodians = 16
+	// backupShareHeaderSize is the backup ID (16 bytes) and threshold (1 byte) in front of each Shamir share
+	backupShareHeaderSize = 17
+)
+
+// BackupCustodian names the holder of one backup share
+// Exactly one of recipient_id or wrapping_public_key is required
+type BackupCustodian struct {
+	RecipientID       string `json:"recipient_id,omitempty"`
+	Name              string `json:"name,omitempty"`                // Optional label for a custodian given by wrapping_public_key
+	WrappingAlgorithm string `json:"wrapping_algorithm,omitempty"`  // Required with wrapping_public_key
+	WrappingPublicKey string `json:"wrapping_public_key,omitempty"` // Base64 encoded
+}
+
+// BackupSharesRequest represents a request to split a key into custodian backup shares
+type BackupSharesRequest struct {
+	Threshold  int               `json:"threshold" binding:"required"` // Shares needed to recover the key
+	Custodians []BackupCustodian `json:"custodians" binding:"required"`
+}
+
+// BackupShare represents one backup share wrapped to its custodian
+type BackupShare struct {
+	Index             int    `json:"index"`
+	RecipientID       string `json:"recipient_id,omitempty"`
+	Name              string `json:"name,omitempty"`
+	WrappingAlgorithm string `json:"wrapping_algorithm"`
+	WrappedShare      string `json:"wrapped_share"` // Base64 encoded, unwrap with the custodian's private key
+}
+
+// BackupSharesResponse represents an encrypted key backup and the shares that recover it
+type BackupSharesResponse struct {
+	BackupID        string        `json:"backup_id"`
+	KeyID           string        `json:"key_id"`
+	KeyType         string        `json:"key_type"`
+	Algorithm       string        `json:"algorithm"`
+	Threshold       int           `json:"threshold"`
+	EncryptedBackup string        `json:"encrypted_backup"` // Base64 encoded, opened by any threshold shares
+	Shares          []BackupShare `json:"shares"`
+	CreatedAt       time.Time     `json:"created_at"`
+}
+
+// RecoverKeyRequest represents a request to restore a key from backup shares
+type RecoverKeyRequest struct {
+	EncryptedBackup string   `json:"encrypted_backup" binding:"required"`
+	Shares          []string `json:"shares" binding:"required"` // Base64 encoded shares, already unwrapped by their custodians
+}
+
+// RecoverKeyResponse represents a key restored from a backup
+type RecoverKeyResponse struct {
+	KeyID     string    `json:"key_id"`
+	BackupID  string    `json:"backup_id"`
+	KeyType   string    `json:"key_type"`
+	Algorithm string    `json:"algorithm"`
+	PublicKey string    `json:"public_key,omitempty"` // Base64 encoded, only for asymmetric keys
+	Versions  int       `json:"versions"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Status    string    `json:"status"`
+}
+
+// keyBackup is the plaintext of an encrypted key backup
+// The key record is stored without its encrypted material, which is carried in plaintext alongside it
+type keyBackup struct {
+	Format    string         `json:"format"`
+	BackupID  string         `json:"backup_id"`
+	CreatedAt time.Time      `json:"created_at"`
+	Key       *storage.Key   `json:"key"`
+	Material  []byte         `json:"material,omitempty"` // The key's current material
+	Versions  map[int][]byte `json:"versions,omitempty"` // Material by version, for keys that have been versioned
+}
+
+// zero erases the plaintext material held by the backup
+func (b *keyBackup) zero() {
+	for i := range b.Material {
+		b.Material[i] = 0
+	}
+	for _, material := range b.Versions {
+		for i := range material {
+			material[i] = 0
+		}
+	}
+}
+
+// encodeBackupShare prefixes a Shamir share with its backup ID and threshold
+func encodeBackupShare(backupID uuid.UUID, threshold int, share []byte) []byte {
+	encoded := make([]byte, 0, backupShareHeaderSize+len(share))
+	encoded = append(encoded, backupID[:]...)
+	encoded = append(encoded, byte(threshold))
+	return append(encoded, share...)
+}
+
+// decodeBackupShare splits an encoded backup share into its backup ID, threshold and Shamir share
+func decodeBackupShare(encoded []byte) (uuid.UUID, int, []byte, error) {
+	if len(encoded) <= backupShareHeaderSize+1 {
+		return uuid.UUID{}, 0, nil, fmt.Errorf("share is too short")
+	}
+	backupID, err := uuid.FromBytes(encoded[:16])
+	if err != nil {
+		return uuid.UUID{}, 0, nil, err
+	}
+	return backupID, int(encoded[16]), encoded[backupShareHeaderSize:], nil
+}
+
+// resolveCustodian returns the wrapping algorithm and public key of a backup custodian
+// Writes the error response and returns false on failure
+func (h *Handler) resolveCustodian(c *gin.Context, custodian BackupCustodian) (string, []byte, bool) {
+	if (custodian.RecipientID == "") == (custodian.WrappingPublicKey == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "each custodian needs exactly one of recipient_id or wrapping_public_key"})
+		return "", nil, false
+	}
+
+	if custodian.RecipientID == "" {
+		publicKey, ok := decodeWrappingPublicKey(c, custodian.WrappingAlgorithm, custodian.WrappingPublicKey)
+		return custodian.WrappingAlgorithm, publicKey, ok
+	}
+
+	recipient, err := h.store.GetExportRecipient(custodian.RecipientID)
+	if err != nil {
+		if err == errors.ErrExportRecipientNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("export recipient %s not found", custodian.RecipientID)})
+			return "", nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve export recipient"})
+		return "", nil, false
+	}
+	return recipient.WrappingAlgorithm, recipient.PublicKey, true
+}
+
+// newKeyBackup decrypts every material version of a key into a backup
+// The caller must zero the backup after use
+func (h *Handler) newKeyBackup(backupID string, key *storage.Key, now time.Time) (*keyBackup, error) {
+	record := *key
+	record.Versions = append([]storage.KeyVersion(nil), key.Versions...)
+	backup := &keyBackup{
+		Format:    keyBackupFormat,
+		BackupID:  backupID,
+		CreatedAt: now,
+		Key:       &record,
+	}
+
+	if len(key.EncryptedPrivateKey) > 0 {
+		material, err := crypto.DecryptKey(h.masterKey, key.EncryptedPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		backup.Material = material
+		record.EncryptedPrivateKey = nil
+	}
+
+	for i := range record.Versions {
+		version := &record.Versions[i]
+		if len(version.EncryptedPrivateKey) == 0 {
+			continue
+		}
+		material, err := h.decryptKeyMaterial(version)
+		if err != nil {
+			backup.zero()
+			return nil, err
+		}
+		if backup.Versions == nil {
+			backup.Versions = map[int][]byte{}
+		}
+		backup.Versions[version.Version] = material
+		version.EncryptedPrivateKey = nil
+	}
+
+	return backup, nil
+}
+
+// BackupKeyShares handles POST /keys/:id/backup-shares - Split a key into Shamir shares wrapped to custodians
+// The key record and every material version are encrypted under a random backup key,
+// and the backup key is split so that any threshold custodians can recover it
+func (h *Handler) BackupKeyShares(c *gin.Context) {
+	var req BackupSharesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Custodians) > maxBackupCustodians {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a key can be split between at most %d custodians", maxBackupCustodians)})
+		return
+	}
+	if req.Threshold < 2 || req.Threshold > len(req.Custodians) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be at least 2 and at most the number of custodians"})
+		return
+	}
+
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if key.IsDestroyed() {
+		c.JSON(http.StatusGone, gin.H{"error": "key material has been destroyed"})
+		return
+	}
+
+	// Disabled, revoked and pending deletion keys never leave the KMS, not even as shares
+	if !requireOperation(c, key, storage.OperationExport) || !requireUsableKey(c, key) || !requireStoredMaterial(c, key) {
+		return
+	}
+
+	// Resolve every custodian before any material is decrypted
+	// Two shares under the same wrapping key would let one custodian count twice towards the threshold
+	algorithms := make([]string, len(req.Custodians))
+	wrappingKeys := make([][]byte, len(req.Custodians))
+	seen := map[string]bool{}
+	for i, custodian := range req.Custodians {
+		algorithms[i], wrappingKeys[i], ok = h.resolveCustodian(c, custodian)
+		if !ok {
+			return
+		}
+		if seen[string(wrappingKeys[i])] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "each custodian must have a different wrapping key"})
+			return
+		}
+		seen[string(wrappingKeys[i])] = true
+	}
+
+	backupID := uuid.New()
+	now := time.Now().UTC()
+	backup, err := h.newKeyBackup(backupID.String(), key, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decrypt key material"})
+		return
+	}
+	plaintext, err := json.Marshal(backup)
+	backup.zero()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode key backup"})
+		return
+	}
+
+	backupKey, err := crypto.GenerateSymmetricKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate backup key"})
+		return
+	}
+	encryptedBackup, err := crypto.EncryptKey(backupKey, plaintext)
+	for i := range plaintext {
+		plaintext[i] = 0
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt key backup"})
+		return
+	}
+
+	shares, err := crypto.SplitSecret(backupKey, len(req.Custodians), req.Threshold)
+	for i := range backupKey {
+		backupKey[i] = 0
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to split backup key"})
+		return
+	}
+	defer func() {
+		// Zero out the unwrapped shares
+		for _, share := range shares {
+			for i := range share {
+				share[i] = 0
+			}
+		}
+	}()
+
+	resp := BackupSharesResponse{
+		BackupID:        backupID.String(),
+		KeyID:           key.ID,
+		KeyType:         string(key.KeyType),
+		Algorithm:       key.KeyAlgorithm(),
+		Threshold:       req.Threshold,
+		EncryptedBackup: base64.StdEncoding.EncodeToString(encryptedBackup),
+		Shares:          make([]BackupShare, 0, len(shares)),
+		CreatedAt:       now,
+	}
+	custodians := make([]string, 0, len(shares))
+	for i, share := range shares {
+		encoded := encodeBackupShare(backupID, req.Threshold, share)
+		wrapped, err := crypto.WrapKeyMaterial(algorithms[i], wrappingKeys[i], encoded)
+		for j := range encoded {
+			encoded[j] = 0
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to wrap backup share with %s", algorithms[i])})
+			return
+		}
+
+		custodian := req.Custodians[i]
+		resp.Shares = append(resp.Shares, BackupShare{
+			Index:             i + 1,
+			RecipientID:       custodian.RecipientID,
+			Name:              custodian.Name,
+			WrappingAlgorithm: algorithms[i],
+			WrappedShare:      base64.StdEncoding.EncodeToString(wrapped),
+		})
+		if custodian.RecipientID != "" {
+			custodians = append(custodians, custodian.RecipientID)
+		} else {
+			custodians = append(custodians, custodian.Name)
+		}
+	}
+
+	// The shares are only returned once the backup has been audited
+	err = h.store.RecordEvent(&storage.Event{
+		Type:  storage.EventKeyBackedUp,
+		KeyID: key.ID,
+		Actor: storage.ActorAPI,
+		Time:  now,
+		Details: map[string]string{
+			"backup_id":  resp.BackupID,
+			"threshold":  strconv.Itoa(req.Threshold),
+			"shares":     strconv.Itoa(len(shares)),
+			"custodians": strings.Join(custodians, ","),
+			"client_ip":  c.ClientIP(),
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to audit key backup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// RecoverKey handles POST /keys/recover - Restore a key from its encrypted backup and a threshold of shares
+// The key keeps its ID and is stored under this server's master key
+func (h *Handler) RecoverKey(c *gin.Context) {
+	var req RecoverKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	encryptedBackup, err := base64.StdEncoding.DecodeString(req.EncryptedBackup)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid encrypted_backup: must be base64 encoded"})
+		return
+	}
+
+	var backupID uuid.UUID
+	var threshold int
+	shares := make([][]byte, 0, len(req.Shares))
+	defer func() {
+		// Zero out the submitted shares
+		for _, share := range shares {
+			for i := range share {
+				share[i] = 0
+			}
+		}
+	}()
+	for i, encoded := range req.Shares {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share: must be base64 encoded"})
+			return
+		}
+		shareBackupID, shareThreshold, share, err := decodeBackupShare(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share"})
+			return
+		}
+		if i > 0 && (shareBackupID != backupID || shareThreshold != threshold) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "shares belong to different backups"})
+			return
+		}
+		backupID, threshold = shareBackupID, shareThreshold
+		shares = append(shares, share)
+	}
+	if len(shares) < threshold || len(shares) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("backup needs %d shares, got %d", threshold, len(shares))})
+		return
+	}
+
+	backupKey, err := crypto.CombineShares(shares)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plaintext, err := crypto.DecryptKey(backupKey, encryptedBackup)
+	for i := range backupKey {
+		backupKey[i] = 0
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shares do not open this backup"})
+		return
+	}
+
+	var backup keyBackup
+	err = json.Unmarshal(plaintext, &backup)
+	for i := range plaintext {
+		plaintext[i] = 0
+	}
+	defer backup.zero()
+	if err != nil || backup.Format != keyBackupFormat || backup.Key == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported key backup format"})
+		return
+	}
+	if backup.BackupID != backupID.String() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shares do not open this backup"})
+		return
+	}
+
+	// Hierarchy keys are recovered top down
+	key := backup.Key
+	if key.ParentKeyID != "" {
+		if _, err := h.store.GetKey(key.ParentKeyID); err != nil {
+			if err == errors.ErrKeyNotFound {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("parent key %s must be recovered first", key.ParentKeyID)})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve parent key"})
+			return
+		}
+	}
+
+	// Store the material under this server's master key
+	if len(backup.Material) > 0 {
+		if key.EncryptedPrivateKey, err = crypto.EncryptKey(h.masterKey, backup.Material); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt key"})
+			return
+		}
+	}
+	for i := range key.Versions {
+		version := &key.Versions[i]
+		material, ok := backup.Versions[version.Version]
+		if !ok {
+			continue
+		}
+		if version.EncryptedPrivateKey, err = crypto.EncryptKey(h.masterKey, material); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt key"})
+			return
+		}
+	}
+
+	details := map[string]string{
+		"backup_id": backup.BackupID,
+		"shares":    strconv.Itoa(len(shares)),
+		"client_ip": c.ClientIP(),
+	}
+	if err := h.store.RestoreKey(key, details); err != nil {
+		if err == errors.ErrKeyExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "key already exists", "key_id": key.ID})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store key"})
+		return
+	}
+
+	resp := RecoverKeyResponse{
+		KeyID:     key.ID,
+		BackupID:  backup.BackupID,
+		KeyType:   string(key.KeyType),
+		Algorithm: key.KeyAlgorithm(),
+		Versions:  len(key.MaterialVersions()),
+		ExpiresAt: key.ExpiresAt,
+		Status:    string(key.Status),
+	}
+	if key.KeyType == storage.KeyTypeAsymmetric {
+		resp.PublicKey = base64.StdEncoding.EncodeToString(key.PublicKey)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
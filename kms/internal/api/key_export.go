@@ -0,0 +1,273 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// ExportRecipientRequest represents a request to register an export recipient
+type ExportRecipientRequest struct {
+	Name              string `json:"name" binding:"required"`
+	WrappingAlgorithm string `json:"wrapping_algorithm" binding:"required"`
+	PublicKey         string `json:"public_key" binding:"required"` // Base64 encoded wrapping public key
+}
+
+// ExportRecipientResponse represents a registered export recipient
+type ExportRecipientResponse struct {
+	RecipientID       string    `json:"recipient_id"`
+	Name              string    `json:"name"`
+	WrappingAlgorithm string    `json:"wrapping_algorithm"`
+	PublicKey         string    `json:"public_key"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// ListExportRecipientsResponse represents a response from listing export recipients
+type ListExportRecipientsResponse struct {
+	Recipients []ExportRecipientResponse `json:"recipients"`
+}
+
+// ExportKeyRequest represents a request to export wrapped key material
+// Exactly one of recipient_id or wrapping_public_key is required
+type ExportKeyRequest struct {
+	RecipientID       string `json:"recipient_id,omitempty"`
+	WrappingAlgorithm string `json:"wrapping_algorithm,omitempty"`  // Required with wrapping_public_key
+	WrappingPublicKey string `json:"wrapping_public_key,omitempty"` // Base64 encoded
+	KeyVersion        int    `json:"key_version,omitempty"`         // Default primary version
+}
+
+// ExportKeyResponse represents exported key material wrapped to the recipient
+type ExportKeyResponse struct {
+	KeyID              string `json:"key_id"`
+	KeyVersion         int    `json:"key_version"`
+	KeyType            string `json:"key_type"`
+	Algorithm          string `json:"algorithm"`
+	PublicKey          string `json:"public_key,omitempty"`  // Base64 encoded, only for asymmetric keys
+	KCV                string `json:"kcv,omitempty"`         // Key check value, only for symmetric keys
+	Fingerprint        string `json:"fingerprint,omitempty"` // SHA-256 public key fingerprint, only for asymmetric keys
+	RecipientID        string `json:"recipient_id,omitempty"`
+	WrappingAlgorithm  string `json:"wrapping_algorithm"`
+	WrappedKeyMaterial string `json:"wrapped_key_material"` // Base64 encoded, unwrap with the recipient's private key
+}
+
+// newExportRecipientResponse converts a stored export recipient to its API form
+func newExportRecipientResponse(recipient *storage.ExportRecipient) ExportRecipientResponse {
+	return ExportRecipientResponse{
+		RecipientID:       recipient.ID,
+		Name:              recipient.Name,
+		WrappingAlgorithm: recipient.WrappingAlgorithm,
+		PublicKey:         base64.StdEncoding.EncodeToString(recipient.PublicKey),
+		CreatedAt:         recipient.CreatedAt,
+	}
+}
+
+// decodeWrappingPublicKey decodes and validates a base64 wrapping public key
+// Writes the error response and returns false on failure
+func decodeWrappingPublicKey(c *gin.Context, algorithm, encoded string) ([]byte, bool) {
+	if !crypto.IsSupportedWrappingAlgorithm(algorithm) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "wrapping_algorithm must be rsa-oaep-sha256 or x25519-hkdf-sha256-aes-256-gcm"})
+		return nil, false
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrapping public key: must be base64 encoded"})
+		return nil, false
+	}
+	if err := crypto.ValidateWrappingPublicKey(algorithm, publicKey); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return publicKey, true
+}
+
+// CreateExportRecipient handles POST /export-recipients - Register a wrapping public key for key export
+func (h *Handler) CreateExportRecipient(c *gin.Context) {
+	var req ExportRecipientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	publicKey, ok := decodeWrappingPublicKey(c, req.WrappingAlgorithm, req.PublicKey)
+	if !ok {
+		return
+	}
+
+	recipient := &storage.ExportRecipient{
+		ID:                uuid.New().String(),
+		Name:              req.Name,
+		WrappingAlgorithm: req.WrappingAlgorithm,
+		PublicKey:         publicKey,
+		CreatedAt:         time.Now().UTC(),
+	}
+	if err := h.store.StoreExportRecipient(recipient); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store export recipient"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newExportRecipientResponse(recipient))
+}
+
+// ListExportRecipients handles GET /export-recipients - List registered export recipients
+func (h *Handler) ListExportRecipients(c *gin.Context) {
+	recipients, err := h.store.ListExportRecipients()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list export recipients"})
+		return
+	}
+
+	resp := ListExportRecipientsResponse{Recipients: make([]ExportRecipientResponse, 0, len(recipients))}
+	for _, recipient := range recipients {
+		resp.Recipients = append(resp.Recipients, newExportRecipientResponse(recipient))
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeleteExportRecipient handles DELETE /export-recipients/:id - Remove an export recipient
+func (h *Handler) DeleteExportRecipient(c *gin.Context) {
+	recipientID := c.Param("id")
+	if err := h.store.DeleteExportRecipient(recipientID); err != nil {
+		if err == errors.ErrExportRecipientNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "export recipient not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete export recipient"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recipient_id": recipientID, "deleted": true})
+}
+
+// ExportKey handles POST /keys/:id/export - Export key material wrapped to a recipient public key
+func (h *Handler) ExportKey(c *gin.Context) {
+	var req ExportKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if (req.RecipientID == "") == (req.WrappingPublicKey == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of recipient_id or wrapping_public_key is required"})
+		return
+	}
+
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if key.IsDestroyed() {
+		c.JSON(http.StatusGone, gin.H{"error": "key material has been destroyed"})
+		return
+	}
+
+	// Disabled, revoked and pending deletion keys never leave the KMS
+	if !requireOperation(c, key, storage.OperationExport) || !requireUsableKey(c, key) || !h.requireDerivationParent(c, key) {
+		return
+	}
+
+	// Resolve where the material is wrapped to
+	wrappingAlgorithm := req.WrappingAlgorithm
+	var wrappingKey []byte
+	if req.RecipientID != "" {
+		recipient, err := h.store.GetExportRecipient(req.RecipientID)
+		if err != nil {
+			if err == errors.ErrExportRecipientNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "export recipient not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve export recipient"})
+			return
+		}
+		wrappingAlgorithm = recipient.WrappingAlgorithm
+		wrappingKey = recipient.PublicKey
+	} else {
+		wrappingKey, ok = decodeWrappingPublicKey(c, wrappingAlgorithm, req.WrappingPublicKey)
+		if !ok {
+			return
+		}
+	}
+
+	version := req.KeyVersion
+	if version == 0 {
+		version = key.PrimaryVersionNumber()
+	}
+	material, ok := materialVersion(c, key, version)
+	if !ok {
+		return
+	}
+
+	keyMaterial, err := h.keySecret(key, material)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decrypt key material"})
+		return
+	}
+	wrapped, err := crypto.WrapKeyMaterial(wrappingAlgorithm, wrappingKey, keyMaterial)
+	for i := range keyMaterial {
+		keyMaterial[i] = 0
+	}
+	if err != nil {
+		// RSA-OAEP can only wrap material shorter than the wrapping key
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to wrap key material with %s", wrappingAlgorithm)})
+		return
+	}
+
+	// The export is only returned once it has been audited
+	details := map[string]string{
+		"key_version":        strconv.Itoa(material.Version),
+		"wrapping_algorithm": wrappingAlgorithm,
+		"client_ip":          c.ClientIP(),
+	}
+	if req.RecipientID != "" {
+		details["recipient_id"] = req.RecipientID
+	}
+	if !h.auditExport(c, key, details) {
+		return
+	}
+
+	resp := ExportKeyResponse{
+		KeyID:              key.ID,
+		KeyVersion:         material.Version,
+		KeyType:            string(key.KeyType),
+		Algorithm:          key.KeyAlgorithm(),
+		KCV:                material.CheckValue,
+		Fingerprint:        versionFingerprint(key, material),
+		RecipientID:        req.RecipientID,
+		WrappingAlgorithm:  wrappingAlgorithm,
+		WrappedKeyMaterial: base64.StdEncoding.EncodeToString(wrapped),
+	}
+	if len(material.PublicKey) > 0 {
+		resp.PublicKey = base64.StdEncoding.EncodeToString(material.PublicKey)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// auditExport records a key export event
+// Writes the error response and returns false if the event could not be recorded
+func (h *Handler) auditExport(c *gin.Context, key *storage.Key, details map[string]string) bool {
+	err := h.store.RecordEvent(&storage.Event{
+		Type:    storage.EventKeyExported,
+		KeyID:   key.ID,
+		Actor:   storage.ActorAPI,
+		Time:    time.Now().UTC(),
+		Details: details,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to audit key export"})
+		return false
+	}
+	return true
+}
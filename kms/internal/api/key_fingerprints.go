@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+var (
+	// checkValuePattern matches a key check value in either case
+	checkValuePattern = regexp.MustCompile(`^[0-9A-Fa-f]{6}$`)
+	// fingerprintPattern matches a hex SHA-256 public key fingerprint in either case
+	fingerprintPattern = regexp.MustCompile(`^[0-9A-Fa-f]{64}$`)
+)
+
+// versionFingerprint returns the SHA-256 fingerprint of a material version's public key
+// Empty for symmetric keys
+func versionFingerprint(key *storage.Key, version *storage.KeyVersion) string {
+	if len(version.PublicKey) == 0 {
+		return ""
+	}
+	fingerprint, err := crypto.PublicKeyFingerprint(key.KeyAlgorithm(), version.PublicKey)
+	if err != nil {
+		return ""
+	}
+	return fingerprint
+}
+
+// keyIdentifierMatch reads the kcv and fingerprint filters of GET /keys
+// A key matches when any of its material versions has the check value or fingerprint, so older
+// material is found too. Returns a nil match without filters
+// Writes the error response and returns false on failure
+func keyIdentifierMatch(c *gin.Context) (func(key *storage.Key) bool, bool) {
+	checkValue := c.Query("kcv")
+	fingerprint := c.Query("fingerprint")
+	if checkValue != "" && !checkValuePattern.MatchString(checkValue) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kcv must be 6 hex digits"})
+		return nil, false
+	}
+	if fingerprint != "" && !fingerprintPattern.MatchString(fingerprint) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fingerprint must be a hex SHA-256 hash"})
+		return nil, false
+	}
+	if checkValue == "" && fingerprint == "" {
+		return nil, true
+	}
+
+	return func(key *storage.Key) bool {
+		for _, version := range key.MaterialVersions() {
+			if checkValue != "" && strings.EqualFold(version.CheckValue, checkValue) {
+				return true
+			}
+			if fingerprint != "" && strings.EqualFold(versionFingerprint(key, &version), fingerprint) {
+				return true
+			}
+		}
+		return false
+	}, true
+}
+
+// BackfillKeyCheckValues records the check values of symmetric keys stored before they were computed
+// Keys whose material cannot be read, such as derived keys under a revoked parent, are skipped
+// Returns the number of keys updated
+func (h *Handler) BackfillKeyCheckValues() (int, error) {
+	missing, err := h.store.KeysWithoutCheckValues()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list keys without check values: %w", err)
+	}
+
+	updated := 0
+	for _, key := range missing {
+		checkValues := map[int]string{}
+		for _, version := range key.MaterialVersions() {
+			if !key.IsDerived() && (len(version.EncryptedPrivateKey) == 0 || version.CheckValue != "") {
+				continue
+			}
+			secret, err := h.keySecret(key, &version)
+			if err != nil {
+				continue
+			}
+			checkValue, err := crypto.KeyCheckValue(key.KeyAlgorithm(), secret)
+			for i := range secret {
+				secret[i] = 0
+			}
+			if err == nil {
+				checkValues[version.Version] = checkValue
+			}
+		}
+		if len(checkValues) == 0 {
+			continue
+		}
+
+		if _, err := h.store.SetKeyCheckValues(key.ID, checkValues); err != nil {
+			return updated, fmt.Errorf("failed to record check values of key %s: %w", key.ID, err)
+		}
+		updated++
+	}
+	return updated, nil
+}
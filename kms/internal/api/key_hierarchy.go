@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// KeyChildrenResponse represents the direct child keys of a key
+type KeyChildrenResponse struct {
+	KeyID    string    `json:"key_id"`
+	Children []KeyInfo `json:"children"`
+}
+
+// KeyAncestryResponse represents the parent chain of a key
+type KeyAncestryResponse struct {
+	KeyID    string    `json:"key_id"`
+	Ancestry []KeyInfo `json:"ancestry"` // Parent first, ending at the hub key
+}
+
+// validateKeyHierarchy checks the level, parent and site mode of a new key
+// A site key must belong to an active enterprise key, which must belong to an active hub key
+// Writes the error response and returns false on failure
+func (h *Handler) validateKeyHierarchy(c *gin.Context, req *RegisterKeyRequest) bool {
+	level := storage.KeyLevel(req.Level)
+	if !level.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "level must be hub, enterprise or site"})
+		return false
+	}
+
+	mode := storage.SiteMode(req.SiteMode)
+	if level == storage.KeyLevelSite {
+		if mode != storage.SiteModeDev && mode != storage.SiteModeProd {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "site keys require site_mode dev or prod"})
+			return false
+		}
+	} else if mode != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "site_mode is only allowed on site keys"})
+		return false
+	}
+
+	parentLevel := level.ParentLevel()
+	if parentLevel == "" {
+		if req.ParentKeyID != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only enterprise and site keys have a parent key"})
+			return false
+		}
+		return true
+	}
+
+	if req.ParentKeyID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s keys require a parent %s key", level, parentLevel)})
+		return false
+	}
+
+	parent, err := h.store.GetKey(req.ParentKeyID)
+	if err != nil {
+		if err == errors.ErrKeyNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parent key not found"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve parent key"})
+		return false
+	}
+
+	if parent.Level != parentLevel {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("parent of a %s key must be a %s key", level, parentLevel)})
+		return false
+	}
+	if !parent.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "parent key is not active or has expired"})
+		return false
+	}
+
+	return true
+}
+
+// ListChildKeys handles GET /keys/:id/children - List the direct child keys of a key
+func (h *Handler) ListChildKeys(c *gin.Context) {
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	children, err := h.store.ListChildKeys(key.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list child keys"})
+		return
+	}
+
+	usages, err := h.keyUsages(children)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve key usage"})
+		return
+	}
+
+	resp := KeyChildrenResponse{KeyID: key.ID, Children: h.newKeyInfos(children, usages)}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetKeyAncestry handles GET /keys/:id/ancestry - List the parent chain of a key up to its hub key
+func (h *Handler) GetKeyAncestry(c *gin.Context) {
+	keyID := c.Param("id")
+	ancestry, err := h.store.KeyAncestry(keyID)
+	if err != nil {
+		if err == errors.ErrKeyNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve key ancestry"})
+		return
+	}
+
+	usages, err := h.keyUsages(ancestry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve key usage"})
+		return
+	}
+
+	resp := KeyAncestryResponse{KeyID: keyID, Ancestry: h.newKeyInfos(ancestry, usages)}
+
+	c.JSON(http.StatusOK, resp)
+}
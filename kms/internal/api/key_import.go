@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// ImportTokenRequest represents a request for a key import token
+type ImportTokenRequest struct {
+	WrappingAlgorithm string `json:"wrapping_algorithm"` // Optional, default rsa-oaep-sha256
+}
+
+// ImportTokenResponse represents a one-time key import token
+type ImportTokenResponse struct {
+	ImportToken       string    `json:"import_token"`
+	WrappingAlgorithm string    `json:"wrapping_algorithm"`
+	PublicKey         string    `json:"public_key"` // Base64 encoded wrapping public key
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// ImportKeyRequest represents a request to import wrapped key material
+// Accepts every RegisterKeyRequest field except key_material
+type ImportKeyRequest struct {
+	RegisterKeyRequest
+	ImportToken        string `json:"import_token" binding:"required"`
+	WrappedKeyMaterial string `json:"wrapped_key_material" binding:"required"` // Base64 encoded, wrapped to the token's public key
+}
+
+// CreateImportToken handles POST /keys/import-token - Issue a one-time wrapping key for a key import
+func (h *Handler) CreateImportToken(c *gin.Context) {
+	var req ImportTokenRequest
+	// The body is optional: an empty body selects the default wrapping algorithm
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.WrappingAlgorithm == "" {
+		req.WrappingAlgorithm = crypto.WrappingRSAOAEPSHA256
+	}
+	if !crypto.IsSupportedWrappingAlgorithm(req.WrappingAlgorithm) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "wrapping_algorithm must be rsa-oaep-sha256 or x25519-hkdf-sha256-aes-256-gcm"})
+		return
+	}
+
+	publicKey, privateKey, err := crypto.GenerateWrappingKey(req.WrappingAlgorithm)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate wrapping key"})
+		return
+	}
+	encryptedPrivateKey, err := crypto.EncryptKey(h.masterKey, privateKey)
+	for i := range privateKey {
+		privateKey[i] = 0
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt wrapping key"})
+		return
+	}
+
+	ttl := h.cfg.ImportTokenTTL
+	if ttl <= 0 {
+		ttl = config.DefaultImportTokenTTLSeconds * time.Second
+	}
+
+	now := time.Now().UTC()
+	token := &storage.ImportToken{
+		ID:                  uuid.New().String(),
+		WrappingAlgorithm:   req.WrappingAlgorithm,
+		PublicKey:           publicKey,
+		EncryptedPrivateKey: encryptedPrivateKey,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(ttl),
+	}
+	if err := h.store.StoreImportToken(token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store import token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ImportTokenResponse{
+		ImportToken:       token.ID,
+		WrappingAlgorithm: token.WrappingAlgorithm,
+		PublicKey:         base64.StdEncoding.EncodeToString(token.PublicKey),
+		ExpiresAt:         token.ExpiresAt,
+	})
+}
+
+// ImportKey handles POST /keys/import - Register a key from material wrapped to an import token
+func (h *Handler) ImportKey(c *gin.Context) {
+	var req ImportKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.KeyMaterial != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key_material is not accepted on import, use wrapped_key_material"})
+		return
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(req.WrappedKeyMaterial)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wrapped_key_material: must be base64 encoded"})
+		return
+	}
+
+	// Validate before consuming the token so a malformed request does not burn it
+	algorithm, keyType, ok := validateRegisterKeyRequest(c, &req.RegisterKeyRequest)
+	if !ok || !h.validateKeyHierarchy(c, &req.RegisterKeyRequest) {
+		return
+	}
+
+	token, err := h.store.ConsumeImportToken(req.ImportToken, time.Now().UTC())
+	if err != nil {
+		switch err {
+		case errors.ErrImportTokenNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "import token not found or already used"})
+		case errors.ErrImportTokenExpired:
+			c.JSON(http.StatusGone, gin.H{"error": "import token expired"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve import token"})
+		}
+		return
+	}
+
+	wrappingKey, err := crypto.DecryptKey(h.masterKey, token.EncryptedPrivateKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decrypt wrapping key"})
+		return
+	}
+	keyMaterial, err := crypto.UnwrapKeyMaterial(token.WrappingAlgorithm, wrappingKey, wrapped)
+	for i := range wrappingKey {
+		wrappingKey[i] = 0
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to unwrap key material"})
+		return
+	}
+	defer func() {
+		// Zero out unwrapped key material
+		for i := range keyMaterial {
+			keyMaterial[i] = 0
+		}
+	}()
+
+	h.createKey(c, &req.RegisterKeyRequest, algorithm, keyType, keyMaterial)
+}
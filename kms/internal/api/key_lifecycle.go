@@ -0,0 +1,114 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// ScheduleKeyDeletionRequest represents a request to schedule a key for destruction
+type ScheduleKeyDeletionRequest struct {
+	WaitingPeriodDays int `json:"waiting_period_days,omitempty"` // Optional, defaults to lifecycle.deletion_waiting_days
+}
+
+// KeyStateResponse represents the lifecycle state of a key
+type KeyStateResponse struct {
+	KeyID        string     `json:"key_id"`
+	Status       string     `json:"status"`
+	ActivateAt   *time.Time `json:"activate_at,omitempty"`
+	DeletionDate *time.Time `json:"deletion_date,omitempty"`
+	DestroyedAt  *time.Time `json:"destroyed_at,omitempty"`
+}
+
+// describeKeyStatus turns a key status into words for error messages
+func describeKeyStatus(status storage.KeyStatus) string {
+	return strings.ReplaceAll(string(status), "_", " ")
+}
+
+// newKeyStateResponse builds the lifecycle state response of a key
+func newKeyStateResponse(key *storage.Key) KeyStateResponse {
+	return KeyStateResponse{
+		KeyID:        key.ID,
+		Status:       string(key.Status),
+		ActivateAt:   key.ActivateAt,
+		DeletionDate: key.DeletionDate,
+		DestroyedAt:  key.DestroyedAt,
+	}
+}
+
+// EnableKey handles POST /keys/:id/enable - Activate a pending key or re-enable a disabled key
+func (h *Handler) EnableKey(c *gin.Context) {
+	h.changeKeyState(c, "enable", h.store.EnableKey)
+}
+
+// DisableKey handles POST /keys/:id/disable - Make a key unusable until it is enabled again
+// Child keys that are active or pending activation follow the revocation policy
+func (h *Handler) DisableKey(c *gin.Context) {
+	h.changeKeyState(c, "disable", func(keyID string) (*storage.Key, error) {
+		return h.store.DisableKey(keyID, h.cfg.CascadeRevocation)
+	})
+}
+
+// CancelKeyDeletion handles POST /keys/:id/cancel-deletion - Stop a pending deletion
+// The key is left disabled and must be enabled before use; a key that was revoked stays revoked
+func (h *Handler) CancelKeyDeletion(c *gin.Context) {
+	h.changeKeyState(c, "cancel deletion of", h.store.CancelKeyDeletion)
+}
+
+// ScheduleKeyDeletion handles POST /keys/:id/schedule-deletion - Destroy a key after a waiting period
+// The key cannot be used while deletion is pending; the deletion can be cancelled until the waiting period ends
+// Live child keys follow the revocation policy
+func (h *Handler) ScheduleKeyDeletion(c *gin.Context) {
+	var req ScheduleKeyDeletionRequest
+	// The body is optional: an empty body uses the configured waiting period
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.WaitingPeriodDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "waiting_period_days must not be negative"})
+		return
+	}
+
+	waitingPeriod := h.cfg.DeletionWaitingPeriod
+	if req.WaitingPeriodDays > 0 {
+		waitingPeriod = time.Duration(req.WaitingPeriodDays) * 24 * time.Hour
+	}
+	deletionDate := time.Now().UTC().Add(waitingPeriod)
+
+	h.changeKeyState(c, "schedule deletion of", func(keyID string) (*storage.Key, error) {
+		return h.store.ScheduleKeyDeletion(keyID, deletionDate, h.cfg.CascadeRevocation)
+	})
+}
+
+// changeKeyState applies a lifecycle transition to the key named in the path
+func (h *Handler) changeKeyState(c *gin.Context, action string, apply func(keyID string) (*storage.Key, error)) {
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	updated, err := apply(key.ID)
+	if err != nil {
+		if err == errors.ErrInvalidKeyState {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("cannot %s a key that is %s", action, describeKeyStatus(key.Status))})
+			return
+		}
+		if err == errors.ErrKeyHasLiveChildren {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("cannot %s a key with live child keys, change them first", action)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update key state"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newKeyStateResponse(updated))
+}
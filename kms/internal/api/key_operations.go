@@ -0,0 +1,446 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+	"github.com/atprof/license-server/kms/pkg/licensefile"
+)
+
+// SignRequest represents a request to sign a message or digest with a stored key
+type SignRequest struct {
+	Message string `json:"message,omitempty"` // Message to sign (same form as POST /keys/validate)
+	Digest  string `json:"digest,omitempty"`  // Base64 encoded digest (SHA-512 for Ed25519ph, SHA-384 for P-384, otherwise SHA-256)
+}
+
+// SignResponse represents a response from signing
+type SignResponse struct {
+	KeyID      string `json:"key_id"`
+	KeyVersion int    `json:"key_version"` // Pass to POST /keys/validate to verify after rotation
+	Algorithm  string `json:"algorithm"`
+	Signature  string `json:"signature"` // Base64 encoded
+}
+
+// loadKey retrieves a key by ID, writing the error response and returning false on failure
+func (h *Handler) loadKey(c *gin.Context, keyID string) (*storage.Key, bool) {
+	if keyID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key_id is required"})
+		return nil, false
+	}
+
+	key, err := h.store.GetKey(keyID)
+	if err != nil {
+		if err == errors.ErrKeyNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve key"})
+		return nil, false
+	}
+
+	return key, true
+}
+
+// requireUsableKey writes an error response and returns false unless the key is active and unexpired
+func requireUsableKey(c *gin.Context, key *storage.Key) bool {
+	if key.IsValid() {
+		return true
+	}
+
+	if key.IsExpired() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is expired"})
+		return false
+	}
+	if key.IsRevoked() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is revoked"})
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "key is " + describeKeyStatus(key.Status)})
+	return false
+}
+
+// materialVersion looks up a usable material version of a key
+// Writes the error response and returns false if it does not exist or is retired
+func materialVersion(c *gin.Context, key *storage.Key, version int) (*storage.KeyVersion, bool) {
+	material := key.MaterialVersion(version)
+	if material == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key version not found"})
+		return nil, false
+	}
+	if material.IsRetired() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key version is retired"})
+		return nil, false
+	}
+	return material, true
+}
+
+// decryptKeyMaterial decrypts the secret material of a key version
+// The caller must zero the returned bytes after use
+func (h *Handler) decryptKeyMaterial(material *storage.KeyVersion) ([]byte, error) {
+	return crypto.DecryptKey(h.masterKey, material.EncryptedPrivateKey)
+}
+
+// Sign handles POST /keys/:id/sign - Sign a message or digest without exposing the private key
+func (h *Handler) Sign(c *gin.Context) {
+	var req SignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if (req.Message == "") == (req.Digest == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of message or digest is required"})
+		return
+	}
+
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	algorithm := key.KeyAlgorithm()
+	if key.KeyType != storage.KeyTypeAsymmetric && algorithm != crypto.AlgorithmHMACSHA256 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only asymmetric and HMAC keys can sign"})
+		return
+	}
+	if req.Digest != "" && crypto.DigestSize(algorithm) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s keys cannot sign digests", algorithm)})
+		return
+	}
+
+	// The response signing key must only ever sign validation responses
+	if key.ID == h.cfg.ResponseSigningKeyID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "key is reserved for response signing"})
+		return
+	}
+
+	// License signatures are made under their own context, which raw signing must never produce
+	if strings.HasPrefix(req.Message, licensefile.SignatureContext) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message is reserved for license signatures"})
+		return
+	}
+	// Neither can it answer a key refresh challenge
+	if strings.HasPrefix(req.Message, RefreshProofContext) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message is reserved for key refresh proofs"})
+		return
+	}
+
+	if !requireOperation(c, key, storage.OperationSign) || !requireUsableKey(c, key) || !h.requireDerivationParent(c, key) {
+		return
+	}
+
+	var digest []byte
+	if req.Digest != "" {
+		var err error
+		digest, err = base64.StdEncoding.DecodeString(req.Digest)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid digest: must be base64 encoded"})
+			return
+		}
+
+		reserved, err := h.answersRefreshChallenge(key, digest)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check refresh challenges"})
+			return
+		}
+		if reserved {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "digest is reserved for key refresh proofs"})
+			return
+		}
+	}
+
+	// Always sign with the primary version
+	material := key.PrimaryMaterial()
+
+	// Decrypt the private key only for the duration of the signing operation
+	privateKey, err := h.keySecret(key, material)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decrypt key material"})
+		return
+	}
+	defer func() {
+		// Zero out decrypted private key after signing
+		for i := range privateKey {
+			privateKey[i] = 0
+		}
+	}()
+
+	resp := SignResponse{
+		KeyID:      key.ID,
+		KeyVersion: material.Version,
+		Algorithm:  crypto.SignatureAlgorithm(algorithm, digest != nil),
+	}
+	var signature []byte
+	if digest != nil {
+		signature, err = crypto.SignPrehashed(algorithm, privateKey, digest)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		signature, err = crypto.Sign(algorithm, privateKey, []byte(req.Message))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign message"})
+			return
+		}
+	}
+
+	resp.Signature = base64.StdEncoding.EncodeToString(signature)
+	h.recordUsage(key.ID, storage.OperationSign)
+	c.JSON(http.StatusOK, resp)
+}
+
+// EncryptRequest represents a request to encrypt data with a symmetric key
+type EncryptRequest struct {
+	Plaintext         string            `json:"plaintext" binding:"required"` // Base64 encoded, at most 4096 bytes
+	EncryptionContext map[string]string `json:"encryption_context,omitempty"`
+}
+
+// EncryptResponse represents a response from encrypting data
+type EncryptResponse struct {
+	KeyID          string `json:"key_id"`
+	KeyVersion     int    `json:"key_version"`
+	CiphertextBlob string `json:"ciphertext_blob"` // Base64 encoded self-describing ciphertext
+}
+
+// DecryptRequest represents a request to decrypt a ciphertext blob
+type DecryptRequest struct {
+	CiphertextBlob    string            `json:"ciphertext_blob" binding:"required"`
+	EncryptionContext map[string]string `json:"encryption_context,omitempty"`
+}
+
+// DecryptResponse represents a response from decrypting data
+type DecryptResponse struct {
+	KeyID      string `json:"key_id"`
+	KeyVersion int    `json:"key_version"`
+	Plaintext  string `json:"plaintext"` // Base64 encoded
+}
+
+// Encrypt handles POST /keys/:id/encrypt - Encrypt data with a symmetric key
+func (h *Handler) Encrypt(c *gin.Context) {
+	var req EncryptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(req.Plaintext)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plaintext: must be base64 encoded"})
+		return
+	}
+	defer func() {
+		for i := range plaintext {
+			plaintext[i] = 0
+		}
+	}()
+
+	if len(plaintext) > crypto.MaxPlaintextSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "plaintext exceeds 4096 bytes"})
+		return
+	}
+
+	key, blob, ok := h.encryptForKey(c, c.Param("id"), plaintext, req.EncryptionContext)
+	if !ok {
+		return
+	}
+	h.recordUsage(key.ID, storage.OperationEncrypt)
+
+	c.JSON(http.StatusOK, EncryptResponse{
+		KeyID:          key.ID,
+		KeyVersion:     key.PrimaryVersionNumber(),
+		CiphertextBlob: base64.StdEncoding.EncodeToString(blob),
+	})
+}
+
+// encryptForKey loads a usable symmetric key and encrypts plaintext under its primary version
+// Writes the error response and returns false on failure
+func (h *Handler) encryptForKey(c *gin.Context, keyID string, plaintext []byte, context map[string]string) (*storage.Key, []byte, bool) {
+	key, ok := h.loadKey(c, keyID)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if key.KeyAlgorithm() != crypto.AlgorithmAES256GCM {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only AES keys can encrypt"})
+		return nil, nil, false
+	}
+
+	if !requireOperation(c, key, storage.OperationEncrypt) || !requireUsableKey(c, key) || !h.requireDerivationParent(c, key) {
+		return nil, nil, false
+	}
+
+	material := key.PrimaryMaterial()
+	keyMaterial, err := h.keySecret(key, material)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decrypt key material"})
+		return nil, nil, false
+	}
+	defer func() {
+		for i := range keyMaterial {
+			keyMaterial[i] = 0
+		}
+	}()
+
+	blob, err := crypto.EncryptWithContext(keyMaterial, key.ID, uint32(material.Version), plaintext, context)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt data"})
+		return nil, nil, false
+	}
+
+	return key, blob, true
+}
+
+// Decrypt handles POST /keys/:id/decrypt - Decrypt a ciphertext blob with a symmetric key
+func (h *Handler) Decrypt(c *gin.Context) {
+	var req DecryptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(req.CiphertextBlob)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ciphertext_blob: must be base64 encoded"})
+		return
+	}
+
+	header, err := crypto.ParseCiphertextHeader(blob)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ciphertext_blob"})
+		return
+	}
+
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if header.KeyID != key.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ciphertext was not encrypted with this key"})
+		return
+	}
+
+	if key.KeyAlgorithm() != crypto.AlgorithmAES256GCM {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only AES keys can decrypt"})
+		return
+	}
+
+	if !requireOperation(c, key, storage.OperationDecrypt) || !requireUsableKey(c, key) || !h.requireDerivationParent(c, key) {
+		return
+	}
+
+	// Decrypt with whichever version the ciphertext names
+	material, ok := materialVersion(c, key, int(header.KeyVersion))
+	if !ok {
+		return
+	}
+
+	keyMaterial, err := h.keySecret(key, material)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decrypt key material"})
+		return
+	}
+	defer func() {
+		for i := range keyMaterial {
+			keyMaterial[i] = 0
+		}
+	}()
+
+	plaintext, err := crypto.DecryptWithContext(keyMaterial, blob, req.EncryptionContext)
+	if err != nil {
+		// Never reveal whether the ciphertext or the context was wrong
+		c.JSON(http.StatusBadRequest, gin.H{"error": "decryption failed"})
+		return
+	}
+	defer func() {
+		for i := range plaintext {
+			plaintext[i] = 0
+		}
+	}()
+
+	h.recordUsage(key.ID, storage.OperationDecrypt)
+	c.JSON(http.StatusOK, DecryptResponse{
+		KeyID:      key.ID,
+		KeyVersion: int(header.KeyVersion),
+		Plaintext:  base64.StdEncoding.EncodeToString(plaintext),
+	})
+}
+
+// DataKeySpecAES256 is the only data key spec: a 256-bit AES key
+const DataKeySpecAES256 = "AES_256"
+
+// GenerateDataKeyRequest represents a request to generate a data key
+type GenerateDataKeyRequest struct {
+	KeySpec           string            `json:"key_spec,omitempty"` // DataKeySpecAES256, the default
+	EncryptionContext map[string]string `json:"encryption_context,omitempty"`
+}
+
+// GenerateDataKeyResponse represents a generated data key
+type GenerateDataKeyResponse struct {
+	KeyID          string `json:"key_id"`
+	KeyVersion     int    `json:"key_version"`
+	Plaintext      string `json:"plaintext,omitempty"` // Base64 encoded 256-bit data key, omitted for the without-plaintext variant
+	CiphertextBlob string `json:"ciphertext_blob"`     // Data key wrapped under the KMS key, decryptable with POST /keys/:id/decrypt
+}
+
+// GenerateDataKey handles POST /keys/:id/data-key - Generate a data key for envelope encryption
+func (h *Handler) GenerateDataKey(c *gin.Context) {
+	h.generateDataKey(c, true)
+}
+
+// GenerateDataKeyWithoutPlaintext handles POST /keys/:id/data-key/without-plaintext
+// Returns only the wrapped data key, for services that decrypt it later
+func (h *Handler) GenerateDataKeyWithoutPlaintext(c *gin.Context) {
+	h.generateDataKey(c, false)
+}
+
+// generateDataKey creates a fresh 256-bit data key and wraps it under the named key
+func (h *Handler) generateDataKey(c *gin.Context, includePlaintext bool) {
+	var req GenerateDataKeyRequest
+	// The body is optional: an empty body means no encryption context
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.KeySpec != "" && req.KeySpec != DataKeySpecAES256 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported key_spec %q, only %s is supported", req.KeySpec, DataKeySpecAES256)})
+		return
+	}
+
+	dataKey, err := crypto.GenerateSymmetricKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate data key"})
+		return
+	}
+	defer func() {
+		for i := range dataKey {
+			dataKey[i] = 0
+		}
+	}()
+
+	key, blob, ok := h.encryptForKey(c, c.Param("id"), dataKey, req.EncryptionContext)
+	if !ok {
+		return
+	}
+	h.recordUsage(key.ID, storage.OperationEncrypt)
+
+	resp := GenerateDataKeyResponse{
+		KeyID:          key.ID,
+		KeyVersion:     key.PrimaryVersionNumber(),
+		CiphertextBlob: base64.StdEncoding.EncodeToString(blob),
+	}
+	if includePlaintext {
+		resp.Plaintext = base64.StdEncoding.EncodeToString(dataKey)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// RefreshProofContext starts every message signed or MACed to prove possession of a key
+// The sign endpoint refuses messages with this prefix, so it cannot answer a refresh challenge
+const RefreshProofContext = "kms-refresh-v1:"
+
+// RefreshChallengeResponse represents a one-time challenge for refreshing a key
+type RefreshChallengeResponse struct {
+	ChallengeID string    `json:"challenge_id"`
+	KeyID       string    `json:"key_id"`
+	Challenge   string    `json:"challenge"` // Base64 encoded, sign or MAC RefreshProofMessage(key_id, challenge)
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// RefreshProofMessage returns the message that answers a refresh challenge
+// challenge is the base64 encoded challenge as returned by the challenge endpoint
+func RefreshProofMessage(keyID, challenge string) []byte {
+	return []byte(RefreshProofContext + keyID + ":" + challenge)
+}
+
+// requireRefreshableKey writes an error response and returns false unless the key can be refreshed
+// Expired keys can only be refreshed within the configured grace period
+func (h *Handler) requireRefreshableKey(c *gin.Context, key *storage.Key) bool {
+	if key.IsRevoked() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot refresh revoked key"})
+		return false
+	}
+	if !key.IsLive() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot refresh key that is " + describeKeyStatus(key.Status)})
+		return false
+	}
+	if key.IsExpired() && time.Since(key.ExpiresAt) > h.cfg.RefreshGracePeriod {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot refresh expired key"})
+		return false
+	}
+	return true
+}
+
+// verifyKeyPossession checks a proof of possession of the key's primary material over challenge
+// Asymmetric keys sign RefreshProofMessage; symmetric keys answer with HMAC-SHA256 of it keyed with the key material
+func (h *Handler) verifyKeyPossession(key *storage.Key, challenge, proof []byte) (bool, error) {
+	material := key.PrimaryMaterial()
+	if material == nil {
+		return false, errors.ErrInvalidKeyMaterial
+	}
+
+	message := RefreshProofMessage(key.ID, base64.StdEncoding.EncodeToString(challenge))
+	if key.KeyType == storage.KeyTypeAsymmetric {
+		return crypto.Verify(key.KeyAlgorithm(), material.PublicKey, message, proof)
+	}
+
+	secret, err := h.keySecret(key, material)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		for i := range secret {
+			secret[i] = 0
+		}
+	}()
+	return crypto.Verify(crypto.AlgorithmHMACSHA256, secret, message, proof)
+}
+
+// answersRefreshChallenge checks if a signature over digest would answer an outstanding refresh challenge of the key
+// ECDSA and RSA keys sign digests of any message, so the prefix check on messages alone cannot stop them
+func (h *Handler) answersRefreshChallenge(key *storage.Key, digest []byte) (bool, error) {
+	challenges, err := h.store.OutstandingRefreshChallenges(key.ID, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+
+	for _, challenge := range challenges {
+		message := RefreshProofMessage(key.ID, base64.StdEncoding.EncodeToString(challenge.Challenge))
+		if expected, ok := crypto.MessageDigest(key.KeyAlgorithm(), message); ok && bytes.Equal(expected, digest) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreateRefreshChallenge handles POST /keys/:id/refresh/challenge - Issue a one-time challenge for a key refresh
+func (h *Handler) CreateRefreshChallenge(c *gin.Context) {
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if !h.requireRefreshableKey(c, key) {
+		return
+	}
+
+	random, err := crypto.GenerateChallenge()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate challenge"})
+		return
+	}
+
+	ttl := h.cfg.RefreshChallengeTTL
+	if ttl <= 0 {
+		ttl = config.DefaultRefreshChallengeTTLSeconds * time.Second
+	}
+
+	now := time.Now().UTC()
+	challenge := &storage.RefreshChallenge{
+		ID:        uuid.New().String(),
+		KeyID:     key.ID,
+		Challenge: random,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := h.store.StoreRefreshChallenge(challenge); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store challenge"})
+		return
+	}
+
+	c.JSON(http.StatusOK, RefreshChallengeResponse{
+		ChallengeID: challenge.ID,
+		KeyID:       key.ID,
+		Challenge:   base64.StdEncoding.EncodeToString(random),
+		ExpiresAt:   challenge.ExpiresAt,
+	})
+}
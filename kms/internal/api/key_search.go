@@ -0,0 +1,182 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// Limits on key labels and listings
+const (
+	maxKeyNameLength        = 128
+	maxKeyDescriptionLength = 1024
+	maxKeyTags              = 32
+	maxTagValueLength       = 256
+	// defaultKeyPageSize is how many keys GET /keys returns without a limit
+	defaultKeyPageSize = 100
+	// maxKeyPageSize is the largest limit GET /keys accepts
+	maxKeyPageSize = 1000
+)
+
+// tagNamePattern restricts tag names, so a tag filter can separate the name from the value at the first colon
+var tagNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// KeyLabelsRequest represents the display name, description and tags of a key
+// Each request replaces all three
+type KeyLabelsRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Tags        map[string]string `json:"tags"` // For example customer, site_id, env
+}
+
+// KeyLabelsResponse represents the labels of a key after an update
+type KeyLabelsResponse struct {
+	KeyID       string            `json:"key_id"`
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// validateKeyLabels checks the length of a name and description and the names and values of tags
+func validateKeyLabels(name, description string, tags map[string]string) error {
+	if len(name) > maxKeyNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxKeyNameLength)
+	}
+	if len(description) > maxKeyDescriptionLength {
+		return fmt.Errorf("description must be at most %d characters", maxKeyDescriptionLength)
+	}
+	if len(tags) > maxKeyTags {
+		return fmt.Errorf("a key can have at most %d tags", maxKeyTags)
+	}
+	for tag, value := range tags {
+		if !tagNamePattern.MatchString(tag) {
+			return fmt.Errorf("invalid tag name %q: use up to 64 letters, digits, '_', '.' or '-'", tag)
+		}
+		if len(value) > maxTagValueLength {
+			return fmt.Errorf("value of tag %s must be at most %d characters", tag, maxTagValueLength)
+		}
+	}
+	return nil
+}
+
+// SetKeyLabels handles PUT /keys/:id/labels - Set the display name, description and tags of a key
+func (h *Handler) SetKeyLabels(c *gin.Context) {
+	var req KeyLabelsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateKeyLabels(req.Name, req.Description, req.Tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	updated, err := h.store.SetKeyLabels(key.ID, req.Name, req.Description, req.Tags)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update key labels"})
+		return
+	}
+
+	c.JSON(http.StatusOK, KeyLabelsResponse{
+		KeyID:       updated.ID,
+		Name:        updated.Name,
+		Description: updated.Description,
+		Tags:        updated.Tags,
+	})
+}
+
+// parseKeyQuery reads the filters, sort order and page of GET /keys from the query string
+// Writes the error response and returns false on failure
+func parseKeyQuery(c *gin.Context) (storage.KeyQuery, bool) {
+	query := storage.KeyQuery{
+		KeyType: storage.KeyType(c.Query("type")),
+		Status:  storage.KeyStatus(c.Query("status")),
+		Sort:    storage.KeySortField(c.DefaultQuery("sort", string(storage.KeySortCreatedAt))),
+		Cursor:  c.Query("cursor"),
+		Limit:   defaultKeyPageSize,
+	}
+
+	switch query.Sort {
+	case storage.KeySortCreatedAt, storage.KeySortExpiresAt, storage.KeySortName:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be created_at, expires_at or name"})
+		return query, false
+	}
+
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return query, false
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxKeyPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxKeyPageSize)})
+			return query, false
+		}
+		query.Limit = n
+	}
+
+	// tag=name:value matches a tag value, tag=name any key carrying the tag
+	for _, tag := range c.QueryArray("tag") {
+		name, value, _ := strings.Cut(tag, ":")
+		if !tagNamePattern.MatchString(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid tag filter %q", tag)})
+			return query, false
+		}
+		if query.Tags == nil {
+			query.Tags = map[string]string{}
+		}
+		query.Tags[name] = value
+	}
+
+	for param, target := range map[string]**time.Time{
+		"expires_after":  &query.ExpiresAfter,
+		"expires_before": &query.ExpiresBefore,
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: must be an RFC 3339 time", param)})
+			return query, false
+		}
+		*target = &t
+	}
+
+	return query, true
+}
+
+// queryKeys runs a key query and writes the error response on failure
+func (h *Handler) queryKeys(c *gin.Context, query storage.KeyQuery) (*storage.KeyPage, bool) {
+	page, err := h.store.QueryKeys(query)
+	if err != nil {
+		if err == errors.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list keys"})
+		return nil, false
+	}
+	return page, true
+}
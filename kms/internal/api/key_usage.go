@@ -0,0 +1,120 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/licenses"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/internal/usage"
+)
+
+// KeyUsageResponse represents the operation counters and last use of a key
+type KeyUsageResponse struct {
+	KeyID         string           `json:"key_id"`
+	Counts        map[string]int64 `json:"counts"` // By operation: sign, verify, encrypt, decrypt, license-issue
+	Total         int64            `json:"total"`
+	LastUsedAt    *time.Time       `json:"last_used_at,omitempty"`
+	LastOperation string           `json:"last_operation,omitempty"`
+	Idle          bool             `json:"idle"`
+}
+
+// UsageTracker returns the tracker buffering key usage, so the server can flush it in the background
+func (h *Handler) UsageTracker() *usage.Tracker {
+	return h.usage
+}
+
+// recordUsage counts a successful operation on a key
+func (h *Handler) recordUsage(keyID string, op storage.KeyOperation) {
+	h.usage.Record(keyID, op, time.Now().UTC())
+}
+
+// recordLicenseIssue counts a license once for the issuing key and once for each other co-signer
+func (h *Handler) recordLicenseIssue(key *storage.Key, opts licenses.GenerateOptions) {
+	h.recordUsage(key.ID, storage.OperationLicenseIssue)
+	for _, signer := range opts.Signers {
+		if signer.ID != key.ID {
+			h.recordUsage(signer.ID, storage.OperationLicenseIssue)
+		}
+	}
+}
+
+// idleKeyPeriod returns how long an active key can go unused before it is flagged as idle
+func (h *Handler) idleKeyPeriod() time.Duration {
+	if h.cfg.IdleKeyPeriod > 0 {
+		return h.cfg.IdleKeyPeriod
+	}
+	return config.DefaultIdleKeyDays * 24 * time.Hour
+}
+
+// isIdle checks if an active key has gone unused, or was never used, for the idle period
+func (h *Handler) isIdle(key *storage.Key, keyUsage *storage.KeyUsage, now time.Time) bool {
+	if key.Status != storage.KeyStatusActive {
+		return false
+	}
+
+	lastActivity := key.CreatedAt
+	if keyUsage != nil && keyUsage.LastUsedAt != nil {
+		lastActivity = *keyUsage.LastUsedAt
+	}
+	return now.Sub(lastActivity) > h.idleKeyPeriod()
+}
+
+// keyUsages reads the usage of each key by key ID, including usage not flushed yet
+func (h *Handler) keyUsages(keys []*storage.Key) (map[string]*storage.KeyUsage, error) {
+	usages := make(map[string]*storage.KeyUsage, len(keys))
+	for _, key := range keys {
+		keyUsage, err := h.usage.Usage(key.ID)
+		if err != nil {
+			return nil, err
+		}
+		usages[key.ID] = keyUsage
+	}
+	return usages, nil
+}
+
+// newKeyInfos converts stored keys to their API form, with their last use and idle flag
+func (h *Handler) newKeyInfos(keys []*storage.Key, usages map[string]*storage.KeyUsage) []KeyInfo {
+	now := time.Now().UTC()
+	infos := make([]KeyInfo, 0, len(keys))
+	for _, key := range keys {
+		info := newKeyInfo(key)
+		if keyUsage, ok := usages[key.ID]; ok {
+			info.LastUsedAt = keyUsage.LastUsedAt
+		}
+		info.Idle = h.isIdle(key, usages[key.ID], now)
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// GetKeyUsage handles GET /keys/:id/usage - Get the operation counters and last use of a key
+func (h *Handler) GetKeyUsage(c *gin.Context) {
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	keyUsage, err := h.usage.Usage(key.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve key usage"})
+		return
+	}
+
+	resp := KeyUsageResponse{
+		KeyID:         key.ID,
+		Counts:        make(map[string]int64, len(storage.KeyOperations)),
+		Total:         keyUsage.Total(),
+		LastUsedAt:    keyUsage.LastUsedAt,
+		LastOperation: string(keyUsage.LastOperation),
+		Idle:          h.isIdle(key, keyUsage, time.Now().UTC()),
+	}
+	for op, count := range keyUsage.Counts {
+		resp.Counts[string(op)] = count
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/keys"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// KeyVersionInfo represents one material version of a key without private key material
+type KeyVersionInfo struct {
+	Version     int        `json:"version"`
+	Status      string     `json:"status"`
+	Primary     bool       `json:"primary"`
+	PublicKey   string     `json:"public_key,omitempty"`  // Base64 encoded, only for asymmetric keys
+	KCV         string     `json:"kcv,omitempty"`         // Key check value, only for symmetric keys
+	Fingerprint string     `json:"fingerprint,omitempty"` // SHA-256 public key fingerprint, only for asymmetric keys
+	CreatedAt   time.Time  `json:"created_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+}
+
+// KeyVersionsResponse represents the material versions of a key after a rotation or retirement
+type KeyVersionsResponse struct {
+	KeyID          string           `json:"key_id"`
+	PrimaryVersion int              `json:"primary_version"`
+	Versions       []KeyVersionInfo `json:"versions"`
+}
+
+// newKeyVersionInfos describes the material versions of a key
+func newKeyVersionInfos(key *storage.Key) []KeyVersionInfo {
+	primary := key.PrimaryVersionNumber()
+	versions := key.MaterialVersions()
+
+	infos := make([]KeyVersionInfo, 0, len(versions))
+	for _, v := range versions {
+		info := KeyVersionInfo{
+			Version:   v.Version,
+			Status:    string(v.Status),
+			Primary:   v.Version == primary,
+			KCV:       v.CheckValue,
+			CreatedAt: v.CreatedAt,
+			RetiredAt: v.RetiredAt,
+		}
+		info.Fingerprint = versionFingerprint(key, &v)
+		if len(v.PublicKey) > 0 {
+			info.PublicKey = base64.StdEncoding.EncodeToString(v.PublicKey)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// newKeyVersionsResponse builds the response for a key version change
+func newKeyVersionsResponse(key *storage.Key) KeyVersionsResponse {
+	return KeyVersionsResponse{
+		KeyID:          key.ID,
+		PrimaryVersion: key.PrimaryVersionNumber(),
+		Versions:       newKeyVersionInfos(key),
+	}
+}
+
+// RotateKey handles POST /keys/:id/rotate - Add new key material and make it the primary version
+// Older versions stay usable for decryption and signature validation until retired
+func (h *Handler) RotateKey(c *gin.Context) {
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if !requireUsableKey(c, key) || !requireStoredMaterial(c, key) {
+		return
+	}
+
+	publicKey, encryptedPrivateKey, checkValue, err := keys.NewMaterial(h.masterKey, key.KeyAlgorithm())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate key material"})
+		return
+	}
+
+	rotated, err := h.store.RotateKey(key.ID, publicKey, encryptedPrivateKey, checkValue)
+	if err != nil {
+		if err == errors.ErrKeyRevoked || err == errors.ErrInvalidKeyState {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "key is " + describeKeyStatus(key.Status)})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newKeyVersionsResponse(rotated))
+}
+
+// RetireKeyVersion handles POST /keys/:id/versions/:version/retire - Retire an old key version
+// Ciphertexts and signatures made with a retired version no longer decrypt or validate
+func (h *Handler) RetireKeyVersion(c *gin.Context) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key version"})
+		return
+	}
+
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if key.IsDestroyed() {
+		c.JSON(http.StatusConflict, gin.H{"error": "key material has been destroyed"})
+		return
+	}
+
+	retired, err := h.store.RetireKeyVersion(key.ID, version)
+	if err != nil {
+		switch err {
+		case errors.ErrKeyVersionNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "key version not found"})
+		case errors.ErrPrimaryKeyVersion:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot retire the primary key version, rotate the key first"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retire key version"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, newKeyVersionsResponse(retired))
+}
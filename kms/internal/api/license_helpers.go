@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/licenses"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// resolveSigningOptions loads the signer keys for a license and fills in the default policy
+// The root signer is used when no signers are given. Writes an error response and returns
+// false if a signer cannot be used.
+func (h *Handler) resolveSigningOptions(c *gin.Context, signerIDs []string, policy *licenses.SignaturePolicy) (licenses.GenerateOptions, bool) {
+	if len(signerIDs) == 0 {
+		signerIDs = []string{licenses.RootSignerID}
+	}
+
+	opts := licenses.GenerateOptions{Policy: policy}
+	seen := make(map[string]bool, len(signerIDs))
+	for _, signerID := range signerIDs {
+		if seen[signerID] {
+			continue
+		}
+		seen[signerID] = true
+
+		if signerID == licenses.RootSignerID {
+			opts.IncludeRoot = true
+			continue
+		}
+
+		signer, err := h.store.GetKey(signerID)
+		if err != nil {
+			if err == errors.ErrKeyNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("signer key %s not found", signerID)})
+				return opts, false
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve signer key"})
+			return opts, false
+		}
+		if signer.KeyAlgorithm() != crypto.AlgorithmEd25519 || !signer.IsValid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("signer key %s must be a valid Ed25519 key", signerID)})
+			return opts, false
+		}
+		if !requireOperation(c, signer, storage.OperationLicenseIssue) {
+			return opts, false
+		}
+		opts.Signers = append(opts.Signers, signer)
+	}
+
+	// Default policy for co-signed licenses: every signer is required
+	if opts.Policy == nil && len(seen) > 1 {
+		required := signerKeyIDs(opts)
+		opts.Policy = &licenses.SignaturePolicy{
+			MinSignatures:  len(required),
+			RequiredKeyIDs: required,
+		}
+	}
+
+	return opts, true
+}
+
+// signerKeyIDs returns the IDs of every signer in the options
+func signerKeyIDs(opts licenses.GenerateOptions) []string {
+	ids := make([]string, 0, len(opts.Signers)+1)
+	if opts.IncludeRoot {
+		ids = append(ids, licenses.RootSignerID)
+	}
+	for _, signer := range opts.Signers {
+		ids = append(ids, signer.ID)
+	}
+	return ids
+}
+
+// newLicenseRecord builds the server-side record for a generated license
+func newLicenseRecord(generated *licenses.GeneratedLicense, opts licenses.GenerateOptions) *storage.LicenseRecord {
+	license := generated.License
+	return &storage.LicenseRecord{
+		ID:              license.LicenseID,
+		KeyID:           license.KeyID,
+		LicenseType:     license.LicenseType,
+		Fingerprint:     license.Fingerprint,
+		Metadata:        license.Metadata,
+		SignerKeyIDs:    signerKeyIDs(opts),
+		SignaturePolicy: opts.Policy,
+		Detached:        opts.Detached,
+		IssuedAt:        license.IssuedAt,
+		ExpiresAt:       license.ExpiresAt,
+		Status:          storage.LicenseStatusActive,
+		LicenseFile:     generated.LicenseBytes,
+		SignatureFile:   generated.SignatureBytes,
+	}
+}
+
+// newGenerateLicenseResponse encodes a generated license for the API response
+func newGenerateLicenseResponse(generated *licenses.GeneratedLicense) licenses.GenerateLicenseResponse {
+	return licenseFileResponse(generated.License.LicenseID, generated.License.LicenseType, generated.LicenseBytes, generated.SignatureBytes)
+}
+
+// licenseFileResponse encodes license file content for the API response
+func licenseFileResponse(licenseID, licenseType string, licenseBytes, signatureBytes []byte) licenses.GenerateLicenseResponse {
+	// Determine filename based on license type
+	filename := licenseType + ".lic"
+	if filename == ".lic" {
+		filename = "license.lic"
+	}
+
+	resp := licenses.GenerateLicenseResponse{
+		LicenseFile: base64.StdEncoding.EncodeToString(licenseBytes),
+		Filename:    filename,
+		LicenseID:   licenseID,
+	}
+
+	if signatureBytes != nil {
+		resp.SignatureFile = base64.StdEncoding.EncodeToString(signatureBytes)
+		resp.SignatureFilename = filename + ".sig"
+	}
+
+	return resp
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/licenses"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// GetLicense handles GET /licenses/:id - Get an issued license record with its transfer history
+func (h *Handler) GetLicense(c *gin.Context) {
+	licenseID := c.Param("id")
+	if licenseID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "license_id is required"})
+		return
+	}
+
+	record, err := h.store.GetLicense(licenseID)
+	if err != nil {
+		if err == errors.ErrLicenseNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "license not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve license"})
+		return
+	}
+
+	c.JSON(http.StatusOK, record)
+}
+
+// TransferLicense handles POST /licenses/:id/transfer - Move a license to a new fingerprint
+// Issues a new node-locked license and revokes the old one
+func (h *Handler) TransferLicense(c *gin.Context) {
+	licenseID := c.Param("id")
+	if licenseID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "license_id is required"})
+		return
+	}
+
+	var req licenses.TransferLicenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record, err := h.store.GetLicense(licenseID)
+	if err != nil {
+		if err == errors.ErrLicenseNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "license not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve license"})
+		return
+	}
+
+	if record.IsRevoked() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot transfer revoked license"})
+		return
+	}
+
+	if req.Fingerprint == record.Fingerprint {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "license is already locked to this fingerprint"})
+		return
+	}
+
+	// An expired license is not worth a transfer slot
+	now := time.Now().UTC()
+	if !now.Before(record.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot transfer expired license"})
+		return
+	}
+
+	// Enforce the transfer limit over the configured period
+	transfersInPeriod := record.TransfersSince(now.Add(-h.cfg.TransferPeriod))
+	if transfersInPeriod >= h.cfg.MaxTransfersPerPeriod {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "license transfer limit reached for this period"})
+		return
+	}
+
+	key, err := h.store.GetKey(record.KeyID)
+	if err != nil {
+		if err == errors.ErrKeyNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve key"})
+		return
+	}
+
+	if !key.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot transfer license for invalid key"})
+		return
+	}
+
+	// The replacement keeps the original expiry unless the key now expires sooner,
+	// and must still be allowed by the key's current usage policy
+	var expiresInSeconds int64
+	if record.ExpiresAt.Before(key.ExpiresAt) {
+		expiresInSeconds = int64(record.ExpiresAt.Sub(now) / time.Second)
+	}
+	expiresAt, ok := licenseExpiry(c, key, record.LicenseType, expiresInSeconds, now)
+	if !ok {
+		return
+	}
+	if record.ExpiresAt.Before(expiresAt) {
+		expiresAt = record.ExpiresAt
+	}
+
+	// Re-issue with the same signers, signature policy and signature format
+	// Records made before the policy was kept get the default policy
+	opts, ok := h.resolveSigningOptions(c, record.SignerKeyIDs, record.SignaturePolicy)
+	if !ok {
+		return
+	}
+	opts.Detached = record.Detached || record.SignatureFile != nil
+	opts.Fingerprint = req.Fingerprint
+	opts.ExpiresAt = expiresAt
+
+	generated, err := licenses.GenerateSignedLicense(key, record.LicenseType, record.Metadata, h.masterKey, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	replacement := newLicenseRecord(generated, opts)
+	replacement.TransferredFrom = record.ID
+	replacement.Transfers = append(record.Transfers, storage.LicenseTransfer{
+		FromLicenseID:   record.ID,
+		ToLicenseID:     replacement.ID,
+		FromFingerprint: record.Fingerprint,
+		ToFingerprint:   req.Fingerprint,
+		Reason:          req.Reason,
+		TransferredAt:   now,
+	})
+
+	if err := h.store.TransferLicense(record.ID, replacement); err != nil {
+		if err == errors.ErrLicenseRevoked {
+			c.JSON(http.StatusConflict, gin.H{"error": "license was revoked during transfer"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store license transfer"})
+		return
+	}
+	h.recordLicenseIssue(key, opts)
+
+	c.JSON(http.StatusOK, licenses.TransferLicenseResponse{
+		GenerateLicenseResponse: newGenerateLicenseResponse(generated),
+		PreviousLicenseID:       record.ID,
+		TransfersInPeriod:       transfersInPeriod + 1,
+		MaxTransfers:            h.cfg.MaxTransfersPerPeriod,
+	})
+}
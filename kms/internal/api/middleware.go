@@ -9,12 +9,6 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-var (
-	// Rate limiter state
-	rateLimiters = make(map[string]*rateLimiter)
-	rateLimitMu  sync.RWMutex
-)
-
 // rateLimiter implements a simple token bucket rate limiter
 type rateLimiter struct {
 	tokens   int
@@ -30,7 +24,12 @@ const (
 )
 
 // RateLimitMiddleware implements rate limiting to prevent brute force attacks
+// Each middleware keeps its own per-client state, so separate routers have separate budgets
 func RateLimitMiddleware() gin.HandlerFunc {
+	// Rate limiter state
+	rateLimiters := make(map[string]*rateLimiter)
+	var rateLimitMu sync.RWMutex
+
 	return func(c *gin.Context) {
 		clientIP := c.ClientIP()
 
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// ListNotificationsResponse represents the expiry notification history, most recently updated first
+type ListNotificationsResponse struct {
+	Notifications []*storage.Notification `json:"notifications"`
+}
+
+// ListNotifications handles GET /notifications - List expiry warnings sent or attempted
+// subject_id filters by key or license ID
+func (h *Handler) ListNotifications(c *gin.Context) {
+	limit := defaultEventLimit
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = parsed
+	}
+
+	notifications, err := h.store.ListNotifications(c.Query("subject_id"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ListNotificationsResponse{Notifications: notifications})
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/licenses"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// ProductKeyResponse represents a response from issuing a product key
+type ProductKeyResponse struct {
+	LicenseID  string `json:"license_id"`
+	ProductKey string `json:"product_key"`
+}
+
+// RedeemProductKeyRequest represents a request to resolve a product key
+type RedeemProductKeyRequest struct {
+	ProductKey string `json:"product_key" binding:"required"`
+}
+
+// IssueProductKey handles POST /licenses/:id/product-key - Issue a short product key for a license
+func (h *Handler) IssueProductKey(c *gin.Context) {
+	licenseID := c.Param("id")
+	if licenseID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "license_id is required"})
+		return
+	}
+
+	record, err := h.store.GetLicense(licenseID)
+	if err != nil {
+		if err == errors.ErrLicenseNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "license not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve license"})
+		return
+	}
+
+	if record.IsRevoked() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot issue product key for revoked license"})
+		return
+	}
+
+	productKey, err := licenses.EncodeProductKey(record.ID, h.masterKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue product key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ProductKeyResponse{
+		LicenseID:  record.ID,
+		ProductKey: productKey,
+	})
+}
+
+// RedeemProductKey handles POST /licenses/redeem - Resolve a product key to the full license file
+func (h *Handler) RedeemProductKey(c *gin.Context) {
+	var req RedeemProductKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Checksum and MAC are verified before any lookup
+	licenseID, err := licenses.DecodeProductKey(req.ProductKey, h.masterKey)
+	if err != nil {
+		if err == errors.ErrProductKeyChecksum || err == errors.ErrInvalidProductKey {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode product key"})
+		return
+	}
+
+	record, err := h.store.GetLicense(licenseID)
+	if err != nil {
+		if err == errors.ErrLicenseNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "license not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve license"})
+		return
+	}
+
+	if record.IsRevoked() {
+		c.JSON(http.StatusGone, gin.H{"error": "license has been revoked", "replaced_by": record.ReplacedBy})
+		return
+	}
+
+	if len(record.LicenseFile) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "license file not available for this license"})
+		return
+	}
+
+	c.JSON(http.StatusOK, licenseFileResponse(record.ID, record.LicenseType, record.LicenseFile, record.SignatureFile))
+}
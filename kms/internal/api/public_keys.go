@@ -0,0 +1,162 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/licensefile"
+)
+
+// publicKeyCacheControl lets verifiers cache published keys briefly; rotations are covered by the overlap period
+const publicKeyCacheControl = "public, max-age=300"
+
+// JWKSResponse is a JSON Web Key Set (RFC 7517)
+type JWKSResponse struct {
+	Keys []*crypto.JWK `json:"keys"`
+}
+
+// PublishedKeyVersion is one published public key version
+type PublishedKeyVersion struct {
+	Kid        string      `json:"kid"`
+	KeyVersion int         `json:"key_version"`
+	Primary    bool        `json:"primary"`
+	JWK        *crypto.JWK `json:"jwk"`
+	PEM        string      `json:"pem"`
+}
+
+// PublicKeyResponse represents the published public keys of one key
+type PublicKeyResponse struct {
+	KeyID     string                `json:"key_id"`
+	Algorithm string                `json:"algorithm"`
+	ExpiresAt time.Time             `json:"expires_at"`
+	Versions  []PublishedKeyVersion `json:"versions"`
+}
+
+// publicKeyID returns the JWK key ID of a key version
+func publicKeyID(keyID string, version int) string {
+	return licensefile.KeyVersionID(keyID, version)
+}
+
+// publicKeyOverlap returns how long rotated and retired versions stay published
+func (h *Handler) publicKeyOverlap() time.Duration {
+	if h.cfg.PublicKeyOverlap > 0 {
+		return h.cfg.PublicKeyOverlap
+	}
+	return config.DefaultPublicKeyOverlapHours * time.Hour
+}
+
+// isPublished checks if a key's public keys are published
+// Only active, unexpired asymmetric keys are published
+func isPublished(key *storage.Key) bool {
+	return key.KeyType == storage.KeyTypeAsymmetric && key.IsValid()
+}
+
+// publishedVersions encodes the published versions of a key
+func (h *Handler) publishedVersions(key *storage.Key, now time.Time) ([]PublishedKeyVersion, error) {
+	algorithm := key.KeyAlgorithm()
+	primary := key.PrimaryVersionNumber()
+
+	var published []PublishedKeyVersion
+	for _, version := range key.PublishedVersions(now, h.publicKeyOverlap()) {
+		kid := publicKeyID(key.ID, version.Version)
+		jwk, err := crypto.NewJWK(algorithm, version.PublicKey, kid)
+		if err != nil {
+			return nil, err
+		}
+		pem, err := crypto.PublicKeyPEM(algorithm, version.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		published = append(published, PublishedKeyVersion{
+			Kid:        kid,
+			KeyVersion: version.Version,
+			Primary:    version.Version == primary,
+			JWK:        jwk,
+			PEM:        pem,
+		})
+	}
+	return published, nil
+}
+
+// JWKS handles GET /.well-known/jwks.json - Publish the public keys of every active asymmetric key
+func (h *Handler) JWKS(c *gin.Context) {
+	keys, err := h.store.ListKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list keys"})
+		return
+	}
+
+	now := time.Now().UTC()
+	resp := JWKSResponse{Keys: []*crypto.JWK{}}
+	for _, key := range keys {
+		if !isPublished(key) {
+			continue
+		}
+		versions, err := h.publishedVersions(key, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode public key"})
+			return
+		}
+		for _, version := range versions {
+			resp.Keys = append(resp.Keys, version.JWK)
+		}
+	}
+
+	writeCacheableJSON(c, resp)
+}
+
+// GetPublicKey handles GET /keys/:id/public - Publish the public keys of one active asymmetric key
+func (h *Handler) GetPublicKey(c *gin.Context) {
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if !isPublished(key) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no public key is published for this key"})
+		return
+	}
+
+	versions, err := h.publishedVersions(key, time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode public key"})
+		return
+	}
+
+	writeCacheableJSON(c, PublicKeyResponse{
+		KeyID:     key.ID,
+		Algorithm: key.KeyAlgorithm(),
+		ExpiresAt: key.ExpiresAt,
+		Versions:  versions,
+	})
+}
+
+// writeCacheableJSON writes a JSON response with cache headers and an ETag
+// Returns 304 Not Modified when the client already holds the same body
+func writeCacheableJSON(c *gin.Context, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode response"})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Header("Cache-Control", publicKeyCacheControl)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/pkg/signedresponse"
+)
+
+// signableResponse is implemented by validation responses that can carry a response signature
+type signableResponse interface {
+	SetResponseSignature(envelope *signedresponse.Envelope)
+}
+
+// SetResponseSignature attaches a response signature to the validation response
+func (r *ValidateKeyResponse) SetResponseSignature(envelope *signedresponse.Envelope) {
+	r.ResponseSignature = envelope
+}
+
+// writeValidationResponse writes a validation result, signing it first when a
+// response signing key is configured. The signature covers the nonce supplied
+// by the client, the server timestamp and the result itself.
+func (h *Handler) writeValidationResponse(c *gin.Context, nonce string, resp signableResponse) {
+	if h.cfg.ResponseSigningKeyID == "" {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	envelope, err := h.signValidationResult(nonce, resp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign validation response"})
+		return
+	}
+
+	resp.SetResponseSignature(envelope)
+	c.JSON(http.StatusOK, resp)
+}
+
+// signValidationResult signs the result with the configured response signing key
+func (h *Handler) signValidationResult(nonce string, result interface{}) (*signedresponse.Envelope, error) {
+	key, err := h.store.GetKey(h.cfg.ResponseSigningKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve response signing key: %w", err)
+	}
+
+	if key.KeyAlgorithm() != crypto.AlgorithmEd25519 {
+		return nil, fmt.Errorf("response signing key must be an Ed25519 key")
+	}
+	if !key.IsValid() {
+		return nil, fmt.Errorf("response signing key is not valid")
+	}
+
+	privateKey, err := crypto.DecryptKey(h.masterKey, key.EncryptedPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// Zero out decrypted private key after signing
+		for i := range privateKey {
+			privateKey[i] = 0
+		}
+	}()
+
+	return signedresponse.Sign(privateKey, key.ID, nonce, result, time.Now().UTC())
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// defaultEventLimit is the number of events returned when no limit is given
+const defaultEventLimit = 100
+
+// RotationPolicyResponse represents the rotation schedule of a key
+type RotationPolicyResponse struct {
+	KeyID          string                  `json:"key_id"`
+	RotationPolicy *storage.RotationPolicy `json:"rotation_policy"`
+	NextRotationAt *time.Time              `json:"next_rotation_at"`
+	LastRotatedAt  *time.Time              `json:"last_rotated_at,omitempty"`
+}
+
+// ListEventsResponse represents a list of key events, newest first
+type ListEventsResponse struct {
+	Events []*storage.Event `json:"events"`
+}
+
+// validateRotationPolicy checks that a rotation policy will not rotate in a loop
+func validateRotationPolicy(policy *storage.RotationPolicy) error {
+	if policy.PeriodDays < 0 || policy.DaysBeforeExpiry < 0 || policy.ValidityDays < 0 {
+		return fmt.Errorf("rotation policy values must not be negative")
+	}
+	if policy.PeriodDays > storage.MaxPolicyDays || policy.DaysBeforeExpiry > storage.MaxPolicyDays || policy.ValidityDays > storage.MaxPolicyDays {
+		return fmt.Errorf("rotation policy values must not exceed %d days", storage.MaxPolicyDays)
+	}
+	if policy.PeriodDays == 0 && policy.DaysBeforeExpiry == 0 {
+		return fmt.Errorf("rotation policy needs period_days or days_before_expiry")
+	}
+	// Rotating before expiry must move the expiry out of the rotation window
+	if policy.DaysBeforeExpiry > 0 && policy.ValidityDays <= policy.DaysBeforeExpiry {
+		return fmt.Errorf("validity_days must be greater than days_before_expiry")
+	}
+	return nil
+}
+
+// newRotationPolicyResponse builds the rotation schedule response of a key
+func newRotationPolicyResponse(key *storage.Key) RotationPolicyResponse {
+	return RotationPolicyResponse{
+		KeyID:          key.ID,
+		RotationPolicy: key.RotationPolicy,
+		NextRotationAt: key.NextRotationAt,
+		LastRotatedAt:  key.LastRotatedAt,
+	}
+}
+
+// SetRotationPolicy handles PUT /keys/:id/rotation-policy - Set the automatic rotation policy of a key
+func (h *Handler) SetRotationPolicy(c *gin.Context) {
+	var policy storage.RotationPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateRotationPolicy(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.updateRotationPolicy(c, &policy)
+}
+
+// RemoveRotationPolicy handles DELETE /keys/:id/rotation-policy - Stop rotating a key automatically
+func (h *Handler) RemoveRotationPolicy(c *gin.Context) {
+	h.updateRotationPolicy(c, nil)
+}
+
+// updateRotationPolicy stores the rotation policy of the key named in the path
+func (h *Handler) updateRotationPolicy(c *gin.Context, policy *storage.RotationPolicy) {
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if key.IsRevoked() || key.Status == storage.KeyStatusPendingDeletion || key.IsDestroyed() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is " + describeKeyStatus(key.Status)})
+		return
+	}
+	if policy != nil && !requireStoredMaterial(c, key) {
+		return
+	}
+
+	updated, err := h.store.SetRotationPolicy(key.ID, policy, time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update rotation policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newRotationPolicyResponse(updated))
+}
+
+// ListKeyEvents handles GET /keys/:id/events - List the events recorded for a key
+func (h *Handler) ListKeyEvents(c *gin.Context) {
+	limit := defaultEventLimit
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = parsed
+	}
+
+	keyID := c.Param("id")
+	if _, err := h.store.GetKey(keyID); err != nil {
+		if err == errors.ErrKeyNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve key"})
+		return
+	}
+
+	events, err := h.store.ListEvents(keyID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ListEventsResponse{Events: events})
+}
@@ -23,6 +23,12 @@ func SetupRouter(handler *Handler, allowedOrigins []string, allowAllOrigins bool
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Signed server time for offline clock rollback detection
+	router.GET("/time/token", handler.TimeToken)
+
+	// Public keys of active asymmetric keys, for verifiers
+	router.GET("/.well-known/jwks.json", handler.JWKS)
+
 	// API routes
 	v1 := router.Group("/keys")
 	{
@@ -30,15 +36,68 @@ func SetupRouter(handler *Handler, allowedOrigins []string, allowAllOrigins bool
 		v1.GET("/:id/download", handler.DownloadKey)    // Download key (must be before /:id routes)
 		v1.POST("", handler.RegisterKey)
 		v1.POST("/validate", handler.ValidateKey)
+		v1.POST("/import-token", handler.CreateImportToken)
+		v1.POST("/import", handler.ImportKey)
+		v1.POST("/recover", handler.RecoverKey)
 		v1.POST("/:id/refresh", handler.RefreshKey)
+		v1.POST("/:id/refresh/challenge", handler.CreateRefreshChallenge)
+		v1.POST("/:id/sign", handler.Sign)
+		v1.POST("/:id/encrypt", handler.Encrypt)
+		v1.POST("/:id/decrypt", handler.Decrypt)
+		v1.POST("/:id/data-key", handler.GenerateDataKey)
+		v1.POST("/:id/data-key/without-plaintext", handler.GenerateDataKeyWithoutPlaintext)
+		v1.POST("/:id/rotate", handler.RotateKey)
+		v1.POST("/:id/versions/:version/retire", handler.RetireKeyVersion)
+		v1.PUT("/:id/rotation-policy", handler.SetRotationPolicy)
+		v1.DELETE("/:id/rotation-policy", handler.RemoveRotationPolicy)
+		v1.GET("/:id/events", handler.ListKeyEvents)
+		v1.POST("/:id/enable", handler.EnableKey)
+		v1.POST("/:id/disable", handler.DisableKey)
+		v1.POST("/:id/schedule-deletion", handler.ScheduleKeyDeletion)
+		v1.POST("/:id/cancel-deletion", handler.CancelKeyDeletion)
+		v1.PUT("/:id/usage-policy", handler.SetUsagePolicy)
+		v1.DELETE("/:id/usage-policy", handler.RemoveUsagePolicy)
+		v1.POST("/:id/export", handler.ExportKey)
+		v1.GET("/:id/children", handler.ListChildKeys)
+		v1.GET("/:id/ancestry", handler.GetKeyAncestry)
+		v1.GET("/:id/public", handler.GetPublicKey)
+		v1.POST("/:id/tokens", handler.IssueSiteToken)
+		v1.GET("/:id/tokens", handler.ListSiteTokens)
+		v1.GET("/:id/usage", handler.GetKeyUsage)
+		v1.PUT("/:id/labels", handler.SetKeyLabels)
+		v1.POST("/:id/derive", handler.DeriveKey)
+		v1.POST("/:id/backup-shares", handler.BackupKeyShares)
 		v1.DELETE("/:id", handler.RemoveKey)
 	}
 
+	// Registered wrapping keys that key material may be exported to
+	recipients := router.Group("/export-recipients")
+	{
+		recipients.GET("", handler.ListExportRecipients)
+		recipients.POST("", handler.CreateExportRecipient)
+		recipients.DELETE("/:id", handler.DeleteExportRecipient)
+	}
+
+	// Site API tokens
+	tokens := router.Group("/tokens")
+	{
+		tokens.POST("/introspect", handler.IntrospectToken)
+		tokens.POST("/:id/revoke", handler.RevokeSiteToken)
+		tokens.POST("/:id/rotate", handler.RotateSiteToken)
+	}
+
+	// Expiry warning history
+	router.GET("/notifications", handler.ListNotifications)
+
 	// License routes
 	licenses := router.Group("/licenses")
 	{
 		licenses.POST("/generate", handler.GenerateLicense)
 		licenses.POST("/validate", handler.ValidateLicense)
+		licenses.POST("/redeem", handler.RedeemProductKey)
+		licenses.GET("/:id", handler.GetLicense)
+		licenses.POST("/:id/product-key", handler.IssueProductKey)
+		licenses.POST("/:id/transfer", handler.TransferLicense)
 	}
 
 	return router
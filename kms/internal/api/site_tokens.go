@@ -0,0 +1,334 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// Site token prefixes, by the site mode of the key they belong to
+const (
+	siteTokenPrefixProd = "site_live_"
+	siteTokenPrefixDev  = "site_dev_"
+	// siteTokenHintSize is how many random characters of a token are kept as its hint
+	siteTokenHintSize = 4
+)
+
+// IssueSiteTokenRequest represents a request to issue or rotate a site token
+type IssueSiteTokenRequest struct {
+	TTLSeconds int `json:"ttl_seconds" binding:"omitempty,min=60"` // Optional, tokens otherwise last as long as their key
+}
+
+// SiteTokenInfo represents a site token without its secret value
+type SiteTokenInfo struct {
+	ID          string     `json:"id"`
+	KeyID       string     `json:"key_id"`
+	Hint        string     `json:"hint"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy  string     `json:"replaced_by,omitempty"`
+	RotatedFrom string     `json:"rotated_from,omitempty"`
+}
+
+// SiteTokenResponse represents a newly issued site token
+// Token is only ever returned here; the server keeps its hash
+type SiteTokenResponse struct {
+	Token string `json:"token"`
+	SiteTokenInfo
+}
+
+// SiteTokenListResponse represents the tokens issued for a site key
+type SiteTokenListResponse struct {
+	KeyID  string          `json:"key_id"`
+	Tokens []SiteTokenInfo `json:"tokens"`
+}
+
+// IntrospectTokenRequest represents a request to check a site token
+type IntrospectTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// IntrospectTokenResponse represents the result of a token check
+// Inactive tokens only report active=false, so callers learn nothing about unknown or revoked tokens
+type IntrospectTokenResponse struct {
+	Active    bool       `json:"active"`
+	TokenID   string     `json:"token_id,omitempty"`
+	KeyID     string     `json:"key_id,omitempty"`
+	Site      string     `json:"site,omitempty"` // Owner of the site key
+	Mode      string     `json:"mode,omitempty"` // dev or prod
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// newSiteTokenInfo builds the public view of a site token
+func newSiteTokenInfo(token *storage.SiteToken) SiteTokenInfo {
+	return SiteTokenInfo{
+		ID:          token.ID,
+		KeyID:       token.KeyID,
+		Hint:        token.Hint,
+		Status:      string(token.Status),
+		CreatedAt:   token.CreatedAt,
+		ExpiresAt:   token.ExpiresAt,
+		RevokedAt:   token.RevokedAt,
+		ReplacedBy:  token.ReplacedBy,
+		RotatedFrom: token.RotatedFrom,
+	}
+}
+
+// siteTokenPrefix returns the token prefix for a site key
+func siteTokenPrefix(key *storage.Key) string {
+	if key.SiteMode == storage.SiteModeProd {
+		return siteTokenPrefixProd
+	}
+	return siteTokenPrefixDev
+}
+
+// bindSiteTokenRequest binds an optional issue or rotate request body
+// Writes the error response and returns false on failure
+func bindSiteTokenRequest(c *gin.Context) (*IssueSiteTokenRequest, bool) {
+	var req IssueSiteTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &req, true
+}
+
+// requireSiteTokenKey writes an error response and returns false unless tokens can be issued for the key
+func requireSiteTokenKey(c *gin.Context, key *storage.Key) bool {
+	if key.Level != storage.KeyLevelSite {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "site tokens can only be issued for site keys"})
+		return false
+	}
+	return requireUsableKey(c, key)
+}
+
+// newSiteToken generates a token value and its record for a site key
+// ttl <= 0 leaves the token without its own expiry
+func newSiteToken(key *storage.Key, ttl time.Duration) (string, *storage.SiteToken, error) {
+	prefix := siteTokenPrefix(key)
+	value, err := crypto.GenerateToken(prefix)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+	token := &storage.SiteToken{
+		ID:        uuid.New().String(),
+		KeyID:     key.ID,
+		Hint:      value[:len(prefix)+siteTokenHintSize],
+		TokenHash: storage.HashSiteToken(value),
+		Status:    storage.SiteTokenStatusActive,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+
+	return value, token, nil
+}
+
+// loadSiteToken looks up a site token by ID
+// Writes the error response and returns false if it does not exist
+func (h *Handler) loadSiteToken(c *gin.Context, tokenID string) (*storage.SiteToken, bool) {
+	token, err := h.store.GetSiteToken(tokenID)
+	if err != nil {
+		if err == errors.ErrSiteTokenNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "site token not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve site token"})
+		return nil, false
+	}
+	return token, true
+}
+
+// IssueSiteToken handles POST /keys/:id/tokens - Issue an API token for a site key
+func (h *Handler) IssueSiteToken(c *gin.Context) {
+	req, ok := bindSiteTokenRequest(c)
+	if !ok {
+		return
+	}
+
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if !requireSiteTokenKey(c, key) {
+		return
+	}
+
+	value, token, err := newSiteToken(key, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate site token"})
+		return
+	}
+
+	if err := h.store.StoreSiteToken(token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store site token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, SiteTokenResponse{Token: value, SiteTokenInfo: newSiteTokenInfo(token)})
+}
+
+// ListSiteTokens handles GET /keys/:id/tokens - List the tokens issued for a site key
+func (h *Handler) ListSiteTokens(c *gin.Context) {
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	tokens, err := h.store.ListSiteTokens(key.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list site tokens"})
+		return
+	}
+
+	resp := SiteTokenListResponse{KeyID: key.ID, Tokens: make([]SiteTokenInfo, 0, len(tokens))}
+	for _, token := range tokens {
+		resp.Tokens = append(resp.Tokens, newSiteTokenInfo(token))
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// RevokeSiteToken handles POST /tokens/:id/revoke - Revoke a site token
+func (h *Handler) RevokeSiteToken(c *gin.Context) {
+	token, err := h.store.RevokeSiteToken(c.Param("id"))
+	if err != nil {
+		if err == errors.ErrSiteTokenNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "site token not found"})
+			return
+		}
+		if err == errors.ErrSiteTokenRevoked {
+			c.JSON(http.StatusConflict, gin.H{"error": "site token is already revoked"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke site token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newSiteTokenInfo(token))
+}
+
+// RotateSiteToken handles POST /tokens/:id/rotate - Replace a site token without touching its key
+// The old token is revoked immediately
+func (h *Handler) RotateSiteToken(c *gin.Context) {
+	req, ok := bindSiteTokenRequest(c)
+	if !ok {
+		return
+	}
+
+	old, ok := h.loadSiteToken(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if old.Status == storage.SiteTokenStatusRevoked {
+		c.JSON(http.StatusConflict, gin.H{"error": "site token is already revoked"})
+		return
+	}
+
+	key, ok := h.loadKey(c, old.KeyID)
+	if !ok {
+		return
+	}
+	if !requireSiteTokenKey(c, key) {
+		return
+	}
+
+	// Without a ttl_seconds, the replacement keeps the lifetime of the old token
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl == 0 && old.ExpiresAt != nil {
+		ttl = old.ExpiresAt.Sub(old.CreatedAt)
+	}
+
+	value, token, err := newSiteToken(key, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate site token"})
+		return
+	}
+	token.RotatedFrom = old.ID
+
+	if err := h.store.RotateSiteToken(old.ID, token); err != nil {
+		if err == errors.ErrSiteTokenRevoked {
+			c.JSON(http.StatusConflict, gin.H{"error": "site token is already revoked"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate site token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, SiteTokenResponse{Token: value, SiteTokenInfo: newSiteTokenInfo(token)})
+}
+
+// IntrospectToken handles POST /tokens/introspect - Check whether a site token is authentic and active
+// A token is active while it is unrevoked and unexpired and its site key is active and unexpired
+func (h *Handler) IntrospectToken(c *gin.Context) {
+	var req IntrospectTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	inactive := IntrospectTokenResponse{Active: false}
+	if !strings.HasPrefix(req.Token, siteTokenPrefixProd) && !strings.HasPrefix(req.Token, siteTokenPrefixDev) {
+		c.JSON(http.StatusOK, inactive)
+		return
+	}
+
+	token, err := h.store.FindSiteToken(req.Token)
+	if err != nil {
+		if err == errors.ErrSiteTokenNotFound {
+			c.JSON(http.StatusOK, inactive)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve site token"})
+		return
+	}
+
+	now := time.Now().UTC()
+	if !token.IsActive(now) {
+		c.JSON(http.StatusOK, inactive)
+		return
+	}
+
+	key, err := h.store.GetKey(token.KeyID)
+	if err != nil {
+		if err == errors.ErrKeyNotFound {
+			c.JSON(http.StatusOK, inactive)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve key"})
+		return
+	}
+	if key.Level != storage.KeyLevelSite || !key.IsValid() {
+		c.JSON(http.StatusOK, inactive)
+		return
+	}
+
+	// The token stops working at whichever comes first, its own expiry or its key's
+	expiresAt := key.ExpiresAt
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(expiresAt) {
+		expiresAt = *token.ExpiresAt
+	}
+
+	c.JSON(http.StatusOK, IntrospectTokenResponse{
+		Active:    true,
+		TokenID:   token.ID,
+		KeyID:     key.ID,
+		Site:      key.Owner,
+		Mode:      string(key.SiteMode),
+		ExpiresAt: &expiresAt,
+	})
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/pkg/signedresponse"
+)
+
+// TimeTokenResponse represents a signed server time token
+// Offline verifiers use it as a trusted time source
+type TimeTokenResponse struct {
+	Time time.Time `json:"time"`
+
+	ResponseSignature *signedresponse.Envelope `json:"response_signature,omitempty"`
+}
+
+// SetResponseSignature attaches a response signature to the time token
+func (r *TimeTokenResponse) SetResponseSignature(envelope *signedresponse.Envelope) {
+	r.ResponseSignature = envelope
+}
+
+// TimeToken handles GET /time/token - Issue a signed server time token
+// Requires a response signing key; the optional nonce query parameter is included in the signature
+func (h *Handler) TimeToken(c *gin.Context) {
+	if h.cfg.ResponseSigningKeyID == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "response signing key is not configured"})
+		return
+	}
+
+	h.writeValidationResponse(c, c.Query("nonce"), &TimeTokenResponse{
+		Time: time.Now().UTC(),
+	})
+}
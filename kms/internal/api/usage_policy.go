@@ -0,0 +1,133 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// UsagePolicyResponse represents the usage policy of a key
+type UsagePolicyResponse struct {
+	KeyID       string               `json:"key_id"`
+	UsagePolicy *storage.UsagePolicy `json:"usage_policy"` // Null allows every operation
+}
+
+// requireOperation writes a forbidden response and returns false if the key's usage policy does not allow op
+func requireOperation(c *gin.Context, key *storage.Key, op storage.KeyOperation) bool {
+	if key.Permits(op) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s: key %s does not allow %s", errors.ErrOperationNotPermitted, key.ID, op)})
+	return false
+}
+
+// licenseExpiry works out when a license issued under key expires
+// Writes the error response and returns false if the request breaks the key's usage policy
+func licenseExpiry(c *gin.Context, key *storage.Key, licenseType string, expiresInSeconds int64, now time.Time) (time.Time, bool) {
+	if !requireOperation(c, key, storage.OperationLicenseIssue) {
+		return time.Time{}, false
+	}
+
+	if !key.PermitsLicenseType(licenseType) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s: key %s does not allow license type %q", errors.ErrOperationNotPermitted, key.ID, licenseType)})
+		return time.Time{}, false
+	}
+
+	if expiresInSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_seconds must not be negative"})
+		return time.Time{}, false
+	}
+
+	expiresAt := key.ExpiresAt
+	if expiresInSeconds > 0 {
+		expiresAt = now.Add(time.Duration(expiresInSeconds) * time.Second)
+	}
+
+	if key.UsagePolicy != nil && key.UsagePolicy.MaxLicenseValidityDays > 0 {
+		maxExpiresAt := now.Add(time.Duration(key.UsagePolicy.MaxLicenseValidityDays) * 24 * time.Hour)
+		if expiresInSeconds > 0 && expiresAt.After(maxExpiresAt) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s: key %s allows licenses of at most %d days", errors.ErrOperationNotPermitted, key.ID, key.UsagePolicy.MaxLicenseValidityDays)})
+			return time.Time{}, false
+		}
+		// Without an explicit expiry the license is capped at the policy maximum
+		if expiresAt.After(maxExpiresAt) {
+			expiresAt = maxExpiresAt
+		}
+	}
+
+	if expiresAt.After(key.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "license cannot expire after its key"})
+		return time.Time{}, false
+	}
+
+	return expiresAt, true
+}
+
+// validateUsagePolicy checks that a usage policy only names known operations
+func validateUsagePolicy(policy *storage.UsagePolicy) error {
+	for _, op := range policy.AllowedOperations {
+		known := false
+		for _, candidate := range storage.KeyOperations {
+			if op == candidate {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown operation %q", op)
+		}
+	}
+	if policy.MaxLicenseValidityDays < 0 {
+		return fmt.Errorf("max_license_validity_days must not be negative")
+	}
+	if policy.MaxLicenseValidityDays > storage.MaxPolicyDays {
+		return fmt.Errorf("max_license_validity_days must not exceed %d", storage.MaxPolicyDays)
+	}
+	return nil
+}
+
+// SetUsagePolicy handles PUT /keys/:id/usage-policy - Restrict what a key may be used for
+func (h *Handler) SetUsagePolicy(c *gin.Context) {
+	var policy storage.UsagePolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateUsagePolicy(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.updateUsagePolicy(c, &policy)
+}
+
+// RemoveUsagePolicy handles DELETE /keys/:id/usage-policy - Allow every operation again
+func (h *Handler) RemoveUsagePolicy(c *gin.Context) {
+	h.updateUsagePolicy(c, nil)
+}
+
+// updateUsagePolicy stores the usage policy of the key named in the path
+func (h *Handler) updateUsagePolicy(c *gin.Context, policy *storage.UsagePolicy) {
+	key, ok := h.loadKey(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	updated, err := h.store.SetUsagePolicy(key.ID, policy)
+	if err != nil {
+		if err == errors.ErrInvalidKeyState {
+			c.JSON(http.StatusConflict, gin.H{"error": "key material has been destroyed"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update usage policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, UsagePolicyResponse{KeyID: updated.ID, UsagePolicy: updated.UsagePolicy})
+}
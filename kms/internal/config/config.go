@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const (
@@ -20,8 +21,35 @@ const (
 	DefaultConfigPath = "./config/setting.json"
 	// DefaultEnvironmentConfigPath is the default path to environment.json file
 	DefaultEnvironmentConfigPath = "./config/environment.json"
+	// DefaultMaxTransfersPerPeriod is the default number of license transfers allowed per period
+	DefaultMaxTransfersPerPeriod = 3
+	// DefaultTransferPeriodDays is the default length of the license transfer period
+	DefaultTransferPeriodDays = 365
+	// DefaultSchedulerIntervalSeconds is the default interval between key maintenance sweeps
+	DefaultSchedulerIntervalSeconds = 60
+	// DefaultDeletionWaitingDays is the default waiting period before a key scheduled for deletion is destroyed
+	DefaultDeletionWaitingDays = 30
+	// RevocationPolicyBlock refuses to revoke, disable or delete a parent key while it has live child keys (the default)
+	RevocationPolicyBlock = "block"
+	// RevocationPolicyCascade applies revocation, disabling and deletion of a parent key to its descendants
+	RevocationPolicyCascade = "cascade"
+	// DefaultPublicKeyOverlapHours is how long rotated and retired public keys stay published
+	DefaultPublicKeyOverlapHours = 168
+	// DefaultImportTokenTTLSeconds is the default lifetime of a key import token
+	DefaultImportTokenTTLSeconds = 900
+	// DefaultRefreshChallengeTTLSeconds is the default lifetime of a key refresh challenge
+	DefaultRefreshChallengeTTLSeconds = 300
+	// DefaultNotificationIntervalSeconds is the default interval between expiry notification sweeps
+	DefaultNotificationIntervalSeconds = 3600
+	// DefaultUsageFlushIntervalSeconds is the default interval between writes of buffered key usage
+	DefaultUsageFlushIntervalSeconds = 10
+	// DefaultIdleKeyDays is how long an active key can go unused before it is flagged as idle
+	DefaultIdleKeyDays = 30
 )
 
+// DefaultNotificationThresholdDays are the default days before expiry at which warnings are sent
+var DefaultNotificationThresholdDays = []int{30, 7, 1}
+
 // Settings represents the settings from JSON file
 type Settings struct {
 	KMSDBPath string `json:"kms_db_path"`
@@ -52,6 +80,75 @@ type EnvironmentConfig struct {
 		AllowedOrigins  []string `json:"allowed_origins"`
 		AllowAllOrigins bool     `json:"allow_all_origins"`
 	} `json:"cors"`
+	Signing struct {
+		ResponseKeyID string `json:"response_key_id"`
+	} `json:"signing"`
+	Transfer struct {
+		MaxPerPeriod int `json:"max_per_period"`
+		PeriodDays   int `json:"period_days"`
+	} `json:"transfer"`
+	Scheduler struct {
+		IntervalSeconds int `json:"interval_seconds"`
+	} `json:"scheduler"`
+	Lifecycle struct {
+		DeletionWaitingDays int `json:"deletion_waiting_days"`
+	} `json:"lifecycle"`
+	Import struct {
+		TokenTTLSeconds int  `json:"token_ttl_seconds"`
+		AllowPlaintext  bool `json:"allow_plaintext"`
+	} `json:"import"`
+	Export struct {
+		AllowPlaintext bool `json:"allow_plaintext"`
+	} `json:"export"`
+	JWKS struct {
+		OverlapHours int `json:"overlap_hours"`
+	} `json:"jwks"`
+	Hierarchy struct {
+		RevocationPolicy string `json:"revocation_policy"`
+	} `json:"hierarchy"`
+	Refresh struct {
+		ChallengeTTLSeconds int `json:"challenge_ttl_seconds"`
+		GracePeriodSeconds  int `json:"grace_period_seconds"`
+	} `json:"refresh"`
+	Usage struct {
+		FlushIntervalSeconds int `json:"flush_interval_seconds"`
+		IdleDays             int `json:"idle_days"`
+	} `json:"usage"`
+	Notifications struct {
+		ThresholdDays   []int `json:"threshold_days"`
+		IntervalSeconds int   `json:"interval_seconds"`
+		Webhook         struct {
+			URL    string `json:"url"`
+			Secret string `json:"secret"`
+		} `json:"webhook"`
+		SMTP struct {
+			Host     string   `json:"host"`
+			Port     int      `json:"port"`
+			Username string   `json:"username"`
+			Password string   `json:"password"`
+			From     string   `json:"from"`
+			To       []string `json:"to"`
+		} `json:"smtp"`
+	} `json:"notifications"`
+}
+
+// NotificationConfig configures expiry warning notifications
+// Notices are only sent over the channels that are configured
+type NotificationConfig struct {
+	// ThresholdDays are the days before expiry at which a warning is sent
+	ThresholdDays []int
+	// Interval is how often keys and licenses are scanned for upcoming expiry
+	Interval time.Duration
+	// WebhookURL receives notices as signed JSON POSTs; webhooks are disabled when empty
+	WebhookURL string
+	// WebhookSecret is the HMAC-SHA256 key used to sign webhook bodies
+	WebhookSecret string
+	// SMTPAddr is the host:port of the mail server; email is disabled when empty
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	SMTPTo       []string
 }
 
 // Config holds the application configuration
@@ -61,6 +158,37 @@ type Config struct {
 	Port             string
 	CORSAllowedOrigins []string
 	CORSAllowAll     bool
+	// ResponseSigningKeyID is the ID of the Ed25519 key used to sign validation responses
+	// Responses are left unsigned when empty
+	ResponseSigningKeyID string
+	// MaxTransfersPerPeriod limits how often a license can be moved to a new fingerprint
+	MaxTransfersPerPeriod int
+	// TransferPeriod is the window MaxTransfersPerPeriod applies to
+	TransferPeriod time.Duration
+	// SchedulerInterval is how often the background scheduler applies rotations and lifecycle changes
+	SchedulerInterval time.Duration
+	// DeletionWaitingPeriod is how long a key stays pending deletion before its material is destroyed
+	DeletionWaitingPeriod time.Duration
+	// ImportTokenTTL is how long a key import token can be used after it is issued
+	ImportTokenTTL time.Duration
+	// AllowPlaintextExport enables GET /keys/:id/download; wrapped export is always available
+	AllowPlaintextExport bool
+	// AllowPlaintextImport enables key_material on POST /keys; wrapped import is always available
+	AllowPlaintextImport bool
+	// CascadeRevocation revokes, disables or deletes child keys with their parent instead of refusing the change
+	CascadeRevocation bool
+	// PublicKeyOverlap is how long public keys of rotated and retired versions stay in the JWKS
+	PublicKeyOverlap time.Duration
+	// RefreshChallengeTTL is how long a key refresh challenge can be answered after it is issued
+	RefreshChallengeTTL time.Duration
+	// RefreshGracePeriod is how long after expiry a key can still be refreshed; zero refuses expired keys
+	RefreshGracePeriod time.Duration
+	// Notifications configures expiry warnings for keys and licenses
+	Notifications NotificationConfig
+	// UsageFlushInterval is how often buffered key usage counters are written to the database
+	UsageFlushInterval time.Duration
+	// IdleKeyPeriod is how long an active key can go unused before listings flag it as idle
+	IdleKeyPeriod time.Duration
 }
 
 // loadSettingsFromFile loads settings from JSON file if it exists
@@ -277,12 +405,174 @@ func Load() (*Config, error) {
 		corsAllowAll = envConfig.CORS.AllowAllOrigins
 	}
 
+	// Load response signing key: environment variable overrides environment.json
+	responseSigningKeyID := ""
+	if envConfig != nil {
+		responseSigningKeyID = envConfig.Signing.ResponseKeyID
+	}
+	if envKeyID := os.Getenv("KMS_RESPONSE_SIGNING_KEY_ID"); envKeyID != "" {
+		responseSigningKeyID = envKeyID
+	}
+
+	// Load license transfer limits from environment.json
+	maxTransfers := DefaultMaxTransfersPerPeriod
+	transferPeriodDays := DefaultTransferPeriodDays
+	if envConfig != nil {
+		if envConfig.Transfer.MaxPerPeriod > 0 {
+			maxTransfers = envConfig.Transfer.MaxPerPeriod
+		}
+		if envConfig.Transfer.PeriodDays > 0 {
+			transferPeriodDays = envConfig.Transfer.PeriodDays
+		}
+	}
+
+	// Load scheduler interval from environment.json
+	schedulerIntervalSeconds := DefaultSchedulerIntervalSeconds
+	if envConfig != nil && envConfig.Scheduler.IntervalSeconds > 0 {
+		schedulerIntervalSeconds = envConfig.Scheduler.IntervalSeconds
+	}
+
+	// Load key deletion waiting period from environment.json
+	deletionWaitingDays := DefaultDeletionWaitingDays
+	if envConfig != nil && envConfig.Lifecycle.DeletionWaitingDays > 0 {
+		deletionWaitingDays = envConfig.Lifecycle.DeletionWaitingDays
+	}
+
+	// Load key import token lifetime from environment.json
+	importTokenTTLSeconds := DefaultImportTokenTTLSeconds
+	if envConfig != nil && envConfig.Import.TokenTTLSeconds > 0 {
+		importTokenTTLSeconds = envConfig.Import.TokenTTLSeconds
+	}
+
+	// Plaintext key download stays disabled unless environment.json enables it
+	allowPlaintextExport := envConfig != nil && envConfig.Export.AllowPlaintext
+
+	// So does registering keys with plaintext key material
+	allowPlaintextImport := envConfig != nil && envConfig.Import.AllowPlaintext
+
+	// Load the parent key revocation policy from environment.json
+	cascadeRevocation := false
+	if envConfig != nil && envConfig.Hierarchy.RevocationPolicy != "" {
+		switch envConfig.Hierarchy.RevocationPolicy {
+		case RevocationPolicyCascade:
+			cascadeRevocation = true
+		case RevocationPolicyBlock:
+		default:
+			return nil, fmt.Errorf("hierarchy.revocation_policy must be %q or %q", RevocationPolicyBlock, RevocationPolicyCascade)
+		}
+	}
+
+	// Load the public key publication overlap from environment.json
+	publicKeyOverlapHours := DefaultPublicKeyOverlapHours
+	if envConfig != nil && envConfig.JWKS.OverlapHours > 0 {
+		publicKeyOverlapHours = envConfig.JWKS.OverlapHours
+	}
+
+	// Load key refresh challenge lifetime and expiry grace period from environment.json
+	refreshChallengeTTLSeconds := DefaultRefreshChallengeTTLSeconds
+	refreshGracePeriodSeconds := 0
+	if envConfig != nil {
+		if envConfig.Refresh.ChallengeTTLSeconds > 0 {
+			refreshChallengeTTLSeconds = envConfig.Refresh.ChallengeTTLSeconds
+		}
+		if envConfig.Refresh.GracePeriodSeconds > 0 {
+			refreshGracePeriodSeconds = envConfig.Refresh.GracePeriodSeconds
+		}
+	}
+
+	// Load key usage flush interval and idle period from environment.json
+	usageFlushIntervalSeconds := DefaultUsageFlushIntervalSeconds
+	idleKeyDays := DefaultIdleKeyDays
+	if envConfig != nil {
+		if envConfig.Usage.FlushIntervalSeconds > 0 {
+			usageFlushIntervalSeconds = envConfig.Usage.FlushIntervalSeconds
+		}
+		if envConfig.Usage.IdleDays > 0 {
+			idleKeyDays = envConfig.Usage.IdleDays
+		}
+	}
+
+	notifications, err := loadNotificationConfig(envConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		MasterKey:        masterKey,
 		DBPath:           dbPath,
 		Port:             port,
 		CORSAllowedOrigins: corsAllowedOrigins,
 		CORSAllowAll:     corsAllowAll,
+		ResponseSigningKeyID: responseSigningKeyID,
+		MaxTransfersPerPeriod: maxTransfers,
+		TransferPeriod:       time.Duration(transferPeriodDays) * 24 * time.Hour,
+		SchedulerInterval:    time.Duration(schedulerIntervalSeconds) * time.Second,
+		DeletionWaitingPeriod: time.Duration(deletionWaitingDays) * 24 * time.Hour,
+		ImportTokenTTL:       time.Duration(importTokenTTLSeconds) * time.Second,
+		AllowPlaintextExport: allowPlaintextExport,
+		AllowPlaintextImport: allowPlaintextImport,
+		CascadeRevocation:    cascadeRevocation,
+		PublicKeyOverlap:     time.Duration(publicKeyOverlapHours) * time.Hour,
+		RefreshChallengeTTL:  time.Duration(refreshChallengeTTLSeconds) * time.Second,
+		RefreshGracePeriod:   time.Duration(refreshGracePeriodSeconds) * time.Second,
+		Notifications:        notifications,
+		UsageFlushInterval:   time.Duration(usageFlushIntervalSeconds) * time.Second,
+		IdleKeyPeriod:        time.Duration(idleKeyDays) * 24 * time.Hour,
 	}, nil
 }
 
+// loadNotificationConfig loads expiry notification settings from environment.json
+// The webhook secret and SMTP password can be overridden by environment variables
+func loadNotificationConfig(envConfig *EnvironmentConfig) (NotificationConfig, error) {
+	notifications := NotificationConfig{
+		ThresholdDays: DefaultNotificationThresholdDays,
+		Interval:      DefaultNotificationIntervalSeconds * time.Second,
+	}
+
+	if envConfig != nil {
+		env := envConfig.Notifications
+		if len(env.ThresholdDays) > 0 {
+			for _, days := range env.ThresholdDays {
+				if days <= 0 {
+					return notifications, fmt.Errorf("notifications.threshold_days must be positive")
+				}
+			}
+			notifications.ThresholdDays = env.ThresholdDays
+		}
+		if env.IntervalSeconds > 0 {
+			notifications.Interval = time.Duration(env.IntervalSeconds) * time.Second
+		}
+
+		notifications.WebhookURL = env.Webhook.URL
+		notifications.WebhookSecret = env.Webhook.Secret
+
+		if env.SMTP.Host != "" {
+			port := env.SMTP.Port
+			if port == 0 {
+				port = 25
+			}
+			notifications.SMTPAddr = fmt.Sprintf("%s:%d", env.SMTP.Host, port)
+		}
+		notifications.SMTPUsername = env.SMTP.Username
+		notifications.SMTPPassword = env.SMTP.Password
+		notifications.SMTPFrom = env.SMTP.From
+		notifications.SMTPTo = env.SMTP.To
+	}
+
+	if secret := os.Getenv("KMS_WEBHOOK_SECRET"); secret != "" {
+		notifications.WebhookSecret = secret
+	}
+	if password := os.Getenv("KMS_SMTP_PASSWORD"); password != "" {
+		notifications.SMTPPassword = password
+	}
+
+	if notifications.WebhookURL != "" && notifications.WebhookSecret == "" {
+		return notifications, fmt.Errorf("notifications.webhook.secret is required when a webhook URL is set")
+	}
+	if notifications.SMTPAddr != "" && (notifications.SMTPFrom == "" || len(notifications.SMTPTo) == 0) {
+		return notifications, fmt.Errorf("notifications.smtp.from and notifications.smtp.to are required when an SMTP host is set")
+	}
+
+	return notifications, nil
+}
+
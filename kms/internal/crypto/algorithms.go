@@ -0,0 +1,356 @@
+package crypto
+
+import (
+	"bytes"
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// Key algorithms
+// Ed25519 keys are stored raw (64-byte private key, 32-byte public key);
+// ECDSA and RSA keys are stored as PKCS#8 private keys and PKIX public keys
+const (
+	AlgorithmAES256GCM   = "aes-256-gcm"
+	AlgorithmHMACSHA256  = "hmac-sha256"
+	AlgorithmEd25519     = "ed25519"
+	AlgorithmECDSAP256   = "ecdsa-p256"
+	AlgorithmECDSAP384   = "ecdsa-p384"
+	AlgorithmRSAPSS2048  = "rsa-pss-2048"
+	AlgorithmRSAPSS3072  = "rsa-pss-3072"
+	AlgorithmRSAPSS4096  = "rsa-pss-4096"
+	minHMACKeySize       = 32
+	maxHMACKeySize       = 64
+	rsaPSSSaltLengthHash = rsa.PSSSaltLengthEqualsHash
+)
+
+// rsaKeyBits maps RSA-PSS algorithms to their modulus size
+var rsaKeyBits = map[string]int{
+	AlgorithmRSAPSS2048: 2048,
+	AlgorithmRSAPSS3072: 3072,
+	AlgorithmRSAPSS4096: 4096,
+}
+
+// IsAsymmetricAlgorithm reports whether the algorithm has a public key
+func IsAsymmetricAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case AlgorithmEd25519, AlgorithmECDSAP256, AlgorithmECDSAP384:
+		return true
+	}
+	_, ok := rsaKeyBits[algorithm]
+	return ok
+}
+
+// IsSupportedAlgorithm reports whether the algorithm is known
+func IsSupportedAlgorithm(algorithm string) bool {
+	return algorithm == AlgorithmAES256GCM || algorithm == AlgorithmHMACSHA256 || IsAsymmetricAlgorithm(algorithm)
+}
+
+// SignatureAlgorithm names the signature scheme produced by Sign (or SignPrehashed when prehashed)
+func SignatureAlgorithm(algorithm string, prehashed bool) string {
+	switch algorithm {
+	case AlgorithmEd25519:
+		if prehashed {
+			return "Ed25519ph"
+		}
+		return "Ed25519"
+	case AlgorithmECDSAP256:
+		return "ECDSA-P256-SHA256"
+	case AlgorithmECDSAP384:
+		return "ECDSA-P384-SHA384"
+	case AlgorithmHMACSHA256:
+		return "HMAC-SHA256"
+	}
+	if _, ok := rsaKeyBits[algorithm]; ok {
+		return "RSA-PSS-SHA256"
+	}
+	return ""
+}
+
+// DigestSize returns the digest length SignPrehashed expects for the algorithm, or 0 if unsupported
+func DigestSize(algorithm string) int {
+	switch algorithm {
+	case AlgorithmEd25519:
+		return sha512.Size
+	case AlgorithmECDSAP256:
+		return sha256.Size
+	case AlgorithmECDSAP384:
+		return sha512.Size384
+	}
+	if _, ok := rsaKeyBits[algorithm]; ok {
+		return sha256.Size
+	}
+	return 0
+}
+
+// GenerateKeyMaterial generates a new key for the algorithm
+// Returns the public key (nil for symmetric algorithms) and the private key or secret
+// The private key must be encrypted before storage
+func GenerateKeyMaterial(algorithm string) (publicKey, privateKey []byte, err error) {
+	switch algorithm {
+	case AlgorithmAES256GCM, AlgorithmHMACSHA256:
+		privateKey, err = GenerateSymmetricKey()
+		return nil, privateKey, err
+	case AlgorithmEd25519:
+		return GenerateAsymmetricKeyPair()
+	case AlgorithmECDSAP256, AlgorithmECDSAP384:
+		priv, err := ecdsa.GenerateKey(ecdsaCurve(algorithm), rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate ECDSA key: %w", err)
+		}
+		return marshalKeyPair(priv, &priv.PublicKey)
+	}
+
+	bits, ok := rsaKeyBits[algorithm]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate RSA key: %w", err)
+	}
+	return marshalKeyPair(priv, &priv.PublicKey)
+}
+
+// ImportKeyMaterial validates imported key material for the algorithm
+// Ed25519 keys are 64-byte raw private keys; ECDSA keys are PKCS#8 or SEC 1 DER; RSA keys are
+// PKCS#8 or PKCS#1 DER. Returns the public key and the private key in its stored form.
+func ImportKeyMaterial(algorithm string, material []byte) (publicKey, privateKey []byte, err error) {
+	switch algorithm {
+	case AlgorithmAES256GCM:
+		if len(material) != SymmetricKeySize {
+			return nil, nil, fmt.Errorf("symmetric key must be %d bytes (256 bits)", SymmetricKeySize)
+		}
+		return nil, append([]byte(nil), material...), nil
+	case AlgorithmHMACSHA256:
+		if len(material) < minHMACKeySize || len(material) > maxHMACKeySize {
+			return nil, nil, fmt.Errorf("HMAC key must be %d to %d bytes", minHMACKeySize, maxHMACKeySize)
+		}
+		return nil, append([]byte(nil), material...), nil
+	case AlgorithmEd25519:
+		if len(material) != Ed25519PrivateKeySize {
+			return nil, nil, fmt.Errorf("asymmetric private key must be %d bytes (Ed25519)", Ed25519PrivateKeySize)
+		}
+		// The second half must be the public key of the seed, or signatures would not verify
+		priv := ed25519.NewKeyFromSeed(material[:ed25519.SeedSize])
+		if !bytes.Equal(priv, material) {
+			return nil, nil, fmt.Errorf("public half of the Ed25519 key does not match its seed")
+		}
+		return append([]byte(nil), priv.Public().(ed25519.PublicKey)...), priv, nil
+	case AlgorithmECDSAP256, AlgorithmECDSAP384:
+		priv, err := parseECDSAPrivateKey(material)
+		if err != nil {
+			return nil, nil, err
+		}
+		if priv.Curve != ecdsaCurve(algorithm) {
+			return nil, nil, fmt.Errorf("ECDSA key is not on curve %s", ecdsaCurve(algorithm).Params().Name)
+		}
+		return marshalKeyPair(priv, &priv.PublicKey)
+	}
+
+	bits, ok := rsaKeyBits[algorithm]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	priv, err := parseRSAPrivateKey(material)
+	if err != nil {
+		return nil, nil, err
+	}
+	if priv.N.BitLen() != bits {
+		return nil, nil, fmt.Errorf("RSA key must be %d bits", bits)
+	}
+	return marshalKeyPair(priv, &priv.PublicKey)
+}
+
+// Sign signs a message with the private key (or HMAC secret) of the algorithm
+// The caller is responsible for zeroing the private key after use
+func Sign(algorithm string, privateKey, message []byte) ([]byte, error) {
+	switch algorithm {
+	case AlgorithmEd25519:
+		return SignMessage(privateKey, message)
+	case AlgorithmHMACSHA256:
+		mac := hmac.New(sha256.New, privateKey)
+		mac.Write(message)
+		return mac.Sum(nil), nil
+	}
+
+	hash, ok := signatureHash(algorithm)
+	if !ok {
+		return nil, fmt.Errorf("algorithm %q cannot sign", algorithm)
+	}
+	h := hash.New()
+	h.Write(message)
+	return SignPrehashed(algorithm, privateKey, h.Sum(nil))
+}
+
+// MessageDigest hashes a message the way Sign does before signing it
+// Returns false for algorithms that sign messages directly (Ed25519 and HMAC-SHA256)
+func MessageDigest(algorithm string, message []byte) ([]byte, bool) {
+	hash, ok := signatureHash(algorithm)
+	if !ok {
+		return nil, false
+	}
+	h := hash.New()
+	h.Write(message)
+	return h.Sum(nil), true
+}
+
+// SignPrehashed signs a digest of DigestSize(algorithm) bytes
+// Ed25519 keys sign SHA-512 digests with Ed25519ph
+func SignPrehashed(algorithm string, privateKey, digest []byte) ([]byte, error) {
+	if size := DigestSize(algorithm); size == 0 || len(digest) != size {
+		if size == 0 {
+			return nil, fmt.Errorf("algorithm %q cannot sign digests", algorithm)
+		}
+		return nil, fmt.Errorf("digest must be %d bytes for %s", size, algorithm)
+	}
+
+	switch algorithm {
+	case AlgorithmEd25519:
+		return SignDigest(privateKey, digest)
+	case AlgorithmECDSAP256, AlgorithmECDSAP384:
+		priv, err := parseECDSAPrivateKey(privateKey)
+		if err != nil {
+			return nil, errors.ErrInvalidKeyMaterial
+		}
+		return ecdsa.SignASN1(rand.Reader, priv, digest)
+	}
+
+	priv, err := parseRSAPrivateKey(privateKey)
+	if err != nil {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+	return rsa.SignPSS(rand.Reader, priv, stdcrypto.SHA256, digest, &rsa.PSSOptions{SaltLength: rsaPSSSaltLengthHash})
+}
+
+// Verify verifies a signature over a message
+// verificationKey is the public key, or the secret for HMAC keys
+func Verify(algorithm string, verificationKey, message, signature []byte) (bool, error) {
+	switch algorithm {
+	case AlgorithmEd25519:
+		return ValidateSignature(verificationKey, message, signature)
+	case AlgorithmHMACSHA256:
+		expected, err := Sign(algorithm, verificationKey, message)
+		if err != nil {
+			return false, err
+		}
+		return hmac.Equal(expected, signature), nil
+	}
+
+	hash, ok := signatureHash(algorithm)
+	if !ok {
+		return false, fmt.Errorf("algorithm %q cannot verify", algorithm)
+	}
+	h := hash.New()
+	h.Write(message)
+	return VerifyPrehashed(algorithm, verificationKey, h.Sum(nil), signature)
+}
+
+// VerifyPrehashed verifies a signature over a digest of DigestSize(algorithm) bytes
+func VerifyPrehashed(algorithm string, publicKey, digest, signature []byte) (bool, error) {
+	if size := DigestSize(algorithm); size == 0 || len(digest) != size {
+		if size == 0 {
+			return false, fmt.Errorf("algorithm %q cannot verify digests", algorithm)
+		}
+		return false, fmt.Errorf("digest must be %d bytes for %s", size, algorithm)
+	}
+
+	if algorithm == AlgorithmEd25519 {
+		return ValidateDigestSignature(publicKey, digest, signature)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return false, errors.ErrInvalidKeyMaterial
+	}
+
+	switch pub := parsed.(type) {
+	case *ecdsa.PublicKey:
+		if pub.Curve != ecdsaCurve(algorithm) {
+			return false, errors.ErrInvalidKeyMaterial
+		}
+		return ecdsa.VerifyASN1(pub, digest, signature), nil
+	case *rsa.PublicKey:
+		if _, ok := rsaKeyBits[algorithm]; !ok {
+			return false, errors.ErrInvalidKeyMaterial
+		}
+		err := rsa.VerifyPSS(pub, stdcrypto.SHA256, digest, signature, &rsa.PSSOptions{SaltLength: rsaPSSSaltLengthHash})
+		return err == nil, nil
+	}
+	return false, errors.ErrInvalidKeyMaterial
+}
+
+// signatureHash returns the message hash used by ECDSA and RSA-PSS algorithms
+func signatureHash(algorithm string) (stdcrypto.Hash, bool) {
+	switch algorithm {
+	case AlgorithmECDSAP256:
+		return stdcrypto.SHA256, true
+	case AlgorithmECDSAP384:
+		return stdcrypto.SHA384, true
+	}
+	if _, ok := rsaKeyBits[algorithm]; ok {
+		return stdcrypto.SHA256, true
+	}
+	return 0, false
+}
+
+// ecdsaCurve returns the curve of an ECDSA algorithm
+func ecdsaCurve(algorithm string) elliptic.Curve {
+	if algorithm == AlgorithmECDSAP384 {
+		return elliptic.P384()
+	}
+	return elliptic.P256()
+}
+
+// marshalKeyPair encodes a private key as PKCS#8 and its public key as PKIX
+func marshalKeyPair(priv, pub interface{}) (publicKey, privateKey []byte, err error) {
+	privateKey, err = x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	publicKey, err = x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	return publicKey, privateKey, nil
+}
+
+// parseECDSAPrivateKey parses a PKCS#8 or SEC 1 DER ECDSA private key
+func parseECDSAPrivateKey(der []byte) (*ecdsa.PrivateKey, error) {
+	if parsed, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if priv, ok := parsed.(*ecdsa.PrivateKey); ok {
+			return priv, nil
+		}
+		return nil, fmt.Errorf("private key is not an ECDSA key")
+	}
+	priv, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ECDSA private key: must be PKCS#8 or SEC 1 DER")
+	}
+	return priv, nil
+}
+
+// parseRSAPrivateKey parses a PKCS#8 or PKCS#1 DER RSA private key
+func parseRSAPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if parsed, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if priv, ok := parsed.(*rsa.PrivateKey); ok {
+			return priv, nil
+		}
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RSA private key: must be PKCS#8 or PKCS#1 DER")
+	}
+	return priv, nil
+}
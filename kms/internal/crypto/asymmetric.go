@@ -1,8 +1,11 @@
 package crypto
 
 import (
+	stdcrypto "crypto"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
 
 	"github.com/atprof/license-server/kms/pkg/errors"
 )
@@ -46,3 +49,41 @@ func ValidateSignature(publicKey, message, signature []byte) (bool, error) {
 	return valid, nil
 }
 
+
+// SignMessage signs a message with an Ed25519 private key
+// The caller is responsible for zeroing the private key after use
+func SignMessage(privateKey, message []byte) ([]byte, error) {
+	if len(privateKey) != Ed25519PrivateKeySize {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+
+	return ed25519.Sign(privateKey, message), nil
+}
+
+// SignDigest signs a SHA-512 digest with Ed25519ph (pre-hashed Ed25519)
+// The caller is responsible for zeroing the private key after use
+func SignDigest(privateKey, digest []byte) ([]byte, error) {
+	if len(privateKey) != Ed25519PrivateKeySize {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+	if len(digest) != sha512.Size {
+		return nil, fmt.Errorf("digest must be %d bytes (SHA-512)", sha512.Size)
+	}
+
+	return ed25519.PrivateKey(privateKey).Sign(nil, digest, &ed25519.Options{Hash: stdcrypto.SHA512})
+}
+
+// ValidateDigestSignature validates an Ed25519ph signature over a SHA-512 digest
+// Returns true if signature is valid, false otherwise
+func ValidateDigestSignature(publicKey, digest, signature []byte) (bool, error) {
+	if len(publicKey) != Ed25519PublicKeySize {
+		return false, errors.ErrInvalidKeyMaterial
+	}
+
+	if len(signature) != Ed25519SignatureSize {
+		return false, errors.ErrInvalidSignature
+	}
+
+	err := ed25519.VerifyWithOptions(publicKey, digest, signature, &ed25519.Options{Hash: stdcrypto.SHA512})
+	return err == nil, nil
+}
@@ -0,0 +1,154 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// Self-describing ciphertext blob layout:
+//
+//	magic "KMS" (3) | format (1) | key ID length (1) | key ID | key version (4, big endian) | nonce (12) | AES-256-GCM sealed data
+//
+// The header and the canonical encryption context are bound as additional authenticated data,
+// so a blob only decrypts with the same key version and the same encryption context.
+const (
+	ciphertextMagic    = "KMS"
+	ciphertextFormatV1 = 1
+	gcmNonceSize       = 12
+
+	// MaxPlaintextSize is the largest plaintext accepted by EncryptWithContext
+	MaxPlaintextSize = 4096
+)
+
+// CiphertextHeader describes which key version produced a ciphertext blob
+type CiphertextHeader struct {
+	KeyID      string
+	KeyVersion uint32
+}
+
+// EncryptWithContext encrypts plaintext with a 256-bit key using AES-256-GCM
+// Returns a self-describing ciphertext blob carrying the key ID and version
+func EncryptWithContext(key []byte, keyID string, keyVersion uint32, plaintext []byte, context map[string]string) ([]byte, error) {
+	if len(key) != SymmetricKeySize {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+	if len(keyID) == 0 || len(keyID) > 255 {
+		return nil, fmt.Errorf("%w: key ID must be 1-255 bytes", errors.ErrEncryptionFailed)
+	}
+	if len(plaintext) > MaxPlaintextSize {
+		return nil, fmt.Errorf("%w: plaintext exceeds %d bytes", errors.ErrEncryptionFailed, MaxPlaintextSize)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrEncryptionFailed, err)
+	}
+
+	header := make([]byte, 0, len(ciphertextMagic)+2+len(keyID)+4)
+	header = append(header, ciphertextMagic...)
+	header = append(header, ciphertextFormatV1, byte(len(keyID)))
+	header = append(header, keyID...)
+	header = binary.BigEndian.AppendUint32(header, keyVersion)
+
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("%w: failed to generate nonce: %v", errors.ErrEncryptionFailed, err)
+	}
+
+	blob := append(header, nonce...)
+	return gcm.Seal(blob, nonce, plaintext, additionalData(header, context)), nil
+}
+
+// ParseCiphertextHeader reads the key ID and version from a ciphertext blob without decrypting it
+func ParseCiphertextHeader(blob []byte) (*CiphertextHeader, error) {
+	header, _, err := splitCiphertext(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	keyIDLen := int(header[len(ciphertextMagic)+1])
+	keyID := header[len(ciphertextMagic)+2 : len(ciphertextMagic)+2+keyIDLen]
+	return &CiphertextHeader{
+		KeyID:      string(keyID),
+		KeyVersion: binary.BigEndian.Uint32(header[len(header)-4:]),
+	}, nil
+}
+
+// DecryptWithContext decrypts a ciphertext blob produced by EncryptWithContext
+// The encryption context must match the one used for encryption
+func DecryptWithContext(key, blob []byte, context map[string]string) ([]byte, error) {
+	if len(key) != SymmetricKeySize {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+
+	header, body, err := splitCiphertext(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrDecryptionFailed, err)
+	}
+
+	nonce, sealed := body[:gcmNonceSize], body[gcmNonceSize:]
+	plaintext, err := gcm.Open(nil, nonce, sealed, additionalData(header, context))
+	if err != nil {
+		return nil, errors.ErrDecryptionFailed
+	}
+
+	return plaintext, nil
+}
+
+// splitCiphertext separates the header from the nonce and sealed data
+func splitCiphertext(blob []byte) (header, body []byte, err error) {
+	prefix := len(ciphertextMagic) + 2
+	if len(blob) < prefix || string(blob[:len(ciphertextMagic)]) != ciphertextMagic {
+		return nil, nil, fmt.Errorf("%w: not a KMS ciphertext", errors.ErrDecryptionFailed)
+	}
+	if blob[len(ciphertextMagic)] != ciphertextFormatV1 {
+		return nil, nil, fmt.Errorf("%w: unsupported ciphertext format %d", errors.ErrDecryptionFailed, blob[len(ciphertextMagic)])
+	}
+
+	headerLen := prefix + int(blob[len(ciphertextMagic)+1]) + 4
+	if len(blob) < headerLen+gcmNonceSize+16 {
+		return nil, nil, fmt.Errorf("%w: ciphertext too short", errors.ErrDecryptionFailed)
+	}
+
+	return blob[:headerLen], blob[headerLen:], nil
+}
+
+// additionalData binds the header and the canonical encryption context
+// Context entries are sorted by key and length-prefixed so the encoding is unambiguous
+func additionalData(header []byte, context map[string]string) []byte {
+	keys := make([]string, 0, len(context))
+	for k := range context {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	aad := append([]byte{}, header...)
+	aad = binary.BigEndian.AppendUint32(aad, uint32(len(keys)))
+	for _, k := range keys {
+		aad = binary.BigEndian.AppendUint32(aad, uint32(len(k)))
+		aad = append(aad, k...)
+		aad = binary.BigEndian.AppendUint32(aad, uint32(len(context[k])))
+		aad = append(aad, context[k]...)
+	}
+	return aad
+}
+
+// newGCM creates an AES-GCM AEAD for the key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
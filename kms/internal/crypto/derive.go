@@ -0,0 +1,25 @@
+package crypto
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// derivationHKDFInfo prefixes the context in the HKDF info, keeping derived keys apart from other HKDF uses
+const derivationHKDFInfo = "kms derived key v1:"
+
+// DeriveKey derives a 256-bit subkey from parent key material and a context with HKDF-SHA256
+// The same parent material and context always yield the same subkey
+// The caller must zero the returned bytes after use
+func DeriveKey(parentKey []byte, context string) ([]byte, error) {
+	if len(parentKey) == 0 {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+	if context == "" {
+		return nil, fmt.Errorf("derivation context is required")
+	}
+	return hkdf.Key(sha256.New, parentKey, nil, derivationHKDFInfo+context, SymmetricKeySize)
+}
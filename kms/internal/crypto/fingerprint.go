@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+const (
+	// KeyCheckValueSize is the length of a key check value in bytes, shown as 6 hex digits
+	KeyCheckValueSize = 3
+	// keyCheckValueMessage is the fixed string HMAC keys are checked with
+	keyCheckValueMessage = "kms key check value"
+)
+
+// KeyCheckValue returns the key check value (KCV) of symmetric key material
+// AES keys encrypt a block of zeros; HMAC keys MAC a fixed string
+// The first 3 bytes are returned as uppercase hex: enough to compare keys, too short to reveal them
+func KeyCheckValue(algorithm string, key []byte) (string, error) {
+	if len(key) == 0 {
+		return "", errors.ErrInvalidKeyMaterial
+	}
+
+	var check []byte
+	switch algorithm {
+	case AlgorithmAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return "", errors.ErrInvalidKeyMaterial
+		}
+		check = make([]byte, aes.BlockSize)
+		block.Encrypt(check, check)
+	case AlgorithmHMACSHA256:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(keyCheckValueMessage))
+		check = mac.Sum(nil)
+	default:
+		return "", fmt.Errorf("key check values are only defined for symmetric algorithms, not %q", algorithm)
+	}
+
+	return strings.ToUpper(hex.EncodeToString(check[:KeyCheckValueSize])), nil
+}
+
+// PublicKeyFingerprint returns the SHA-256 fingerprint of an asymmetric public key as lowercase hex
+// The hash is over the PKIX DER encoding, so it matches `openssl pkey -pubin -outform DER | sha256sum`
+func PublicKeyFingerprint(algorithm string, publicKey []byte) (string, error) {
+	der, err := publicKeyDER(algorithm, publicKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"` // OKP and EC keys
+	X   string `json:"x,omitempty"`   // OKP and EC keys
+	Y   string `json:"y,omitempty"`   // EC keys
+	N   string `json:"n,omitempty"`   // RSA keys
+	E   string `json:"e,omitempty"`   // RSA keys
+}
+
+// NewJWK encodes the public key of an asymmetric algorithm as a signature verification JWK
+func NewJWK(algorithm string, publicKey []byte, kid string) (*JWK, error) {
+	jwk := &JWK{Kid: kid, Use: "sig"}
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	if algorithm == AlgorithmEd25519 {
+		if len(publicKey) != Ed25519PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key")
+		}
+		jwk.Kty, jwk.Crv, jwk.Alg, jwk.X = "OKP", "Ed25519", "EdDSA", b64(publicKey)
+		return jwk, nil
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	switch pub := parsed.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty, jwk.Crv = "EC", pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+		if algorithm == AlgorithmECDSAP384 {
+			jwk.Alg = "ES384"
+		} else {
+			jwk.Alg = "ES256"
+		}
+	case *rsa.PublicKey:
+		jwk.Kty, jwk.Alg = "RSA", "PS256"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	default:
+		return nil, fmt.Errorf("unsupported public key type for %s", algorithm)
+	}
+
+	return jwk, nil
+}
+
+// PublicKeyPEM encodes the public key of an asymmetric algorithm as a PKIX "PUBLIC KEY" PEM block
+// Ed25519 keys are stored raw and converted to PKIX first
+func PublicKeyPEM(algorithm string, publicKey []byte) (string, error) {
+	der, err := publicKeyDER(algorithm, publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// publicKeyDER returns the PKIX DER encoding of the public key of an asymmetric algorithm
+// Ed25519 keys are stored raw and converted to PKIX first
+func publicKeyDER(algorithm string, publicKey []byte) ([]byte, error) {
+	if algorithm == AlgorithmEd25519 {
+		if len(publicKey) != Ed25519PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key")
+		}
+		return x509.MarshalPKIXPublicKey(ed25519.PublicKey(publicKey))
+	}
+	if _, err := x509.ParsePKIXPublicKey(publicKey); err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	return publicKey, nil
+}
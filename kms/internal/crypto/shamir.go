@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// MaxSecretShares is the most shares a secret can be split into
+// Every share needs its own non-zero x coordinate in GF(256)
+const MaxSecretShares = 255
+
+// SplitSecret splits a secret into parts shares with Shamir's secret sharing over GF(256)
+// Any threshold shares recover the secret; fewer reveal nothing about it
+// Each share is one byte longer than the secret: the polynomial values, then the x coordinate
+func SplitSecret(secret []byte, parts, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+	if threshold < 2 || threshold > parts || parts > MaxSecretShares {
+		return nil, fmt.Errorf("threshold must be between 2 and the number of shares, which is at most %d", MaxSecretShares)
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	// Each secret byte is the constant term of its own random polynomial of degree threshold-1
+	coefficients := make([]byte, threshold-1)
+	defer func() {
+		for i := range coefficients {
+			coefficients[i] = 0
+		}
+	}()
+	for b, value := range secret {
+		if _, err := rand.Read(coefficients); err != nil {
+			return nil, fmt.Errorf("failed to generate share coefficients: %w", err)
+		}
+		for _, share := range shares {
+			x := share[len(secret)]
+			// Horner's rule, highest coefficient first
+			var y byte
+			for j := len(coefficients) - 1; j >= 0; j-- {
+				y = gfMul(y, x) ^ coefficients[j]
+			}
+			share[b] = gfMul(y, x) ^ value
+		}
+	}
+
+	return shares, nil
+}
+
+// CombineShares recovers a secret from shares made by SplitSecret
+// Fewer shares than the threshold yield a wrong secret rather than an error,
+// so callers must check the result, for example by decrypting with it
+// The caller must zero the returned bytes after use
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("at least 2 shares are required")
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("share is too short")
+	}
+
+	xs := make([]byte, len(shares))
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, fmt.Errorf("shares differ in length")
+		}
+		xs[i] = share[size-1]
+		if xs[i] == 0 {
+			return nil, fmt.Errorf("share has no x coordinate")
+		}
+		for _, x := range xs[:i] {
+			if x == xs[i] {
+				return nil, fmt.Errorf("duplicate share %d", xs[i])
+			}
+		}
+	}
+
+	// Lagrange interpolation at x = 0; subtraction in GF(256) is XOR
+	basis := make([]byte, len(shares))
+	for i := range shares {
+		basis[i] = 1
+		for j := range shares {
+			if i != j {
+				basis[i] = gfMul(basis[i], gfMul(xs[j], gfInv(xs[i]^xs[j])))
+			}
+		}
+	}
+
+	secret := make([]byte, size-1)
+	for b := range secret {
+		var value byte
+		for i, share := range shares {
+			value ^= gfMul(share[b], basis[i])
+		}
+		secret[b] = value
+	}
+	return secret, nil
+}
+
+// gfMul multiplies in GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1
+// Runs without branches or table lookups on secret values
+func gfMul(a, b byte) byte {
+	var product byte
+	for i := 0; i < 8; i++ {
+		product ^= -(b & 1) & a
+		carry := -(a >> 7)
+		a = (a << 1) ^ (carry & 0x1b)
+		b >>= 1
+	}
+	return product
+}
+
+// gfInv returns the multiplicative inverse of a non-zero element, a^254
+func gfInv(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 7; i++ {
+		a = gfMul(a, a)
+		result = gfMul(result, a)
+	}
+	return result
+}
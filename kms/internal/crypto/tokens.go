@@ -0,0 +1,28 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// tokenRandomSize is the number of random bytes in opaque API tokens and challenges
+const tokenRandomSize = 32
+
+// GenerateToken generates an opaque API token: the prefix followed by 256 random bits, base64url encoded
+func GenerateToken(prefix string) (string, error) {
+	random, err := GenerateChallenge()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// GenerateChallenge generates 256 random bits for a proof of possession challenge
+func GenerateChallenge() ([]byte, error) {
+	random := make([]byte, tokenRandomSize)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	return random, nil
+}
@@ -0,0 +1,191 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// Key wrapping algorithms used to move key material in and out of the service
+const (
+	// WrappingRSAOAEPSHA256 wraps key material with RSA-OAEP (SHA-256) under a 3072-bit key
+	// The public key is PKIX DER; the wrapped blob is the raw OAEP ciphertext
+	WrappingRSAOAEPSHA256 = "rsa-oaep-sha256"
+	// WrappingX25519AES256GCM derives an AES-256-GCM key from an X25519 exchange with HKDF-SHA256
+	// The public key is 32 raw bytes; the wrapped blob is the sender's ephemeral
+	// X25519 public key (32 bytes) || nonce (12 bytes) || ciphertext and tag
+	WrappingX25519AES256GCM = "x25519-hkdf-sha256-aes-256-gcm"
+
+	wrappingRSAKeyBits    = 3072
+	minWrappingRSAKeyBits = 2048
+	wrappingHKDFInfo      = "kms key wrapping"
+	wrappingNonceSize     = 12
+	x25519KeySize         = 32
+)
+
+// IsSupportedWrappingAlgorithm reports whether the wrapping algorithm is known
+func IsSupportedWrappingAlgorithm(algorithm string) bool {
+	return algorithm == WrappingRSAOAEPSHA256 || algorithm == WrappingX25519AES256GCM
+}
+
+// GenerateWrappingKey generates a key pair for a wrapping algorithm
+// The private key must be encrypted before storage
+func GenerateWrappingKey(algorithm string) (publicKey, privateKey []byte, err error) {
+	switch algorithm {
+	case WrappingRSAOAEPSHA256:
+		priv, err := rsa.GenerateKey(rand.Reader, wrappingRSAKeyBits)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate RSA wrapping key: %w", err)
+		}
+		return marshalKeyPair(priv, &priv.PublicKey)
+	case WrappingX25519AES256GCM:
+		priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate X25519 wrapping key: %w", err)
+		}
+		return priv.PublicKey().Bytes(), priv.Bytes(), nil
+	}
+	return nil, nil, fmt.Errorf("unsupported wrapping algorithm %q", algorithm)
+}
+
+// ValidateWrappingPublicKey checks a caller-supplied wrapping public key
+// RSA keys must be at least 2048 bits
+func ValidateWrappingPublicKey(algorithm string, publicKey []byte) error {
+	switch algorithm {
+	case WrappingRSAOAEPSHA256:
+		parsed, err := x509.ParsePKIXPublicKey(publicKey)
+		if err != nil {
+			return fmt.Errorf("wrapping public key must be a PKIX DER RSA key")
+		}
+		pub, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("wrapping public key must be a PKIX DER RSA key")
+		}
+		if pub.N.BitLen() < minWrappingRSAKeyBits {
+			return fmt.Errorf("RSA wrapping key must be at least %d bits", minWrappingRSAKeyBits)
+		}
+		return nil
+	case WrappingX25519AES256GCM:
+		if _, err := ecdh.X25519().NewPublicKey(publicKey); err != nil {
+			return fmt.Errorf("wrapping public key must be a %d-byte X25519 key", x25519KeySize)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported wrapping algorithm %q", algorithm)
+}
+
+// WrapKeyMaterial encrypts key material to a wrapping public key
+func WrapKeyMaterial(algorithm string, publicKey, material []byte) ([]byte, error) {
+	switch algorithm {
+	case WrappingRSAOAEPSHA256:
+		parsed, err := x509.ParsePKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, errors.ErrInvalidKeyMaterial
+		}
+		pub, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.ErrInvalidKeyMaterial
+		}
+		return rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, material, nil)
+	case WrappingX25519AES256GCM:
+		recipient, err := ecdh.X25519().NewPublicKey(publicKey)
+		if err != nil {
+			return nil, errors.ErrInvalidKeyMaterial
+		}
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := x25519WrappingCipher(ephemeral, recipient)
+		if err != nil {
+			return nil, err
+		}
+
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+
+		wrapped := append(ephemeral.PublicKey().Bytes(), nonce...)
+		return gcm.Seal(wrapped, nonce, material, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported wrapping algorithm %q", algorithm)
+}
+
+// UnwrapKeyMaterial decrypts key material wrapped to the wrapping private key
+// The caller is responsible for zeroing the returned material
+func UnwrapKeyMaterial(algorithm string, privateKey, wrapped []byte) ([]byte, error) {
+	switch algorithm {
+	case WrappingRSAOAEPSHA256:
+		priv, err := parseRSAPrivateKey(privateKey)
+		if err != nil {
+			return nil, errors.ErrInvalidKeyMaterial
+		}
+		material, err := rsa.DecryptOAEP(sha256.New(), nil, priv, wrapped, nil)
+		if err != nil {
+			return nil, errors.ErrDecryptionFailed
+		}
+		return material, nil
+	case WrappingX25519AES256GCM:
+		priv, err := ecdh.X25519().NewPrivateKey(privateKey)
+		if err != nil {
+			return nil, errors.ErrInvalidKeyMaterial
+		}
+		if len(wrapped) < x25519KeySize+wrappingNonceSize {
+			return nil, errors.ErrDecryptionFailed
+		}
+		sender, err := ecdh.X25519().NewPublicKey(wrapped[:x25519KeySize])
+		if err != nil {
+			return nil, errors.ErrDecryptionFailed
+		}
+		gcm, err := x25519WrappingCipher(priv, sender)
+		if err != nil {
+			return nil, errors.ErrDecryptionFailed
+		}
+
+		nonce := wrapped[x25519KeySize : x25519KeySize+wrappingNonceSize]
+		material, err := gcm.Open(nil, nonce, wrapped[x25519KeySize+wrappingNonceSize:], nil)
+		if err != nil {
+			return nil, errors.ErrDecryptionFailed
+		}
+		return material, nil
+	}
+	return nil, fmt.Errorf("unsupported wrapping algorithm %q", algorithm)
+}
+
+// x25519WrappingCipher derives the AES-256-GCM cipher shared by an X25519 key pair and a peer
+func x25519WrappingCipher(priv *ecdh.PrivateKey, peer *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for i := range shared {
+			shared[i] = 0
+		}
+	}()
+
+	key, err := hkdf.Key(sha256.New, shared, nil, wrappingHKDFInfo, SymmetricKeySize)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for i := range key {
+			key[i] = 0
+		}
+	}()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
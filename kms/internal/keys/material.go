@@ -0,0 +1,43 @@
+// Package keys holds key lifecycle helpers shared by the API and the background scheduler
+package keys
+
+import (
+	"github.com/atprof/license-server/kms/internal/crypto"
+)
+
+// NewMaterial generates fresh material for a key algorithm
+// Returns the public key (asymmetric only), the private key encrypted with the master key
+// and the key check value (symmetric only)
+func NewMaterial(masterKey []byte, algorithm string) (publicKey, encryptedPrivateKey []byte, checkValue string, err error) {
+	publicKey, privateKey, err := crypto.GenerateKeyMaterial(algorithm)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer func() {
+		// Zero out plaintext key material
+		for i := range privateKey {
+			privateKey[i] = 0
+		}
+	}()
+
+	checkValue, err = CheckValue(algorithm, privateKey)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	encryptedPrivateKey, err = crypto.EncryptKey(masterKey, privateKey)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return publicKey, encryptedPrivateKey, checkValue, nil
+}
+
+// CheckValue returns the key check value of plaintext material
+// Asymmetric keys are identified by their public key fingerprint instead and get an empty check value
+func CheckValue(algorithm string, material []byte) (string, error) {
+	if crypto.IsAsymmetricAlgorithm(algorithm) {
+		return "", nil
+	}
+	return crypto.KeyCheckValue(algorithm, material)
+}
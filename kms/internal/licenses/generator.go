@@ -9,31 +9,88 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/licensefile"
 )
 
-// GenerateLicense generates a license file for a given key
+// GenerateOptions controls node-locking and which keys sign a generated license
+type GenerateOptions struct {
+	Fingerprint string           // Node-locks the license to a hardware fingerprint
+	IncludeRoot bool             // Sign with the root signer (master key HMAC)
+	Signers     []*storage.Key   // Asymmetric keys co-signing the license
+	Policy      *SignaturePolicy // Embedded in the license and covered by every signature
+	Detached    bool             // Return signatures in a separate .sig file
+	ExpiresAt   time.Time        // License expiry, zero for the expiry of the key
+}
+
+// GeneratedLicense holds the output of license generation
+type GeneratedLicense struct {
+	License        *LicenseFile
+	LicenseBytes   []byte // License JSON with embedded signatures, or the unsigned payload when detached
+	SignatureBytes []byte // Detached .sig file content, only set when detached
+}
+
+// GenerateLicense generates a license file for a given key signed by the root signer
 // Returns the LicenseFile struct and raw JSON bytes
 func GenerateLicense(key *storage.Key, licenseType string, metadata map[string]string, masterKey []byte) (*LicenseFile, []byte, error) {
+	generated, err := GenerateSignedLicense(key, licenseType, metadata, masterKey, GenerateOptions{IncludeRoot: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	return generated.License, generated.LicenseBytes, nil
+}
+
+// GenerateSignedLicense generates a license file for a given key signed according to opts
+func GenerateSignedLicense(key *storage.Key, licenseType string, metadata map[string]string, masterKey []byte, opts GenerateOptions) (*GeneratedLicense, error) {
 	// Validate key is active
 	if !key.IsValid() {
 		if key.IsExpired() {
-			return nil, nil, fmt.Errorf("cannot generate license for expired key")
+			return nil, fmt.Errorf("cannot generate license for expired key")
 		}
 		if key.IsRevoked() {
-			return nil, nil, fmt.Errorf("cannot generate license for revoked key")
+			return nil, fmt.Errorf("cannot generate license for revoked key")
+		}
+		return nil, fmt.Errorf("key is not valid")
+	}
+
+	// Collect signer IDs and make sure the policy can be satisfied by them
+	signerIDs := make([]string, 0, len(opts.Signers)+1)
+	if opts.IncludeRoot {
+		signerIDs = append(signerIDs, RootSignerID)
+	}
+	for _, signer := range opts.Signers {
+		if !signer.IsValid() {
+			return nil, fmt.Errorf("signer key %s is not valid", signer.ID)
+		}
+		signerIDs = append(signerIDs, signer.ID)
+	}
+	if len(signerIDs) == 0 {
+		return nil, fmt.Errorf("at least one signer is required")
+	}
+	if err := CheckSignaturePolicy(opts.Policy, signerIDs); err != nil {
+		return nil, fmt.Errorf("signers do not satisfy signature policy: %w", err)
+	}
+
+	// A license never outlives its key
+	expiresAt := key.ExpiresAt
+	if !opts.ExpiresAt.IsZero() {
+		if opts.ExpiresAt.After(key.ExpiresAt) {
+			return nil, fmt.Errorf("license cannot expire after its key")
 		}
-		return nil, nil, fmt.Errorf("key is not valid")
+		expiresAt = opts.ExpiresAt
 	}
 
 	// Create license structure
 	license := &LicenseFile{
-		LicenseID:   uuid.New().String(),
-		LicenseType: licenseType,
-		KeyID:       key.ID,
-		KeyType:     string(key.KeyType),
-		IssuedAt:    time.Now().UTC(),
-		ExpiresAt:   key.ExpiresAt,
-		Metadata:    metadata,
+		LicenseID:       uuid.New().String(),
+		LicenseType:     licenseType,
+		KeyID:           key.ID,
+		KeyType:         string(key.KeyType),
+		IssuedAt:        time.Now().UTC(),
+		ExpiresAt:       expiresAt,
+		Metadata:        metadata,
+		Fingerprint:     opts.Fingerprint,
+		SignaturePolicy: opts.Policy,
+		Signers:         signerIDs,
 	}
 
 	// Add public key if asymmetric
@@ -41,28 +98,66 @@ func GenerateLicense(key *storage.Key, licenseType string, metadata map[string]s
 		license.PublicKey = base64.StdEncoding.EncodeToString(key.PublicKey)
 	}
 
-	// Serialize to JSON without signature first
-	tempLicense := *license
-	tempLicense.Signature = ""
-	jsonWithoutSig, err := json.Marshal(tempLicense)
+	// Serialize to JSON without signatures first
+	payload, err := SigningPayload(license)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to marshal license: %w", err)
+		return nil, err
 	}
 
-	// Sign the JSON content (without signature)
-	signature, err := SignLicense(jsonWithoutSig, masterKey)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to sign license: %w", err)
+	// Sign the JSON content (without signatures) with every signer
+	signatures := make([]LicenseSignature, 0, len(signerIDs))
+	if opts.IncludeRoot {
+		signature, err := SignLicense(payload, masterKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign license: %w", err)
+		}
+		signatures = append(signatures, LicenseSignature{
+			KeyID:     RootSignerID,
+			Algorithm: SignatureAlgorithmHMACSHA256,
+			Signature: signature,
+		})
+	}
+	for _, signer := range opts.Signers {
+		signature, err := SignWithKey(payload, signer, masterKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign license with key %s: %w", signer.ID, err)
+		}
+		signatures = append(signatures, *signature)
+	}
+
+	if opts.Detached {
+		// The payload file is left unchanged so the .sig covers its exact bytes
+		signatureBytes, err := json.Marshal(DetachedSignatureFile{
+			LicenseID:  license.LicenseID,
+			Signatures: signatures,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal signature file: %w", err)
+		}
+		return &GeneratedLicense{
+			License:        license,
+			LicenseBytes:   payload,
+			SignatureBytes: signatureBytes,
+		}, nil
 	}
 
-	// Add signature to license
-	license.Signature = signature
+	// Add signatures to license
+	license.Signatures = signatures
 
-	// Serialize final JSON with signature
+	// Serialize final JSON with signatures
 	finalJSON, err := json.Marshal(license)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to marshal final license: %w", err)
+		return nil, fmt.Errorf("failed to marshal final license: %w", err)
 	}
 
-	return license, finalJSON, nil
+	return &GeneratedLicense{
+		License:      license,
+		LicenseBytes: finalJSON,
+	}, nil
+}
+
+// SigningPayload returns the JSON encoding of the license with all signatures removed
+// These are the bytes covered by embedded signatures
+func SigningPayload(license *LicenseFile) ([]byte, error) {
+	return licensefile.SigningPayload(license)
 }
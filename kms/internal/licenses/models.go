@@ -1,38 +1,83 @@
 package licenses
 
-import "time"
+import (
+	"time"
+
+	"github.com/atprof/license-server/kms/pkg/licensefile"
+	"github.com/atprof/license-server/kms/pkg/signedresponse"
+)
+
+// The license file format lives in pkg/licensefile so offline verifiers can use it
+const (
+	// RootSignerID identifies the server root signer (HMAC-SHA256 with the master key)
+	RootSignerID = licensefile.RootSignerID
+
+	// SignatureAlgorithmHMACSHA256 is used by the root signer
+	SignatureAlgorithmHMACSHA256 = licensefile.SignatureAlgorithmHMACSHA256
+	// SignatureAlgorithmEd25519 is used by asymmetric keys stored in the KMS
+	SignatureAlgorithmEd25519 = licensefile.SignatureAlgorithmEd25519
+)
 
 // LicenseFile represents a license file structure
-type LicenseFile struct {
-	LicenseID   string            `json:"license_id"`
-	LicenseType string            `json:"license_type"`
-	KeyID       string            `json:"key_id"`
-	KeyType     string            `json:"key_type"`
-	PublicKey   string            `json:"public_key,omitempty"` // Base64 encoded, only for asymmetric keys
-	IssuedAt    time.Time         `json:"issued_at"`
-	ExpiresAt   time.Time         `json:"expires_at"`
-	Metadata    map[string]string `json:"metadata,omitempty"`
-	Signature   string            `json:"signature"` // Base64 encoded HMAC-SHA256 signature
-}
+type LicenseFile = licensefile.License
+
+// LicenseSignature is one signature over the license payload
+type LicenseSignature = licensefile.Signature
+
+// SignaturePolicy states how many signatures a license needs and from which keys
+type SignaturePolicy = licensefile.SignaturePolicy
+
+// DetachedSignatureFile is the content of a detached .sig file
+type DetachedSignatureFile = licensefile.DetachedSignatureFile
 
 // GenerateLicenseRequest represents a request to generate a license file
 type GenerateLicenseRequest struct {
-	KeyID       string            `json:"key_id" binding:"required"`
-	LicenseType string            `json:"license_type" binding:"required"`
-	Metadata    map[string]string `json:"metadata,omitempty"`
+	KeyID           string            `json:"key_id" binding:"required"`
+	LicenseType     string            `json:"license_type" binding:"required"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Signers         []string          `json:"signers,omitempty"`          // Signer key IDs, default ["root"]
+	SignaturePolicy *SignaturePolicy  `json:"signature_policy,omitempty"` // Default: every signer required
+	Detached        bool              `json:"detached,omitempty"`         // Emit a separate .sig file
+	Fingerprint     string            `json:"fingerprint,omitempty"`      // Node-lock to a hardware fingerprint
+	ExpiresInSeconds int64            `json:"expires_in_seconds,omitempty"` // Default: until the key expires
 }
 
 // GenerateLicenseResponse represents a response from generating a license file
 type GenerateLicenseResponse struct {
-	LicenseFile string `json:"license_file"` // Base64 encoded license file content
-	Filename    string `json:"filename"`     // Suggested filename (e.g., "enterprise.lic")
-	LicenseID   string `json:"license_id"`
+	LicenseFile       string `json:"license_file"` // Base64 encoded license file content
+	Filename          string `json:"filename"`     // Suggested filename (e.g., "enterprise.lic")
+	LicenseID         string `json:"license_id"`
+	SignatureFile     string `json:"signature_file,omitempty"`     // Base64 encoded detached signature file
+	SignatureFilename string `json:"signature_filename,omitempty"` // Suggested filename (e.g., "enterprise.lic.sig")
 }
 
 // ValidateLicenseRequest represents a request to validate a license file
 // Can be either multipart file upload or JSON with base64 content
 type ValidateLicenseRequest struct {
-	LicenseContent string `json:"license_content,omitempty"` // Base64 encoded license file (for JSON body)
+	LicenseContent   string `json:"license_content,omitempty"`   // Base64 encoded license file (for JSON body)
+	SignatureContent string `json:"signature_content,omitempty"` // Base64 encoded detached .sig file (optional)
+	Fingerprint      string `json:"fingerprint,omitempty"`       // Fingerprint of the validating host, required for node-locked licenses
+	Nonce            string `json:"nonce,omitempty"`             // Echoed in the signed response
+}
+
+// ValidateOptions carries optional inputs to license validation
+type ValidateOptions struct {
+	SignatureContent []byte // Content of a detached .sig file, nil for embedded signatures
+	Fingerprint      string // Fingerprint of the validating host, checked against node-locked licenses
+}
+
+// TransferLicenseRequest represents a request to move a license to a new fingerprint
+type TransferLicenseRequest struct {
+	Fingerprint string `json:"fingerprint" binding:"required"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// TransferLicenseResponse represents a response from transferring a license
+type TransferLicenseResponse struct {
+	GenerateLicenseResponse
+	PreviousLicenseID string `json:"previous_license_id"`
+	TransfersInPeriod int    `json:"transfers_in_period"`
+	MaxTransfers      int    `json:"max_transfers_per_period"`
 }
 
 // ValidationResult represents the result of license validation
@@ -45,6 +90,7 @@ type ValidationResult struct {
 	Expired    bool              `json:"expired"`
 	Revoked    bool              `json:"revoked"`
 	Metadata   map[string]string `json:"metadata,omitempty"`
+	SignedBy   []string          `json:"signed_by,omitempty"` // Key IDs of verified signers
 	Error      string            `json:"error,omitempty"` // Error message if validation failed
 }
 
@@ -58,6 +104,14 @@ type ValidateLicenseResponse struct {
 	Expired     bool              `json:"expired"`
 	Revoked     bool              `json:"revoked"`
 	Metadata    map[string]string `json:"metadata,omitempty"`
+	SignedBy    []string          `json:"signed_by,omitempty"`
 	Error       string            `json:"error,omitempty"`
+
+	ResponseSignature *signedresponse.Envelope `json:"response_signature,omitempty"`
 }
 
+
+// SetResponseSignature attaches a response signature to the validation response
+func (r *ValidateLicenseResponse) SetResponseSignature(envelope *signedresponse.Envelope) {
+	r.ResponseSignature = envelope
+}
@@ -0,0 +1,186 @@
+package licenses
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+const (
+	// productKeyVersion is the first byte of every product key
+	productKeyVersion = 1
+	// productKeyMACSize is the number of HMAC-SHA256 bytes kept in a product key
+	productKeyMACSize = 6
+	// productKeySize is version (1) + license ID (16) + MAC (6) + CRC-16 (2) = 25 bytes = 40 characters
+	productKeySize = 1 + 16 + productKeyMACSize + 2
+	// productKeyGroupSize is the number of characters per dash-separated group
+	productKeyGroupSize = 5
+
+	// productKeyAlphabet is Crockford base32: no I, L, O or U to avoid misreading
+	productKeyAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	// productKeyMACContext derives the product key MAC key from the master key
+	productKeyMACContext = "kms-product-key-v1"
+)
+
+// EncodeProductKey derives the human-typeable product key for a license
+// Format: 8 groups of 5 Crockford base32 characters, e.g. 0G5Y2-...-7QW3K
+func EncodeProductKey(licenseID string, masterKey []byte) (string, error) {
+	id, err := uuid.Parse(licenseID)
+	if err != nil {
+		return "", fmt.Errorf("license ID must be a UUID: %w", err)
+	}
+
+	data := make([]byte, 0, productKeySize)
+	data = append(data, productKeyVersion)
+	data = append(data, id[:]...)
+
+	mac, err := productKeyMAC(data, masterKey)
+	if err != nil {
+		return "", err
+	}
+	data = append(data, mac...)
+
+	checksum := crc16(data)
+	data = append(data, byte(checksum>>8), byte(checksum))
+
+	encoded := encodeBase32(data)
+	groups := make([]string, 0, len(encoded)/productKeyGroupSize)
+	for i := 0; i < len(encoded); i += productKeyGroupSize {
+		groups = append(groups, encoded[i:i+productKeyGroupSize])
+	}
+
+	return strings.Join(groups, "-"), nil
+}
+
+// DecodeProductKey checks a product key and returns the embedded license ID
+// The checksum is verified first so typos are reported without further work,
+// then the MAC is verified so forged keys never reach the database
+func DecodeProductKey(productKey string, masterKey []byte) (string, error) {
+	data, err := decodeBase32(normalizeProductKey(productKey))
+	if err != nil || len(data) != productKeySize {
+		return "", errors.ErrProductKeyChecksum
+	}
+
+	body, checksum := data[:productKeySize-2], data[productKeySize-2:]
+	if crc16(body) != uint16(checksum[0])<<8|uint16(checksum[1]) {
+		return "", errors.ErrProductKeyChecksum
+	}
+
+	if body[0] != productKeyVersion {
+		return "", errors.ErrInvalidProductKey
+	}
+
+	payload, mac := body[:1+16], body[1+16:]
+	expectedMAC, err := productKeyMAC(payload, masterKey)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal(mac, expectedMAC) {
+		return "", errors.ErrInvalidProductKey
+	}
+
+	id, err := uuid.FromBytes(payload[1:])
+	if err != nil {
+		return "", errors.ErrInvalidProductKey
+	}
+
+	return id.String(), nil
+}
+
+// productKeyMAC computes the truncated MAC over the product key payload
+func productKeyMAC(payload, masterKey []byte) ([]byte, error) {
+	if len(masterKey) != 32 {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+
+	derive := hmac.New(sha256.New, masterKey)
+	derive.Write([]byte(productKeyMACContext))
+	macKey := derive.Sum(nil)
+	defer func() {
+		for i := range macKey {
+			macKey[i] = 0
+		}
+	}()
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(payload)
+	return mac.Sum(nil)[:productKeyMACSize], nil
+}
+
+// normalizeProductKey uppercases the key, drops separators and maps
+// commonly confused characters to their Crockford equivalents
+func normalizeProductKey(productKey string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(productKey) {
+		switch r {
+		case '-', ' ':
+			continue
+		case 'O':
+			r = '0'
+		case 'I', 'L':
+			r = '1'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// encodeBase32 encodes data with the product key alphabet (no padding)
+func encodeBase32(data []byte) string {
+	var b strings.Builder
+	var buffer uint32
+	bits := 0
+	for _, v := range data {
+		buffer = buffer<<8 | uint32(v)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			b.WriteByte(productKeyAlphabet[(buffer>>uint(bits))&0x1f])
+		}
+	}
+	if bits > 0 {
+		b.WriteByte(productKeyAlphabet[(buffer<<uint(5-bits))&0x1f])
+	}
+	return b.String()
+}
+
+// decodeBase32 decodes a string in the product key alphabet
+func decodeBase32(s string) ([]byte, error) {
+	out := make([]byte, 0, len(s)*5/8)
+	var buffer uint32
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		index := strings.IndexByte(productKeyAlphabet, s[i])
+		if index < 0 {
+			return nil, fmt.Errorf("invalid character %q", s[i])
+		}
+		buffer = buffer<<5 | uint32(index)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			out = append(out, byte(buffer>>uint(bits)))
+		}
+	}
+	return out, nil
+}
+
+// crc16 computes CRC-16/CCITT-FALSE over data
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, v := range data {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
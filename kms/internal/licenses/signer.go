@@ -1,11 +1,16 @@
 package licenses
 
 import (
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"fmt"
 
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
 	"github.com/atprof/license-server/kms/pkg/errors"
+	"github.com/atprof/license-server/kms/pkg/licensefile"
 )
 
 // SignLicense signs license content using HMAC-SHA256 with the master key
@@ -54,3 +59,104 @@ func VerifyLicenseSignature(content []byte, signatureBase64 string, masterKey []
 	return result == 0, nil
 }
 
+
+// SignWithKey signs license content with the primary version of an asymmetric key stored in the KMS
+// The content is signed under the license signature context. The private key is decrypted with
+// the master key and zeroed after signing
+func SignWithKey(content []byte, key *storage.Key, masterKey []byte) (*LicenseSignature, error) {
+	if key.KeyAlgorithm() != crypto.AlgorithmEd25519 {
+		return nil, fmt.Errorf("signer key %s is not an Ed25519 key", key.ID)
+	}
+
+	material := key.PrimaryMaterial()
+	privateKey, err := crypto.DecryptKey(masterKey, material.EncryptedPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for i := range privateKey {
+			privateKey[i] = 0
+		}
+	}()
+
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+
+	return &LicenseSignature{
+		KeyID:      key.ID,
+		KeyVersion: material.Version,
+		Algorithm:  SignatureAlgorithmEd25519,
+		Signature:  base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, licensefile.SignedMessage(content))),
+	}, nil
+}
+
+// VerifyWithKey verifies an Ed25519 license signature against a key stored in the KMS
+// Signatures from keys that are not active or from retired key versions are never valid
+func VerifyWithKey(content []byte, sig LicenseSignature, key *storage.Key) (bool, error) {
+	if sig.Algorithm != SignatureAlgorithmEd25519 || key.KeyAlgorithm() != crypto.AlgorithmEd25519 {
+		return false, errors.ErrInvalidSignature
+	}
+	if key.Status != storage.KeyStatusActive {
+		return false, nil
+	}
+
+	version := sig.KeyVersion
+	if version == 0 {
+		version = 1
+	}
+	material := key.MaterialVersion(version)
+	if material == nil || material.IsRetired() {
+		return false, nil
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return false, errors.ErrInvalidSignature
+	}
+
+	return crypto.ValidateSignature(material.PublicKey, licensefile.SignedMessage(content), signature)
+}
+
+// CheckSignaturePolicy checks that the verified signers satisfy the policy
+// A nil policy only requires at least one verified signer. Otherwise only signers the policy
+// names as required or allowed count towards MinSignatures; an empty allow-list admits no others
+func CheckSignaturePolicy(policy *SignaturePolicy, signedBy []string) error {
+	signed := make(map[string]bool, len(signedBy))
+	for _, keyID := range signedBy {
+		signed[keyID] = true
+	}
+
+	if policy == nil {
+		if len(signed) == 0 {
+			return fmt.Errorf("license has no valid signatures")
+		}
+		return nil
+	}
+
+	for _, keyID := range policy.RequiredKeyIDs {
+		if !signed[keyID] {
+			return fmt.Errorf("missing required signature from %s", keyID)
+		}
+	}
+
+	allowed := make(map[string]bool, len(policy.AllowedKeyIDs)+len(policy.RequiredKeyIDs))
+	for _, keyID := range policy.AllowedKeyIDs {
+		allowed[keyID] = true
+	}
+	for _, keyID := range policy.RequiredKeyIDs {
+		allowed[keyID] = true
+	}
+	count := 0
+	for keyID := range signed {
+		if allowed[keyID] {
+			count++
+		}
+	}
+
+	if count < policy.MinSignatures {
+		return fmt.Errorf("license has %d of %d required signatures", count, policy.MinSignatures)
+	}
+
+	return nil
+}
@@ -11,7 +11,7 @@ import (
 
 // ValidateLicense validates a license file
 // Returns validation result with license information
-func ValidateLicense(fileContent []byte, store *storage.BoltStore, masterKey []byte) (*ValidationResult, error) {
+func ValidateLicense(fileContent []byte, opts ValidateOptions, store *storage.BoltStore, masterKey []byte) (*ValidationResult, error) {
 	// Parse license file
 	var license LicenseFile
 	if err := json.Unmarshal(fileContent, &license); err != nil {
@@ -21,39 +21,114 @@ func ValidateLicense(fileContent []byte, store *storage.BoltStore, masterKey []b
 		}, nil
 	}
 
-	// Extract signature for verification
-	signature := license.Signature
-	if signature == "" {
+	// Work out which bytes were signed and by whom
+	var payload []byte
+	var signatures []LicenseSignature
+	var err error
+
+	if opts.SignatureContent != nil {
+		// Detached signatures cover the exact bytes of the payload file
+		var sigFile DetachedSignatureFile
+		if err := json.Unmarshal(opts.SignatureContent, &sigFile); err != nil {
+			return &ValidationResult{
+				Valid: false,
+				Error: fmt.Sprintf("failed to parse signature file: %v", err),
+			}, nil
+		}
+		if sigFile.LicenseID != license.LicenseID {
+			return &ValidationResult{
+				Valid: false,
+				Error: "signature file does not belong to this license",
+			}, nil
+		}
+		payload = fileContent
+		signatures = sigFile.Signatures
+	} else {
+		// Remove signatures for verification
+		payload, err = SigningPayload(&license)
+		if err != nil {
+			return &ValidationResult{
+				Valid: false,
+				Error: fmt.Sprintf("failed to marshal license for verification: %v", err),
+			}, nil
+		}
+
+		signatures = license.Signatures
+		if len(signatures) == 0 && license.Signature != "" {
+			// Legacy license with a single root signature
+			signatures = []LicenseSignature{{
+				KeyID:     RootSignerID,
+				Algorithm: SignatureAlgorithmHMACSHA256,
+				Signature: license.Signature,
+			}}
+		}
+	}
+
+	if len(signatures) == 0 {
 		return &ValidationResult{
 			Valid: false,
 			Error: "license file missing signature",
 		}, nil
 	}
 
-	// Remove signature for verification
-	tempLicense := license
-	tempLicense.Signature = ""
-	jsonWithoutSig, err := json.Marshal(tempLicense)
-	if err != nil {
+	// Verify every signature; a single bad or undeclared signature invalidates the license
+	declared := make(map[string]bool)
+	for _, keyID := range license.SignerKeyIDs() {
+		declared[keyID] = true
+	}
+	signedBy := make([]string, 0, len(signatures))
+	for _, sig := range signatures {
+		if !declared[sig.KeyID] {
+			return &ValidationResult{
+				Valid: false,
+				Error: fmt.Sprintf("license was not issued to be signed by %s", sig.KeyID),
+			}, nil
+		}
+
+		validSig, err := verifyLicenseSignature(payload, sig, store, masterKey)
+		if err != nil {
+			return &ValidationResult{
+				Valid: false,
+				Error: fmt.Sprintf("signature verification failed: %v", err),
+			}, nil
+		}
+
+		if !validSig {
+			return &ValidationResult{
+				Valid: false,
+				Error: "invalid license signature",
+			}, nil
+		}
+
+		signedBy = append(signedBy, sig.KeyID)
+	}
+
+	// Check the signers against the embedded signature policy
+	if err := CheckSignaturePolicy(license.SignaturePolicy, signedBy); err != nil {
 		return &ValidationResult{
-			Valid: false,
-			Error: fmt.Sprintf("failed to marshal license for verification: %v", err),
+			Valid:     false,
+			Error:     fmt.Sprintf("signature policy not satisfied: %v", err),
+			LicenseID: license.LicenseID,
+			KeyID:     license.KeyID,
+			SignedBy:  signedBy,
 		}, nil
 	}
 
-	// Verify signature
-	validSig, err := VerifyLicenseSignature(jsonWithoutSig, signature, masterKey)
-	if err != nil {
+	// Node-locked licenses only validate for the host they are locked to
+	if license.Fingerprint != "" && opts.Fingerprint == "" {
 		return &ValidationResult{
-			Valid: false,
-			Error: fmt.Sprintf("signature verification failed: %v", err),
+			Valid:     false,
+			Error:     "license is node-locked and no fingerprint was supplied",
+			LicenseID: license.LicenseID,
+			KeyID:     license.KeyID,
 		}, nil
 	}
-
-	if !validSig {
+	if license.Fingerprint != "" && license.Fingerprint != opts.Fingerprint {
 		return &ValidationResult{
-			Valid: false,
-			Error: "invalid license signature",
+			Valid:     false,
+			Error:     "license is locked to a different fingerprint",
+			LicenseID: license.LicenseID,
+			KeyID:     license.KeyID,
 		}, nil
 	}
 
@@ -97,6 +172,40 @@ func ValidateLicense(fileContent []byte, store *storage.BoltStore, masterKey []b
 		}, nil
 	}
 
+	// Disabled, pending deletion and destroyed keys validate nothing
+	if key.Status != storage.KeyStatusActive {
+		return &ValidationResult{
+			Valid:     false,
+			Error:     fmt.Sprintf("key is %s", key.Status),
+			LicenseID: license.LicenseID,
+			KeyID:     license.KeyID,
+		}, nil
+	}
+
+	// Check if the license itself was revoked (e.g. transferred to a new fingerprint)
+	// Licenses issued before records were kept have no record and are skipped
+	record, err := store.GetLicense(license.LicenseID)
+	if err != nil && err != errors.ErrLicenseNotFound {
+		return &ValidationResult{
+			Valid:     false,
+			Error:     fmt.Sprintf("failed to retrieve license record: %v", err),
+			LicenseID: license.LicenseID,
+			KeyID:     license.KeyID,
+		}, nil
+	}
+	if record != nil && record.IsRevoked() {
+		result := &ValidationResult{
+			Valid:     false,
+			Revoked:   true,
+			LicenseID: license.LicenseID,
+			KeyID:     license.KeyID,
+		}
+		if record.ReplacedBy != "" {
+			result.Error = fmt.Sprintf("license was transferred to %s", record.ReplacedBy)
+		}
+		return result, nil
+	}
+
 	// License is valid
 	return &ValidationResult{
 		Valid:      true,
@@ -107,5 +216,26 @@ func ValidateLicense(fileContent []byte, store *storage.BoltStore, masterKey []b
 		Expired:    false,
 		Revoked:    false,
 		Metadata:   license.Metadata,
+		SignedBy:   signedBy,
 	}, nil
 }
+
+// verifyLicenseSignature verifies one license signature with the root signer or a stored key
+func verifyLicenseSignature(payload []byte, sig LicenseSignature, store *storage.BoltStore, masterKey []byte) (bool, error) {
+	if sig.KeyID == RootSignerID {
+		if sig.Algorithm != SignatureAlgorithmHMACSHA256 {
+			return false, errors.ErrInvalidSignature
+		}
+		return VerifyLicenseSignature(payload, sig.Signature, masterKey)
+	}
+
+	signer, err := store.GetKey(sig.KeyID)
+	if err != nil {
+		if err == errors.ErrKeyNotFound {
+			return false, fmt.Errorf("unknown signer %s", sig.KeyID)
+		}
+		return false, err
+	}
+
+	return VerifyWithKey(payload, sig, signer)
+}
@@ -0,0 +1,293 @@
+// Package notify sends expiry warnings for keys and licenses over webhooks and SMTP
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+const (
+	// notificationLease is the lease name held while sweeping for upcoming expiries
+	notificationLease = "expiry-notifications"
+	// maxDeliveryAttempts is how often a failing notice is retried before it is given up
+	maxDeliveryAttempts = 5
+	// EventExpiryWarning is the event name of expiry warning notices
+	EventExpiryWarning = "expiry_warning"
+)
+
+// Notice is an expiry warning about one key or license
+type Notice struct {
+	ID            string                      `json:"id"` // Stable per subject, threshold, expiry and channel, for deduplication by receivers
+	Event         string                      `json:"event"`
+	SubjectType   storage.NotificationSubject `json:"subject_type"`
+	SubjectID     string                      `json:"subject_id"`
+	ThresholdDays int                         `json:"threshold_days"`
+	ExpiresAt     time.Time                   `json:"expires_at"`
+	Details       map[string]string           `json:"details,omitempty"`
+}
+
+// Summary returns a one-line description of the notice
+func (n *Notice) Summary() string {
+	unit := "days"
+	if n.ThresholdDays == 1 {
+		unit = "day"
+	}
+	return fmt.Sprintf("%s %s expires within %d %s", n.SubjectType, n.SubjectID, n.ThresholdDays, unit)
+}
+
+// Channel delivers notices to one destination
+type Channel interface {
+	// Name identifies the channel in the notification history
+	Name() string
+	// Send delivers the notice, returning an error if it was not accepted
+	Send(notice *Notice) error
+}
+
+// Channels builds the channels enabled in the notification configuration
+func Channels(cfg config.NotificationConfig) []Channel {
+	var channels []Channel
+	if cfg.WebhookURL != "" {
+		channels = append(channels, NewWebhookChannel(cfg.WebhookURL, []byte(cfg.WebhookSecret)))
+	}
+	if cfg.SMTPAddr != "" {
+		channels = append(channels, NewSMTPChannel(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom, cfg.SMTPTo))
+	}
+	return channels
+}
+
+// Notifier scans keys and licenses for upcoming expiry on a fixed interval and sends warnings
+//
+// Each warning is recorded per channel, so it is delivered once even across
+// restarts; failed deliveries are retried on later sweeps up to maxDeliveryAttempts.
+// When several instances share a database, a lease lets only one of them sweep at a time.
+type Notifier struct {
+	store      *storage.BoltStore
+	channels   []Channel
+	thresholds []int // Ascending
+	interval   time.Duration
+	owner      string
+	now        func() time.Time
+}
+
+// New creates a notifier that sweeps every interval and warns at the given days before expiry
+func New(store *storage.BoltStore, channels []Channel, thresholdDays []int, interval time.Duration) *Notifier {
+	thresholds := append([]int(nil), thresholdDays...)
+	sort.Ints(thresholds)
+
+	return &Notifier{
+		store:      store,
+		channels:   channels,
+		thresholds: thresholds,
+		interval:   interval,
+		owner:      newOwnerID(),
+		now:        func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Run sweeps until ctx is cancelled
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := n.RunOnce(n.now()); err != nil {
+			log.Printf("Expiry notification sweep failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := n.store.ReleaseLease(notificationLease, n.owner); err != nil {
+				log.Printf("Failed to release notification lease: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce sends every expiry warning due at now that has not been delivered yet
+// Returns the number of notices delivered; zero if another instance holds the lease
+func (n *Notifier) RunOnce(now time.Time) (int, error) {
+	acquired, err := n.store.AcquireLease(notificationLease, n.owner, now, 2*n.interval)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire notification lease: %w", err)
+	}
+	if !acquired {
+		return 0, nil
+	}
+
+	notices, err := n.dueNotices(now)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, notice := range notices {
+		for _, channel := range n.channels {
+			delivered, err := n.deliver(channel, *notice, now)
+			if err != nil {
+				return sent, err
+			}
+			if delivered {
+				sent++
+			}
+		}
+	}
+
+	return sent, nil
+}
+
+// deliver sends a notice over one channel unless it was already delivered or given up
+// Returns whether the notice was delivered by this call
+func (n *Notifier) deliver(channel Channel, notice Notice, now time.Time) (bool, error) {
+	notice.ID = storage.NotificationID(notice.SubjectType, notice.SubjectID, notice.ThresholdDays, notice.ExpiresAt, channel.Name())
+
+	record, err := n.store.GetNotification(notice.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read notification %s: %w", notice.ID, err)
+	}
+	if record == nil {
+		record = &storage.Notification{
+			ID:            notice.ID,
+			SubjectType:   notice.SubjectType,
+			SubjectID:     notice.SubjectID,
+			ThresholdDays: notice.ThresholdDays,
+			ExpiresAt:     notice.ExpiresAt,
+			Channel:       channel.Name(),
+			CreatedAt:     now,
+		}
+	} else if record.Status == storage.NotificationStatusSent || record.Attempts >= maxDeliveryAttempts {
+		return false, nil
+	}
+
+	sendErr := channel.Send(&notice)
+	record.Attempts++
+	record.UpdatedAt = now
+	if sendErr != nil {
+		log.Printf("Failed to send %s notification %s: %v", channel.Name(), notice.ID, sendErr)
+		record.Status = storage.NotificationStatusFailed
+		record.LastError = sendErr.Error()
+	} else {
+		record.Status = storage.NotificationStatusSent
+		record.LastError = ""
+		record.SentAt = &now
+	}
+
+	if err := n.store.StoreNotification(record); err != nil {
+		return false, fmt.Errorf("failed to record notification %s: %w", notice.ID, err)
+	}
+	return sendErr == nil, nil
+}
+
+// dueNotices lists a warning for every active key and license expiring within a threshold of now
+// Only the most urgent threshold an expiry falls within is warned about
+func (n *Notifier) dueNotices(now time.Time) ([]*Notice, error) {
+	keys, err := n.store.ListKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	licenses, err := n.store.ListLicenses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list licenses: %w", err)
+	}
+
+	var notices []*Notice
+	for _, key := range keys {
+		if key.Status != storage.KeyStatusActive {
+			continue
+		}
+		threshold := n.thresholdFor(key.ExpiresAt.Sub(now))
+		if threshold == 0 {
+			continue
+		}
+		notices = append(notices, &Notice{
+			Event:         EventExpiryWarning,
+			SubjectType:   storage.NotificationSubjectKey,
+			SubjectID:     key.ID,
+			ThresholdDays: threshold,
+			ExpiresAt:     key.ExpiresAt,
+			Details:       keyDetails(key),
+		})
+	}
+
+	for _, license := range licenses {
+		if license.IsRevoked() {
+			continue
+		}
+		threshold := n.thresholdFor(license.ExpiresAt.Sub(now))
+		if threshold == 0 {
+			continue
+		}
+		notices = append(notices, &Notice{
+			Event:         EventExpiryWarning,
+			SubjectType:   storage.NotificationSubjectLicense,
+			SubjectID:     license.ID,
+			ThresholdDays: threshold,
+			ExpiresAt:     license.ExpiresAt,
+			Details:       licenseDetails(license),
+		})
+	}
+
+	return notices, nil
+}
+
+// thresholdFor returns the smallest threshold, in days, that remaining falls within
+// Returns zero if the expiry has passed or is further away than every threshold
+func (n *Notifier) thresholdFor(remaining time.Duration) int {
+	if remaining <= 0 {
+		return 0
+	}
+	for _, days := range n.thresholds {
+		if remaining <= time.Duration(days)*24*time.Hour {
+			return days
+		}
+	}
+	return 0
+}
+
+// keyDetails describes a key in a notice
+func keyDetails(key *storage.Key) map[string]string {
+	details := map[string]string{
+		"key_type":  string(key.KeyType),
+		"algorithm": key.KeyAlgorithm(),
+	}
+	if key.Level != "" {
+		details["level"] = string(key.Level)
+	}
+	if key.Owner != "" {
+		details["owner"] = key.Owner
+	}
+	if key.SiteMode != "" {
+		details["site_mode"] = string(key.SiteMode)
+	}
+	return details
+}
+
+// licenseDetails describes a license in a notice
+func licenseDetails(license *storage.LicenseRecord) map[string]string {
+	details := map[string]string{
+		"license_type": license.LicenseType,
+		"key_id":       license.KeyID,
+	}
+	if license.Fingerprint != "" {
+		details["fingerprint"] = license.Fingerprint
+	}
+	return details
+}
+
+// newOwnerID returns a lease owner ID unique to this process
+func newOwnerID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
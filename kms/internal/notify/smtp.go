@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net"
+	"net/smtp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SMTPChannel emails notices through a mail server
+type SMTPChannel struct {
+	addr     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// NewSMTPChannel creates an SMTP channel
+// Authentication is skipped when username is empty, for example with a local relay
+func NewSMTPChannel(addr, username, password, from string, to []string) *SMTPChannel {
+	return &SMTPChannel{
+		addr:     addr,
+		username: username,
+		password: password,
+		from:     from,
+		to:       to,
+	}
+}
+
+// Name identifies the channel in the notification history
+func (s *SMTPChannel) Name() string {
+	return "smtp"
+}
+
+// Send emails the notice to every recipient
+func (s *SMTPChannel) Send(notice *Notice) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, err := net.SplitHostPort(s.addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+
+	if err := smtp.SendMail(s.addr, auth, s.from, s.to, s.message(notice)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// message builds the plain text email for a notice
+func (s *SMTPChannel) message(notice *Notice) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: [KMS] %s\r\n", notice.Summary())
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@kms>\r\n", strings.NewReplacer(":", ".").Replace(notice.ID))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&msg, "The %s %s expires at %s.\r\n\r\n", notice.SubjectType, notice.SubjectID, notice.ExpiresAt.UTC().Format(time.RFC3339))
+	for _, name := range slices.Sorted(maps.Keys(notice.Details)) {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, notice.Details[name])
+	}
+	return msg.Bytes()
+}
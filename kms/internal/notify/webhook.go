@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the timestamp, a dot and the body
+	WebhookSignatureHeader = "X-KMS-Signature"
+	// WebhookTimestampHeader carries the Unix time the webhook was signed at
+	WebhookTimestampHeader = "X-KMS-Timestamp"
+	// webhookTimeout bounds a single webhook delivery
+	webhookTimeout = 10 * time.Second
+)
+
+// WebhookChannel posts notices as signed JSON to a URL
+type WebhookChannel struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhookChannel creates a webhook channel signing with secret
+func NewWebhookChannel(url string, secret []byte) *WebhookChannel {
+	return &WebhookChannel{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// Name identifies the channel in the notification history
+func (w *WebhookChannel) Name() string {
+	return "webhook"
+}
+
+// Send posts the notice; any non-2xx response is a failed delivery
+func (w *WebhookChannel) Send(notice *Notice) error {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notice: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(w.secret, timestamp, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhook returns the signature header value for a webhook body
+// Receivers recompute it with the shared secret and compare in constant time
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
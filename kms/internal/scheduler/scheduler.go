@@ -0,0 +1,178 @@
+// Package scheduler runs background key maintenance jobs inside the server process
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/atprof/license-server/kms/internal/keys"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// maintenanceLease is the lease name held while sweeping for due key changes
+const maintenanceLease = "key-maintenance"
+
+// Scheduler applies key rotation policies, scheduled activations and
+// scheduled destructions on a fixed interval, and purges expired import tokens
+// and refresh challenges
+//
+// Due times are persisted on each key, so changes missed while the server was
+// down are applied on the first sweep after a restart. When several instances
+// share a database, a lease lets only one of them sweep at a time, and each
+// change re-checks the persisted due time in the same transaction that applies
+// it, so a key is never rotated twice for one due time.
+type Scheduler struct {
+	store     *storage.BoltStore
+	masterKey []byte
+	interval  time.Duration
+	owner     string
+	now       func() time.Time
+
+	// cascade revokes live child keys when their parent is destroyed instead of deferring it
+	cascade bool
+}
+
+// New creates a scheduler that sweeps every interval
+// cascade is the parent key revocation policy, applied when a parent key is destroyed
+func New(store *storage.BoltStore, masterKey []byte, interval time.Duration, cascade bool) *Scheduler {
+	return &Scheduler{
+		store:     store,
+		masterKey: masterKey,
+		interval:  interval,
+		owner:     newOwnerID(),
+		now:       func() time.Time { return time.Now().UTC() },
+		cascade:   cascade,
+	}
+}
+
+// Run sweeps until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.RunOnce(s.now()); err != nil {
+			log.Printf("Key maintenance sweep failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := s.store.ReleaseLease(maintenanceLease, s.owner); err != nil {
+				log.Printf("Failed to release scheduler lease: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce applies every rotation, activation and destruction due at now
+// Returns the number of keys changed; zero if another instance holds the lease
+func (s *Scheduler) RunOnce(now time.Time) (int, error) {
+	acquired, err := s.store.AcquireLease(maintenanceLease, s.owner, now, 2*s.interval)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire scheduler lease: %w", err)
+	}
+	if !acquired {
+		return 0, nil
+	}
+
+	rotated, err := s.rotateDueKeys(now)
+	if err != nil {
+		return rotated, err
+	}
+
+	changed, err := s.applyLifecycleChanges(now)
+	if err != nil {
+		return rotated + changed, err
+	}
+
+	// Unused import tokens and refresh challenges are purged here; used ones are deleted on consumption
+	if _, err := s.store.PurgeExpiredImportTokens(now); err != nil {
+		log.Printf("Failed to purge expired import tokens: %v", err)
+	}
+	if _, err := s.store.PurgeExpiredRefreshChallenges(now); err != nil {
+		log.Printf("Failed to purge expired refresh challenges: %v", err)
+	}
+
+	return rotated + changed, nil
+}
+
+// rotateDueKeys rotates every key whose rotation is due at now
+func (s *Scheduler) rotateDueKeys(now time.Time) (int, error) {
+	due, err := s.store.DueRotations(now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list due rotations: %w", err)
+	}
+
+	rotated := 0
+	for _, key := range due {
+		publicKey, encryptedPrivateKey, checkValue, err := keys.NewMaterial(s.masterKey, key.KeyAlgorithm())
+		if err != nil {
+			log.Printf("Failed to generate material for key %s: %v", key.ID, err)
+			continue
+		}
+
+		updated, err := s.store.RotateKeyIfDue(key.ID, publicKey, encryptedPrivateKey, checkValue, now)
+		if err != nil {
+			if err != errors.ErrRotationNotDue {
+				log.Printf("Failed to rotate key %s: %v", key.ID, err)
+			}
+			continue
+		}
+
+		log.Printf("Rotated key %s to version %d", updated.ID, updated.PrimaryVersionNumber())
+		rotated++
+	}
+
+	return rotated, nil
+}
+
+// applyLifecycleChanges activates pending keys and destroys keys whose deletion waiting period has ended
+func (s *Scheduler) applyLifecycleChanges(now time.Time) (int, error) {
+	activations, destructions, err := s.store.DueLifecycleChanges(now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list due lifecycle changes: %w", err)
+	}
+
+	changed := 0
+	for _, key := range activations {
+		if _, err := s.store.ActivateKeyIfDue(key.ID, now); err != nil {
+			if err != errors.ErrInvalidKeyState {
+				log.Printf("Failed to activate key %s: %v", key.ID, err)
+			}
+			continue
+		}
+		log.Printf("Activated key %s", key.ID)
+		changed++
+	}
+
+	for _, key := range destructions {
+		if _, err := s.store.DestroyKeyIfDue(key.ID, now, s.cascade); err != nil {
+			if err == errors.ErrKeyHasLiveChildren {
+				log.Printf("Deferred destroying key %s: it has live child keys", key.ID)
+			} else if err != errors.ErrInvalidKeyState {
+				log.Printf("Failed to destroy key %s: %v", key.ID, err)
+			}
+			continue
+		}
+		log.Printf("Destroyed key material of key %s", key.ID)
+		changed++
+	}
+
+	return changed, nil
+}
+
+// newOwnerID identifies this server instance in scheduler leases
+func newOwnerID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
@@ -13,8 +13,55 @@ import (
 const (
 	// KeysBucket is the name of the bucket storing keys
 	KeysBucket = "keys"
+	// LicensesBucket is the name of the bucket storing issued license records
+	LicensesBucket = "licenses"
+	// EventsBucket is the name of the bucket storing key events
+	EventsBucket = "events"
+	// LeasesBucket is the name of the bucket storing background job leases
+	LeasesBucket = "leases"
+	// ImportTokensBucket is the name of the bucket storing one-time key import tokens
+	ImportTokensBucket = "import_tokens"
+	// ExportRecipientsBucket is the name of the bucket storing registered key export recipients
+	ExportRecipientsBucket = "export_recipients"
+	// SiteTokensBucket is the name of the bucket storing site API tokens
+	SiteTokensBucket = "site_tokens"
+	// SiteTokenHashesBucket is the name of the bucket indexing site token IDs by token hash
+	SiteTokenHashesBucket = "site_token_hashes"
+	// RefreshChallengesBucket is the name of the bucket storing one-time key refresh challenges
+	RefreshChallengesBucket = "refresh_challenges"
+	// NotificationsBucket is the name of the bucket storing expiry notification deliveries
+	NotificationsBucket = "notifications"
+	// KeyUsageBucket is the name of the bucket storing per-key operation counters
+	KeyUsageBucket = "key_usage"
+	// KeysByCreatedAtBucket is the name of the bucket indexing key IDs by creation time
+	KeysByCreatedAtBucket = "keys_by_created_at"
+	// KeysByExpiresAtBucket is the name of the bucket indexing key IDs by expiry
+	KeysByExpiresAtBucket = "keys_by_expires_at"
+	// KeysByNameBucket is the name of the bucket indexing key IDs by display name
+	KeysByNameBucket = "keys_by_name"
+	// DerivedKeysBucket is the name of the bucket indexing derived key IDs by parent and context
+	DerivedKeysBucket = "derived_keys"
 )
 
+// buckets lists every bucket created when the store is opened
+var buckets = []string{
+	KeysBucket,
+	LicensesBucket,
+	EventsBucket,
+	LeasesBucket,
+	ImportTokensBucket,
+	ExportRecipientsBucket,
+	SiteTokensBucket,
+	SiteTokenHashesBucket,
+	RefreshChallengesBucket,
+	NotificationsBucket,
+	KeyUsageBucket,
+	KeysByCreatedAtBucket,
+	KeysByExpiresAtBucket,
+	KeysByNameBucket,
+	DerivedKeysBucket,
+}
+
 // BoltStore implements the storage interface using BoltDB
 type BoltStore struct {
 	db *bbolt.DB
@@ -29,12 +76,18 @@ func NewBoltStore(dbPath string) (*BoltStore, error) {
 
 	store := &BoltStore{db: db}
 
-	// Initialize the keys bucket
+	// Initialize the buckets
 	if err := store.initBucket(); err != nil {
 		db.Close()
 		return nil, err
 	}
 
+	// Index keys stored before the indexes existed
+	if err := store.buildKeyIndexes(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return store, nil
 }
 
@@ -43,11 +96,15 @@ func (s *BoltStore) Close() error {
 	return s.db.Close()
 }
 
-// initBucket initializes the keys bucket if it doesn't exist
+// initBucket initializes all buckets if they don't exist
 func (s *BoltStore) initBucket() error {
 	return s.db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(KeysBucket))
-		return err
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
@@ -59,6 +116,15 @@ func (s *BoltStore) StoreKey(key *Key) error {
 			return fmt.Errorf("bucket %s not found", KeysBucket)
 		}
 
+		// A key stored again under the same ID replaces its old index entries
+		old, err := getKey(bucket, key.ID)
+		if err != nil && err != errors.ErrKeyNotFound {
+			return err
+		}
+		if err := indexKey(tx, old, key); err != nil {
+			return err
+		}
+
 		data, err := json.Marshal(key)
 		if err != nil {
 			return fmt.Errorf("failed to marshal key: %w", err)
@@ -112,8 +178,12 @@ func (s *BoltStore) UpdateKeyExpiry(keyID string, newExpiry time.Time) error {
 			return fmt.Errorf("failed to unmarshal key: %w", err)
 		}
 
+		old := key
 		key.ExpiresAt = newExpiry
 		key.Version++
+		if err := indexKey(tx, &old, &key); err != nil {
+			return err
+		}
 
 		data, err := json.Marshal(&key)
 		if err != nil {
@@ -142,6 +212,13 @@ func (s *BoltStore) RevokeKey(keyID string) error {
 			return fmt.Errorf("failed to unmarshal key: %w", err)
 		}
 
+		if !key.CanTransition(KeyStatusRevoked) {
+			return errors.ErrInvalidKeyState
+		}
+		if err := putEvent(tx, statusChangedEvent(&key, KeyStatusRevoked, ActorAPI, time.Now().UTC())); err != nil {
+			return err
+		}
+
 		key.Status = KeyStatusRevoked
 		key.Version++
 
@@ -172,6 +249,9 @@ func (s *BoltStore) ListKeys() ([]*Key, error) {
 
 			// Clear private key material for audit
 			key.EncryptedPrivateKey = nil
+			for i := range key.Versions {
+				key.Versions[i].EncryptedPrivateKey = nil
+			}
 			keys = append(keys, &key)
 			return nil
 		})
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// needsCheckValues reports whether a symmetric key has material stored without its check value
+func needsCheckValues(key *Key) bool {
+	if key.KeyType != KeyTypeSymmetric || key.IsDestroyed() {
+		return false
+	}
+	if key.IsDerived() {
+		return key.CheckValue == ""
+	}
+	for _, version := range key.MaterialVersions() {
+		if len(version.EncryptedPrivateKey) > 0 && version.CheckValue == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// KeysWithoutCheckValues lists symmetric keys stored before check values were recorded
+// Returns keys with their encrypted material, so the caller can compute the missing values
+func (s *BoltStore) KeysWithoutCheckValues() ([]*Key, error) {
+	var missing []*Key
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(KeysBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var key Key
+			if err := json.Unmarshal(v, &key); err != nil {
+				return fmt.Errorf("failed to unmarshal key: %w", err)
+			}
+			if needsCheckValues(&key) {
+				missing = append(missing, &key)
+			}
+			return nil
+		})
+	})
+	return missing, err
+}
+
+// SetKeyCheckValues records check values by material version where none is recorded yet
+// The top-level check value follows the primary version, like the top-level material
+func (s *BoltStore) SetKeyCheckValues(keyID string, checkValues map[int]string) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		for i := range key.Versions {
+			if value, ok := checkValues[key.Versions[i].Version]; ok && key.Versions[i].CheckValue == "" {
+				key.Versions[i].CheckValue = value
+			}
+		}
+		if value, ok := checkValues[key.PrimaryVersionNumber()]; ok && key.CheckValue == "" {
+			key.CheckValue = value
+		}
+		return nil
+	})
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// StoreDerivedKey stores a derived key record and indexes it under its parent and context
+// Fails with ErrDerivedKeyExists if the parent already has a key derived with the same context,
+// and with ErrInvalidKeyState if the parent is no longer usable or holds no pinned material
+func (s *BoltStore) StoreDerivedKey(key *Key) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		keys := tx.Bucket([]byte(KeysBucket))
+		if keys == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+		derived := tx.Bucket([]byte(DerivedKeysBucket))
+		if derived == nil {
+			return fmt.Errorf("bucket %s not found", DerivedKeysBucket)
+		}
+
+		parent, err := getKey(keys, key.DerivedFrom)
+		if err != nil {
+			return err
+		}
+		if !parent.IsValid() || parent.IsDerived() || parent.MaterialVersion(key.DerivedFromVersion) == nil {
+			return errors.ErrInvalidKeyState
+		}
+
+		indexEntry := derivedKeyIndexEntry(key.DerivedFrom, key.DerivationContext)
+		if derived.Get(indexEntry) != nil {
+			return errors.ErrDerivedKeyExists
+		}
+
+		if err := indexKey(tx, nil, key); err != nil {
+			return err
+		}
+		data, err := json.Marshal(key)
+		if err != nil {
+			return fmt.Errorf("failed to marshal key: %w", err)
+		}
+		if err := keys.Put([]byte(key.ID), data); err != nil {
+			return err
+		}
+		if err := derived.Put(indexEntry, []byte(key.ID)); err != nil {
+			return err
+		}
+
+		return putEvent(tx, &Event{
+			Type:  EventKeyDerived,
+			KeyID: parent.ID,
+			Actor: ActorAPI,
+			Time:  key.CreatedAt,
+			Details: map[string]string{
+				"derived_key_id": key.ID,
+				"context":        key.DerivationContext,
+				"parent_version": strconv.Itoa(key.DerivedFromVersion),
+			},
+		})
+	})
+}
+
+// FindDerivedKey retrieves the key derived from a parent with the given context
+func (s *BoltStore) FindDerivedKey(parentID, context string) (*Key, error) {
+	var key *Key
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		derived := tx.Bucket([]byte(DerivedKeysBucket))
+		if derived == nil {
+			return fmt.Errorf("bucket %s not found", DerivedKeysBucket)
+		}
+		keys := tx.Bucket([]byte(KeysBucket))
+		if keys == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+
+		keyID := derived.Get(derivedKeyIndexEntry(parentID, context))
+		if keyID == nil {
+			return errors.ErrKeyNotFound
+		}
+
+		var err error
+		key, err = getKey(keys, string(keyID))
+		return err
+	})
+	return key, err
+}
+
+// derivedKeyIndexEntry returns the index entry of a derived key
+// Key IDs never contain a NUL byte, so the separator keeps parents apart
+func derivedKeyIndexEntry(parentID, context string) []byte {
+	return []byte(parentID + "\x00" + context)
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// putEvent appends an event within an open transaction
+// Events are keyed by a big-endian sequence number so they iterate in order
+func putEvent(tx *bbolt.Tx, event *Event) error {
+	bucket := tx.Bucket([]byte(EventsBucket))
+	if bucket == nil {
+		return fmt.Errorf("bucket %s not found", EventsBucket)
+	}
+
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return fmt.Errorf("failed to allocate event id: %w", err)
+	}
+	event.ID = id
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	return bucket.Put(eventKey(id), data)
+}
+
+// RecordEvent appends an event
+func (s *BoltStore) RecordEvent(event *Event) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return putEvent(tx, event)
+	})
+}
+
+// ListEvents returns the most recent events, newest first
+// An empty keyID returns events for all keys; limit <= 0 returns every event
+func (s *BoltStore) ListEvents(keyID string, limit int) ([]*Event, error) {
+	events := []*Event{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(EventsBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", EventsBucket)
+		}
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var event Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				return fmt.Errorf("failed to unmarshal event: %w", err)
+			}
+			if keyID != "" && event.KeyID != keyID {
+				continue
+			}
+
+			events = append(events, &event)
+			if limit > 0 && len(events) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+
+	return events, err
+}
+
+// eventKey encodes an event ID as a sortable bucket key
+func eventKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
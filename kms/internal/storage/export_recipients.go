@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// ExportRecipient is a registered wrapping public key that key material may be exported to
+type ExportRecipient struct {
+	ID                string    `json:"id"`
+	Name              string    `json:"name"`
+	WrappingAlgorithm string    `json:"wrapping_algorithm"`
+	PublicKey         []byte    `json:"public_key"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// StoreExportRecipient stores a new export recipient
+func (s *BoltStore) StoreExportRecipient(recipient *ExportRecipient) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(ExportRecipientsBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", ExportRecipientsBucket)
+		}
+
+		data, err := json.Marshal(recipient)
+		if err != nil {
+			return fmt.Errorf("failed to marshal export recipient: %w", err)
+		}
+
+		return bucket.Put([]byte(recipient.ID), data)
+	})
+}
+
+// GetExportRecipient retrieves an export recipient by ID
+func (s *BoltStore) GetExportRecipient(recipientID string) (*ExportRecipient, error) {
+	var recipient ExportRecipient
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(ExportRecipientsBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", ExportRecipientsBucket)
+		}
+
+		data := bucket.Get([]byte(recipientID))
+		if data == nil {
+			return errors.ErrExportRecipientNotFound
+		}
+
+		return json.Unmarshal(data, &recipient)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &recipient, nil
+}
+
+// ListExportRecipients returns every export recipient, oldest first
+func (s *BoltStore) ListExportRecipients() ([]*ExportRecipient, error) {
+	var recipients []*ExportRecipient
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(ExportRecipientsBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", ExportRecipientsBucket)
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var recipient ExportRecipient
+			if err := json.Unmarshal(v, &recipient); err != nil {
+				return fmt.Errorf("failed to unmarshal export recipient: %w", err)
+			}
+			recipients = append(recipients, &recipient)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(recipients, func(i, j int) bool {
+		return recipients[i].CreatedAt.Before(recipients[j].CreatedAt)
+	})
+	return recipients, nil
+}
+
+// DeleteExportRecipient removes an export recipient
+func (s *BoltStore) DeleteExportRecipient(recipientID string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(ExportRecipientsBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", ExportRecipientsBucket)
+		}
+
+		if bucket.Get([]byte(recipientID)) == nil {
+			return errors.ErrExportRecipientNotFound
+		}
+
+		return bucket.Delete([]byte(recipientID))
+	})
+}
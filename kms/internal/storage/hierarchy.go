@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// ListChildKeys returns the keys whose parent is parentID, oldest first
+// Returns keys without private key material
+func (s *BoltStore) ListChildKeys(parentID string) ([]*Key, error) {
+	keys, err := s.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var children []*Key
+	for _, key := range keys {
+		if key.ParentKeyID == parentID {
+			children = append(children, key)
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].CreatedAt.Before(children[j].CreatedAt)
+	})
+	return children, nil
+}
+
+// KeyAncestry returns the parent chain of a key, starting with its parent and ending at the hub key
+// Returns keys without private key material
+func (s *BoltStore) KeyAncestry(keyID string) ([]*Key, error) {
+	var ancestry []*Key
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(KeysBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+
+		key, err := getKey(bucket, keyID)
+		if err != nil {
+			return err
+		}
+
+		// Levels are strictly ordered, so a valid chain is at most two parents long
+		seen := map[string]bool{key.ID: true}
+		for key.ParentKeyID != "" {
+			if seen[key.ParentKeyID] {
+				return fmt.Errorf("key hierarchy of %s contains a cycle", keyID)
+			}
+			seen[key.ParentKeyID] = true
+
+			key, err = getKey(bucket, key.ParentKeyID)
+			if err != nil {
+				return err
+			}
+			key.EncryptedPrivateKey = nil
+			for i := range key.Versions {
+				key.Versions[i].EncryptedPrivateKey = nil
+			}
+			ancestry = append(ancestry, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ancestry, nil
+}
+
+// RevokeKeyTree revokes a key together with its descendants
+// Without cascade, the key is only revoked if none of its descendants are live;
+// otherwise it returns ErrKeyHasLiveChildren. With cascade, every descendant that
+// can still be revoked is revoked in the same transaction. Returns the IDs of the
+// revoked descendants.
+func (s *BoltStore) RevokeKeyTree(keyID string, cascade bool) ([]string, error) {
+	var revoked []string
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(KeysBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+
+		key, err := getKey(bucket, keyID)
+		if err != nil {
+			return err
+		}
+		if !key.CanTransition(KeyStatusRevoked) {
+			return errors.ErrInvalidKeyState
+		}
+
+		descendants, err := descendantKeys(bucket, keyID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, descendant := range descendants {
+			if !descendant.IsLive() {
+				continue
+			}
+			if !cascade {
+				return errors.ErrKeyHasLiveChildren
+			}
+
+			event := statusChangedEvent(descendant, KeyStatusRevoked, ActorAPI, now)
+			event.Details["cascade_from"] = keyID
+			if err := revokeInBucket(tx, bucket, descendant, event); err != nil {
+				return err
+			}
+			revoked = append(revoked, descendant.ID)
+		}
+
+		return revokeInBucket(tx, bucket, key, statusChangedEvent(key, KeyStatusRevoked, ActorAPI, now))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return revoked, nil
+}
+
+// revokeInBucket marks a key revoked and records its event
+func revokeInBucket(tx *bbolt.Tx, bucket *bbolt.Bucket, key *Key, event *Event) error {
+	if err := putEvent(tx, event); err != nil {
+		return err
+	}
+
+	key.Status = KeyStatusRevoked
+	return putKeyInBucket(bucket, key)
+}
+
+// putKeyInBucket saves a changed key, bumping its version
+func putKeyInBucket(bucket *bbolt.Bucket, key *Key) error {
+	key.Version++
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key: %w", err)
+	}
+	return bucket.Put([]byte(key.ID), data)
+}
+
+// cascadeToDescendants applies the parent key policy to a change of keyID inside tx
+// Descendants for which affected returns true stop the change with ErrKeyHasLiveChildren,
+// unless cascade is set, in which case apply is called on each and the descendant is saved
+func cascadeToDescendants(tx *bbolt.Tx, keyID string, cascade bool, affected func(*Key) bool, apply func(*Key) error) error {
+	bucket := tx.Bucket([]byte(KeysBucket))
+	if bucket == nil {
+		return fmt.Errorf("bucket %s not found", KeysBucket)
+	}
+
+	descendants, err := descendantKeys(bucket, keyID)
+	if err != nil {
+		return err
+	}
+
+	for _, descendant := range descendants {
+		if !affected(descendant) {
+			continue
+		}
+		if !cascade {
+			return errors.ErrKeyHasLiveChildren
+		}
+		if err := apply(descendant); err != nil {
+			return err
+		}
+		if err := putKeyInBucket(bucket, descendant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cascadeTransition moves a descendant of parentID to the given state and records the event
+func cascadeTransition(tx *bbolt.Tx, key *Key, to KeyStatus, now time.Time, actor, parentID string) error {
+	if !key.CanTransition(to) {
+		return errors.ErrInvalidKeyState
+	}
+
+	event := statusChangedEvent(key, to, actor, now)
+	event.Details["cascade_from"] = parentID
+	key.Status = to
+	return putEvent(tx, event)
+}
+
+// requireActiveAncestors returns ErrInvalidKeyState unless every key above key in the hierarchy is active
+// Use-time checks never consult the parent chain, so a key may only become usable under active parents
+func requireActiveAncestors(bucket *bbolt.Bucket, key *Key) error {
+	seen := map[string]bool{key.ID: true}
+	for parentID := key.ParentKeyID; parentID != ""; {
+		if seen[parentID] {
+			return fmt.Errorf("key hierarchy of %s contains a cycle", key.ID)
+		}
+		seen[parentID] = true
+
+		parent, err := getKey(bucket, parentID)
+		if err != nil {
+			return err
+		}
+		if parent.Status != KeyStatusActive {
+			return errors.ErrInvalidKeyState
+		}
+		parentID = parent.ParentKeyID
+	}
+	return nil
+}
+
+// descendantKeys returns every key below parentID in the hierarchy
+func descendantKeys(bucket *bbolt.Bucket, parentID string) ([]*Key, error) {
+	children := make(map[string][]*Key)
+	err := bucket.ForEach(func(k, v []byte) error {
+		var key Key
+		if err := json.Unmarshal(v, &key); err != nil {
+			return fmt.Errorf("failed to unmarshal key: %w", err)
+		}
+		if key.ParentKeyID != "" {
+			children[key.ParentKeyID] = append(children[key.ParentKeyID], &key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var descendants []*Key
+	seen := map[string]bool{parentID: true}
+	queue := []string{parentID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, child := range children[id] {
+			if seen[child.ID] {
+				continue
+			}
+			seen[child.ID] = true
+			descendants = append(descendants, child)
+			queue = append(queue, child.ID)
+		}
+	}
+	return descendants, nil
+}
+
+// getKey reads a key from the keys bucket
+func getKey(bucket *bbolt.Bucket, keyID string) (*Key, error) {
+	data := bucket.Get([]byte(keyID))
+	if data == nil {
+		return nil, errors.ErrKeyNotFound
+	}
+
+	var key Key
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key: %w", err)
+	}
+	return &key, nil
+}
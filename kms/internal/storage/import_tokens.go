@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// ImportToken holds the ephemeral wrapping key a client encrypts imported key material to
+// Tokens are single-use: consuming one deletes it, whether or not the import succeeds
+type ImportToken struct {
+	ID                  string    `json:"id"`
+	WrappingAlgorithm   string    `json:"wrapping_algorithm"`
+	PublicKey           []byte    `json:"public_key"`
+	EncryptedPrivateKey []byte    `json:"encrypted_private_key"` // Wrapping private key, AES-GCM encrypted with the master key
+	CreatedAt           time.Time `json:"created_at"`
+	ExpiresAt           time.Time `json:"expires_at"`
+}
+
+// IsExpired checks if the token can no longer be used at now
+func (t *ImportToken) IsExpired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// StoreImportToken stores a new import token
+func (s *BoltStore) StoreImportToken(token *ImportToken) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(ImportTokensBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", ImportTokensBucket)
+		}
+
+		data, err := json.Marshal(token)
+		if err != nil {
+			return fmt.Errorf("failed to marshal import token: %w", err)
+		}
+
+		return bucket.Put([]byte(token.ID), data)
+	})
+}
+
+// ConsumeImportToken removes the token and returns it
+// Expired tokens are removed as well, so a token can never be presented twice
+func (s *BoltStore) ConsumeImportToken(tokenID string, now time.Time) (*ImportToken, error) {
+	var token ImportToken
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(ImportTokensBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", ImportTokensBucket)
+		}
+
+		data := bucket.Get([]byte(tokenID))
+		if data == nil {
+			return errors.ErrImportTokenNotFound
+		}
+		if err := json.Unmarshal(data, &token); err != nil {
+			return fmt.Errorf("failed to unmarshal import token: %w", err)
+		}
+
+		return bucket.Delete([]byte(tokenID))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if token.IsExpired(now) {
+		return nil, errors.ErrImportTokenExpired
+	}
+	return &token, nil
+}
+
+// PurgeExpiredImportTokens deletes tokens that expired before now
+// Returns the number of tokens deleted
+func (s *BoltStore) PurgeExpiredImportTokens(now time.Time) (int, error) {
+	purged := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(ImportTokensBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", ImportTokensBucket)
+		}
+
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var token ImportToken
+			if err := json.Unmarshal(v, &token); err != nil {
+				return fmt.Errorf("failed to unmarshal import token: %w", err)
+			}
+			if token.IsExpired(now) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		purged = len(expired)
+		return nil
+	})
+
+	return purged, err
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// RestoreKey stores a key recovered from a backup under its original ID and records a key.recovered event
+// Fails with ErrKeyExists if a key with the same ID is already stored
+func (s *BoltStore) RestoreKey(key *Key, details map[string]string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(KeysBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+
+		if bucket.Get([]byte(key.ID)) != nil {
+			return errors.ErrKeyExists
+		}
+
+		if err := indexKey(tx, nil, key); err != nil {
+			return err
+		}
+		data, err := json.Marshal(key)
+		if err != nil {
+			return fmt.Errorf("failed to marshal key: %w", err)
+		}
+		if err := bucket.Put([]byte(key.ID), data); err != nil {
+			return err
+		}
+
+		return putEvent(tx, &Event{
+			Type:    EventKeyRecovered,
+			KeyID:   key.ID,
+			Actor:   ActorAPI,
+			Time:    time.Now().UTC(),
+			Details: details,
+		})
+	})
+}
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// KeySortField names a field keys can be listed in order of
+type KeySortField string
+
+const (
+	// KeySortCreatedAt lists keys by creation time
+	KeySortCreatedAt KeySortField = "created_at"
+	// KeySortExpiresAt lists keys by expiry
+	KeySortExpiresAt KeySortField = "expires_at"
+	// KeySortName lists keys by display name, case-insensitively
+	KeySortName KeySortField = "name"
+)
+
+// keyIndexBuckets maps each sort field to the bucket indexing keys by it
+// Index entries are the sort value followed by the key ID, so a Bolt cursor walks them in order
+var keyIndexBuckets = map[KeySortField]string{
+	KeySortCreatedAt: KeysByCreatedAtBucket,
+	KeySortExpiresAt: KeysByExpiresAtBucket,
+	KeySortName:      KeysByNameBucket,
+}
+
+// KeyQuery selects a page of keys
+// Zero-valued filters match every key
+type KeyQuery struct {
+	Tags          map[string]string // Every tag must be present; an empty value matches any value
+	KeyType       KeyType
+	Status        KeyStatus
+	ExpiresAfter  *time.Time
+	ExpiresBefore *time.Time
+	Match         func(key *Key) bool // Optional filter applied after the others
+
+	// IncludeUsage reads the stored usage of each matching key in the same transaction,
+	// passes it to MatchUsage and returns it for the page in KeyPage.Usage
+	IncludeUsage bool
+	MatchUsage   func(key *Key, usage *KeyUsage) bool // Optional filter applied last, requires IncludeUsage
+
+	Sort       KeySortField // Default created_at
+	Descending bool
+	Cursor     string // NextCursor of the previous page
+	Limit      int    // Required, at least 1
+}
+
+// KeyPage holds one page of keys
+type KeyPage struct {
+	Keys       []*Key
+	NextCursor string               // Empty on the last page
+	Usage      map[string]*KeyUsage // Stored usage of each key on the page by key ID, set with IncludeUsage
+}
+
+// matches checks a key against the filters of the query
+func (q *KeyQuery) matches(key *Key) bool {
+	if q.KeyType != "" && key.KeyType != q.KeyType {
+		return false
+	}
+	if q.Status != "" && key.Status != q.Status {
+		return false
+	}
+	if q.ExpiresAfter != nil && !key.ExpiresAt.After(*q.ExpiresAfter) {
+		return false
+	}
+	if q.ExpiresBefore != nil && !key.ExpiresAt.Before(*q.ExpiresBefore) {
+		return false
+	}
+	for name, value := range q.Tags {
+		tag, ok := key.Tags[name]
+		if !ok || (value != "" && tag != value) {
+			return false
+		}
+	}
+	return q.Match == nil || q.Match(key)
+}
+
+// QueryKeys returns a page of the keys matching a query, walking the index of its sort field
+// Only the keys on the page are held in memory
+// Returns keys without private key material
+func (s *BoltStore) QueryKeys(query KeyQuery) (*KeyPage, error) {
+	if query.Sort == "" {
+		query.Sort = KeySortCreatedAt
+	}
+	indexName, ok := keyIndexBuckets[query.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field %q", query.Sort)
+	}
+	if query.Limit < 1 {
+		return nil, fmt.Errorf("limit must be at least 1")
+	}
+
+	var after []byte
+	if query.Cursor != "" {
+		var err error
+		after, err = decodeKeyCursor(query.Sort, query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page := &KeyPage{Keys: []*Key{}}
+	if query.IncludeUsage {
+		page.Usage = map[string]*KeyUsage{}
+	}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		keys := tx.Bucket([]byte(KeysBucket))
+		if keys == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+		usages := tx.Bucket([]byte(KeyUsageBucket))
+		if usages == nil {
+			return fmt.Errorf("bucket %s not found", KeyUsageBucket)
+		}
+		index := tx.Bucket([]byte(indexName))
+		if index == nil {
+			return fmt.Errorf("bucket %s not found", indexName)
+		}
+
+		cursor := index.Cursor()
+		next := cursor.Next
+		if query.Descending {
+			next = cursor.Prev
+		}
+
+		var entry, keyID []byte
+		switch {
+		case after == nil && query.Descending:
+			entry, keyID = cursor.Last()
+		case after == nil:
+			entry, keyID = cursor.First()
+		case query.Descending:
+			// Seek lands on the cursor entry or the first one after it; the page continues before both
+			if entry, _ = cursor.Seek(after); entry == nil {
+				entry, keyID = cursor.Last()
+			} else {
+				entry, keyID = cursor.Prev()
+			}
+		default:
+			if entry, keyID = cursor.Seek(after); entry != nil && bytes.Equal(entry, after) {
+				entry, keyID = cursor.Next()
+			}
+		}
+
+		var last []byte
+		for ; entry != nil; entry, keyID = next() {
+			key, err := getKey(keys, string(keyID))
+			if err != nil {
+				return err
+			}
+			if !query.matches(key) {
+				continue
+			}
+
+			// Usage is only read for keys that pass the other filters
+			var usage *KeyUsage
+			if query.IncludeUsage {
+				if usage, err = getKeyUsage(usages, key.ID); err != nil {
+					return err
+				}
+				if query.MatchUsage != nil && !query.MatchUsage(key, usage) {
+					continue
+				}
+			}
+
+			// Another match after a full page means there is a next page
+			if len(page.Keys) == query.Limit {
+				page.NextCursor = encodeKeyCursor(query.Sort, last)
+				return nil
+			}
+
+			key.EncryptedPrivateKey = nil
+			for i := range key.Versions {
+				key.Versions[i].EncryptedPrivateKey = nil
+			}
+			page.Keys = append(page.Keys, key)
+			if usage != nil {
+				page.Usage[key.ID] = usage
+			}
+			last = append(last[:0], entry...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// SetKeyLabels replaces the display name, description and tags of a key
+func (s *BoltStore) SetKeyLabels(keyID, name, description string, tags map[string]string) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		key.Name = name
+		key.Description = description
+		key.Tags = tags
+		return nil
+	})
+}
+
+// keyIndexEntry returns the index entry of a key for a sort field
+func keyIndexEntry(field KeySortField, key *Key) []byte {
+	var value []byte
+	switch field {
+	case KeySortCreatedAt:
+		value = sortableTime(key.CreatedAt)
+	case KeySortExpiresAt:
+		value = sortableTime(key.ExpiresAt)
+	case KeySortName:
+		// The separator sorts a name before every longer name it is a prefix of
+		value = append([]byte(strings.ToLower(key.Name)), 0)
+	}
+	return append(value, key.ID...)
+}
+
+// sortableTime encodes a time so that byte order matches time order, including before 1970
+func sortableTime(t time.Time) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(t.UnixNano())^(1<<63))
+	return value
+}
+
+// indexKey updates the index entries of a key whose previous version was old
+// old is nil for a new key
+func indexKey(tx *bbolt.Tx, old, key *Key) error {
+	for field, name := range keyIndexBuckets {
+		index := tx.Bucket([]byte(name))
+		if index == nil {
+			return fmt.Errorf("bucket %s not found", name)
+		}
+
+		entry := keyIndexEntry(field, key)
+		if old != nil {
+			previous := keyIndexEntry(field, old)
+			if bytes.Equal(previous, entry) {
+				continue
+			}
+			if err := index.Delete(previous); err != nil {
+				return err
+			}
+		}
+		if err := index.Put(entry, []byte(key.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildKeyIndexes indexes every key when an index is empty but keys exist
+func (s *BoltStore) buildKeyIndexes() error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		keys := tx.Bucket([]byte(KeysBucket))
+		if keys == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+		if first, _ := keys.Cursor().First(); first == nil {
+			return nil
+		}
+
+		for field, name := range keyIndexBuckets {
+			index := tx.Bucket([]byte(name))
+			if index == nil {
+				return fmt.Errorf("bucket %s not found", name)
+			}
+			if first, _ := index.Cursor().First(); first != nil {
+				continue
+			}
+
+			err := keys.ForEach(func(k, v []byte) error {
+				var key Key
+				if err := json.Unmarshal(v, &key); err != nil {
+					return fmt.Errorf("failed to unmarshal key: %w", err)
+				}
+				return index.Put(keyIndexEntry(field, &key), k)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// encodeKeyCursor makes an opaque page cursor from the last index entry of a page
+func encodeKeyCursor(field KeySortField, entry []byte) string {
+	return base64.RawURLEncoding.EncodeToString(append([]byte(string(field)+":"), entry...))
+}
+
+// decodeKeyCursor returns the index entry of a page cursor made for the same sort field
+func decodeKeyCursor(field KeySortField, cursor string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.ErrInvalidCursor
+	}
+
+	prefix := []byte(string(field) + ":")
+	if !bytes.HasPrefix(data, prefix) || len(data) == len(prefix) {
+		return nil, errors.ErrInvalidCursor
+	}
+	return data[len(prefix):], nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// KeyUsage holds the operation counters and last use of a key
+// Usage is stored apart from the key so counting never bumps the key's revision
+type KeyUsage struct {
+	KeyID         string                 `json:"key_id"`
+	Counts        map[KeyOperation]int64 `json:"counts"`
+	LastUsedAt    *time.Time             `json:"last_used_at,omitempty"`
+	LastOperation KeyOperation           `json:"last_operation,omitempty"`
+}
+
+// NewKeyUsage returns empty usage for a key
+func NewKeyUsage(keyID string) *KeyUsage {
+	return &KeyUsage{KeyID: keyID, Counts: map[KeyOperation]int64{}}
+}
+
+// Record counts one operation at the given time
+func (u *KeyUsage) Record(op KeyOperation, at time.Time) {
+	u.Counts[op]++
+	if u.LastUsedAt == nil || at.After(*u.LastUsedAt) {
+		u.LastUsedAt = &at
+		u.LastOperation = op
+	}
+}
+
+// Add merges the counters and last use of other into u
+func (u *KeyUsage) Add(other *KeyUsage) {
+	for op, count := range other.Counts {
+		u.Counts[op] += count
+	}
+	if other.LastUsedAt != nil && (u.LastUsedAt == nil || other.LastUsedAt.After(*u.LastUsedAt)) {
+		lastUsedAt := *other.LastUsedAt
+		u.LastUsedAt = &lastUsedAt
+		u.LastOperation = other.LastOperation
+	}
+}
+
+// Total returns the number of operations counted across every operation type
+func (u *KeyUsage) Total() int64 {
+	var total int64
+	for _, count := range u.Counts {
+		total += count
+	}
+	return total
+}
+
+// AddKeyUsage merges a batch of usage deltas into the stored usage in one transaction
+// Deltas for keys that do not exist are dropped
+func (s *BoltStore) AddKeyUsage(deltas []*KeyUsage) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		keys := tx.Bucket([]byte(KeysBucket))
+		if keys == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+		bucket := tx.Bucket([]byte(KeyUsageBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", KeyUsageBucket)
+		}
+
+		for _, delta := range deltas {
+			if keys.Get([]byte(delta.KeyID)) == nil {
+				continue
+			}
+
+			usage, err := getKeyUsage(bucket, delta.KeyID)
+			if err != nil {
+				return err
+			}
+			usage.Add(delta)
+
+			data, err := json.Marshal(usage)
+			if err != nil {
+				return fmt.Errorf("failed to marshal key usage: %w", err)
+			}
+			if err := bucket.Put([]byte(delta.KeyID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetKeyUsage retrieves the stored usage of a key
+// Returns empty usage if the key was never used
+func (s *BoltStore) GetKeyUsage(keyID string) (*KeyUsage, error) {
+	var usage *KeyUsage
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(KeyUsageBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", KeyUsageBucket)
+		}
+
+		var err error
+		usage, err = getKeyUsage(bucket, keyID)
+		return err
+	})
+	return usage, err
+}
+
+// ListKeyUsage returns the stored usage of every key that was used, by key ID
+func (s *BoltStore) ListKeyUsage() (map[string]*KeyUsage, error) {
+	usages := map[string]*KeyUsage{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(KeyUsageBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", KeyUsageBucket)
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			usage := NewKeyUsage(string(k))
+			if err := json.Unmarshal(v, usage); err != nil {
+				return fmt.Errorf("failed to unmarshal key usage: %w", err)
+			}
+			usages[usage.KeyID] = usage
+			return nil
+		})
+	})
+	return usages, err
+}
+
+// getKeyUsage reads the usage of a key from the key usage bucket
+func getKeyUsage(bucket *bbolt.Bucket, keyID string) (*KeyUsage, error) {
+	usage := NewKeyUsage(keyID)
+	data := bucket.Get([]byte(keyID))
+	if data == nil {
+		return usage, nil
+	}
+
+	if err := json.Unmarshal(data, usage); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key usage: %w", err)
+	}
+	if usage.Counts == nil {
+		usage.Counts = map[KeyOperation]int64{}
+	}
+	return usage, nil
+}
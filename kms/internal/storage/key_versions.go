@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// Actors recorded on key events
+const (
+	// ActorAPI marks changes made through the HTTP API
+	ActorAPI = "api"
+	// ActorScheduler marks changes made by the background scheduler
+	ActorScheduler = "scheduler"
+)
+
+// RotateKey adds new material to a key and makes it the primary version
+// The new version number is assigned by the store; older versions stay usable
+// checkValue is the key check value of symmetric material and empty for asymmetric keys
+func (s *BoltStore) RotateKey(keyID string, publicKey, encryptedPrivateKey []byte, checkValue string) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		if key.IsRevoked() {
+			return errors.ErrKeyRevoked
+		}
+		if key.Status != KeyStatusActive {
+			return errors.ErrInvalidKeyState
+		}
+		return rotateKey(tx, key, publicKey, encryptedPrivateKey, checkValue, time.Now().UTC(), ActorAPI)
+	})
+}
+
+// RotateKeyIfDue rotates a key only if its scheduled rotation is still due at now
+// Checking and rotating in one transaction means a rotation already applied by
+// another scheduler instance is detected and reported as errors.ErrRotationNotDue
+func (s *BoltStore) RotateKeyIfDue(keyID string, publicKey, encryptedPrivateKey []byte, checkValue string, now time.Time) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		if key.Status != KeyStatusActive || key.NextRotationAt == nil || key.NextRotationAt.After(now) {
+			return errors.ErrRotationNotDue
+		}
+		return rotateKey(tx, key, publicKey, encryptedPrivateKey, checkValue, now, ActorScheduler)
+	})
+}
+
+// rotateKey appends a new primary version to key and records the rotation event
+func rotateKey(tx *bbolt.Tx, key *Key, publicKey, encryptedPrivateKey []byte, checkValue string, now time.Time, actor string) error {
+	// Keys stored before versioning get their material recorded as version 1
+	versions := key.MaterialVersions()
+	next := 0
+	for _, v := range versions {
+		if v.Version > next {
+			next = v.Version
+		}
+	}
+	next++
+
+	versions = append(versions, KeyVersion{
+		Version:             next,
+		PublicKey:           publicKey,
+		EncryptedPrivateKey: encryptedPrivateKey,
+		CheckValue:          checkValue,
+		Status:              KeyVersionStatusEnabled,
+		CreatedAt:           now,
+	})
+
+	key.Versions = versions
+	key.PrimaryVersion = next
+	// The top-level material always mirrors the primary version
+	key.PublicKey = publicKey
+	key.EncryptedPrivateKey = encryptedPrivateKey
+	key.CheckValue = checkValue
+	key.LastRotatedAt = &now
+
+	if policy := key.RotationPolicy; policy != nil {
+		if policy.ValidityDays > 0 {
+			key.ExpiresAt = now.Add(time.Duration(policy.ValidityDays) * 24 * time.Hour)
+		}
+		nextRotation := policy.NextRotation(key, now)
+		key.NextRotationAt = &nextRotation
+	}
+
+	details := map[string]string{"primary_version": strconv.Itoa(next)}
+	if key.NextRotationAt != nil {
+		details["next_rotation_at"] = key.NextRotationAt.Format(time.RFC3339)
+	}
+
+	return putEvent(tx, &Event{
+		Type:    EventKeyRotated,
+		KeyID:   key.ID,
+		Actor:   actor,
+		Time:    now,
+		Details: details,
+	})
+}
+
+// SetRotationPolicy sets or clears (nil policy) the automatic rotation policy of a key
+// The next rotation is scheduled from now
+func (s *BoltStore) SetRotationPolicy(keyID string, policy *RotationPolicy, now time.Time) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		key.RotationPolicy = policy
+		key.NextRotationAt = nil
+		if policy != nil {
+			next := policy.NextRotation(key, now)
+			key.NextRotationAt = &next
+		}
+		return nil
+	})
+}
+
+// DueRotations lists keys whose scheduled rotation is due at now
+// Only active keys are rotated
+func (s *BoltStore) DueRotations(now time.Time) ([]*Key, error) {
+	var due []*Key
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(KeysBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var key Key
+			if err := json.Unmarshal(v, &key); err != nil {
+				return fmt.Errorf("failed to unmarshal key: %w", err)
+			}
+			if key.Status != KeyStatusActive || key.NextRotationAt == nil || key.NextRotationAt.After(now) {
+				return nil
+			}
+			due = append(due, &key)
+			return nil
+		})
+	})
+
+	return due, err
+}
+
+// RetireKeyVersion retires one material version of a key
+// The primary version cannot be retired; retiring a retired version is a no-op
+func (s *BoltStore) RetireKeyVersion(keyID string, version int) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		if version == key.PrimaryVersionNumber() {
+			return errors.ErrPrimaryKeyVersion
+		}
+
+		versions := key.MaterialVersions()
+		for i := range versions {
+			if versions[i].Version != version {
+				continue
+			}
+			if !versions[i].IsRetired() {
+				now := time.Now().UTC()
+				versions[i].Status = KeyVersionStatusRetired
+				versions[i].RetiredAt = &now
+			}
+			key.Versions = versions
+			return nil
+		}
+
+		return errors.ErrKeyVersionNotFound
+	})
+}
+
+// updateKey applies fn to a stored key in a single transaction and bumps its revision
+func (s *BoltStore) updateKey(keyID string, fn func(tx *bbolt.Tx, key *Key) error) (*Key, error) {
+	var updated *Key
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(KeysBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+
+		data := bucket.Get([]byte(keyID))
+		if data == nil {
+			return errors.ErrKeyNotFound
+		}
+
+		var key Key
+		if err := json.Unmarshal(data, &key); err != nil {
+			return fmt.Errorf("failed to unmarshal key: %w", err)
+		}
+
+		old := key
+		if err := fn(tx, &key); err != nil {
+			return err
+		}
+		key.Version++
+		if err := indexKey(tx, &old, &key); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(&key)
+		if err != nil {
+			return fmt.Errorf("failed to marshal key: %w", err)
+		}
+
+		updated = &key
+		return bucket.Put([]byte(keyID), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Lease is held by one server instance while it runs a background job
+type Lease struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AcquireLease takes or renews the named lease for owner until now+ttl
+// Returns false if another owner holds an unexpired lease
+func (s *BoltStore) AcquireLease(name, owner string, now time.Time, ttl time.Duration) (bool, error) {
+	acquired := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(LeasesBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", LeasesBucket)
+		}
+
+		if data := bucket.Get([]byte(name)); data != nil {
+			var current Lease
+			if err := json.Unmarshal(data, &current); err != nil {
+				return fmt.Errorf("failed to unmarshal lease: %w", err)
+			}
+			if current.Owner != owner && now.Before(current.ExpiresAt) {
+				return nil
+			}
+		}
+
+		data, err := json.Marshal(&Lease{Owner: owner, ExpiresAt: now.Add(ttl)})
+		if err != nil {
+			return fmt.Errorf("failed to marshal lease: %w", err)
+		}
+
+		acquired = true
+		return bucket.Put([]byte(name), data)
+	})
+
+	return acquired, err
+}
+
+// ReleaseLease gives up the named lease if owner holds it
+func (s *BoltStore) ReleaseLease(name, owner string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(LeasesBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", LeasesBucket)
+		}
+
+		data := bucket.Get([]byte(name))
+		if data == nil {
+			return nil
+		}
+
+		var current Lease
+		if err := json.Unmarshal(data, &current); err != nil {
+			return fmt.Errorf("failed to unmarshal lease: %w", err)
+		}
+		if current.Owner != owner {
+			return nil
+		}
+
+		return bucket.Delete([]byte(name))
+	})
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// StoreLicense stores an issued license record
+func (s *BoltStore) StoreLicense(license *LicenseRecord) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(LicensesBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", LicensesBucket)
+		}
+
+		return putLicense(bucket, license)
+	})
+}
+
+// GetLicense retrieves a license record by license ID
+func (s *BoltStore) GetLicense(licenseID string) (*LicenseRecord, error) {
+	var license *LicenseRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(LicensesBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", LicensesBucket)
+		}
+
+		l, err := getLicense(bucket, licenseID)
+		if err != nil {
+			return err
+		}
+
+		license = l
+		return nil
+	})
+
+	return license, err
+}
+
+// ListLicenses lists every license record
+// Returns records without the issued license and signature files
+func (s *BoltStore) ListLicenses() ([]*LicenseRecord, error) {
+	var licenses []*LicenseRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(LicensesBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", LicensesBucket)
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var license LicenseRecord
+			if err := json.Unmarshal(v, &license); err != nil {
+				return fmt.Errorf("failed to unmarshal license: %w", err)
+			}
+
+			license.LicenseFile = nil
+			license.SignatureFile = nil
+			licenses = append(licenses, &license)
+			return nil
+		})
+	})
+
+	return licenses, err
+}
+
+// TransferLicense revokes the old license and stores its replacement in one transaction
+// Fails if the old license was revoked in the meantime
+func (s *BoltStore) TransferLicense(oldLicenseID string, replacement *LicenseRecord) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(LicensesBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", LicensesBucket)
+		}
+
+		old, err := getLicense(bucket, oldLicenseID)
+		if err != nil {
+			return err
+		}
+
+		if old.IsRevoked() {
+			return errors.ErrLicenseRevoked
+		}
+
+		now := time.Now().UTC()
+		old.Status = LicenseStatusRevoked
+		old.RevokedAt = &now
+		old.ReplacedBy = replacement.ID
+
+		if err := putLicense(bucket, old); err != nil {
+			return err
+		}
+
+		return putLicense(bucket, replacement)
+	})
+}
+
+// getLicense reads a license record from the licenses bucket
+func getLicense(bucket *bbolt.Bucket, licenseID string) (*LicenseRecord, error) {
+	data := bucket.Get([]byte(licenseID))
+	if data == nil {
+		return nil, errors.ErrLicenseNotFound
+	}
+
+	var license LicenseRecord
+	if err := json.Unmarshal(data, &license); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal license: %w", err)
+	}
+
+	return &license, nil
+}
+
+// putLicense writes a license record to the licenses bucket
+func putLicense(bucket *bbolt.Bucket, license *LicenseRecord) error {
+	data, err := json.Marshal(license)
+	if err != nil {
+		return fmt.Errorf("failed to marshal license: %w", err)
+	}
+
+	return bucket.Put([]byte(license.ID), data)
+}
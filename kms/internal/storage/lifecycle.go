@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// EnableKey activates a pending key or re-enables a disabled key
+// A key whose hierarchy parents are not all active cannot be enabled
+func (s *BoltStore) EnableKey(keyID string) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		if err := requireActiveAncestors(tx.Bucket([]byte(KeysBucket)), key); err != nil {
+			return err
+		}
+		if err := transitionKey(tx, key, KeyStatusActive, time.Now().UTC(), ActorAPI); err != nil {
+			return err
+		}
+		key.ActivateAt = nil
+		return nil
+	})
+}
+
+// DisableKey makes an active or pending key unusable until it is enabled again
+// Descendants that are active or pending activation follow the same policy as RevokeKeyTree:
+// without cascade the key is not disabled and ErrKeyHasLiveChildren is returned, with cascade
+// they are disabled in the same transaction
+func (s *BoltStore) DisableKey(keyID string, cascade bool) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		now := time.Now().UTC()
+		if err := transitionKey(tx, key, KeyStatusDisabled, now, ActorAPI); err != nil {
+			return err
+		}
+		// A disabled key waits for an explicit enable instead of its activation time
+		key.ActivateAt = nil
+
+		return cascadeToDescendants(tx, keyID, cascade, isUsableOrPending, func(descendant *Key) error {
+			if err := cascadeTransition(tx, descendant, KeyStatusDisabled, now, ActorAPI, keyID); err != nil {
+				return err
+			}
+			descendant.ActivateAt = nil
+			return nil
+		})
+	})
+}
+
+// isUsableOrPending checks if a key is active or will become active by itself
+func isUsableOrPending(key *Key) bool {
+	return key.Status == KeyStatusActive || key.Status == KeyStatusPendingActivation
+}
+
+// ScheduleKeyDeletion moves a key to pending deletion; it is destroyed at deletionDate
+// The current state is recorded so cancelling the deletion cannot revive a revoked key
+// Live descendants follow the same policy as RevokeKeyTree: without cascade the deletion is
+// refused with ErrKeyHasLiveChildren, with cascade they are scheduled for the same date
+func (s *BoltStore) ScheduleKeyDeletion(keyID string, deletionDate time.Time, cascade bool) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		now := time.Now().UTC()
+		previous := key.Status
+		if err := transitionKey(tx, key, KeyStatusPendingDeletion, now, ActorAPI); err != nil {
+			return err
+		}
+		key.StatusBeforeDeletion = previous
+		key.DeletionDate = &deletionDate
+
+		return cascadeToDescendants(tx, keyID, cascade, (*Key).IsLive, func(descendant *Key) error {
+			previous := descendant.Status
+			if err := cascadeTransition(tx, descendant, KeyStatusPendingDeletion, now, ActorAPI, keyID); err != nil {
+				return err
+			}
+			descendant.StatusBeforeDeletion = previous
+			descendant.DeletionDate = &deletionDate
+			return nil
+		})
+	})
+}
+
+// CancelKeyDeletion stops a pending deletion
+// A key that was revoked before deletion was scheduled is revoked again; any other key is left
+// disabled and must be enabled before use
+func (s *BoltStore) CancelKeyDeletion(keyID string) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		if key.Status != KeyStatusPendingDeletion {
+			return errors.ErrInvalidKeyState
+		}
+
+		restored := KeyStatusDisabled
+		if key.StatusBeforeDeletion == KeyStatusRevoked {
+			restored = KeyStatusRevoked
+		}
+		if err := putEvent(tx, statusChangedEvent(key, restored, ActorAPI, time.Now().UTC())); err != nil {
+			return err
+		}
+		key.Status = restored
+		key.StatusBeforeDeletion = ""
+		key.DeletionDate = nil
+		return nil
+	})
+}
+
+// SetUsagePolicy sets or clears (nil policy) the usage policy of a key
+func (s *BoltStore) SetUsagePolicy(keyID string, policy *UsagePolicy) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		if key.IsDestroyed() {
+			return errors.ErrInvalidKeyState
+		}
+		key.UsagePolicy = policy
+		return nil
+	})
+}
+
+// ActivateKeyIfDue activates a pending key once its activation time has passed
+// Like EnableKey, it waits while any of the key's hierarchy parents is not active
+func (s *BoltStore) ActivateKeyIfDue(keyID string, now time.Time) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		if key.Status != KeyStatusPendingActivation || key.ActivateAt == nil || key.ActivateAt.After(now) {
+			return errors.ErrInvalidKeyState
+		}
+		if err := requireActiveAncestors(tx.Bucket([]byte(KeysBucket)), key); err != nil {
+			return err
+		}
+		if err := transitionKey(tx, key, KeyStatusActive, now, ActorScheduler); err != nil {
+			return err
+		}
+		key.ActivateAt = nil
+		return nil
+	})
+}
+
+// DestroyKeyIfDue erases the material of a key whose deletion waiting period has ended
+// The key record is kept, without material, so its ID is never reused and its history stays readable
+// Descendants that became live again, e.g. because their own deletion was cancelled, follow the
+// same policy as RevokeKeyTree: without cascade the destruction is deferred with
+// ErrKeyHasLiveChildren, with cascade they are revoked in the same transaction
+func (s *BoltStore) DestroyKeyIfDue(keyID string, now time.Time, cascade bool) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		if key.Status != KeyStatusPendingDeletion || key.DeletionDate == nil || key.DeletionDate.After(now) {
+			return errors.ErrInvalidKeyState
+		}
+		if err := transitionKey(tx, key, KeyStatusDestroyed, now, ActorScheduler); err != nil {
+			return err
+		}
+		if err := cascadeToDescendants(tx, keyID, cascade, (*Key).IsLive, func(descendant *Key) error {
+			return cascadeTransition(tx, descendant, KeyStatusRevoked, now, ActorScheduler, keyID)
+		}); err != nil {
+			return err
+		}
+
+		key.PublicKey = nil
+		key.EncryptedPrivateKey = nil
+		for i := range key.Versions {
+			key.Versions[i].PublicKey = nil
+			key.Versions[i].EncryptedPrivateKey = nil
+		}
+		key.RotationPolicy = nil
+		key.NextRotationAt = nil
+		key.DestroyedAt = &now
+		return nil
+	})
+}
+
+// DueLifecycleChanges lists pending keys due for activation and pending deletions due for destruction
+func (s *BoltStore) DueLifecycleChanges(now time.Time) (activations, destructions []*Key, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(KeysBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", KeysBucket)
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var key Key
+			if err := json.Unmarshal(v, &key); err != nil {
+				return fmt.Errorf("failed to unmarshal key: %w", err)
+			}
+
+			switch {
+			case key.Status == KeyStatusPendingActivation && key.ActivateAt != nil && !key.ActivateAt.After(now):
+				activations = append(activations, &key)
+			case key.Status == KeyStatusPendingDeletion && key.DeletionDate != nil && !key.DeletionDate.After(now):
+				destructions = append(destructions, &key)
+			}
+			return nil
+		})
+	})
+
+	return activations, destructions, err
+}
+
+// transitionKey validates and applies a lifecycle transition and records it as an event
+func transitionKey(tx *bbolt.Tx, key *Key, to KeyStatus, now time.Time, actor string) error {
+	if !key.CanTransition(to) {
+		return errors.ErrInvalidKeyState
+	}
+
+	event := statusChangedEvent(key, to, actor, now)
+	key.Status = to
+	return putEvent(tx, event)
+}
+
+// statusChangedEvent describes a lifecycle transition of key to the given state
+func statusChangedEvent(key *Key, to KeyStatus, actor string, now time.Time) *Event {
+	return &Event{
+		Type:    EventKeyStatusChanged,
+		KeyID:   key.ID,
+		Actor:   actor,
+		Time:    now,
+		Details: map[string]string{"from": string(key.Status), "to": string(to)},
+	}
+}
@@ -1,6 +1,11 @@
 package storage
 
-import "time"
+import (
+	"time"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/pkg/licensefile"
+)
 
 // KeyType represents the type of cryptographic key
 type KeyType string
@@ -8,30 +13,353 @@ type KeyType string
 const (
 	// KeyTypeSymmetric represents a symmetric key (AES)
 	KeyTypeSymmetric KeyType = "symmetric"
-	// KeyTypeAsymmetric represents an asymmetric key pair (Ed25519)
+	// KeyTypeAsymmetric represents an asymmetric key pair (Ed25519, ECDSA or RSA-PSS)
 	KeyTypeAsymmetric KeyType = "asymmetric"
 )
 
+// DefaultAlgorithm returns the algorithm of keys registered before algorithms were recorded
+func (t KeyType) DefaultAlgorithm() string {
+	if t == KeyTypeAsymmetric {
+		return crypto.AlgorithmEd25519
+	}
+	return crypto.AlgorithmAES256GCM
+}
+
+// AlgorithmKeyType returns the key type an algorithm belongs to
+func AlgorithmKeyType(algorithm string) KeyType {
+	if crypto.IsAsymmetricAlgorithm(algorithm) {
+		return KeyTypeAsymmetric
+	}
+	return KeyTypeSymmetric
+}
+
+// KeyLevel places a key in the Hub -> Enterprise -> Site hierarchy
+type KeyLevel string
+
+const (
+	// KeyLevelHub is the top-level key of a customer organisation
+	KeyLevelHub KeyLevel = "hub"
+	// KeyLevelEnterprise is an enterprise key, owned by a hub key
+	KeyLevelEnterprise KeyLevel = "enterprise"
+	// KeyLevelSite is a site key, owned by an enterprise key
+	KeyLevelSite KeyLevel = "site"
+)
+
+// ParentLevel returns the level a key's parent must have, or "" if the level has no parent
+func (l KeyLevel) ParentLevel() KeyLevel {
+	switch l {
+	case KeyLevelEnterprise:
+		return KeyLevelHub
+	case KeyLevelSite:
+		return KeyLevelEnterprise
+	}
+	return ""
+}
+
+// IsValid checks if the level is known; the empty level is a key outside the hierarchy
+func (l KeyLevel) IsValid() bool {
+	return l == "" || l == KeyLevelHub || l == KeyLevelEnterprise || l == KeyLevelSite
+}
+
+// SiteMode distinguishes development and production site keys
+type SiteMode string
+
+const (
+	// SiteModeDev is a development site key
+	SiteModeDev SiteMode = "dev"
+	// SiteModeProd is a production site key
+	SiteModeProd SiteMode = "prod"
+)
+
 // KeyStatus represents the status of a key
 type KeyStatus string
 
 const (
-	// KeyStatusActive indicates the key is active and can be used
+	// KeyStatusPendingActivation indicates the key was created but cannot be used until activated
+	KeyStatusPendingActivation KeyStatus = "pending_activation"
+	// KeyStatusActive indicates the key is active (enabled) and can be used
 	KeyStatusActive KeyStatus = "active"
+	// KeyStatusDisabled indicates the key is temporarily unusable and can be enabled again
+	KeyStatusDisabled KeyStatus = "disabled"
 	// KeyStatusRevoked indicates the key has been revoked
 	KeyStatusRevoked KeyStatus = "revoked"
+	// KeyStatusPendingDeletion indicates the key will be destroyed when its waiting period ends
+	KeyStatusPendingDeletion KeyStatus = "pending_deletion"
+	// KeyStatusDestroyed indicates the key material has been erased
+	KeyStatusDestroyed KeyStatus = "destroyed"
 )
 
+// keyTransitions lists the states each state may move to
+// Destruction is only applied by the scheduler once the deletion waiting period ends.
+// Leaving pending deletion otherwise is only possible by cancelling it, which returns a
+// revoked key to revoked, so a revoked key can never become usable again
+var keyTransitions = map[KeyStatus][]KeyStatus{
+	KeyStatusPendingActivation: {KeyStatusActive, KeyStatusDisabled, KeyStatusRevoked, KeyStatusPendingDeletion},
+	KeyStatusActive:            {KeyStatusDisabled, KeyStatusRevoked, KeyStatusPendingDeletion},
+	KeyStatusDisabled:          {KeyStatusActive, KeyStatusRevoked, KeyStatusPendingDeletion},
+	KeyStatusRevoked:           {KeyStatusRevoked, KeyStatusPendingDeletion},
+	KeyStatusPendingDeletion:   {KeyStatusDestroyed},
+	KeyStatusDestroyed:         {},
+}
+
+// CanTransition checks if the key may move from its current state to the given state
+func (k *Key) CanTransition(to KeyStatus) bool {
+	for _, allowed := range keyTransitions[k.Status] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyAlgorithm returns the key's algorithm, falling back to the key type's default
+func (k *Key) KeyAlgorithm() string {
+	if k.Algorithm != "" {
+		return k.Algorithm
+	}
+	return k.KeyType.DefaultAlgorithm()
+}
+
+// IsLive checks if the key has not been revoked, scheduled for deletion or destroyed
+func (k *Key) IsLive() bool {
+	return k.Status != KeyStatusRevoked && k.Status != KeyStatusPendingDeletion && k.Status != KeyStatusDestroyed
+}
+
+// IsDestroyed checks if the key material has been erased
+func (k *Key) IsDestroyed() bool {
+	return k.Status == KeyStatusDestroyed
+}
+
+// IsDerived checks if the key has no material of its own and is derived from a parent key on demand
+func (k *Key) IsDerived() bool {
+	return k.DerivedFrom != ""
+}
+
 // Key represents a cryptographic key stored in the system
 type Key struct {
 	ID                 string     `json:"id"`
 	KeyType            KeyType    `json:"key_type"`
+	Algorithm          string     `json:"algorithm,omitempty"`          // Empty for keys registered before algorithms were recorded
 	PublicKey          []byte     `json:"public_key,omitempty"`          // Only for asymmetric keys
 	EncryptedPrivateKey []byte    `json:"encrypted_private_key"`        // AES-GCM encrypted
+	CheckValue         string     `json:"check_value,omitempty"`        // Key check value of symmetric material, see crypto.KeyCheckValue
 	ExpiresAt          time.Time  `json:"expires_at"`
 	CreatedAt          time.Time  `json:"created_at"`
 	Status             KeyStatus  `json:"status"`
-	Version            int        `json:"version"`
+	Version            int        `json:"version"`                      // Record revision, bumped on every update
+	PrimaryVersion     int        `json:"primary_version,omitempty"`    // Material version used to sign and encrypt
+	Versions           []KeyVersion `json:"versions,omitempty"`         // All material versions, oldest first
+
+	RotationPolicy     *RotationPolicy `json:"rotation_policy,omitempty"`
+	NextRotationAt     *time.Time `json:"next_rotation_at,omitempty"`  // Persisted so scheduled rotations survive restarts
+	LastRotatedAt      *time.Time `json:"last_rotated_at,omitempty"`
+	ActivateAt         *time.Time `json:"activate_at,omitempty"`        // Scheduled activation of a pending key
+	DeletionDate       *time.Time `json:"deletion_date,omitempty"`      // End of the deletion waiting period
+	StatusBeforeDeletion KeyStatus `json:"status_before_deletion,omitempty"` // Decides the state a cancelled deletion returns to
+	DestroyedAt        *time.Time `json:"destroyed_at,omitempty"`
+	UsagePolicy        *UsagePolicy `json:"usage_policy,omitempty"`     // Nil allows every operation
+
+	Level              KeyLevel   `json:"level,omitempty"`              // Empty for keys outside the hierarchy
+	ParentKeyID        string     `json:"parent_key_id,omitempty"`      // Hub key of an enterprise key, enterprise key of a site key
+	Owner              string     `json:"owner,omitempty"`              // Organisation, enterprise or site ID the key belongs to
+	SiteMode           SiteMode   `json:"site_mode,omitempty"`          // Only for site keys
+
+	Name               string     `json:"name,omitempty"`               // Display name
+	Description        string     `json:"description,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`        // Free-form labels such as customer, site_id or env
+
+	DerivedFrom        string     `json:"derived_from,omitempty"`       // Parent symmetric key of a derived key
+	DerivedFromVersion int        `json:"derived_from_version,omitempty"` // Parent material version the key is derived from
+	DerivationContext  string     `json:"derivation_context,omitempty"` // HKDF context, such as a site or plant ID
+}
+
+// KeyOperation names an operation a usage policy can allow
+type KeyOperation string
+
+const (
+	// OperationSign allows signing messages and digests
+	OperationSign KeyOperation = "sign"
+	// OperationVerify allows validating key material and signatures
+	OperationVerify KeyOperation = "verify"
+	// OperationEncrypt allows encrypting data and generating data keys
+	OperationEncrypt KeyOperation = "encrypt"
+	// OperationDecrypt allows decrypting ciphertext blobs
+	OperationDecrypt KeyOperation = "decrypt"
+	// OperationLicenseIssue allows issuing and co-signing licenses
+	OperationLicenseIssue KeyOperation = "license-issue"
+	// OperationExport allows taking key material out of the KMS
+	OperationExport KeyOperation = "export"
+)
+
+// KeyOperations lists every operation a usage policy can allow
+var KeyOperations = []KeyOperation{
+	OperationSign,
+	OperationVerify,
+	OperationEncrypt,
+	OperationDecrypt,
+	OperationLicenseIssue,
+	OperationExport,
+}
+
+// UsagePolicy restricts what a key may be used for
+type UsagePolicy struct {
+	AllowedOperations      []KeyOperation `json:"allowed_operations"`
+	MaxLicenseValidityDays int            `json:"max_license_validity_days,omitempty"` // 0 means licenses may last until the key expires
+	AllowedLicenseTypes    []string       `json:"allowed_license_types,omitempty"`     // Empty allows every license type
+	AllowDownload          bool           `json:"allow_download"`                      // Plaintext download, also requires export
+}
+
+// Permits checks if the key's usage policy allows an operation
+// Keys without a usage policy allow every operation
+func (k *Key) Permits(op KeyOperation) bool {
+	if k.UsagePolicy == nil {
+		return true
+	}
+	for _, allowed := range k.UsagePolicy.AllowedOperations {
+		if allowed == op {
+			return true
+		}
+	}
+	return false
+}
+
+// PermitsLicenseType checks if the key's usage policy allows issuing a license type
+func (k *Key) PermitsLicenseType(licenseType string) bool {
+	if k.UsagePolicy == nil || len(k.UsagePolicy.AllowedLicenseTypes) == 0 {
+		return true
+	}
+	for _, allowed := range k.UsagePolicy.AllowedLicenseTypes {
+		if allowed == licenseType {
+			return true
+		}
+	}
+	return false
+}
+
+// PermitsDownload checks if the key's plaintext material may be downloaded
+func (k *Key) PermitsDownload() bool {
+	return k.UsagePolicy == nil || (k.Permits(OperationExport) && k.UsagePolicy.AllowDownload)
+}
+
+// MaxPolicyDays caps the day counts of rotation and usage policies (about 100 years)
+// Larger values overflow once converted to a time.Duration
+const MaxPolicyDays = 36500
+
+// RotationPolicy describes when a key is rotated automatically
+// PeriodDays and DaysBeforeExpiry may be combined; the earlier rotation wins
+type RotationPolicy struct {
+	PeriodDays       int `json:"period_days,omitempty"`        // Rotate every N days
+	DaysBeforeExpiry int `json:"days_before_expiry,omitempty"` // Rotate N days before the key expires
+	ValidityDays     int `json:"validity_days,omitempty"`      // Expiry set on rotation, required with DaysBeforeExpiry
+}
+
+// NextRotation returns when a key with this policy should next rotate
+// from is the time of the last rotation, or when the policy was set
+func (p *RotationPolicy) NextRotation(key *Key, from time.Time) time.Time {
+	var next time.Time
+	if p.PeriodDays > 0 {
+		next = from.Add(time.Duration(p.PeriodDays) * 24 * time.Hour)
+	}
+	if p.DaysBeforeExpiry > 0 {
+		beforeExpiry := key.ExpiresAt.Add(-time.Duration(p.DaysBeforeExpiry) * 24 * time.Hour)
+		if next.IsZero() || beforeExpiry.Before(next) {
+			next = beforeExpiry
+		}
+	}
+	return next
+}
+
+// KeyVersionStatus represents the status of one material version of a key
+type KeyVersionStatus string
+
+const (
+	// KeyVersionStatusEnabled indicates the version can be used
+	KeyVersionStatusEnabled KeyVersionStatus = "enabled"
+	// KeyVersionStatusRetired indicates the version can no longer be used
+	KeyVersionStatusRetired KeyVersionStatus = "retired"
+)
+
+// KeyVersion is one piece of key material
+// Keys stored before versioning have no versions and are treated as version 1
+type KeyVersion struct {
+	Version             int              `json:"version"`
+	PublicKey           []byte           `json:"public_key,omitempty"` // Only for asymmetric keys
+	EncryptedPrivateKey []byte           `json:"encrypted_private_key"`
+	CheckValue          string           `json:"check_value,omitempty"` // Only for symmetric keys
+	Status              KeyVersionStatus `json:"status"`
+	CreatedAt           time.Time        `json:"created_at"`
+	RetiredAt           *time.Time       `json:"retired_at,omitempty"`
+}
+
+// IsRetired checks if the key version has been retired
+func (v *KeyVersion) IsRetired() bool {
+	return v.Status == KeyVersionStatusRetired
+}
+
+// PrimaryVersionNumber returns the material version used to sign and encrypt
+func (k *Key) PrimaryVersionNumber() int {
+	if k.PrimaryVersion == 0 {
+		return 1
+	}
+	return k.PrimaryVersion
+}
+
+// MaterialVersions returns all material versions of the key
+// A key stored before versioning is returned as a single version 1
+func (k *Key) MaterialVersions() []KeyVersion {
+	if len(k.Versions) > 0 {
+		return k.Versions
+	}
+	return []KeyVersion{{
+		Version:             1,
+		PublicKey:           k.PublicKey,
+		EncryptedPrivateKey: k.EncryptedPrivateKey,
+		CheckValue:          k.CheckValue,
+		Status:              KeyVersionStatusEnabled,
+		CreatedAt:           k.CreatedAt,
+	}}
+}
+
+// MaterialVersion returns the given material version, or nil if it does not exist
+func (k *Key) MaterialVersion(version int) *KeyVersion {
+	versions := k.MaterialVersions()
+	for i := range versions {
+		if versions[i].Version == version {
+			return &versions[i]
+		}
+	}
+	return nil
+}
+
+// PrimaryMaterial returns the material version used to sign and encrypt
+func (k *Key) PrimaryMaterial() *KeyVersion {
+	return k.MaterialVersion(k.PrimaryVersionNumber())
+}
+
+// PublishedVersions returns the material versions whose public keys are published at now
+// The primary version is always published. A version replaced by a rotation or
+// retired stays published for overlap, so verifiers can refresh their caches.
+func (k *Key) PublishedVersions(now time.Time, overlap time.Duration) []KeyVersion {
+	primary := k.PrimaryVersionNumber()
+	versions := k.MaterialVersions()
+
+	var published []KeyVersion
+	for i, version := range versions {
+		if version.Version != primary {
+			var supersededAt time.Time
+			if i+1 < len(versions) {
+				supersededAt = versions[i+1].CreatedAt
+			}
+			if version.RetiredAt != nil && (supersededAt.IsZero() || version.RetiredAt.Before(supersededAt)) {
+				supersededAt = *version.RetiredAt
+			}
+			if !supersededAt.IsZero() && !now.Before(supersededAt.Add(overlap)) {
+				continue
+			}
+		}
+		published = append(published, version)
+	}
+	return published
 }
 
 // IsExpired checks if the key has expired
@@ -49,3 +377,94 @@ func (k *Key) IsValid() bool {
 	return k.Status == KeyStatusActive && !k.IsExpired()
 }
 
+
+// LicenseStatus represents the status of an issued license
+type LicenseStatus string
+
+const (
+	// LicenseStatusActive indicates the license is in use
+	LicenseStatusActive LicenseStatus = "active"
+	// LicenseStatusRevoked indicates the license has been revoked or transferred away
+	LicenseStatusRevoked LicenseStatus = "revoked"
+)
+
+// LicenseRecord is the server-side record of an issued license
+type LicenseRecord struct {
+	ID              string            `json:"id"`
+	KeyID           string            `json:"key_id"`
+	LicenseType     string            `json:"license_type"`
+	Fingerprint     string            `json:"fingerprint,omitempty"` // Hardware fingerprint for node-locked licenses
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	SignerKeyIDs    []string          `json:"signer_key_ids,omitempty"`
+	SignaturePolicy *licensefile.SignaturePolicy `json:"signature_policy,omitempty"` // Policy embedded in the license, reused on transfer
+	Detached        bool              `json:"detached,omitempty"`         // Signatures were issued in a separate .sig file
+	IssuedAt        time.Time         `json:"issued_at"`
+	ExpiresAt       time.Time         `json:"expires_at"`
+	Status          LicenseStatus     `json:"status"`
+	RevokedAt       *time.Time        `json:"revoked_at,omitempty"`
+	ReplacedBy      string            `json:"replaced_by,omitempty"`      // License issued by a transfer of this one
+	TransferredFrom string            `json:"transferred_from,omitempty"` // License this one was transferred from
+	Transfers       []LicenseTransfer `json:"transfers,omitempty"`        // Full transfer history of the license chain
+	LicenseFile     []byte            `json:"license_file,omitempty"`     // Issued license file, returned on product key redemption
+	SignatureFile   []byte            `json:"signature_file,omitempty"`   // Detached .sig file, if any
+}
+
+// LicenseTransfer records one move of a license to a new fingerprint
+type LicenseTransfer struct {
+	FromLicenseID   string    `json:"from_license_id"`
+	ToLicenseID     string    `json:"to_license_id"`
+	FromFingerprint string    `json:"from_fingerprint,omitempty"`
+	ToFingerprint   string    `json:"to_fingerprint"`
+	Reason          string    `json:"reason,omitempty"`
+	TransferredAt   time.Time `json:"transferred_at"`
+}
+
+// IsRevoked checks if the license has been revoked
+func (l *LicenseRecord) IsRevoked() bool {
+	return l.Status == LicenseStatusRevoked
+}
+
+// TransfersSince counts the transfers made at or after the given time
+func (l *LicenseRecord) TransfersSince(since time.Time) int {
+	count := 0
+	for _, transfer := range l.Transfers {
+		if !transfer.TransferredAt.Before(since) {
+			count++
+		}
+	}
+	return count
+}
+
+// EventType identifies what an event records
+type EventType string
+
+const (
+	// EventKeyRotated records a key rotation
+	EventKeyRotated EventType = "key.rotated"
+	// EventKeyStatusChanged records a key lifecycle transition
+	EventKeyStatusChanged EventType = "key.status_changed"
+	// EventKeyExported records an export of key material, wrapped or in plaintext
+	EventKeyExported EventType = "key.exported"
+	// EventKeyRefreshed records a key's expiry being extended after proof of possession
+	EventKeyRefreshed EventType = "key.refreshed"
+	// EventKeyDerived records a derived key being registered under its parent
+	EventKeyDerived EventType = "key.derived"
+	// EventKeyBackedUp records a key's material being split into custodian backup shares
+	EventKeyBackedUp EventType = "key.backed_up"
+	// EventKeyRecovered records a key being restored from backup shares
+	EventKeyRecovered EventType = "key.recovered"
+	// EventSiteTokenIssued records a site API token being issued, directly or by rotation
+	EventSiteTokenIssued EventType = "site_token.issued"
+	// EventSiteTokenRevoked records a site API token being revoked or rotated away
+	EventSiteTokenRevoked EventType = "site_token.revoked"
+)
+
+// Event is an append-only record of something that happened to a key
+type Event struct {
+	ID      uint64            `json:"id"` // Monotonic, events are stored in order
+	Type    EventType         `json:"type"`
+	KeyID   string            `json:"key_id,omitempty"`
+	Actor   string            `json:"actor"` // "api" or "scheduler"
+	Time    time.Time         `json:"time"`
+	Details map[string]string `json:"details,omitempty"`
+}
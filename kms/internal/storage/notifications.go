@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// NotificationSubject identifies what kind of record a notification is about
+type NotificationSubject string
+
+const (
+	// NotificationSubjectKey is a notification about a key
+	NotificationSubjectKey NotificationSubject = "key"
+	// NotificationSubjectLicense is a notification about an issued license
+	NotificationSubjectLicense NotificationSubject = "license"
+)
+
+// NotificationStatus represents the delivery status of a notification
+type NotificationStatus string
+
+const (
+	// NotificationStatusSent indicates the notice was delivered
+	NotificationStatusSent NotificationStatus = "sent"
+	// NotificationStatusFailed indicates every delivery attempt so far has failed
+	NotificationStatusFailed NotificationStatus = "failed"
+)
+
+// Notification records the delivery of one expiry warning over one channel
+// The ID is derived from the subject, threshold, expiry and channel, so a warning
+// is sent once per channel; refreshing a key or license gives it new warnings
+type Notification struct {
+	ID            string              `json:"id"`
+	SubjectType   NotificationSubject `json:"subject_type"`
+	SubjectID     string              `json:"subject_id"`
+	ThresholdDays int                 `json:"threshold_days"`
+	ExpiresAt     time.Time           `json:"expires_at"`
+	Channel       string              `json:"channel"` // "webhook" or "smtp"
+	Status        NotificationStatus  `json:"status"`
+	Attempts      int                 `json:"attempts"`
+	LastError     string              `json:"last_error,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+	SentAt        *time.Time          `json:"sent_at,omitempty"`
+}
+
+// NotificationID returns the ID of the notification for a subject, threshold, expiry and channel
+func NotificationID(subjectType NotificationSubject, subjectID string, thresholdDays int, expiresAt time.Time, channel string) string {
+	return fmt.Sprintf("%s:%s:%dd:%d:%s", subjectType, subjectID, thresholdDays, expiresAt.Unix(), channel)
+}
+
+// GetNotification retrieves a notification by ID
+// Returns nil without an error if the notification was never attempted
+func (s *BoltStore) GetNotification(notificationID string) (*Notification, error) {
+	var notification *Notification
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(NotificationsBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", NotificationsBucket)
+		}
+
+		data := bucket.Get([]byte(notificationID))
+		if data == nil {
+			return nil
+		}
+
+		notification = &Notification{}
+		if err := json.Unmarshal(data, notification); err != nil {
+			return fmt.Errorf("failed to unmarshal notification: %w", err)
+		}
+		return nil
+	})
+	return notification, err
+}
+
+// StoreNotification stores a notification, replacing any earlier attempt with the same ID
+func (s *BoltStore) StoreNotification(notification *Notification) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(NotificationsBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", NotificationsBucket)
+		}
+
+		data, err := json.Marshal(notification)
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification: %w", err)
+		}
+
+		return bucket.Put([]byte(notification.ID), data)
+	})
+}
+
+// ListNotifications returns the most recently updated notifications, newest first
+// An empty subjectID returns notifications for every subject; limit <= 0 returns every notification
+func (s *BoltStore) ListNotifications(subjectID string, limit int) ([]*Notification, error) {
+	notifications := []*Notification{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(NotificationsBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", NotificationsBucket)
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var notification Notification
+			if err := json.Unmarshal(v, &notification); err != nil {
+				return fmt.Errorf("failed to unmarshal notification: %w", err)
+			}
+			if subjectID == "" || notification.SubjectID == subjectID {
+				notifications = append(notifications, &notification)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].UpdatedAt.After(notifications[j].UpdatedAt)
+	})
+	if limit > 0 && len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
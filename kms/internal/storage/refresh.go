@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// RefreshChallenge is a one-time random value the holder of a key must sign or MAC to refresh it
+// Challenges are single-use: consuming one deletes it, whether or not the refresh succeeds
+type RefreshChallenge struct {
+	ID        string    `json:"id"`
+	KeyID     string    `json:"key_id"`
+	Challenge []byte    `json:"challenge"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IsExpired checks if the challenge can no longer be answered at now
+func (c *RefreshChallenge) IsExpired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
+
+// StoreRefreshChallenge stores a new refresh challenge
+func (s *BoltStore) StoreRefreshChallenge(challenge *RefreshChallenge) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(RefreshChallengesBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", RefreshChallengesBucket)
+		}
+
+		data, err := json.Marshal(challenge)
+		if err != nil {
+			return fmt.Errorf("failed to marshal refresh challenge: %w", err)
+		}
+
+		return bucket.Put([]byte(challenge.ID), data)
+	})
+}
+
+// ConsumeRefreshChallenge removes the challenge and returns it
+// Expired challenges are removed as well, so a challenge can never be answered twice
+func (s *BoltStore) ConsumeRefreshChallenge(challengeID string, now time.Time) (*RefreshChallenge, error) {
+	var challenge RefreshChallenge
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(RefreshChallengesBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", RefreshChallengesBucket)
+		}
+
+		data := bucket.Get([]byte(challengeID))
+		if data == nil {
+			return errors.ErrRefreshChallengeNotFound
+		}
+		if err := json.Unmarshal(data, &challenge); err != nil {
+			return fmt.Errorf("failed to unmarshal refresh challenge: %w", err)
+		}
+
+		return bucket.Delete([]byte(challengeID))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if challenge.IsExpired(now) {
+		return nil, errors.ErrRefreshChallengeExpired
+	}
+	return &challenge, nil
+}
+
+// OutstandingRefreshChallenges returns the challenges of a key that can still be answered at now
+func (s *BoltStore) OutstandingRefreshChallenges(keyID string, now time.Time) ([]*RefreshChallenge, error) {
+	var challenges []*RefreshChallenge
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(RefreshChallengesBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", RefreshChallengesBucket)
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var challenge RefreshChallenge
+			if err := json.Unmarshal(v, &challenge); err != nil {
+				return fmt.Errorf("failed to unmarshal refresh challenge: %w", err)
+			}
+			if challenge.KeyID == keyID && !challenge.IsExpired(now) {
+				challenges = append(challenges, &challenge)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return challenges, nil
+}
+
+// PurgeExpiredRefreshChallenges deletes challenges that expired before now
+// Returns the number of challenges deleted
+func (s *BoltStore) PurgeExpiredRefreshChallenges(now time.Time) (int, error) {
+	purged := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(RefreshChallengesBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", RefreshChallengesBucket)
+		}
+
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var challenge RefreshChallenge
+			if err := json.Unmarshal(v, &challenge); err != nil {
+				return fmt.Errorf("failed to unmarshal refresh challenge: %w", err)
+			}
+			if challenge.IsExpired(now) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		purged = len(expired)
+		return nil
+	})
+
+	return purged, err
+}
+
+// RefreshKey sets a new expiry on a key and records the refresh event
+// When publicKey or encryptedPrivateKey is set, the key is rotated to that material and checkValue first
+// Revoked, pending deletion and destroyed keys cannot be refreshed
+func (s *BoltStore) RefreshKey(keyID string, expiresAt time.Time, publicKey, encryptedPrivateKey []byte, checkValue string) (*Key, error) {
+	return s.updateKey(keyID, func(tx *bbolt.Tx, key *Key) error {
+		if key.IsRevoked() {
+			return errors.ErrKeyRevoked
+		}
+		if !key.IsLive() {
+			return errors.ErrInvalidKeyState
+		}
+
+		now := time.Now().UTC()
+		rolled := publicKey != nil || encryptedPrivateKey != nil
+		if rolled {
+			if err := rotateKey(tx, key, publicKey, encryptedPrivateKey, checkValue, now, ActorAPI); err != nil {
+				return err
+			}
+		}
+		// The requested expiry wins over any rotation policy validity
+		key.ExpiresAt = expiresAt
+
+		return putEvent(tx, &Event{
+			Type:  EventKeyRefreshed,
+			KeyID: key.ID,
+			Actor: ActorAPI,
+			Time:  now,
+			Details: map[string]string{
+				"expires_at":      expiresAt.Format(time.RFC3339),
+				"rolled":          strconv.FormatBool(rolled),
+				"primary_version": strconv.Itoa(key.PrimaryVersionNumber()),
+			},
+		})
+	})
+}
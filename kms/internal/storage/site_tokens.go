@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// SiteTokenStatus represents the status of a site API token
+type SiteTokenStatus string
+
+const (
+	// SiteTokenStatusActive indicates the token is accepted
+	SiteTokenStatusActive SiteTokenStatus = "active"
+	// SiteTokenStatusRevoked indicates the token was revoked or rotated away
+	SiteTokenStatusRevoked SiteTokenStatus = "revoked"
+)
+
+// SiteToken is an opaque API token issued for a site key
+// Only the SHA-256 hash of the token is stored; the token itself is returned once at issuance
+type SiteToken struct {
+	ID          string          `json:"id"`
+	KeyID       string          `json:"key_id"`     // Site key the token was issued for
+	Hint        string          `json:"hint"`       // Prefix and first characters, to tell tokens apart
+	TokenHash   string          `json:"token_hash"` // Hex-encoded SHA-256 of the token
+	Status      SiteTokenStatus `json:"status"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ExpiresAt   *time.Time      `json:"expires_at,omitempty"` // Nil means the token lasts as long as its key
+	RevokedAt   *time.Time      `json:"revoked_at,omitempty"`
+	ReplacedBy  string          `json:"replaced_by,omitempty"`  // Token issued by a rotation of this one
+	RotatedFrom string          `json:"rotated_from,omitempty"` // Token this one was rotated from
+}
+
+// IsActive checks if the token is unrevoked and unexpired at now
+func (t *SiteToken) IsActive(now time.Time) bool {
+	if t.Status != SiteTokenStatusActive {
+		return false
+	}
+	return t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
+}
+
+// HashSiteToken returns the hex-encoded SHA-256 hash a site token is stored under
+// Tokens carry 256 bits of randomness, so a fast hash is sufficient
+func HashSiteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StoreSiteToken stores a new site token, indexes it by hash and records its issuance on the key
+func (s *BoltStore) StoreSiteToken(token *SiteToken) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := putSiteToken(tx, token, true); err != nil {
+			return err
+		}
+		return putEvent(tx, siteTokenEvent(EventSiteTokenIssued, token, token.CreatedAt, nil))
+	})
+}
+
+// GetSiteToken retrieves a site token by ID
+func (s *BoltStore) GetSiteToken(tokenID string) (*SiteToken, error) {
+	var token *SiteToken
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		token, err = getSiteToken(tx, tokenID)
+		return err
+	})
+	return token, err
+}
+
+// FindSiteToken retrieves the site token matching a presented token value
+func (s *BoltStore) FindSiteToken(value string) (*SiteToken, error) {
+	var token *SiteToken
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		index := tx.Bucket([]byte(SiteTokenHashesBucket))
+		if index == nil {
+			return fmt.Errorf("bucket %s not found", SiteTokenHashesBucket)
+		}
+
+		tokenID := index.Get([]byte(HashSiteToken(value)))
+		if tokenID == nil {
+			return errors.ErrSiteTokenNotFound
+		}
+
+		var err error
+		token, err = getSiteToken(tx, string(tokenID))
+		return err
+	})
+	return token, err
+}
+
+// ListSiteTokens returns the tokens issued for a key, oldest first
+func (s *BoltStore) ListSiteTokens(keyID string) ([]*SiteToken, error) {
+	tokens := []*SiteToken{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(SiteTokensBucket))
+		if bucket == nil {
+			return fmt.Errorf("bucket %s not found", SiteTokensBucket)
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var token SiteToken
+			if err := json.Unmarshal(v, &token); err != nil {
+				return fmt.Errorf("failed to unmarshal site token: %w", err)
+			}
+			if token.KeyID == keyID {
+				tokens = append(tokens, &token)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+// RevokeSiteToken revokes a site token
+// Fails with ErrSiteTokenRevoked if it was already revoked
+func (s *BoltStore) RevokeSiteToken(tokenID string) (*SiteToken, error) {
+	var token *SiteToken
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		token, err = getSiteToken(tx, tokenID)
+		if err != nil {
+			return err
+		}
+
+		if token.Status == SiteTokenStatusRevoked {
+			return errors.ErrSiteTokenRevoked
+		}
+
+		now := time.Now().UTC()
+		token.Status = SiteTokenStatusRevoked
+		token.RevokedAt = &now
+		if err := putSiteToken(tx, token, false); err != nil {
+			return err
+		}
+		return putEvent(tx, siteTokenEvent(EventSiteTokenRevoked, token, now, nil))
+	})
+	return token, err
+}
+
+// RotateSiteToken revokes the old token and stores its replacement in one transaction
+// The key itself is left untouched
+// Fails if the old token was revoked in the meantime
+func (s *BoltStore) RotateSiteToken(oldTokenID string, replacement *SiteToken) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		old, err := getSiteToken(tx, oldTokenID)
+		if err != nil {
+			return err
+		}
+
+		if old.Status == SiteTokenStatusRevoked {
+			return errors.ErrSiteTokenRevoked
+		}
+
+		now := time.Now().UTC()
+		old.Status = SiteTokenStatusRevoked
+		old.RevokedAt = &now
+		old.ReplacedBy = replacement.ID
+
+		if err := putSiteToken(tx, old, false); err != nil {
+			return err
+		}
+		if err := putEvent(tx, siteTokenEvent(EventSiteTokenRevoked, old, now, map[string]string{"replaced_by": replacement.ID})); err != nil {
+			return err
+		}
+
+		if err := putSiteToken(tx, replacement, true); err != nil {
+			return err
+		}
+		return putEvent(tx, siteTokenEvent(EventSiteTokenIssued, replacement, now, map[string]string{"rotated_from": old.ID}))
+	})
+}
+
+// siteTokenEvent builds the key event recording a site token change
+func siteTokenEvent(eventType EventType, token *SiteToken, now time.Time, details map[string]string) *Event {
+	if details == nil {
+		details = map[string]string{}
+	}
+	details["token_id"] = token.ID
+	details["hint"] = token.Hint
+
+	return &Event{
+		Type:    eventType,
+		KeyID:   token.KeyID,
+		Actor:   ActorAPI,
+		Time:    now,
+		Details: details,
+	}
+}
+
+// getSiteToken reads a site token from the site tokens bucket
+func getSiteToken(tx *bbolt.Tx, tokenID string) (*SiteToken, error) {
+	bucket := tx.Bucket([]byte(SiteTokensBucket))
+	if bucket == nil {
+		return nil, fmt.Errorf("bucket %s not found", SiteTokensBucket)
+	}
+
+	data := bucket.Get([]byte(tokenID))
+	if data == nil {
+		return nil, errors.ErrSiteTokenNotFound
+	}
+
+	var token SiteToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal site token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// putSiteToken writes a site token, adding its hash to the index when index is true
+func putSiteToken(tx *bbolt.Tx, token *SiteToken, index bool) error {
+	bucket := tx.Bucket([]byte(SiteTokensBucket))
+	if bucket == nil {
+		return fmt.Errorf("bucket %s not found", SiteTokensBucket)
+	}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshal site token: %w", err)
+	}
+
+	if err := bucket.Put([]byte(token.ID), data); err != nil {
+		return err
+	}
+
+	if !index {
+		return nil
+	}
+
+	hashes := tx.Bucket([]byte(SiteTokenHashesBucket))
+	if hashes == nil {
+		return fmt.Errorf("bucket %s not found", SiteTokenHashesBucket)
+	}
+	return hashes.Put([]byte(token.TokenHash), []byte(token.ID))
+}
@@ -0,0 +1,134 @@
+// Package usage counts key operations in memory and writes them to the store in batches
+package usage
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// Tracker buffers key usage so hot request paths never open a write transaction
+//
+// Pending usage is held per key, so memory is bounded by the number of keys in
+// use between flushes. Flush writes every pending key in one transaction; usage
+// that fails to flush is kept and retried on the next flush.
+type Tracker struct {
+	store   *storage.BoltStore
+	mu      sync.Mutex
+	pending map[string]*storage.KeyUsage
+}
+
+// NewTracker creates a tracker writing to store
+func NewTracker(store *storage.BoltStore) *Tracker {
+	return &Tracker{
+		store:   store,
+		pending: map[string]*storage.KeyUsage{},
+	}
+}
+
+// Record counts one operation on a key
+func (t *Tracker) Record(keyID string, op storage.KeyOperation, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage, ok := t.pending[keyID]
+	if !ok {
+		usage = storage.NewKeyUsage(keyID)
+		t.pending[keyID] = usage
+	}
+	usage.Record(op, at)
+}
+
+// Flush writes the pending usage to the store
+func (t *Tracker) Flush() error {
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = map[string]*storage.KeyUsage{}
+	t.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	deltas := make([]*storage.KeyUsage, 0, len(batch))
+	for _, usage := range batch {
+		deltas = append(deltas, usage)
+	}
+	if err := t.store.AddKeyUsage(deltas); err != nil {
+		// Put the batch back so the counts are not lost
+		t.mu.Lock()
+		for keyID, usage := range batch {
+			if newer, ok := t.pending[keyID]; ok {
+				usage.Add(newer)
+			}
+			t.pending[keyID] = usage
+		}
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Usage returns the usage of a key, including usage not flushed yet
+func (t *Tracker) Usage(keyID string) (*storage.KeyUsage, error) {
+	usage, err := t.store.GetKeyUsage(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	t.AddPending(usage)
+	return usage, nil
+}
+
+// AddPending adds the usage not flushed yet to usage, the stored usage of usage.KeyID
+// It never reads the store, so it is safe to call inside a store transaction
+func (t *Tracker) AddPending(usage *storage.KeyUsage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if pending, ok := t.pending[usage.KeyID]; ok {
+		usage.Add(pending)
+	}
+}
+
+// AllUsage returns the usage of every used key by key ID, including usage not flushed yet
+func (t *Tracker) AllUsage() (map[string]*storage.KeyUsage, error) {
+	usages, err := t.store.ListKeyUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for keyID, pending := range t.pending {
+		usage, ok := usages[keyID]
+		if !ok {
+			usage = storage.NewKeyUsage(keyID)
+			usages[keyID] = usage
+		}
+		usage.Add(pending)
+	}
+	return usages, nil
+}
+
+// Run flushes every interval until ctx is cancelled, then flushes once more
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := t.Flush(); err != nil {
+				log.Printf("Failed to flush key usage: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := t.Flush(); err != nil {
+				log.Printf("Failed to flush key usage: %v", err)
+			}
+		}
+	}
+}
@@ -14,6 +14,54 @@ var (
 	// ErrKeyRevoked indicates the key has been revoked
 	ErrKeyRevoked = fmt.Errorf("key revoked")
 	
+	// ErrKeyVersionNotFound indicates the requested key material version does not exist
+	ErrKeyVersionNotFound = fmt.Errorf("key version not found")
+	
+	// ErrPrimaryKeyVersion indicates the operation is not allowed on the primary key version
+	ErrPrimaryKeyVersion = fmt.Errorf("cannot retire the primary key version")
+	
+	// ErrRotationNotDue indicates a scheduled rotation was already applied or is no longer due
+	ErrRotationNotDue = fmt.Errorf("key rotation not due")
+	
+	// ErrInvalidKeyState indicates the operation is not allowed in the key's current lifecycle state
+	ErrInvalidKeyState = fmt.Errorf("invalid key state")
+	
+	// ErrKeyHasLiveChildren indicates a parent key cannot be revoked while its child keys are live
+	ErrKeyHasLiveChildren = fmt.Errorf("key has live child keys")
+
+	// ErrDerivedKeyExists indicates a key was already derived from the parent with the same context
+	ErrDerivedKeyExists = fmt.Errorf("derived key already exists")
+
+	// ErrKeyExists indicates a key with the same ID is already stored
+	ErrKeyExists = fmt.Errorf("key already exists")
+
+	// ErrInvalidCursor indicates a pagination cursor is malformed or belongs to another sort order
+	ErrInvalidCursor = fmt.Errorf("invalid cursor")
+
+	// ErrOperationNotPermitted indicates the key's usage policy does not allow the operation
+	ErrOperationNotPermitted = fmt.Errorf("operation not permitted by key usage policy")
+	
+	// ErrImportTokenNotFound indicates the import token does not exist or was already used
+	ErrImportTokenNotFound = fmt.Errorf("import token not found")
+
+	// ErrImportTokenExpired indicates the import token expired before it was used
+	ErrImportTokenExpired = fmt.Errorf("import token expired")
+
+	// ErrRefreshChallengeNotFound indicates the refresh challenge does not exist or was already used
+	ErrRefreshChallengeNotFound = fmt.Errorf("refresh challenge not found")
+
+	// ErrRefreshChallengeExpired indicates the refresh challenge expired before it was answered
+	ErrRefreshChallengeExpired = fmt.Errorf("refresh challenge expired")
+
+	// ErrExportRecipientNotFound indicates the requested export recipient was not registered
+	ErrExportRecipientNotFound = fmt.Errorf("export recipient not found")
+
+	// ErrSiteTokenNotFound indicates the site token was never issued
+	ErrSiteTokenNotFound = fmt.Errorf("site token not found")
+
+	// ErrSiteTokenRevoked indicates the site token was already revoked or rotated
+	ErrSiteTokenRevoked = fmt.Errorf("site token revoked")
+
 	// ErrInvalidKeyMaterial indicates the provided key material is invalid
 	ErrInvalidKeyMaterial = fmt.Errorf("invalid key material")
 	
@@ -37,5 +85,13 @@ var (
 	
 	// ErrLicenseRevoked indicates the license has been revoked
 	ErrLicenseRevoked = fmt.Errorf("license revoked")
+	
+	// ErrLicenseNotFound indicates the requested license record was not found
+	ErrLicenseNotFound = fmt.Errorf("license not found")
+	
+	// ErrProductKeyChecksum indicates a product key was mistyped (checksum mismatch)
+	ErrProductKeyChecksum = fmt.Errorf("invalid product key checksum")
+	
+	// ErrInvalidProductKey indicates a well-formed product key that was not issued by this server
+	ErrInvalidProductKey = fmt.Errorf("invalid product key")
 )
-
@@ -0,0 +1,103 @@
+// Package licensefile defines the license file format shared by the KMS and offline verifiers.
+//
+// It has no dependencies on the server, so verifiers embedded in licensed
+// products can import it without pulling in storage or configuration code.
+package licensefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// RootSignerID identifies the server root signer (HMAC-SHA256 with the master key)
+	RootSignerID = "root"
+
+	// SignatureAlgorithmHMACSHA256 is used by the root signer
+	SignatureAlgorithmHMACSHA256 = "HMAC-SHA256"
+	// SignatureAlgorithmEd25519 is used by asymmetric keys stored in the KMS
+	SignatureAlgorithmEd25519 = "Ed25519"
+
+	// SignatureContext is prepended to the payload before it is signed with an Ed25519 key
+	// so that a license signature can never be confused with a signature made for another purpose
+	SignatureContext = "kms-license-v1\n"
+)
+
+// License represents a license file structure
+type License struct {
+	LicenseID       string            `json:"license_id"`
+	LicenseType     string            `json:"license_type"`
+	KeyID           string            `json:"key_id"`
+	KeyType         string            `json:"key_type"`
+	PublicKey       string            `json:"public_key,omitempty"` // Base64 encoded, only for asymmetric keys
+	IssuedAt        time.Time         `json:"issued_at"`
+	ExpiresAt       time.Time         `json:"expires_at"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Fingerprint     string            `json:"fingerprint,omitempty"`      // Set for node-locked licenses
+	SignaturePolicy *SignaturePolicy  `json:"signature_policy,omitempty"` // Covered by every signature
+	Signers         []string          `json:"signers,omitempty"`          // Key IDs of every signer, covered by every signature
+	Signature       string            `json:"signature"`                  // Legacy single HMAC-SHA256 signature
+	Signatures      []Signature       `json:"signatures,omitempty"`       // Only signatures from the listed Signers are accepted
+}
+
+// SignerKeyIDs returns the key IDs the license was issued to be signed by
+// Licenses issued before signers were listed were only ever signed by the root signer
+func (l *License) SignerKeyIDs() []string {
+	if len(l.Signers) == 0 {
+		return []string{RootSignerID}
+	}
+	return l.Signers
+}
+
+// KeyVersionID identifies one material version of a signer key as "key-id:version"
+// This is the kid the KMS publishes the version's public key under; version 0 means version 1
+func KeyVersionID(keyID string, version int) string {
+	if version < 1 {
+		version = 1
+	}
+	return fmt.Sprintf("%s:%d", keyID, version)
+}
+
+// Signature is one signature over the license payload
+type Signature struct {
+	KeyID      string `json:"key_id"`                // RootSignerID or the ID of an asymmetric key
+	KeyVersion int    `json:"key_version,omitempty"` // Material version of the key, absent means version 1
+	Algorithm  string `json:"algorithm"`             // SignatureAlgorithmHMACSHA256 or SignatureAlgorithmEd25519
+	Signature  string `json:"signature"`             // Base64 encoded
+}
+
+// SignaturePolicy states how many signatures a license needs and from which keys
+// Example 2-of-2: {"min_signatures": 2, "required_key_ids": ["root", "<reseller-key-id>"]}
+// Example root plus one of two resellers: {"min_signatures": 2, "required_key_ids": ["root"], "allowed_key_ids": ["<reseller-a>", "<reseller-b>"]}
+// Only required and allowed keys count towards MinSignatures
+type SignaturePolicy struct {
+	MinSignatures  int      `json:"min_signatures"`
+	RequiredKeyIDs []string `json:"required_key_ids,omitempty"` // Every listed key must sign
+	AllowedKeyIDs  []string `json:"allowed_key_ids,omitempty"`  // Further signers counted towards MinSignatures, empty allows none
+}
+
+// DetachedSignatureFile is the content of a detached .sig file
+// The signatures cover the exact bytes of the accompanying license payload file
+type DetachedSignatureFile struct {
+	LicenseID  string      `json:"license_id"`
+	Signatures []Signature `json:"signatures"`
+}
+
+// SigningPayload returns the JSON encoding of the license with all signatures removed
+// These are the bytes covered by embedded signatures
+func SigningPayload(license *License) ([]byte, error) {
+	tempLicense := *license
+	tempLicense.Signature = ""
+	tempLicense.Signatures = nil
+	payload, err := json.Marshal(tempLicense)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal license: %w", err)
+	}
+	return payload, nil
+}
+
+// SignedMessage returns the bytes an Ed25519 license signature covers for a payload
+func SignedMessage(payload []byte) []byte {
+	return append([]byte(SignatureContext), payload...)
+}
@@ -0,0 +1,224 @@
+package licenseverify
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/atprof/license-server/kms/pkg/signedresponse"
+)
+
+var (
+	// ErrClockRollback indicates the system clock is behind the last seen trusted time
+	ErrClockRollback = fmt.Errorf("system clock rolled back")
+
+	// ErrStateTampered indicates the clock state file failed its integrity check
+	ErrStateTampered = fmt.Errorf("clock state file has been tampered with")
+
+	// ErrStateMissing indicates the clock state file does not exist
+	// Deleting the file would otherwise reset rollback detection, so it is never silently recreated
+	ErrStateMissing = fmt.Errorf("clock state file is missing")
+)
+
+// clockState is the persisted monotonic time state
+type clockState struct {
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// clockStateFile is the on-disk form of clockState
+type clockStateFile struct {
+	State string `json:"state"` // Base64 encoded JSON clockState
+	MAC   string `json:"mac"`   // Base64 encoded HMAC-SHA256 over State
+}
+
+// Clock provides a rollback-resistant notion of "now" for offline validation
+// It remembers the latest time it has seen in an HMAC-protected state file and
+// refuses to go backwards by more than the tolerance. Server-issued time tokens
+// can move the trusted time forward.
+//
+// The state file must be created once with Initialize, typically at install
+// time. Afterwards a missing file is reported as ErrStateMissing rather than
+// starting over, and only a server time token can re-create it.
+type Clock struct {
+	path      string
+	stateKey  []byte
+	tolerance time.Duration
+
+	// now returns the system time; replaced in tests
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// NewClock creates a clock backed by the state file at path
+// stateKey protects the state file and should be a per-site secret
+// tolerance is how far the system clock may go backwards before it is treated as a rollback
+func NewClock(path string, stateKey []byte, tolerance time.Duration) *Clock {
+	return &Clock{
+		path:      path,
+		stateKey:  stateKey,
+		tolerance: tolerance,
+		now:       time.Now,
+	}
+}
+
+// Initialize creates the state file with the current system time as the last seen time
+// Returns an error if the state file already exists
+func (c *Clock) Initialize() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := os.Stat(c.path); err == nil {
+		return fmt.Errorf("clock state file %s already exists", c.path)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check clock state: %w", err)
+	}
+
+	return c.save(&clockState{LastSeen: c.now().UTC()})
+}
+
+// SetTimeSource replaces the system time source (useful for tests)
+func (c *Clock) SetTimeSource(now func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Now returns the trusted current time and records it in the state file
+// Returns ErrClockRollback if the system clock is behind the last seen time by more than the tolerance,
+// and ErrStateMissing if the clock has not been initialised or its state file was removed
+func (c *Clock) Now() (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, err := c.load()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now := c.now().UTC()
+	if now.Add(c.tolerance).Before(state.LastSeen) {
+		return time.Time{}, fmt.Errorf("%w: system time %s is before last seen time %s", ErrClockRollback, now.Format(time.RFC3339), state.LastSeen.Format(time.RFC3339))
+	}
+
+	// Never move the trusted time backwards (within the tolerance)
+	if now.After(state.LastSeen) {
+		state.LastSeen = now
+	}
+	if err := c.save(state); err != nil {
+		return time.Time{}, err
+	}
+
+	return state.LastSeen, nil
+}
+
+// AcceptTimeToken verifies a server-issued time token and advances the trusted time to it
+// The token is a signed response from GET /time/token; nonce must match the nonce sent with the request
+// A missing state file is re-created from the server time, since the token vouches for it
+func (c *Clock) AcceptTimeToken(publicKey ed25519.PublicKey, token *signedresponse.Envelope, nonce string) error {
+	payload, err := signedresponse.Verify(publicKey, token, nonce, 0)
+	if err != nil {
+		return fmt.Errorf("invalid time token: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, err := c.load()
+	if err == ErrStateMissing {
+		state = &clockState{}
+	} else if err != nil {
+		return err
+	}
+
+	// A trusted time far ahead of the system clock means the clock has been rolled back
+	if c.now().UTC().Add(c.tolerance).Before(payload.Timestamp) {
+		return fmt.Errorf("%w: system time is behind server time %s", ErrClockRollback, payload.Timestamp.Format(time.RFC3339))
+	}
+
+	if payload.Timestamp.After(state.LastSeen) {
+		state.LastSeen = payload.Timestamp.UTC()
+	}
+
+	return c.save(state)
+}
+
+// load reads and verifies the state file
+func (c *Clock) load() (*clockState, error) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrStateMissing
+		}
+		return nil, fmt.Errorf("failed to read clock state: %w", err)
+	}
+
+	var file clockStateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, ErrStateTampered
+	}
+
+	stateJSON, err := base64.StdEncoding.DecodeString(file.State)
+	if err != nil {
+		return nil, ErrStateTampered
+	}
+	mac, err := base64.StdEncoding.DecodeString(file.MAC)
+	if err != nil {
+		return nil, ErrStateTampered
+	}
+	if !hmac.Equal(mac, c.mac(stateJSON)) {
+		return nil, ErrStateTampered
+	}
+
+	var state clockState
+	if err := json.Unmarshal(stateJSON, &state); err != nil {
+		return nil, ErrStateTampered
+	}
+
+	return &state, nil
+}
+
+// save writes the state file atomically
+func (c *Clock) save(state *clockState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal clock state: %w", err)
+	}
+
+	data, err := json.Marshal(clockStateFile{
+		State: base64.StdEncoding.EncodeToString(stateJSON),
+		MAC:   base64.StdEncoding.EncodeToString(c.mac(stateJSON)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal clock state file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".clock-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to write clock state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write clock state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write clock state: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// mac computes the HMAC-SHA256 of the state JSON
+func (c *Clock) mac(stateJSON []byte) []byte {
+	mac := hmac.New(sha256.New, c.stateKey)
+	mac.Write(stateJSON)
+	return mac.Sum(nil)
+}
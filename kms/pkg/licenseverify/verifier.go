@@ -0,0 +1,142 @@
+// Package licenseverify verifies license files offline, without calling the KMS.
+//
+// Offline verification can only check asymmetric (Ed25519) signatures from
+// pinned public keys, so licenses meant for offline sites must be co-signed by
+// an asymmetric KMS key. Expiry is checked against a rollback-resistant Clock.
+package licenseverify
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/atprof/license-server/kms/pkg/licensefile"
+)
+
+// Verifier checks license files against a set of trusted public keys
+type Verifier struct {
+	// TrustedKeys maps licensefile.KeyVersionID(key ID, version), the JWKS kid, to Ed25519 public keys
+	// Pin every version of a signer that licenses may be signed with; rotated signers add a version
+	TrustedKeys map[string]ed25519.PublicKey
+	// MinSignatures is the number of trusted signatures required (default 1)
+	MinSignatures int
+	// Clock supplies the trusted time; system time is used when nil
+	Clock *Clock
+	// Fingerprint of this host, required to verify node-locked licenses
+	Fingerprint string
+}
+
+// Result represents the result of offline license verification
+type Result struct {
+	Valid       bool              `json:"valid"`
+	LicenseID   string            `json:"license_id,omitempty"`
+	LicenseType string            `json:"license_type,omitempty"`
+	ExpiresAt   time.Time         `json:"expires_at,omitempty"`
+	Expired     bool              `json:"expired"`
+	CheckedAt   time.Time         `json:"checked_at"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	SignedBy    []string          `json:"signed_by,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// Verify verifies a license file; signatureContent is the detached .sig file or nil
+// Returns an error only for clock problems (rollback or tampered state), which
+// callers should treat as a hard failure rather than an invalid license
+func (v *Verifier) Verify(fileContent, signatureContent []byte) (*Result, error) {
+	var license licensefile.License
+	if err := json.Unmarshal(fileContent, &license); err != nil {
+		return &Result{Error: fmt.Sprintf("failed to parse license file: %v", err)}, nil
+	}
+
+	var payload []byte
+	var signatures []licensefile.Signature
+	if signatureContent != nil {
+		var sigFile licensefile.DetachedSignatureFile
+		if err := json.Unmarshal(signatureContent, &sigFile); err != nil {
+			return &Result{Error: fmt.Sprintf("failed to parse signature file: %v", err)}, nil
+		}
+		if sigFile.LicenseID != license.LicenseID {
+			return &Result{Error: "signature file does not belong to this license"}, nil
+		}
+		payload = fileContent
+		signatures = sigFile.Signatures
+	} else {
+		var err error
+		payload, err = licensefile.SigningPayload(&license)
+		if err != nil {
+			return &Result{Error: err.Error()}, nil
+		}
+		signatures = license.Signatures
+	}
+
+	// Count trusted signatures; signatures from unknown keys or versions (e.g. root HMAC) are skipped
+	// and signatures from keys the license does not list as signers are refused
+	declared := make(map[string]bool)
+	for _, keyID := range license.SignerKeyIDs() {
+		declared[keyID] = true
+	}
+	signedBy := make([]string, 0, len(signatures))
+	seen := make(map[string]bool, len(signatures))
+	for _, sig := range signatures {
+		if !declared[sig.KeyID] {
+			return &Result{LicenseID: license.LicenseID, Error: fmt.Sprintf("license was not issued to be signed by %s", sig.KeyID)}, nil
+		}
+		publicKey, trusted := v.TrustedKeys[licensefile.KeyVersionID(sig.KeyID, sig.KeyVersion)]
+		if !trusted || sig.Algorithm != licensefile.SignatureAlgorithmEd25519 || seen[sig.KeyID] {
+			continue
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+		if err != nil || !ed25519.Verify(publicKey, licensefile.SignedMessage(payload), signature) {
+			return &Result{LicenseID: license.LicenseID, Error: "invalid license signature"}, nil
+		}
+
+		seen[sig.KeyID] = true
+		signedBy = append(signedBy, sig.KeyID)
+	}
+
+	minSignatures := v.MinSignatures
+	if minSignatures <= 0 {
+		minSignatures = 1
+	}
+	if len(signedBy) < minSignatures {
+		return &Result{
+			LicenseID: license.LicenseID,
+			SignedBy:  signedBy,
+			Error:     fmt.Sprintf("license has %d of %d required trusted signatures", len(signedBy), minSignatures),
+		}, nil
+	}
+
+	// Node-locked licenses only verify on the host they are locked to
+	if license.Fingerprint != "" && v.Fingerprint == "" {
+		return &Result{LicenseID: license.LicenseID, Error: "license is node-locked and the verifier has no fingerprint"}, nil
+	}
+	if license.Fingerprint != "" && license.Fingerprint != v.Fingerprint {
+		return &Result{LicenseID: license.LicenseID, Error: "license is locked to a different fingerprint"}, nil
+	}
+
+	// Check expiry against the trusted time
+	now := time.Now().UTC()
+	if v.Clock != nil {
+		var err error
+		now, err = v.Clock.Now()
+		if err != nil {
+			return &Result{LicenseID: license.LicenseID, Error: err.Error()}, err
+		}
+	}
+
+	result := &Result{
+		Valid:       !now.After(license.ExpiresAt),
+		LicenseID:   license.LicenseID,
+		LicenseType: license.LicenseType,
+		ExpiresAt:   license.ExpiresAt,
+		Expired:     now.After(license.ExpiresAt),
+		CheckedAt:   now,
+		Metadata:    license.Metadata,
+		SignedBy:    signedBy,
+	}
+
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package signedresponse
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+const (
+	// AlgorithmEd25519 is the only algorithm currently used to sign validation responses
+	AlgorithmEd25519 = "Ed25519"
+
+	// signingContext is prepended to the payload before signing so that a response
+	// signature can never be confused with a signature produced for another purpose
+	signingContext = "kms-validation-response-v1\n"
+)
+
+var (
+	// ErrNonceMismatch indicates the signed nonce does not match the nonce sent by the client
+	ErrNonceMismatch = fmt.Errorf("response nonce mismatch")
+
+	// ErrResponseStale indicates the signed timestamp is outside the accepted window
+	ErrResponseStale = fmt.Errorf("response timestamp outside accepted window")
+
+	// ErrMissingSignature indicates the response body carries no response_signature
+	ErrMissingSignature = fmt.Errorf("response is not signed")
+)
+
+// Payload is the signed content of a validation response
+type Payload struct {
+	Nonce     string          `json:"nonce,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	Result    json.RawMessage `json:"result"`
+}
+
+// Envelope carries a signed payload and the information needed to verify it
+type Envelope struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	Payload   string `json:"payload"`   // Base64 encoded JSON Payload
+	Signature string `json:"signature"` // Base64 encoded signature over signingContext + payload bytes
+}
+
+// Sign builds a Payload from the nonce, timestamp and result and signs it with an Ed25519 private key
+func Sign(privateKey ed25519.PrivateKey, keyID, nonce string, result interface{}, timestamp time.Time) (*Envelope, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+
+	payloadJSON, err := json.Marshal(Payload{
+		Nonce:     nonce,
+		Timestamp: timestamp.UTC(),
+		Result:    resultJSON,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	signature := ed25519.Sign(privateKey, append([]byte(signingContext), payloadJSON...))
+
+	return &Envelope{
+		KeyID:     keyID,
+		Algorithm: AlgorithmEd25519,
+		Payload:   base64.StdEncoding.EncodeToString(payloadJSON),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}, nil
+}
+
+// Verify checks the envelope signature with the given public key and returns the signed payload
+// The signed nonce must equal expectedNonce and the timestamp must be within maxAge of now
+// A zero maxAge disables the timestamp check
+func Verify(publicKey ed25519.PublicKey, envelope *Envelope, expectedNonce string, maxAge time.Duration) (*Payload, error) {
+	if envelope == nil {
+		return nil, ErrMissingSignature
+	}
+	if envelope.Algorithm != AlgorithmEd25519 {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", errors.ErrInvalidSignature, envelope.Algorithm)
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, errors.ErrInvalidKeyMaterial
+	}
+
+	payloadJSON, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload is not base64 encoded", errors.ErrInvalidSignature)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature is not base64 encoded", errors.ErrInvalidSignature)
+	}
+
+	if !ed25519.Verify(publicKey, append([]byte(signingContext), payloadJSON...), signature) {
+		return nil, errors.ErrInvalidSignature
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		return nil, fmt.Errorf("%w: malformed payload: %v", errors.ErrInvalidSignature, err)
+	}
+
+	if payload.Nonce != expectedNonce {
+		return nil, ErrNonceMismatch
+	}
+
+	if maxAge > 0 {
+		age := time.Since(payload.Timestamp)
+		if age > maxAge || age < -maxAge {
+			return nil, ErrResponseStale
+		}
+	}
+
+	return &payload, nil
+}
+
+// VerifyResponseBody verifies the response_signature of a raw validation response body
+// On success the signed result is decoded into result (if non-nil)
+func VerifyResponseBody(publicKey ed25519.PublicKey, body []byte, expectedNonce string, maxAge time.Duration, result interface{}) (*Payload, error) {
+	var wrapper struct {
+		ResponseSignature *Envelope `json:"response_signature"`
+	}
+	if err := json.Unmarshal(body, &wrapper); err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %w", err)
+	}
+
+	payload, err := Verify(publicKey, wrapper.ResponseSignature, expectedNonce, maxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(payload.Result, result); err != nil {
+			return nil, fmt.Errorf("failed to decode signed result: %w", err)
+		}
+	}
+
+	return payload, nil
+}
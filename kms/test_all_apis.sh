@@ -93,6 +93,7 @@ else
 fi
 
 # Test Register with External Key Material (Symmetric)
+# Needs import.allow_plaintext in environment.json
 print_header "4. Register Symmetric Key (External Key Material)"
 
 # Generate a test key
@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+)
+
+// TestKeyAlgorithms tests generation, signing and verification for every signing algorithm
+func TestKeyAlgorithms(t *testing.T) {
+	message := []byte("algorithm test message")
+	algorithms := []string{
+		crypto.AlgorithmHMACSHA256,
+		crypto.AlgorithmEd25519,
+		crypto.AlgorithmECDSAP256,
+		crypto.AlgorithmECDSAP384,
+		crypto.AlgorithmRSAPSS2048,
+	}
+
+	for _, algorithm := range algorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			publicKey, privateKey, err := crypto.GenerateKeyMaterial(algorithm)
+			if err != nil {
+				t.Fatalf("Failed to generate key: %v", err)
+			}
+			if crypto.IsAsymmetricAlgorithm(algorithm) != (publicKey != nil) {
+				t.Fatalf("Unexpected public key presence for %s", algorithm)
+			}
+
+			signature, err := crypto.Sign(algorithm, privateKey, message)
+			if err != nil {
+				t.Fatalf("Failed to sign: %v", err)
+			}
+
+			verificationKey := publicKey
+			if verificationKey == nil {
+				verificationKey = privateKey
+			}
+			valid, err := crypto.Verify(algorithm, verificationKey, message, signature)
+			if err != nil || !valid {
+				t.Fatalf("Signature should verify: %v", err)
+			}
+			if valid, _ := crypto.Verify(algorithm, verificationKey, []byte("tampered"), signature); valid {
+				t.Error("Signature should not verify a different message")
+			}
+
+			// Imported material must reproduce the same public key
+			importedPublic, _, err := crypto.ImportKeyMaterial(algorithm, privateKey)
+			if err != nil {
+				t.Fatalf("Failed to import generated key: %v", err)
+			}
+			if !bytes.Equal(importedPublic, publicKey) {
+				t.Error("Imported key has a different public key")
+			}
+		})
+	}
+
+	// SEC 1 encoded ECDSA keys are accepted, keys on the wrong curve are not
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sec1, _ := x509.MarshalECPrivateKey(p256)
+	if _, _, err := crypto.ImportKeyMaterial(crypto.AlgorithmECDSAP256, sec1); err != nil {
+		t.Errorf("Failed to import SEC 1 key: %v", err)
+	}
+	if _, _, err := crypto.ImportKeyMaterial(crypto.AlgorithmECDSAP384, sec1); err == nil {
+		t.Error("P-256 key should not import as P-384")
+	}
+	if _, _, err := crypto.ImportKeyMaterial(crypto.AlgorithmHMACSHA256, make([]byte, 16)); err == nil {
+		t.Error("Short HMAC key should be rejected")
+	}
+
+	// An Ed25519 key whose public half belongs to another seed is rejected
+	_, ed25519Key, _ := crypto.GenerateAsymmetricKeyPair()
+	_, otherKey, _ := crypto.GenerateAsymmetricKeyPair()
+	mismatched := append(append([]byte(nil), ed25519Key[:32]...), otherKey[32:]...)
+	if _, _, err := crypto.ImportKeyMaterial(crypto.AlgorithmEd25519, mismatched); err == nil {
+		t.Error("Ed25519 key with a mismatched public half should be rejected")
+	}
+}
+
+// TestRegisterKeyAlgorithms tests registering, signing with and validating non-default algorithms through the API
+func TestRegisterKeyAlgorithms(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	do := func(method, path string, body interface{}, out interface{}) int {
+		var payload bytes.Buffer
+		json.NewEncoder(&payload).Encode(body)
+		req := httptest.NewRequest(method, path, &payload)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	if code := do(http.MethodPost, "/keys", map[string]string{"key_type": "symmetric", "algorithm": crypto.AlgorithmECDSAP256}, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected mismatched key_type to be rejected, got %d", code)
+	}
+
+	for _, algorithm := range []string{crypto.AlgorithmECDSAP256, crypto.AlgorithmRSAPSS2048, crypto.AlgorithmHMACSHA256} {
+		var registered api.RegisterKeyResponse
+		if code := do(http.MethodPost, "/keys", map[string]string{"algorithm": algorithm}, &registered); code != http.StatusOK {
+			t.Fatalf("Failed to register %s key: %d", algorithm, code)
+		}
+		if registered.Algorithm != algorithm {
+			t.Errorf("Expected algorithm %s, got %s", algorithm, registered.Algorithm)
+		}
+
+		var signed api.SignResponse
+		if code := do(http.MethodPost, "/keys/"+registered.KeyID+"/sign", map[string]string{"message": "hello"}, &signed); code != http.StatusOK {
+			t.Fatalf("Failed to sign with %s key: %d", algorithm, code)
+		}
+		if signed.Algorithm != crypto.SignatureAlgorithm(algorithm, false) {
+			t.Errorf("Unexpected signature algorithm %s", signed.Algorithm)
+		}
+
+		var validated api.ValidateKeyResponse
+		request := map[string]string{"key_id": registered.KeyID, "message": "hello", "signature": signed.Signature}
+		if code := do(http.MethodPost, "/keys/validate", request, &validated); code != http.StatusOK || !validated.Valid {
+			t.Errorf("Expected %s signature to validate, got %d", algorithm, code)
+		}
+
+		if code := do(http.MethodPost, "/keys/"+registered.KeyID+"/encrypt", map[string]string{"plaintext": "aGk="}, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected %s key to refuse encryption, got %d", algorithm, code)
+		}
+	}
+
+	// ECDSA digests are SHA-256 and verify against the exported public key
+	var registered api.RegisterKeyResponse
+	do(http.MethodPost, "/keys", map[string]string{"algorithm": crypto.AlgorithmECDSAP256}, &registered)
+	digest := sha256.Sum256([]byte("digest message"))
+	var signed api.SignResponse
+	if code := do(http.MethodPost, "/keys/"+registered.KeyID+"/sign", map[string]string{"digest": base64.StdEncoding.EncodeToString(digest[:])}, &signed); code != http.StatusOK {
+		t.Fatalf("Failed to sign digest: %d", code)
+	}
+	publicKey, _ := base64.StdEncoding.DecodeString(registered.PublicKey)
+	parsed, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("Public key is not PKIX: %v", err)
+	}
+	signature, _ := base64.StdEncoding.DecodeString(signed.Signature)
+	if !ecdsa.VerifyASN1(parsed.(*ecdsa.PublicKey), digest[:], signature) {
+		t.Error("Digest signature should verify with the standard library")
+	}
+}
@@ -3,6 +3,7 @@ package tests
 import (
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha512"
 	"testing"
 
 	"github.com/atprof/license-server/kms/internal/crypto"
@@ -174,3 +175,91 @@ func TestValidateSymmetricKey(t *testing.T) {
 	}
 }
 
+
+// TestSignMessageAndDigest tests server-side signing with Ed25519 and Ed25519ph
+func TestSignMessageAndDigest(t *testing.T) {
+	publicKey, privateKey, err := crypto.GenerateAsymmetricKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	message := []byte("firmware image")
+	signature, err := crypto.SignMessage(privateKey, message)
+	if err != nil {
+		t.Fatalf("Failed to sign message: %v", err)
+	}
+
+	valid, err := crypto.ValidateSignature(publicKey, message, signature)
+	if err != nil || !valid {
+		t.Errorf("Expected message signature to verify, got valid=%v err=%v", valid, err)
+	}
+
+	digest := sha512.Sum512(message)
+	signature, err = crypto.SignDigest(privateKey, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign digest: %v", err)
+	}
+
+	valid, err = crypto.ValidateDigestSignature(publicKey, digest[:], signature)
+	if err != nil || !valid {
+		t.Errorf("Expected digest signature to verify, got valid=%v err=%v", valid, err)
+	}
+
+	// Ed25519ph signatures are not valid plain Ed25519 signatures over the digest
+	valid, _ = crypto.ValidateSignature(publicKey, digest[:], signature)
+	if valid {
+		t.Error("Ed25519ph signature should not verify as plain Ed25519")
+	}
+
+	// Wrong digest size
+	if _, err := crypto.SignDigest(privateKey, []byte("short")); err == nil {
+		t.Error("Expected error for digest of wrong size")
+	}
+}
+
+// TestEncryptWithContext tests self-describing ciphertexts bound to an encryption context
+func TestEncryptWithContext(t *testing.T) {
+	key, err := crypto.GenerateSymmetricKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	context := map[string]string{"site_id": "SITE-1", "purpose": "db-password"}
+	blob, err := crypto.EncryptWithContext(key, "key-123", 2, []byte("s3cret"), context)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	header, err := crypto.ParseCiphertextHeader(blob)
+	if err != nil {
+		t.Fatalf("Failed to parse header: %v", err)
+	}
+	if header.KeyID != "key-123" || header.KeyVersion != 2 {
+		t.Errorf("Expected key-123 v2, got %s v%d", header.KeyID, header.KeyVersion)
+	}
+
+	plaintext, err := crypto.DecryptWithContext(key, blob, map[string]string{"purpose": "db-password", "site_id": "SITE-1"})
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if string(plaintext) != "s3cret" {
+		t.Errorf("Expected plaintext s3cret, got %q", plaintext)
+	}
+
+	// Different context - should fail
+	if _, err := crypto.DecryptWithContext(key, blob, map[string]string{"site_id": "SITE-2", "purpose": "db-password"}); err == nil {
+		t.Error("Decryption with a different encryption context should fail")
+	}
+
+	// Missing context - should fail
+	if _, err := crypto.DecryptWithContext(key, blob, nil); err == nil {
+		t.Error("Decryption without encryption context should fail")
+	}
+
+	// Tampered header (key version) - should fail
+	tampered := append([]byte{}, blob...)
+	tampered[len("KMS")+2+len("key-123")+3] ^= 0x01
+	if _, err := crypto.DecryptWithContext(key, tampered, context); err == nil {
+		t.Error("Decryption with a tampered header should fail")
+	}
+}
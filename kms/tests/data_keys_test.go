@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+)
+
+// TestGenerateDataKey tests envelope encryption with generated data keys
+func TestGenerateDataKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	register := func(algorithm string) string {
+		var resp api.RegisterKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys", map[string]string{"algorithm": algorithm}, &resp); code != http.StatusOK {
+			t.Fatalf("Failed to register key: %d", code)
+		}
+		return resp.KeyID
+	}
+	context := map[string]string{"bucket": "backups"}
+	keyID := register("aes-256-gcm")
+
+	t.Run("plaintext key matches the wrapped key", func(t *testing.T) {
+		var generated api.GenerateDataKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/data-key", map[string]interface{}{"encryption_context": context}, &generated); code != http.StatusOK {
+			t.Fatalf("Expected 200 from data-key, got %d", code)
+		}
+		dataKey, _ := base64.StdEncoding.DecodeString(generated.Plaintext)
+		if len(dataKey) != 32 {
+			t.Fatalf("Expected a 256-bit data key, got %d bytes", len(dataKey))
+		}
+
+		var unwrapped api.DecryptResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/decrypt", map[string]interface{}{
+			"ciphertext_blob":    generated.CiphertextBlob,
+			"encryption_context": context,
+		}, &unwrapped); code != http.StatusOK {
+			t.Fatalf("Expected 200 decrypting the wrapped key, got %d", code)
+		}
+		if unwrapped.Plaintext != generated.Plaintext {
+			t.Error("Expected the wrapped key to decrypt to the plaintext data key")
+		}
+
+		// The data key works as an AES-256 key for local encryption
+		block, err := aes.NewCipher(dataKey)
+		if err != nil {
+			t.Fatalf("Data key is not a usable AES key: %v", err)
+		}
+		aead, _ := cipher.NewGCM(block)
+		nonce := make([]byte, aead.NonceSize())
+		sealed := aead.Seal(nil, nonce, []byte("large payload"), nil)
+		if opened, err := aead.Open(nil, nonce, sealed, nil); err != nil || !bytes.Equal(opened, []byte("large payload")) {
+			t.Errorf("Failed to round-trip a payload under the data key: %v", err)
+		}
+
+		// The wrapped key is bound to its encryption context
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/decrypt", map[string]string{"ciphertext_blob": generated.CiphertextBlob}, nil); code == http.StatusOK {
+			t.Error("Expected decrypting without the encryption context to fail")
+		}
+	})
+
+	t.Run("without plaintext", func(t *testing.T) {
+		var generated api.GenerateDataKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/data-key/without-plaintext", nil, &generated); code != http.StatusOK {
+			t.Fatalf("Expected 200 from data-key/without-plaintext, got %d", code)
+		}
+		if generated.Plaintext != "" || generated.CiphertextBlob == "" {
+			t.Errorf("Expected only the wrapped key, got %+v", generated)
+		}
+
+		var unwrapped api.DecryptResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/decrypt", map[string]string{"ciphertext_blob": generated.CiphertextBlob}, &unwrapped); code != http.StatusOK {
+			t.Fatalf("Expected 200 decrypting the wrapped key, got %d", code)
+		}
+		if dataKey, _ := base64.StdEncoding.DecodeString(unwrapped.Plaintext); len(dataKey) != 32 {
+			t.Errorf("Expected a 256-bit data key, got %d bytes", len(dataKey))
+		}
+	})
+
+	t.Run("invalid key specs", func(t *testing.T) {
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/data-key", map[string]string{"key_spec": api.DataKeySpecAES256}, nil); code != http.StatusOK {
+			t.Errorf("Expected 200 for %s, got %d", api.DataKeySpecAES256, code)
+		}
+		for _, spec := range []string{"AES_128", "RSA_2048", "aes-256"} {
+			if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/data-key", map[string]string{"key_spec": spec}, nil); code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for key spec %s, got %d", spec, code)
+			}
+		}
+		if code := doJSON(router, http.MethodPost, "/keys/"+register("ed25519")+"/data-key", nil, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 generating a data key under an Ed25519 key, got %d", code)
+		}
+	})
+
+	t.Run("disabled and revoked keys", func(t *testing.T) {
+		disabledID := register("aes-256-gcm")
+		if code := doJSON(router, http.MethodPost, "/keys/"+disabledID+"/disable", nil, nil); code != http.StatusOK {
+			t.Fatalf("Expected 200 disabling the key, got %d", code)
+		}
+		revokedID := register("aes-256-gcm")
+		if code := doJSON(router, http.MethodDelete, "/keys/"+revokedID, nil, nil); code != http.StatusOK {
+			t.Fatalf("Expected 200 revoking the key, got %d", code)
+		}
+
+		for _, id := range []string{disabledID, revokedID} {
+			for _, path := range []string{"/data-key", "/data-key/without-plaintext"} {
+				if code := doJSON(router, http.MethodPost, "/keys/"+id+path, nil, nil); code != http.StatusBadRequest {
+					t.Errorf("Expected 400 from %s under an unusable key, got %d", path, code)
+				}
+			}
+		}
+	})
+}
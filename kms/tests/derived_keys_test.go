@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+)
+
+// TestDerivedKeys tests HKDF subkeys derived from a symmetric parent key
+func TestDerivedKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	register := func(algorithm string) string {
+		var resp api.RegisterKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys", map[string]string{"algorithm": algorithm}, &resp); code != http.StatusOK {
+			t.Fatalf("Failed to register key: %d", code)
+		}
+		return resp.KeyID
+	}
+	derive := func(parentID, context string) api.DerivedKeyResponse {
+		var resp api.DerivedKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+parentID+"/derive", map[string]string{"context": context}, &resp); code != http.StatusCreated {
+			t.Fatalf("Expected 201 deriving a key, got %d", code)
+		}
+		return resp
+	}
+	validate := func(keyID string, material []byte) bool {
+		var resp api.ValidateKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys/validate", map[string]string{"key_id": keyID, "key_material": base64.StdEncoding.EncodeToString(material)}, &resp); code != http.StatusOK {
+			t.Fatalf("Expected 200 from validate, got %d", code)
+		}
+		return resp.Valid
+	}
+	encrypt := func(keyID string) (string, int) {
+		var resp api.EncryptResponse
+		code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString([]byte("site secret"))}, &resp)
+		return resp.CiphertextBlob, code
+	}
+	decrypt := func(keyID, blob string) int {
+		var resp api.DecryptResponse
+		code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/decrypt", map[string]string{"ciphertext_blob": blob}, &resp)
+		if code == http.StatusOK && resp.Plaintext != base64.StdEncoding.EncodeToString([]byte("site secret")) {
+			t.Errorf("Expected the original plaintext, got %s", resp.Plaintext)
+		}
+		return code
+	}
+	// expected derives the subkey a site can recompute from the parent material and its context
+	expected := func(parentID, context string) []byte {
+		parent, _ := store.GetKey(parentID)
+		secret, _ := crypto.DecryptKey(masterKey, parent.PrimaryMaterial().EncryptedPrivateKey)
+		derived, err := crypto.DeriveKey(secret, context)
+		if err != nil {
+			t.Fatalf("Failed to derive key: %v", err)
+		}
+		return derived
+	}
+
+	parentID := register("aes-256-gcm")
+	site7 := derive(parentID, "site-7")
+	site8 := derive(parentID, "site-8")
+	site7Material := expected(parentID, "site-7")
+	site8Material := expected(parentID, "site-8")
+
+	t.Run("derived keys store only their context", func(t *testing.T) {
+		key, err := store.GetKey(site7.KeyID)
+		if err != nil {
+			t.Fatalf("Failed to get derived key: %v", err)
+		}
+		if len(key.EncryptedPrivateKey) != 0 || len(key.Versions) != 0 {
+			t.Error("Expected a derived key to store no material")
+		}
+		if key.DerivedFrom != parentID || key.DerivationContext != "site-7" || key.DerivedFromVersion != 1 {
+			t.Errorf("Expected the parent, version and context to be stored, got %s v%d %q", key.DerivedFrom, key.DerivedFromVersion, key.DerivationContext)
+		}
+		if site7.Algorithm != crypto.AlgorithmAES256GCM {
+			t.Errorf("Expected the parent's algorithm, got %s", site7.Algorithm)
+		}
+
+		var resp map[string]string
+		if code := doJSON(router, http.MethodPost, "/keys/"+parentID+"/derive", map[string]string{"context": "site-7"}, &resp); code != http.StatusConflict || resp["key_id"] != site7.KeyID {
+			t.Errorf("Expected 409 naming the existing key, got %d %v", code, resp)
+		}
+	})
+
+	t.Run("material is deterministic per context", func(t *testing.T) {
+		if !validate(site7.KeyID, site7Material) {
+			t.Error("Expected the HKDF subkey to validate")
+		}
+		if validate(site8.KeyID, site7Material) {
+			t.Error("Expected another context to yield another subkey")
+		}
+		if validate(parentID, site7Material) {
+			t.Error("Expected the subkey to differ from its parent")
+		}
+	})
+
+	t.Run("encrypt and decrypt", func(t *testing.T) {
+		blob, code := encrypt(site7.KeyID)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200 from encrypt, got %d", code)
+		}
+		if code := decrypt(site7.KeyID, blob); code != http.StatusOK {
+			t.Errorf("Expected 200 from decrypt, got %d", code)
+		}
+
+		// Rotating the parent does not change keys already derived from it
+		if code := doJSON(router, http.MethodPost, "/keys/"+parentID+"/rotate", nil, nil); code != http.StatusOK {
+			t.Fatalf("Expected 200 rotating the parent, got %d", code)
+		}
+		if code := decrypt(site7.KeyID, blob); code != http.StatusOK {
+			t.Errorf("Expected 200 from decrypt after a parent rotation, got %d", code)
+		}
+		if !validate(site7.KeyID, site7Material) {
+			t.Error("Expected the subkey to survive a parent rotation")
+		}
+		if site9 := derive(parentID, "site-9"); site9.ParentVersion != 2 {
+			t.Errorf("Expected new keys to derive from the new primary version, got %d", site9.ParentVersion)
+		}
+	})
+
+	t.Run("derived keys have no material of their own", func(t *testing.T) {
+		if code := doJSON(router, http.MethodPost, "/keys/"+site7.KeyID+"/rotate", nil, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 rotating a derived key, got %d", code)
+		}
+		if code := doJSON(router, http.MethodPost, "/keys/"+site7.KeyID+"/derive", map[string]string{"context": "nested"}, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 deriving from a derived key, got %d", code)
+		}
+		if code := doJSON(router, http.MethodPost, "/keys/"+register("ed25519")+"/derive", map[string]string{"context": "site-7"}, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 deriving from an asymmetric key, got %d", code)
+		}
+		if code := doJSON(router, http.MethodPost, "/keys/"+parentID+"/derive", map[string]interface{}{"context": "site-10", "expires_in_seconds": 10 * 365 * 24 * 3600}, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a derived key outliving its parent, got %d", code)
+		}
+	})
+
+	t.Run("HMAC parents derive HMAC keys", func(t *testing.T) {
+		hmacParent := register("hmac-sha256")
+		derived := derive(hmacParent, "plant-3")
+
+		var signed api.SignResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+derived.KeyID+"/sign", map[string]string{"message": "hello"}, &signed); code != http.StatusOK {
+			t.Fatalf("Expected 200 from sign, got %d", code)
+		}
+		tag, _ := base64.StdEncoding.DecodeString(signed.Signature)
+		if valid, _ := crypto.Verify(crypto.AlgorithmHMACSHA256, expected(hmacParent, "plant-3"), []byte("hello"), tag); !valid {
+			t.Error("Expected the tag to verify with the recomputed subkey")
+		}
+	})
+
+	t.Run("revocation", func(t *testing.T) {
+		// A derived key is revoked on its own
+		if code := doJSON(router, http.MethodDelete, "/keys/"+site7.KeyID, nil, nil); code != http.StatusOK {
+			t.Fatalf("Expected 200 revoking the derived key, got %d", code)
+		}
+		if _, code := encrypt(site7.KeyID); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 encrypting with a revoked derived key, got %d", code)
+		}
+		if _, code := encrypt(site8.KeyID); code != http.StatusOK {
+			t.Errorf("Expected its sibling to keep working, got %d", code)
+		}
+		if _, code := encrypt(parentID); code != http.StatusOK {
+			t.Errorf("Expected the parent to keep working, got %d", code)
+		}
+
+		// Revoking the parent stops every key derived from it
+		if !validate(site8.KeyID, site8Material) {
+			t.Fatal("Expected the sibling's subkey to validate before its parent is revoked")
+		}
+		if err := store.RevokeKey(parentID); err != nil {
+			t.Fatalf("Failed to revoke parent: %v", err)
+		}
+		if validate(site8.KeyID, site8Material) {
+			t.Error("Expected keys under a revoked parent not to validate")
+		}
+		if _, code := encrypt(site8.KeyID); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 encrypting under a revoked parent, got %d", code)
+		}
+	})
+}
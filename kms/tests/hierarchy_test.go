@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/scheduler"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestKeyHierarchy tests hub, enterprise and site key creation rules, lookups and revocation policy
+func TestKeyHierarchy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	cfg := &config.Config{MasterKey: masterKey}
+	router := api.SetupRouter(api.NewHandler(store, cfg), nil, false)
+
+	do := func(method, path string, body interface{}, out interface{}) int {
+		var payload bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&payload).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &payload)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+	register := func(body map[string]string) (string, int) {
+		body["key_type"] = "asymmetric"
+		var resp api.RegisterKeyResponse
+		code := do(http.MethodPost, "/keys", body, &resp)
+		return resp.KeyID, code
+	}
+
+	hub, _ := register(map[string]string{"level": "hub", "owner": "org-1"})
+	enterprise, code := register(map[string]string{"level": "enterprise", "parent_key_id": hub})
+	if code != http.StatusOK {
+		t.Fatalf("Failed to create enterprise key: %d", code)
+	}
+	site, code := register(map[string]string{"level": "site", "parent_key_id": enterprise, "site_mode": "prod"})
+	if code != http.StatusOK {
+		t.Fatalf("Failed to create site key: %d", code)
+	}
+
+	invalid := []map[string]string{
+		{"level": "site", "parent_key_id": hub, "site_mode": "dev"},        // Site under a hub
+		{"level": "site", "parent_key_id": enterprise},                     // Missing site mode
+		{"level": "enterprise"},                                            // Missing parent
+		{"level": "hub", "parent_key_id": hub},                             // Hub with a parent
+		{"level": "enterprise", "parent_key_id": hub, "site_mode": "prod"}, // Site mode on an enterprise key
+		{"level": "region"},
+	}
+	for _, body := range invalid {
+		if _, code := register(body); code != http.StatusBadRequest {
+			t.Errorf("Expected %v to be rejected, got %d", body, code)
+		}
+	}
+
+	var children api.KeyChildrenResponse
+	do(http.MethodGet, "/keys/"+hub+"/children", nil, &children)
+	if len(children.Children) != 1 || children.Children[0].KeyID != enterprise {
+		t.Errorf("Expected the enterprise key as the only child, got %+v", children.Children)
+	}
+
+	var ancestry api.KeyAncestryResponse
+	do(http.MethodGet, "/keys/"+site+"/ancestry", nil, &ancestry)
+	if len(ancestry.Ancestry) != 2 || ancestry.Ancestry[0].KeyID != enterprise || ancestry.Ancestry[1].KeyID != hub {
+		t.Errorf("Unexpected ancestry %+v", ancestry.Ancestry)
+	}
+
+	// The default policy blocks revoking a parent with live children
+	if code := do(http.MethodDelete, "/keys/"+hub, nil, nil); code != http.StatusConflict {
+		t.Errorf("Expected revocation to be blocked, got %d", code)
+	}
+
+	// The cascade policy revokes the whole subtree
+	cfg.CascadeRevocation = true
+	var removed api.RemoveKeyResponse
+	if code := do(http.MethodDelete, "/keys/"+hub, nil, &removed); code != http.StatusOK {
+		t.Fatalf("Expected cascading revocation, got %d", code)
+	}
+	if len(removed.RevokedChildren) != 2 {
+		t.Errorf("Expected 2 revoked descendants, got %v", removed.RevokedChildren)
+	}
+	for _, id := range []string{hub, enterprise, site} {
+		key, _ := store.GetKey(id)
+		if key.Status != storage.KeyStatusRevoked {
+			t.Errorf("Expected key %s to be revoked, got %s", id, key.Status)
+		}
+	}
+
+	// Revoked parents cannot take new children
+	if _, code := register(map[string]string{"level": "enterprise", "parent_key_id": hub}); code != http.StatusBadRequest {
+		t.Errorf("Expected child of a revoked key to be rejected, got %d", code)
+	}
+}
+
+// TestKeyHierarchyLifecyclePolicy tests that disabling, deleting and destroying a parent key follow the revocation policy
+func TestKeyHierarchyLifecyclePolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	cfg := &config.Config{MasterKey: masterKey, DeletionWaitingPeriod: time.Hour}
+	router := api.SetupRouter(api.NewHandler(store, cfg), nil, false)
+
+	register := func(body map[string]string) string {
+		body["key_type"] = "asymmetric"
+		var resp api.RegisterKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys", body, &resp); code != http.StatusOK {
+			t.Fatalf("Failed to register %s key: %d", body["level"], code)
+		}
+		return resp.KeyID
+	}
+	tree := func() []string {
+		hub := register(map[string]string{"level": "hub"})
+		enterprise := register(map[string]string{"level": "enterprise", "parent_key_id": hub})
+		site := register(map[string]string{"level": "site", "parent_key_id": enterprise, "site_mode": "prod"})
+		return []string{hub, enterprise, site}
+	}
+	expectStatus := func(t *testing.T, ids []string, status storage.KeyStatus) {
+		t.Helper()
+		for _, id := range ids {
+			key, err := store.GetKey(id)
+			if err != nil {
+				t.Fatalf("Failed to get key: %v", err)
+			}
+			if key.Status != status {
+				t.Errorf("Expected key %s to be %s, got %s", id, status, key.Status)
+			}
+		}
+	}
+
+	t.Run("scheduler destroy", func(t *testing.T) {
+		keys := tree()
+		now := time.Now().UTC()
+		if _, err := store.ScheduleKeyDeletion(keys[0], now.Add(time.Hour), true); err != nil {
+			t.Fatalf("Failed to schedule deletion: %v", err)
+		}
+
+		// Cancelling the site key's deletion leaves it disabled, and live, under parents due for destruction
+		if _, err := store.CancelKeyDeletion(keys[2]); err != nil {
+			t.Fatalf("Failed to cancel deletion: %v", err)
+		}
+
+		due := now.Add(2 * time.Hour)
+		if changed, _ := scheduler.New(store, masterKey, time.Minute, false).RunOnce(due); changed != 0 {
+			t.Errorf("Expected destruction to be deferred, got %d changes", changed)
+		}
+		expectStatus(t, keys[:2], storage.KeyStatusPendingDeletion)
+		expectStatus(t, keys[2:], storage.KeyStatusDisabled)
+
+		// A later sweep under the cascade policy destroys the parents and revokes the site key
+		if changed, _ := scheduler.New(store, masterKey, time.Minute, true).RunOnce(due.Add(time.Hour)); changed != 2 {
+			t.Errorf("Expected 2 destroyed keys, got %d changes", changed)
+		}
+		expectStatus(t, keys[:2], storage.KeyStatusDestroyed)
+		expectStatus(t, keys[2:], storage.KeyStatusRevoked)
+	})
+
+	for _, path := range []struct {
+		action string
+		status storage.KeyStatus
+	}{
+		{"disable", storage.KeyStatusDisabled},
+		{"schedule-deletion", storage.KeyStatusPendingDeletion},
+	} {
+		t.Run(path.action, func(t *testing.T) {
+			keys := tree()
+
+			cfg.CascadeRevocation = false
+			if code := doJSON(router, http.MethodPost, "/keys/"+keys[0]+"/"+path.action, nil, nil); code != http.StatusConflict {
+				t.Errorf("Expected %s to be blocked by live child keys, got %d", path.action, code)
+			}
+			expectStatus(t, keys, storage.KeyStatusActive)
+
+			cfg.CascadeRevocation = true
+			if code := doJSON(router, http.MethodPost, "/keys/"+keys[0]+"/"+path.action, nil, nil); code != http.StatusOK {
+				t.Fatalf("Expected cascading %s, got %d", path.action, code)
+			}
+			expectStatus(t, keys, path.status)
+		})
+	}
+
+	t.Run("enable under disabled parents", func(t *testing.T) {
+		keys := tree()
+		cfg.CascadeRevocation = true
+		if code := doJSON(router, http.MethodPost, "/keys/"+keys[0]+"/disable", nil, nil); code != http.StatusOK {
+			t.Fatalf("Expected cascading disable, got %d", code)
+		}
+
+		// A child is only enabled once every key above it is active again
+		if code := doJSON(router, http.MethodPost, "/keys/"+keys[2]+"/enable", nil, nil); code != http.StatusConflict {
+			t.Errorf("Expected 409 enabling a key under disabled parents, got %d", code)
+		}
+		expectStatus(t, keys, storage.KeyStatusDisabled)
+
+		for _, id := range keys {
+			if code := doJSON(router, http.MethodPost, "/keys/"+id+"/enable", nil, nil); code != http.StatusOK {
+				t.Errorf("Expected 200 enabling keys top down, got %d", code)
+			}
+		}
+		expectStatus(t, keys, storage.KeyStatusActive)
+	})
+}
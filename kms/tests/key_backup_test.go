@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestSecretSharing tests splitting a secret into Shamir shares and combining them
+func TestSecretSharing(t *testing.T) {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	shares, err := crypto.SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("Failed to split secret: %v", err)
+	}
+	if len(shares) != 5 || len(shares[0]) != len(secret)+1 {
+		t.Fatalf("Expected 5 shares of %d bytes, got %d", len(secret)+1, len(shares))
+	}
+
+	// Every choice of 3 shares recovers the secret
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for c := b + 1; c < 5; c++ {
+				combined, err := crypto.CombineShares([][]byte{shares[c], shares[a], shares[b]})
+				if err != nil || !bytes.Equal(combined, secret) {
+					t.Errorf("Shares %d, %d and %d did not recover the secret: %v", a+1, b+1, c+1, err)
+				}
+			}
+		}
+	}
+
+	if combined, _ := crypto.CombineShares(shares[:2]); bytes.Equal(combined, secret) {
+		t.Error("Expected fewer shares than the threshold not to recover the secret")
+	}
+	if _, err := crypto.CombineShares([][]byte{shares[0], shares[0]}); err == nil {
+		t.Error("Expected an error for duplicate shares")
+	}
+	for _, params := range [][2]int{{5, 1}, {2, 3}, {256, 2}} {
+		if _, err := crypto.SplitSecret(secret, params[0], params[1]); err == nil {
+			t.Errorf("Expected an error splitting into %d shares with threshold %d", params[0], params[1])
+		}
+	}
+}
+
+// TestKeyBackupShares tests backing a key up to custodians and recovering it on another server
+func TestKeyBackupShares(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	// A second store with its own master key stands in for a fresh server
+	freshStore, freshMasterKey, _, _ := newLicenseTestStore(t)
+	freshRouter := api.SetupRouter(api.NewHandler(freshStore, &config.Config{MasterKey: freshMasterKey}), nil, false)
+
+	encrypt := func(keyID string) string {
+		var resp api.EncryptResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString([]byte("hub secret"))}, &resp); code != http.StatusOK {
+			t.Fatalf("Expected 200 from encrypt, got %d", code)
+		}
+		return resp.CiphertextBlob
+	}
+
+	var registered api.RegisterKeyResponse
+	if code := doJSON(router, http.MethodPost, "/keys", map[string]interface{}{"algorithm": "aes-256-gcm", "name": "Hub root"}, &registered); code != http.StatusOK {
+		t.Fatalf("Failed to register key: %d", code)
+	}
+	keyID := registered.KeyID
+	v1Blob := encrypt(keyID)
+	if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/rotate", nil, nil); code != http.StatusOK {
+		t.Fatalf("Expected 200 rotating the key, got %d", code)
+	}
+	v2Blob := encrypt(keyID)
+
+	// Three custodians: a registered recipient and two supplied wrapping keys
+	alicePublic, alicePrivate, _ := crypto.GenerateWrappingKey(crypto.WrappingX25519AES256GCM)
+	bobPublic, bobPrivate, _ := crypto.GenerateWrappingKey(crypto.WrappingRSAOAEPSHA256)
+	carolPublic, carolPrivate, _ := crypto.GenerateWrappingKey(crypto.WrappingX25519AES256GCM)
+	var alice api.ExportRecipientResponse
+	if code := doJSON(router, http.MethodPost, "/export-recipients", map[string]string{
+		"name":               "alice",
+		"wrapping_algorithm": crypto.WrappingX25519AES256GCM,
+		"public_key":         base64.StdEncoding.EncodeToString(alicePublic),
+	}, &alice); code != http.StatusOK {
+		t.Fatalf("Failed to register export recipient: %d", code)
+	}
+	custodians := []map[string]string{
+		{"recipient_id": alice.RecipientID},
+		{"name": "bob", "wrapping_algorithm": crypto.WrappingRSAOAEPSHA256, "wrapping_public_key": base64.StdEncoding.EncodeToString(bobPublic)},
+		{"name": "carol", "wrapping_algorithm": crypto.WrappingX25519AES256GCM, "wrapping_public_key": base64.StdEncoding.EncodeToString(carolPublic)},
+	}
+	privateKeys := [][]byte{alicePrivate, bobPrivate, carolPrivate}
+
+	backUp := func() (api.BackupSharesResponse, []string) {
+		var resp api.BackupSharesResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/backup-shares", map[string]interface{}{"threshold": 2, "custodians": custodians}, &resp); code != http.StatusOK {
+			t.Fatalf("Expected 200 from backup-shares, got %d", code)
+		}
+		if len(resp.Shares) != 3 || resp.Threshold != 2 {
+			t.Fatalf("Expected 3 shares with threshold 2, got %d with %d", len(resp.Shares), resp.Threshold)
+		}
+
+		// Each custodian unwraps their own share
+		shares := make([]string, len(resp.Shares))
+		for i, share := range resp.Shares {
+			wrapped, _ := base64.StdEncoding.DecodeString(share.WrappedShare)
+			unwrapped, err := crypto.UnwrapKeyMaterial(share.WrappingAlgorithm, privateKeys[i], wrapped)
+			if err != nil {
+				t.Fatalf("Custodian %d failed to unwrap their share: %v", i+1, err)
+			}
+			shares[i] = base64.StdEncoding.EncodeToString(unwrapped)
+		}
+		return resp, shares
+	}
+	restore := func(router *gin.Engine, encryptedBackup string, shares ...string) (api.RecoverKeyResponse, int) {
+		var resp api.RecoverKeyResponse
+		code := doJSON(router, http.MethodPost, "/keys/recover", map[string]interface{}{"encrypted_backup": encryptedBackup, "shares": shares}, &resp)
+		return resp, code
+	}
+
+	backup, shares := backUp()
+	other, otherShares := backUp()
+
+	t.Run("backups are audited", func(t *testing.T) {
+		events, err := store.ListEvents(keyID, 0)
+		if err != nil {
+			t.Fatalf("Failed to list events: %v", err)
+		}
+		var found bool
+		for _, event := range events {
+			if event.Type == storage.EventKeyBackedUp && event.Details["backup_id"] == backup.BackupID {
+				found = event.Details["threshold"] == "2" && event.Details["custodians"] == alice.RecipientID+",bob,carol"
+			}
+		}
+		if !found {
+			t.Error("Expected a key.backed_up event naming the threshold and custodians")
+		}
+	})
+
+	t.Run("too few or mismatched shares", func(t *testing.T) {
+		if _, code := restore(freshRouter, backup.EncryptedBackup, shares[0]); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a single share, got %d", code)
+		}
+		if _, code := restore(freshRouter, backup.EncryptedBackup, shares[0], otherShares[1]); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for shares of different backups, got %d", code)
+		}
+		if _, code := restore(freshRouter, backup.EncryptedBackup, otherShares[0], otherShares[1]); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for shares of another backup, got %d", code)
+		}
+		if _, code := restore(freshRouter, other.EncryptedBackup, shares[0], shares[0]); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a duplicated share, got %d", code)
+		}
+	})
+
+	t.Run("recovery on a fresh server", func(t *testing.T) {
+		recovered, code := restore(freshRouter, backup.EncryptedBackup, shares[2], shares[0])
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200 from recover, got %d", code)
+		}
+		if recovered.KeyID != keyID || recovered.BackupID != backup.BackupID || recovered.Versions != 2 {
+			t.Errorf("Expected the key with both versions, got %+v", recovered)
+		}
+
+		key, err := freshStore.GetKey(keyID)
+		if err != nil {
+			t.Fatalf("Failed to get recovered key: %v", err)
+		}
+		if key.Name != "Hub root" || key.PrimaryVersionNumber() != 2 {
+			t.Errorf("Expected the key record to be restored, got %q v%d", key.Name, key.PrimaryVersionNumber())
+		}
+
+		// Ciphertexts under either version decrypt on the fresh server
+		for _, blob := range []string{v1Blob, v2Blob} {
+			var resp api.DecryptResponse
+			if code := doJSON(freshRouter, http.MethodPost, "/keys/"+keyID+"/decrypt", map[string]string{"ciphertext_blob": blob}, &resp); code != http.StatusOK {
+				t.Errorf("Expected 200 decrypting on the fresh server, got %d", code)
+			} else if resp.Plaintext != base64.StdEncoding.EncodeToString([]byte("hub secret")) {
+				t.Errorf("Expected the original plaintext, got %s", resp.Plaintext)
+			}
+		}
+
+		events, _ := freshStore.ListEvents(keyID, 0)
+		if len(events) == 0 || events[0].Type != storage.EventKeyRecovered {
+			t.Error("Expected a key.recovered event")
+		}
+
+		if _, code := restore(freshRouter, backup.EncryptedBackup, shares[1], shares[2]); code != http.StatusConflict {
+			t.Errorf("Expected 409 recovering a key that exists, got %d", code)
+		}
+	})
+
+	t.Run("invalid backup requests", func(t *testing.T) {
+		for _, body := range []map[string]interface{}{
+			{"threshold": 1, "custodians": custodians},
+			{"threshold": 4, "custodians": custodians},
+			{"threshold": 2, "custodians": []map[string]string{custodians[0], custodians[0]}},
+			{"threshold": 2, "custodians": []map[string]string{custodians[1], {"name": "dave"}}},
+		} {
+			if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/backup-shares", body, nil); code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %v, got %d", body, code)
+			}
+		}
+
+		var derived api.DerivedKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/derive", map[string]string{"context": "site-1"}, &derived); code != http.StatusCreated {
+			t.Fatalf("Expected 201 deriving a key, got %d", code)
+		}
+		if code := doJSON(router, http.MethodPost, "/keys/"+derived.KeyID+"/backup-shares", map[string]interface{}{"threshold": 2, "custodians": custodians}, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 backing up a derived key, got %d", code)
+		}
+	})
+
+	t.Run("keys that are not active", func(t *testing.T) {
+		// Disable one key and revoke the other
+		for _, change := range []struct{ method, suffix string }{
+			{http.MethodPost, "/disable"},
+			{http.MethodDelete, ""},
+		} {
+			var inactive api.RegisterKeyResponse
+			if code := doJSON(router, http.MethodPost, "/keys", map[string]string{"algorithm": "aes-256-gcm"}, &inactive); code != http.StatusOK {
+				t.Fatalf("Failed to register key: %d", code)
+			}
+			if code := doJSON(router, change.method, "/keys/"+inactive.KeyID+change.suffix, nil, nil); code != http.StatusOK {
+				t.Fatalf("Expected 200 changing the key state, got %d", code)
+			}
+			if code := doJSON(router, http.MethodPost, "/keys/"+inactive.KeyID+"/backup-shares", map[string]interface{}{"threshold": 2, "custodians": custodians}, nil); code != http.StatusBadRequest {
+				t.Errorf("Expected 400 backing up a key that is not active, got %d", code)
+			}
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestWrappedKeyExport tests exporting wrapped key material and the audit trail it leaves
+func TestWrappedKeyExport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+	cfg := &config.Config{MasterKey: masterKey}
+	router := api.SetupRouter(api.NewHandler(store, cfg), nil, false)
+
+	do := func(method, path string, body interface{}, out interface{}) int {
+		var payload bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&payload).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &payload)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	expected, err := crypto.DecryptKey(masterKey, key.EncryptedPrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to decrypt test key: %v", err)
+	}
+
+	// Export to a registered X25519 recipient
+	recipientPublic, recipientPrivate, _ := crypto.GenerateWrappingKey(crypto.WrappingX25519AES256GCM)
+	var recipient api.ExportRecipientResponse
+	code := do(http.MethodPost, "/export-recipients", map[string]string{
+		"name":               "backup-hsm",
+		"wrapping_algorithm": crypto.WrappingX25519AES256GCM,
+		"public_key":         base64.StdEncoding.EncodeToString(recipientPublic),
+	}, &recipient)
+	if code != http.StatusOK {
+		t.Fatalf("Failed to register export recipient: %d", code)
+	}
+
+	var exported api.ExportKeyResponse
+	if code := do(http.MethodPost, "/keys/"+key.ID+"/export", map[string]string{"recipient_id": recipient.RecipientID}, &exported); code != http.StatusOK {
+		t.Fatalf("Failed to export to recipient: %d", code)
+	}
+	wrapped, _ := base64.StdEncoding.DecodeString(exported.WrappedKeyMaterial)
+	unwrapped, err := crypto.UnwrapKeyMaterial(crypto.WrappingX25519AES256GCM, recipientPrivate, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, expected) {
+		t.Errorf("Exported material does not unwrap to the key: %v", err)
+	}
+
+	// Export to a caller-supplied RSA-OAEP key
+	rsaPublic, rsaPrivate, _ := crypto.GenerateWrappingKey(crypto.WrappingRSAOAEPSHA256)
+	request := map[string]string{
+		"wrapping_algorithm":  crypto.WrappingRSAOAEPSHA256,
+		"wrapping_public_key": base64.StdEncoding.EncodeToString(rsaPublic),
+	}
+	if code := do(http.MethodPost, "/keys/"+key.ID+"/export", request, &exported); code != http.StatusOK {
+		t.Fatalf("Failed to export to supplied key: %d", code)
+	}
+	wrapped, _ = base64.StdEncoding.DecodeString(exported.WrappedKeyMaterial)
+	unwrapped, err = crypto.UnwrapKeyMaterial(crypto.WrappingRSAOAEPSHA256, rsaPrivate, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, expected) {
+		t.Errorf("RSA-OAEP export does not unwrap to the key: %v", err)
+	}
+
+	// Plaintext download is disabled unless configured
+	if code := do(http.MethodGet, "/keys/"+key.ID+"/download", nil, nil); code != http.StatusForbidden {
+		t.Errorf("Expected plaintext download to be disabled, got %d", code)
+	}
+	cfg.AllowPlaintextExport = true
+	if code := do(http.MethodGet, "/keys/"+key.ID+"/download", nil, nil); code != http.StatusOK {
+		t.Errorf("Expected plaintext download to be enabled, got %d", code)
+	}
+
+	// Keys that are not active cannot be exported
+	if err := store.RevokeKey(reseller.ID); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+	if code := do(http.MethodPost, "/keys/"+reseller.ID+"/export", map[string]string{"recipient_id": recipient.RecipientID}, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 exporting a revoked key, got %d", code)
+	}
+	if code := do(http.MethodGet, "/keys/"+reseller.ID+"/download", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 downloading a revoked key, got %d", code)
+	}
+	if revoked, _ := store.ListEvents(reseller.ID, 0); len(revoked) != 0 && revoked[0].Type == storage.EventKeyExported {
+		t.Error("Expected the refused export not to be audited as an export")
+	}
+
+	events, err := store.ListEvents(key.ID, 0)
+	if err != nil {
+		t.Fatalf("Failed to list events: %v", err)
+	}
+	exports := 0
+	for _, event := range events {
+		if event.Type == storage.EventKeyExported {
+			exports++
+		}
+	}
+	if exports != 3 {
+		t.Errorf("Expected 3 audited exports, got %d", exports)
+	}
+}
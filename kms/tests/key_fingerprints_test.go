@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestKeyCheckValue tests key check values against a known answer
+func TestKeyCheckValue(t *testing.T) {
+	// AES-256 under the all-zero key encrypts the zero block to DC95C078...
+	kcv, err := crypto.KeyCheckValue(crypto.AlgorithmAES256GCM, make([]byte, 32))
+	if err != nil || kcv != "DC95C0" {
+		t.Errorf("Expected KCV DC95C0, got %s (%v)", kcv, err)
+	}
+
+	hmacKCV, err := crypto.KeyCheckValue(crypto.AlgorithmHMACSHA256, make([]byte, 32))
+	if err != nil || len(hmacKCV) != 6 || hmacKCV == kcv {
+		t.Errorf("Expected a distinct 6-digit HMAC KCV, got %s (%v)", hmacKCV, err)
+	}
+
+	if _, err := crypto.KeyCheckValue(crypto.AlgorithmEd25519, make([]byte, 32)); err == nil {
+		t.Error("Expected an error for an asymmetric algorithm")
+	}
+}
+
+// TestKeyFingerprints tests check values and fingerprints on keys and looking keys up by them
+func TestKeyFingerprints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	handler := api.NewHandler(store, &config.Config{MasterKey: masterKey})
+	router := api.SetupRouter(handler, nil, false)
+
+	register := func(algorithm string) string {
+		var resp api.RegisterKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys", map[string]string{"algorithm": algorithm}, &resp); code != http.StatusOK {
+			t.Fatalf("Failed to register key: %d", code)
+		}
+		return resp.KeyID
+	}
+	lookup := func(query string) []api.KeyInfo {
+		var resp api.ListKeysResponse
+		if code := doJSON(router, http.MethodGet, "/keys?"+query, nil, &resp); code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d", query, code)
+		}
+		return resp.Keys
+	}
+	// checkValue computes the KCV a customer holding the material would get
+	checkValue := func(keyID string, version int) string {
+		key, _ := store.GetKey(keyID)
+		material, _ := crypto.DecryptKey(masterKey, key.MaterialVersion(version).EncryptedPrivateKey)
+		kcv, err := crypto.KeyCheckValue(key.KeyAlgorithm(), material)
+		if err != nil {
+			t.Fatalf("Failed to compute KCV: %v", err)
+		}
+		return kcv
+	}
+
+	aesID := register("aes-256-gcm")
+	edID := register("ed25519")
+
+	t.Run("symmetric keys have a check value", func(t *testing.T) {
+		keys := lookup("kcv=" + checkValue(aesID, 1))
+		if len(keys) != 1 || keys[0].KeyID != aesID {
+			t.Fatalf("Expected the key to be found by its KCV, got %+v", keys)
+		}
+		if keys[0].KCV != checkValue(aesID, 1) || keys[0].Fingerprint != "" {
+			t.Errorf("Expected only a KCV, got %q and %q", keys[0].KCV, keys[0].Fingerprint)
+		}
+
+		// Rotated keys are still found by the check value of older material
+		if code := doJSON(router, http.MethodPost, "/keys/"+aesID+"/rotate", nil, nil); code != http.StatusOK {
+			t.Fatalf("Expected 200 rotating the key, got %d", code)
+		}
+		keys = lookup("kcv=" + strings.ToLower(checkValue(aesID, 1)))
+		if len(keys) != 1 || keys[0].KCV != checkValue(aesID, 2) {
+			t.Fatalf("Expected the rotated key with its new KCV, got %+v", keys)
+		}
+		if keys[0].Versions[0].KCV != checkValue(aesID, 1) || keys[0].Versions[1].KCV != checkValue(aesID, 2) {
+			t.Errorf("Expected a KCV on every version, got %+v", keys[0].Versions)
+		}
+	})
+
+	t.Run("asymmetric keys have a fingerprint", func(t *testing.T) {
+		key, _ := store.GetKey(edID)
+		der, _ := x509.MarshalPKIXPublicKey(ed25519.PublicKey(key.PublicKey))
+		sum := sha256.Sum256(der)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		keys := lookup("fingerprint=" + strings.ToUpper(fingerprint))
+		if len(keys) != 1 || keys[0].KeyID != edID || keys[0].Fingerprint != fingerprint || keys[0].KCV != "" {
+			t.Errorf("Expected the key to be found by its fingerprint, got %+v", keys)
+		}
+	})
+
+	t.Run("export and derived keys", func(t *testing.T) {
+		recipientPublic, _, _ := crypto.GenerateWrappingKey(crypto.WrappingX25519AES256GCM)
+		var exported api.ExportKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+aesID+"/export", map[string]interface{}{
+			"wrapping_algorithm":  crypto.WrappingX25519AES256GCM,
+			"wrapping_public_key": base64.StdEncoding.EncodeToString(recipientPublic),
+			"key_version":         1,
+		}, &exported); code != http.StatusOK {
+			t.Fatalf("Expected 200 from export, got %d", code)
+		}
+		if exported.KCV != checkValue(aesID, 1) {
+			t.Errorf("Expected the KCV of the exported version, got %q", exported.KCV)
+		}
+
+		var derived api.DerivedKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+aesID+"/derive", map[string]string{"context": "site-1"}, &derived); code != http.StatusCreated {
+			t.Fatalf("Expected 201 deriving a key, got %d", code)
+		}
+		parent, _ := store.GetKey(aesID)
+		parentMaterial, _ := crypto.DecryptKey(masterKey, parent.PrimaryMaterial().EncryptedPrivateKey)
+		subkey, _ := crypto.DeriveKey(parentMaterial, "site-1")
+		expected, _ := crypto.KeyCheckValue(crypto.AlgorithmAES256GCM, subkey)
+		if derived.KCV != expected {
+			t.Errorf("Expected the KCV of the derived material, got %q want %q", derived.KCV, expected)
+		}
+		if keys := lookup("kcv=" + expected); len(keys) != 1 || keys[0].KeyID != derived.KeyID {
+			t.Errorf("Expected the derived key to be found by its KCV, got %+v", keys)
+		}
+	})
+
+	t.Run("invalid lookups", func(t *testing.T) {
+		for _, query := range []string{"kcv=XYZ123", "kcv=ABCD", "fingerprint=abc"} {
+			if code := doJSON(router, http.MethodGet, "/keys?"+query, nil, nil); code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %s, got %d", query, code)
+			}
+		}
+		if keys := lookup("kcv=000000&fingerprint=" + strings.Repeat("0", 64)); len(keys) != 0 {
+			t.Errorf("Expected no matches, got %d", len(keys))
+		}
+	})
+
+	t.Run("backfill", func(t *testing.T) {
+		// A key stored before check values were recorded
+		material, _ := crypto.GenerateSymmetricKey()
+		encrypted, _ := crypto.EncryptKey(masterKey, material)
+		now := time.Now().UTC()
+		legacy := &storage.Key{ID: "legacy-key", KeyType: storage.KeyTypeSymmetric, EncryptedPrivateKey: encrypted, Status: storage.KeyStatusActive, CreatedAt: now, ExpiresAt: now.Add(time.Hour), Version: 1}
+		if err := store.StoreKey(legacy); err != nil {
+			t.Fatalf("Failed to store key: %v", err)
+		}
+
+		updated, err := handler.BackfillKeyCheckValues()
+		if err != nil || updated != 1 {
+			t.Fatalf("Expected 1 key to be backfilled, got %d (%v)", updated, err)
+		}
+		expected, _ := crypto.KeyCheckValue(crypto.AlgorithmAES256GCM, material)
+		if key, _ := store.GetKey(legacy.ID); key.CheckValue != expected {
+			t.Errorf("Expected KCV %s after the backfill, got %q", expected, key.CheckValue)
+		}
+		if updated, _ := handler.BackfillKeyCheckValues(); updated != 0 {
+			t.Errorf("Expected nothing left to backfill, got %d", updated)
+		}
+	})
+}
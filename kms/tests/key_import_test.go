@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// TestWrappedKeyImport tests importing key material wrapped to a one-time import token
+func TestWrappedKeyImport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	do := func(path string, body interface{}, out interface{}) int {
+		var payload bytes.Buffer
+		json.NewEncoder(&payload).Encode(body)
+		req := httptest.NewRequest(http.MethodPost, path, &payload)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	_, ecdsaKey, _ := crypto.GenerateKeyMaterial(crypto.AlgorithmECDSAP256)
+	aesKey, _ := crypto.GenerateSymmetricKey()
+	imports := []struct {
+		wrapping  string
+		algorithm string
+		material  []byte
+	}{
+		{crypto.WrappingRSAOAEPSHA256, crypto.AlgorithmAES256GCM, aesKey},
+		{crypto.WrappingX25519AES256GCM, crypto.AlgorithmECDSAP256, ecdsaKey},
+	}
+
+	for _, imp := range imports {
+		var token api.ImportTokenResponse
+		if code := do("/keys/import-token", map[string]string{"wrapping_algorithm": imp.wrapping}, &token); code != http.StatusOK {
+			t.Fatalf("Failed to create %s import token: %d", imp.wrapping, code)
+		}
+
+		publicKey, _ := base64.StdEncoding.DecodeString(token.PublicKey)
+		wrapped, err := crypto.WrapKeyMaterial(imp.wrapping, publicKey, imp.material)
+		if err != nil {
+			t.Fatalf("Failed to wrap key material: %v", err)
+		}
+
+		request := map[string]string{
+			"algorithm":            imp.algorithm,
+			"import_token":         token.ImportToken,
+			"wrapped_key_material": base64.StdEncoding.EncodeToString(wrapped),
+		}
+		var registered api.RegisterKeyResponse
+		if code := do("/keys/import", request, &registered); code != http.StatusOK {
+			t.Fatalf("Failed to import %s key: %d", imp.algorithm, code)
+		}
+
+		stored, err := store.GetKey(registered.KeyID)
+		if err != nil {
+			t.Fatalf("Imported key not stored: %v", err)
+		}
+		decrypted, _ := crypto.DecryptKey(masterKey, stored.EncryptedPrivateKey)
+		if !bytes.Equal(decrypted, imp.material) {
+			t.Errorf("Imported %s material does not match", imp.algorithm)
+		}
+
+		// Tokens are single-use
+		if code := do("/keys/import", request, nil); code != http.StatusNotFound {
+			t.Errorf("Expected reused import token to be rejected, got %d", code)
+		}
+	}
+
+	// Raw key material is refused on the import endpoint
+	request := map[string]string{"key_type": "symmetric", "key_material": "AAAA", "import_token": "x", "wrapped_key_material": "AAAA"}
+	if code := do("/keys/import", request, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected key_material to be refused, got %d", code)
+	}
+
+	// Expired tokens cannot be used and are purged
+	now := time.Now().UTC()
+	for _, id := range []string{"expired-consumed", "expired-purged"} {
+		store.StoreImportToken(&storage.ImportToken{ID: id, CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)})
+	}
+	if _, err := store.ConsumeImportToken("expired-consumed", now); err != errors.ErrImportTokenExpired {
+		t.Errorf("Expected ErrImportTokenExpired, got %v", err)
+	}
+	if purged, err := store.PurgeExpiredImportTokens(now); err != nil || purged != 1 {
+		t.Errorf("Expected one expired token to be purged, got %d (%v)", purged, err)
+	}
+}
+
+// TestPlaintextKeyImport tests that raw key material on POST /keys needs the plaintext import setting
+func TestPlaintextKeyImport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	cfg := &config.Config{MasterKey: masterKey}
+	router := api.SetupRouter(api.NewHandler(store, cfg), nil, false)
+
+	material, _ := crypto.GenerateSymmetricKey()
+	request := map[string]string{"algorithm": "aes-256-gcm", "key_material": base64.StdEncoding.EncodeToString(material)}
+	if code := doJSON(router, http.MethodPost, "/keys", request, nil); code != http.StatusForbidden {
+		t.Errorf("Expected plaintext import to be disabled, got %d", code)
+	}
+
+	cfg.AllowPlaintextImport = true
+	var registered api.RegisterKeyResponse
+	if code := doJSON(router, http.MethodPost, "/keys", request, &registered); code != http.StatusOK {
+		t.Fatalf("Expected plaintext import to be enabled, got %d", code)
+	}
+	stored, err := store.GetKey(registered.KeyID)
+	if err != nil {
+		t.Fatalf("Imported key not stored: %v", err)
+	}
+	if decrypted, _ := crypto.DecryptKey(masterKey, stored.EncryptedPrivateKey); !bytes.Equal(decrypted, material) {
+		t.Error("Imported material does not match")
+	}
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestRefreshProofOfPossession tests that key refresh requires answering a challenge with the key
+func TestRefreshProofOfPossession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	cfg := &config.Config{MasterKey: masterKey}
+	router := api.SetupRouter(api.NewHandler(store, cfg), nil, false)
+
+	register := func(algorithm string) string {
+		var resp api.RegisterKeyResponse
+		doJSON(router, http.MethodPost, "/keys", map[string]string{"algorithm": algorithm}, &resp)
+		return resp.KeyID
+	}
+	challenge := func(keyID string) api.RefreshChallengeResponse {
+		var resp api.RefreshChallengeResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/refresh/challenge", nil, &resp); code != http.StatusOK {
+			t.Fatalf("Failed to create refresh challenge: %d", code)
+		}
+		return resp
+	}
+	// prove answers a challenge with the primary material of a stored key
+	prove := func(keyID string, ch api.RefreshChallengeResponse) string {
+		key, _ := store.GetKey(keyID)
+		secret, _ := crypto.DecryptKey(masterKey, key.PrimaryMaterial().EncryptedPrivateKey)
+		algorithm := key.KeyAlgorithm()
+		if key.KeyType == storage.KeyTypeSymmetric {
+			algorithm = crypto.AlgorithmHMACSHA256
+		}
+		proof, err := crypto.Sign(algorithm, secret, api.RefreshProofMessage(ch.KeyID, ch.Challenge))
+		if err != nil {
+			t.Fatalf("Failed to answer challenge: %v", err)
+		}
+		return base64.StdEncoding.EncodeToString(proof)
+	}
+	refresh := func(keyID string, ch api.RefreshChallengeResponse, proof string, roll bool, out interface{}) int {
+		return doJSON(router, http.MethodPost, "/keys/"+keyID+"/refresh", map[string]interface{}{
+			"expires_in_seconds": 3600,
+			"challenge_id":       ch.ChallengeID,
+			"proof":              proof,
+			"roll_key_material":  roll,
+		}, out)
+	}
+
+	for _, algorithm := range []string{"ed25519", "ecdsa-p256", "aes-256-gcm", "hmac-sha256"} {
+		keyID := register(algorithm)
+		ch := challenge(keyID)
+		var resp api.RefreshKeyResponse
+		if code := refresh(keyID, ch, prove(keyID, ch), false, &resp); code != http.StatusOK {
+			t.Errorf("Failed to refresh %s key: %d", algorithm, code)
+		}
+		if resp.Rolled || resp.PrimaryVersion != 1 || time.Until(resp.NewExpiresAt) > time.Hour {
+			t.Errorf("Unexpected %s refresh %+v", algorithm, resp)
+		}
+	}
+
+	keyID := register("ed25519")
+	other := register("ed25519")
+
+	// Refresh without a proof is refused
+	if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/refresh", map[string]int{"expires_in_seconds": 3600}, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a proof, got %d", code)
+	}
+
+	// A wrong proof fails and uses up the challenge
+	ch := challenge(keyID)
+	if code := refresh(keyID, ch, prove(other, ch), false, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a proof made with another key, got %d", code)
+	}
+	if code := refresh(keyID, ch, prove(keyID, ch), false, nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 reusing a challenge, got %d", code)
+	}
+
+	// Challenges are bound to their key
+	ch = challenge(other)
+	if code := refresh(keyID, ch, prove(keyID, ch), false, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for another key's challenge, got %d", code)
+	}
+
+	// Rolling rotates the material as part of the refresh
+	ch = challenge(keyID)
+	var rolled api.RefreshKeyResponse
+	if code := refresh(keyID, ch, prove(keyID, ch), true, &rolled); code != http.StatusOK || !rolled.Rolled || rolled.PrimaryVersion != 2 {
+		t.Errorf("Unexpected rolled refresh %d %+v", code, rolled)
+	}
+	events, _ := store.ListEvents(keyID, 0)
+	if len(events) < 2 || events[0].Type != storage.EventKeyRefreshed || events[1].Type != storage.EventKeyRotated {
+		t.Errorf("Expected rotation and refresh events, got %+v", events)
+	}
+
+	// Expired keys are refused unless they are within the grace period
+	ch = challenge(keyID)
+	store.UpdateKeyExpiry(keyID, time.Now().UTC().Add(-time.Minute))
+	if code := refresh(keyID, ch, prove(keyID, ch), false, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 refreshing an expired key, got %d", code)
+	}
+	cfg.RefreshGracePeriod = time.Hour
+	ch = challenge(keyID)
+	if code := refresh(keyID, ch, prove(keyID, ch), false, nil); code != http.StatusOK {
+		t.Errorf("Expected refresh within the grace period to succeed, got %d", code)
+	}
+
+	// Revoked keys cannot be refreshed
+	store.RevokeKey(other)
+	if code := doJSON(router, http.MethodPost, "/keys/"+other+"/refresh/challenge", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a revoked key challenge, got %d", code)
+	}
+}
+
+// TestRefreshProofFromSignEndpoint tests that the sign endpoint cannot answer a refresh challenge
+func TestRefreshProofFromSignEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	for _, algorithm := range []string{"ed25519", "ecdsa-p256", "rsa-pss-2048", "hmac-sha256"} {
+		var registered api.RegisterKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys", map[string]string{"algorithm": algorithm}, &registered); code != http.StatusOK {
+			t.Fatalf("Failed to register %s key: %d", algorithm, code)
+		}
+		keyID := registered.KeyID
+
+		var ch api.RefreshChallengeResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/refresh/challenge", nil, &ch); code != http.StatusOK {
+			t.Fatalf("Failed to create refresh challenge: %d", code)
+		}
+		message := api.RefreshProofMessage(keyID, ch.Challenge)
+
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/sign", map[string]string{"message": string(message)}, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 signing a %s refresh proof message, got %d", algorithm, code)
+		}
+		if digest, ok := crypto.MessageDigest(algorithm, message); ok {
+			if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/sign", map[string]string{"digest": base64.StdEncoding.EncodeToString(digest)}, nil); code != http.StatusBadRequest {
+				t.Errorf("Expected 400 signing the digest of a %s refresh proof message, got %d", algorithm, code)
+			}
+		}
+
+		// A signature over the bare challenge is not a proof
+		var signed api.SignResponse
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/sign", map[string]string{"message": ch.Challenge}, &signed); code != http.StatusOK {
+			t.Fatalf("Expected 200 signing the bare challenge, got %d", code)
+		}
+		if code := doJSON(router, http.MethodPost, "/keys/"+keyID+"/refresh", map[string]interface{}{
+			"expires_in_seconds": 3600,
+			"challenge_id":       ch.ChallengeID,
+			"proof":              signed.Signature,
+		}, nil); code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for a %s proof from the sign endpoint, got %d", algorithm, code)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/bbolt"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestKeySearch tests key labels, filtered and sorted listings and cursor pagination
+func TestKeySearch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	register := func(body map[string]interface{}) string {
+		var resp api.RegisterKeyResponse
+		if code := doJSON(router, http.MethodPost, "/keys", body, &resp); code != http.StatusOK {
+			t.Fatalf("Failed to register key: %d", code)
+		}
+		return resp.KeyID
+	}
+	list := func(params url.Values) ([]string, string) {
+		var resp api.ListKeysResponse
+		if code := doJSON(router, http.MethodGet, "/keys?"+params.Encode(), nil, &resp); code != http.StatusOK {
+			t.Fatalf("Expected 200 listing %s, got %d", params.Encode(), code)
+		}
+		ids := make([]string, 0, len(resp.Keys))
+		for _, info := range resp.Keys {
+			ids = append(ids, info.KeyID)
+		}
+		return ids, resp.NextCursor
+	}
+	equal := func(got, want []string) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	// The fixture keys have no tags, so tag filters only ever see the keys below
+	bravo := register(map[string]interface{}{"algorithm": "ed25519", "name": "Bravo", "tags": map[string]string{"customer": "acme", "env": "prod"}})
+	alpha := register(map[string]interface{}{"algorithm": "aes-256-gcm", "name": "alpha", "tags": map[string]string{"customer": "acme", "env": "dev"}, "expires_in_seconds": 3600})
+	charlie := register(map[string]interface{}{"algorithm": "ed25519", "name": "Charlie", "description": "Plant 7", "tags": map[string]string{"customer": "globex"}})
+
+	t.Run("labels are returned with the key", func(t *testing.T) {
+		var resp api.ListKeysResponse
+		doJSON(router, http.MethodGet, "/keys?tag=customer:globex", nil, &resp)
+		if len(resp.Keys) != 1 || resp.Keys[0].Name != "Charlie" || resp.Keys[0].Description != "Plant 7" || resp.Keys[0].Tags["customer"] != "globex" {
+			t.Errorf("Expected the labels of Charlie, got %+v", resp.Keys)
+		}
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		if ids, _ := list(url.Values{"tag": {"customer:acme"}}); !equal(ids, []string{bravo, alpha}) {
+			t.Errorf("Expected the acme keys oldest first, got %v", ids)
+		}
+		if ids, _ := list(url.Values{"tag": {"customer:acme", "env:prod"}}); !equal(ids, []string{bravo}) {
+			t.Errorf("Expected every tag to have to match, got %v", ids)
+		}
+		if ids, _ := list(url.Values{"tag": {"env"}}); !equal(ids, []string{bravo, alpha}) {
+			t.Errorf("Expected a tag without a value to match any value, got %v", ids)
+		}
+		if ids, _ := list(url.Values{"tag": {"customer"}, "type": {"symmetric"}}); !equal(ids, []string{alpha}) {
+			t.Errorf("Expected only the symmetric key, got %v", ids)
+		}
+
+		expiresBefore := time.Now().UTC().Add(2 * time.Hour).Format(time.RFC3339)
+		if ids, _ := list(url.Values{"tag": {"customer"}, "expires_before": {expiresBefore}}); !equal(ids, []string{alpha}) {
+			t.Errorf("Expected only the key expiring within 2 hours, got %v", ids)
+		}
+		if ids, _ := list(url.Values{"tag": {"customer"}, "expires_after": {expiresBefore}}); !equal(ids, []string{bravo, charlie}) {
+			t.Errorf("Expected the keys expiring after 2 hours, got %v", ids)
+		}
+
+		if err := store.RevokeKey(charlie); err != nil {
+			t.Fatalf("Failed to revoke key: %v", err)
+		}
+		if ids, _ := list(url.Values{"tag": {"customer"}, "status": {"revoked"}}); !equal(ids, []string{charlie}) {
+			t.Errorf("Expected only the revoked key, got %v", ids)
+		}
+	})
+
+	t.Run("sorting", func(t *testing.T) {
+		if ids, _ := list(url.Values{"tag": {"customer"}, "sort": {"name"}}); !equal(ids, []string{alpha, bravo, charlie}) {
+			t.Errorf("Expected case-insensitive name order, got %v", ids)
+		}
+		if ids, _ := list(url.Values{"tag": {"customer"}, "sort": {"name"}, "order": {"desc"}}); !equal(ids, []string{charlie, bravo, alpha}) {
+			t.Errorf("Expected reverse name order, got %v", ids)
+		}
+		if ids, _ := list(url.Values{"tag": {"customer"}, "sort": {"expires_at"}}); !equal(ids, []string{alpha, bravo, charlie}) {
+			t.Errorf("Expected expiry order, got %v", ids)
+		}
+
+		// Renaming and refreshing move a key within the order
+		if code := doJSON(router, http.MethodPut, "/keys/"+alpha+"/labels", map[string]interface{}{"name": "Delta", "tags": map[string]string{"customer": "acme"}}, nil); code != http.StatusOK {
+			t.Fatalf("Expected 200 setting labels, got %d", code)
+		}
+		if ids, _ := list(url.Values{"tag": {"customer"}, "sort": {"name"}}); !equal(ids, []string{bravo, charlie, alpha}) {
+			t.Errorf("Expected the renamed key last, got %v", ids)
+		}
+		if err := store.UpdateKeyExpiry(alpha, time.Now().UTC().Add(10*365*24*time.Hour)); err != nil {
+			t.Fatalf("Failed to update expiry: %v", err)
+		}
+		if ids, _ := list(url.Values{"tag": {"customer"}, "sort": {"expires_at"}}); !equal(ids, []string{bravo, charlie, alpha}) {
+			t.Errorf("Expected the extended key last, got %v", ids)
+		}
+		if ids, _ := list(url.Values{"tag": {"env"}}); !equal(ids, []string{bravo}) {
+			t.Errorf("Expected labels to be replaced as a whole, got %v", ids)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		all, cursor := list(url.Values{"limit": {"1000"}})
+		if len(all) != 5 || cursor != "" {
+			t.Fatalf("Expected all 5 keys on one page, got %d with cursor %q", len(all), cursor)
+		}
+
+		for _, order := range []string{"asc", "desc"} {
+			var walked []string
+			params := url.Values{"limit": {"2"}, "order": {order}}
+			for pages := 0; ; pages++ {
+				if pages > 5 {
+					t.Fatalf("Pagination did not end")
+				}
+				ids, next := list(params)
+				walked = append(walked, ids...)
+				if next == "" {
+					break
+				}
+				params.Set("cursor", next)
+			}
+
+			want := all
+			if order == "desc" {
+				want = make([]string, len(all))
+				for i, id := range all {
+					want[len(all)-1-i] = id
+				}
+			}
+			if !equal(walked, want) {
+				t.Errorf("Expected pages in %s order to cover every key once, got %v want %v", order, walked, want)
+			}
+		}
+
+		// A full last page has no cursor
+		if ids, cursor := list(url.Values{"tag": {"customer"}, "limit": {"3"}}); len(ids) != 3 || cursor != "" {
+			t.Errorf("Expected no cursor after the last match, got %q", cursor)
+		}
+	})
+
+	t.Run("invalid queries", func(t *testing.T) {
+		_, cursor := list(url.Values{"limit": {"1"}})
+		for _, query := range []string{
+			"sort=owner",
+			"order=up",
+			"limit=0",
+			"limit=1001",
+			"expires_after=tomorrow",
+			"tag=bad%20tag:x",
+			"cursor=not-a-cursor",
+			"sort=name&cursor=" + cursor, // Cursors only continue the order they came from
+		} {
+			if code := doJSON(router, http.MethodGet, "/keys?"+query, nil, nil); code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %s, got %d", query, code)
+			}
+		}
+
+		tags := map[string]string{}
+		for i := 0; i < 33; i++ {
+			tags[string(rune('a'+i%26))+string(rune('a'+i/26))] = "x"
+		}
+		for _, body := range []map[string]interface{}{
+			{"tags": tags},
+			{"tags": map[string]string{"site id": "7"}},
+			{"name": string(make([]byte, 129))},
+		} {
+			if code := doJSON(router, http.MethodPut, "/keys/"+bravo+"/labels", body, nil); code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for labels %v, got %d", body, code)
+			}
+		}
+		if code := doJSON(router, http.MethodPut, "/keys/missing-key/labels", map[string]string{"name": "x"}, nil); code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", code)
+		}
+	})
+}
+
+// TestKeyIndexBackfill tests that keys stored before the indexes existed are indexed when the store is opened
+func TestKeyIndexBackfill(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "backfill.db")
+	store, err := storage.NewBoltStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	now := time.Now().UTC()
+	for _, id := range []string{"key-b", "key-a"} {
+		key := &storage.Key{ID: id, KeyType: storage.KeyTypeSymmetric, Status: storage.KeyStatusActive, CreatedAt: now, ExpiresAt: now.Add(time.Hour), Version: 1}
+		if err := store.StoreKey(key); err != nil {
+			t.Fatalf("Failed to store key: %v", err)
+		}
+		now = now.Add(time.Second)
+	}
+	store.Close()
+
+	// Drop the indexes, as in a database written before they were added
+	db, err := bbolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range []string{storage.KeysByCreatedAtBucket, storage.KeysByExpiresAtBucket, storage.KeysByNameBucket} {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to drop indexes: %v", err)
+	}
+
+	store, err = storage.NewBoltStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	page, err := store.QueryKeys(storage.KeyQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("Failed to query keys: %v", err)
+	}
+	if len(page.Keys) != 2 || page.Keys[0].ID != "key-b" || page.Keys[1].ID != "key-a" {
+		t.Errorf("Expected both keys in creation order, got %+v", page.Keys)
+	}
+}
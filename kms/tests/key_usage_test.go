@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestKeyUsageTracking tests that key operations are counted, batched and exposed per key
+func TestKeyUsageTracking(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, key, _ := newLicenseTestStore(t)
+	handler := api.NewHandler(store, &config.Config{MasterKey: masterKey})
+	router := api.SetupRouter(handler, nil, false)
+
+	// A key created long ago and never used since
+	idleKey := &storage.Key{
+		ID:        "idle-key",
+		KeyType:   storage.KeyTypeAsymmetric,
+		PublicKey: key.PublicKey,
+		ExpiresAt: time.Now().UTC().Add(24 * time.Hour),
+		CreatedAt: time.Now().UTC().Add(-60 * 24 * time.Hour),
+		Status:    storage.KeyStatusActive,
+		Version:   1,
+	}
+	if err := store.StoreKey(idleKey); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+
+	var signed api.SignResponse
+	if code := doJSON(router, http.MethodPost, "/keys/"+key.ID+"/sign", map[string]string{"message": "hello"}, &signed); code != http.StatusOK {
+		t.Fatalf("Expected 200 from sign, got %d", code)
+	}
+	if code := doJSON(router, http.MethodPost, "/keys/validate", map[string]string{"key_id": key.ID, "message": "hello", "signature": signed.Signature}, nil); code != http.StatusOK {
+		t.Fatalf("Expected 200 from validate, got %d", code)
+	}
+	if code := doJSON(router, http.MethodPost, "/licenses/generate", map[string]interface{}{"key_id": key.ID, "license_type": "standard"}, nil); code != http.StatusOK {
+		t.Fatalf("Expected 200 from license generation, got %d", code)
+	}
+
+	t.Run("usage is reported before it is flushed", func(t *testing.T) {
+		stored, err := store.GetKeyUsage(key.ID)
+		if err != nil {
+			t.Fatalf("Failed to get stored usage: %v", err)
+		}
+		if stored.Total() != 0 {
+			t.Errorf("Expected nothing stored before a flush, got %d operations", stored.Total())
+		}
+
+		var usage api.KeyUsageResponse
+		if code := doJSON(router, http.MethodGet, "/keys/"+key.ID+"/usage", nil, &usage); code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		for _, op := range []string{"sign", "verify", "license-issue"} {
+			if usage.Counts[op] != 1 {
+				t.Errorf("Expected 1 %s operation, got %d", op, usage.Counts[op])
+			}
+		}
+		if usage.Total != 3 {
+			t.Errorf("Expected 3 operations, got %d", usage.Total)
+		}
+		if usage.LastUsedAt == nil || usage.LastOperation != "license-issue" {
+			t.Errorf("Expected last use to be the license issue, got %v %q", usage.LastUsedAt, usage.LastOperation)
+		}
+		if usage.Idle {
+			t.Error("Expected a used key not to be idle")
+		}
+	})
+
+	t.Run("flush writes the batch", func(t *testing.T) {
+		// Usage of keys that do not exist is dropped rather than failing the batch
+		handler.UsageTracker().Record("missing-key", storage.OperationSign, time.Now().UTC())
+		if err := handler.UsageTracker().Flush(); err != nil {
+			t.Fatalf("Failed to flush usage: %v", err)
+		}
+
+		stored, err := store.GetKeyUsage(key.ID)
+		if err != nil {
+			t.Fatalf("Failed to get stored usage: %v", err)
+		}
+		if stored.Total() != 3 || stored.Counts[storage.OperationSign] != 1 {
+			t.Errorf("Expected the 3 operations to be stored, got %v", stored.Counts)
+		}
+
+		// Counts keep adding up across flushes
+		doJSON(router, http.MethodPost, "/keys/"+key.ID+"/sign", map[string]string{"message": "again"}, nil)
+		var usage api.KeyUsageResponse
+		doJSON(router, http.MethodGet, "/keys/"+key.ID+"/usage", nil, &usage)
+		if usage.Counts["sign"] != 2 || usage.Total != 4 {
+			t.Errorf("Expected 2 sign operations out of 4, got %v", usage.Counts)
+		}
+	})
+
+	t.Run("idle keys are flagged in listings", func(t *testing.T) {
+		var list api.ListKeysResponse
+		if code := doJSON(router, http.MethodGet, "/keys", nil, &list); code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		idle := map[string]bool{}
+		for _, info := range list.Keys {
+			idle[info.KeyID] = info.Idle
+			if info.KeyID == key.ID && info.LastUsedAt == nil {
+				t.Error("Expected the listing to show when the key was last used")
+			}
+		}
+		if !idle["idle-key"] || idle[key.ID] || idle["reseller-key"] {
+			t.Errorf("Expected only idle-key to be idle, got %v", idle)
+		}
+
+		var filtered api.ListKeysResponse
+		doJSON(router, http.MethodGet, "/keys?idle=true", nil, &filtered)
+		if len(filtered.Keys) != 1 || filtered.Keys[0].KeyID != "idle-key" {
+			t.Errorf("Expected idle=true to list only idle-key, got %+v", filtered.Keys)
+		}
+	})
+
+	t.Run("idle filter follows the cursor", func(t *testing.T) {
+		for _, id := range []string{"idle-key-2", "idle-key-3"} {
+			other := *idleKey
+			other.ID = id
+			if err := store.StoreKey(&other); err != nil {
+				t.Fatalf("Failed to store key: %v", err)
+			}
+		}
+		// Usage that is not flushed yet counts while walking the pages
+		handler.UsageTracker().Record("idle-key-3", storage.OperationSign, time.Now().UTC())
+
+		var listed []string
+		cursor := ""
+		for {
+			var page api.ListKeysResponse
+			if code := doJSON(router, http.MethodGet, "/keys?idle=true&limit=1&cursor="+url.QueryEscape(cursor), nil, &page); code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", code)
+			}
+			for _, info := range page.Keys {
+				listed = append(listed, info.KeyID)
+				if !info.Idle {
+					t.Errorf("Expected listed key %s to be idle", info.KeyID)
+				}
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		sort.Strings(listed)
+		if len(listed) != 2 || listed[0] != "idle-key" || listed[1] != "idle-key-2" {
+			t.Errorf("Expected idle-key and idle-key-2 across the pages, got %v", listed)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		if code := doJSON(router, http.MethodGet, "/keys/missing-key/usage", nil, nil); code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", code)
+		}
+	})
+}
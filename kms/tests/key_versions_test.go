@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/licenses"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// TestKeyRotation tests that old key versions stay usable until retired
+func TestKeyRotation(t *testing.T) {
+	store, masterKey, _, reseller := newLicenseTestStore(t)
+	content := []byte("license payload")
+
+	oldSig, err := licenses.SignWithKey(content, reseller, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	if oldSig.KeyVersion != 1 {
+		t.Fatalf("Expected legacy key to sign as version 1, got %d", oldSig.KeyVersion)
+	}
+
+	publicKey, privateKey, err := crypto.GenerateAsymmetricKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	encrypted, err := crypto.EncryptKey(masterKey, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt key: %v", err)
+	}
+
+	rotated, err := store.RotateKey(reseller.ID, publicKey, encrypted, "")
+	if err != nil {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+	if rotated.PrimaryVersionNumber() != 2 || len(rotated.Versions) != 2 {
+		t.Fatalf("Expected primary version 2 of 2, got %d of %d", rotated.PrimaryVersionNumber(), len(rotated.Versions))
+	}
+	if string(rotated.PublicKey) != string(publicKey) {
+		t.Error("Top-level public key should mirror the primary version")
+	}
+
+	newSig, err := licenses.SignWithKey(content, rotated, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to sign with rotated key: %v", err)
+	}
+	if newSig.KeyVersion != 2 {
+		t.Errorf("Expected new signature from version 2, got %d", newSig.KeyVersion)
+	}
+
+	for _, sig := range []*licenses.LicenseSignature{oldSig, newSig} {
+		valid, err := licenses.VerifyWithKey(content, *sig, rotated)
+		if err != nil || !valid {
+			t.Errorf("Expected version %d signature to verify after rotation: %v", sig.KeyVersion, err)
+		}
+	}
+
+	if _, err := store.RetireKeyVersion(reseller.ID, 2); err != errors.ErrPrimaryKeyVersion {
+		t.Errorf("Expected ErrPrimaryKeyVersion, got %v", err)
+	}
+	if _, err := store.RetireKeyVersion(reseller.ID, 5); err != errors.ErrKeyVersionNotFound {
+		t.Errorf("Expected ErrKeyVersionNotFound, got %v", err)
+	}
+
+	retired, err := store.RetireKeyVersion(reseller.ID, 1)
+	if err != nil {
+		t.Fatalf("Failed to retire key version: %v", err)
+	}
+	if valid, _ := licenses.VerifyWithKey(content, *oldSig, retired); valid {
+		t.Error("Signature from a retired version should not verify")
+	}
+	if valid, _ := licenses.VerifyWithKey(content, *newSig, retired); !valid {
+		t.Error("Signature from the primary version should still verify")
+	}
+
+	keys, err := store.ListKeys()
+	if err != nil {
+		t.Fatalf("Failed to list keys: %v", err)
+	}
+	for _, key := range keys {
+		for _, v := range key.Versions {
+			if v.EncryptedPrivateKey != nil {
+				t.Errorf("ListKeys leaked material of key %s version %d", key.ID, v.Version)
+			}
+		}
+	}
+}
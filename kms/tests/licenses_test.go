@@ -0,0 +1,416 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/licenses"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/licensefile"
+)
+
+// newLicenseTestStore creates a store with a license key and a reseller signing key
+func newLicenseTestStore(t *testing.T) (*storage.BoltStore, []byte, *storage.Key, *storage.Key) {
+	t.Helper()
+
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+
+	store, err := storage.NewBoltStore(filepath.Join(t.TempDir(), "licenses.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	newKey := func(id string) *storage.Key {
+		publicKey, privateKey, err := crypto.GenerateAsymmetricKeyPair()
+		if err != nil {
+			t.Fatalf("Failed to generate key pair: %v", err)
+		}
+		encrypted, err := crypto.EncryptKey(masterKey, privateKey)
+		if err != nil {
+			t.Fatalf("Failed to encrypt key: %v", err)
+		}
+		key := &storage.Key{
+			ID:                  id,
+			KeyType:             storage.KeyTypeAsymmetric,
+			PublicKey:           publicKey,
+			EncryptedPrivateKey: encrypted,
+			ExpiresAt:           time.Now().UTC().Add(24 * time.Hour),
+			CreatedAt:           time.Now().UTC(),
+			Status:              storage.KeyStatusActive,
+			Version:             1,
+		}
+		if err := store.StoreKey(key); err != nil {
+			t.Fatalf("Failed to store key: %v", err)
+		}
+		return key
+	}
+
+	return store, masterKey, newKey("license-key"), newKey("reseller-key")
+}
+
+// doJSON sends a request with an optional JSON body to a router and decodes the JSON response into out
+// Returns the response status code
+func doJSON(router http.Handler, method, path string, body interface{}, out interface{}) int {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if out != nil {
+		json.Unmarshal(w.Body.Bytes(), out)
+	}
+	return w.Code
+}
+
+// TestMultiSignatureLicense tests root plus reseller co-signed licenses
+func TestMultiSignatureLicense(t *testing.T) {
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+
+	opts := licenses.GenerateOptions{
+		IncludeRoot: true,
+		Signers:     []*storage.Key{reseller},
+		Policy: &licenses.SignaturePolicy{
+			MinSignatures:  2,
+			RequiredKeyIDs: []string{licenses.RootSignerID},
+			AllowedKeyIDs:  []string{reseller.ID},
+		},
+	}
+
+	generated, err := licenses.GenerateSignedLicense(key, "enterprise", map[string]string{"customer_id": "C1"}, masterKey, opts)
+	if err != nil {
+		t.Fatalf("Failed to generate license: %v", err)
+	}
+
+	if len(generated.License.Signatures) != 2 {
+		t.Fatalf("Expected 2 signatures, got %d", len(generated.License.Signatures))
+	}
+
+	result, err := licenses.ValidateLicense(generated.LicenseBytes, licenses.ValidateOptions{}, store, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to validate license: %v", err)
+	}
+	if !result.Valid {
+		t.Fatalf("Expected valid license, got error: %s", result.Error)
+	}
+
+	// Strip the reseller signature - policy should no longer be satisfied
+	var stripped licenses.LicenseFile
+	json.Unmarshal(generated.LicenseBytes, &stripped)
+	stripped.Signatures = stripped.Signatures[:1]
+	strippedBytes, _ := json.Marshal(stripped)
+
+	result, err = licenses.ValidateLicense(strippedBytes, licenses.ValidateOptions{}, store, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to validate license: %v", err)
+	}
+	if result.Valid {
+		t.Error("License missing a required signature should be invalid")
+	}
+
+	// Tamper with metadata - signatures should fail
+	var tampered licenses.LicenseFile
+	json.Unmarshal(generated.LicenseBytes, &tampered)
+	tampered.Metadata["customer_id"] = "C2"
+	tamperedBytes, _ := json.Marshal(tampered)
+
+	result, err = licenses.ValidateLicense(tamperedBytes, licenses.ValidateOptions{}, store, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to validate license: %v", err)
+	}
+	if result.Valid {
+		t.Error("Tampered license should be invalid")
+	}
+}
+
+// TestSignaturePolicyBinding tests that only the signers a license was issued with count
+func TestSignaturePolicyBinding(t *testing.T) {
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+
+	// Without an allow-list only the required signers count
+	if err := licenses.CheckSignaturePolicy(&licenses.SignaturePolicy{
+		MinSignatures:  2,
+		RequiredKeyIDs: []string{licenses.RootSignerID},
+	}, []string{licenses.RootSignerID, reseller.ID}); err == nil {
+		t.Error("Expected a signer outside the policy not to count")
+	}
+	if _, err := licenses.GenerateSignedLicense(key, "enterprise", nil, masterKey, licenses.GenerateOptions{
+		IncludeRoot: true,
+		Signers:     []*storage.Key{reseller},
+		Policy:      &licenses.SignaturePolicy{MinSignatures: 2, RequiredKeyIDs: []string{licenses.RootSignerID}},
+	}); err == nil {
+		t.Error("Expected generation to fail for a policy its signers cannot satisfy")
+	}
+
+	generated, err := licenses.GenerateSignedLicense(key, "enterprise", nil, masterKey, licenses.GenerateOptions{IncludeRoot: true})
+	if err != nil {
+		t.Fatalf("Failed to generate license: %v", err)
+	}
+	if len(generated.License.Signers) != 1 || generated.License.Signers[0] != licenses.RootSignerID {
+		t.Fatalf("Expected the license to list its signers, got %v", generated.License.Signers)
+	}
+
+	// A valid signature from another KMS key appended after issue is refused
+	var extended licenses.LicenseFile
+	json.Unmarshal(generated.LicenseBytes, &extended)
+	payload, _ := licenses.SigningPayload(&extended)
+	extra, err := licenses.SignWithKey(payload, reseller, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to sign payload: %v", err)
+	}
+	extended.Signatures = append(extended.Signatures, *extra)
+	extendedBytes, _ := json.Marshal(extended)
+
+	result, err := licenses.ValidateLicense(extendedBytes, licenses.ValidateOptions{}, store, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to validate license: %v", err)
+	}
+	if result.Valid {
+		t.Error("Expected a signature from an undeclared signer to invalidate the license")
+	}
+
+	// Declaring the extra signer breaks the existing signatures
+	extended.Signers = append(extended.Signers, reseller.ID)
+	extendedBytes, _ = json.Marshal(extended)
+	if result, _ := licenses.ValidateLicense(extendedBytes, licenses.ValidateOptions{}, store, masterKey); result.Valid {
+		t.Error("Expected a changed signer list to invalidate the license")
+	}
+}
+
+// TestNodeLockedLicense tests that a node-locked license needs the fingerprint it is locked to
+func TestNodeLockedLicense(t *testing.T) {
+	store, masterKey, key, _ := newLicenseTestStore(t)
+
+	generated, err := licenses.GenerateSignedLicense(key, "site", nil, masterKey, licenses.GenerateOptions{IncludeRoot: true, Fingerprint: "host-a"})
+	if err != nil {
+		t.Fatalf("Failed to generate license: %v", err)
+	}
+
+	for fingerprint, valid := range map[string]bool{"host-a": true, "host-b": false, "": false} {
+		result, err := licenses.ValidateLicense(generated.LicenseBytes, licenses.ValidateOptions{Fingerprint: fingerprint}, store, masterKey)
+		if err != nil {
+			t.Fatalf("Failed to validate license: %v", err)
+		}
+		if result.Valid != valid {
+			t.Errorf("Expected valid=%v with fingerprint %q, got %v (%s)", valid, fingerprint, result.Valid, result.Error)
+		}
+	}
+}
+
+// TestSignEndpointCannotForgeLicense tests that the raw sign endpoint cannot produce license signatures
+func TestSignEndpointCannotForgeLicense(t *testing.T) {
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	// A license naming only the reseller as signer, never issued by the server
+	forged := licenses.LicenseFile{
+		LicenseID:   "forged-license",
+		LicenseType: "enterprise",
+		KeyID:       key.ID,
+		KeyType:     string(key.KeyType),
+		IssuedAt:    time.Now().UTC(),
+		ExpiresAt:   key.ExpiresAt,
+		Signers:     []string{reseller.ID},
+	}
+	payload, _ := licenses.SigningPayload(&forged)
+
+	var signed api.SignResponse
+	if code := doJSON(router, http.MethodPost, "/keys/"+reseller.ID+"/sign", map[string]string{"message": string(payload)}, &signed); code != http.StatusOK {
+		t.Fatalf("Expected 200 signing the payload, got %d", code)
+	}
+	forged.Signatures = []licenses.LicenseSignature{{
+		KeyID:      reseller.ID,
+		KeyVersion: signed.KeyVersion,
+		Algorithm:  licenses.SignatureAlgorithmEd25519,
+		Signature:  signed.Signature,
+	}}
+	forgedBytes, _ := json.Marshal(forged)
+
+	result, err := licenses.ValidateLicense(forgedBytes, licenses.ValidateOptions{}, store, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to validate license: %v", err)
+	}
+	if result.Valid {
+		t.Error("Expected a raw signature over the payload not to be a valid license signature")
+	}
+
+	// Signing under the license context directly is refused
+	message := string(licensefile.SignedMessage(payload))
+	if code := doJSON(router, http.MethodPost, "/keys/"+reseller.ID+"/sign", map[string]string{"message": message}, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 signing a message in the license context, got %d", code)
+	}
+}
+
+// TestDetachedSignatureLicense tests licenses with a separate .sig file
+func TestDetachedSignatureLicense(t *testing.T) {
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+
+	opts := licenses.GenerateOptions{
+		IncludeRoot: true,
+		Signers:     []*storage.Key{reseller},
+		Detached:    true,
+	}
+
+	generated, err := licenses.GenerateSignedLicense(key, "site", nil, masterKey, opts)
+	if err != nil {
+		t.Fatalf("Failed to generate license: %v", err)
+	}
+
+	if generated.SignatureBytes == nil {
+		t.Fatal("Expected detached signature file")
+	}
+
+	// Payload file must not contain signatures
+	var payload licenses.LicenseFile
+	json.Unmarshal(generated.LicenseBytes, &payload)
+	if payload.Signature != "" || len(payload.Signatures) != 0 {
+		t.Error("Detached payload should not carry signatures")
+	}
+
+	result, err := licenses.ValidateLicense(generated.LicenseBytes, licenses.ValidateOptions{SignatureContent: generated.SignatureBytes}, store, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to validate license: %v", err)
+	}
+	if !result.Valid {
+		t.Fatalf("Expected valid license, got error: %s", result.Error)
+	}
+
+	// Payload without its .sig file - should be invalid
+	result, err = licenses.ValidateLicense(generated.LicenseBytes, licenses.ValidateOptions{}, store, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to validate license: %v", err)
+	}
+	if result.Valid {
+		t.Error("Detached payload without signatures should be invalid")
+	}
+}
+
+// TestLegacyLicenseSignature tests that single-signature licenses still validate
+func TestLegacyLicenseSignature(t *testing.T) {
+	store, masterKey, key, _ := newLicenseTestStore(t)
+
+	legacy := licenses.LicenseFile{
+		LicenseID:   "legacy-license",
+		LicenseType: "trial",
+		KeyID:       key.ID,
+		KeyType:     string(key.KeyType),
+		IssuedAt:    time.Now().UTC(),
+		ExpiresAt:   key.ExpiresAt,
+	}
+	payload, _ := json.Marshal(legacy)
+	signature, err := licenses.SignLicense(payload, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to sign license: %v", err)
+	}
+	legacy.Signature = signature
+	licenseBytes, _ := json.Marshal(legacy)
+
+	result, err := licenses.ValidateLicense(licenseBytes, licenses.ValidateOptions{}, store, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to validate license: %v", err)
+	}
+	if !result.Valid {
+		t.Fatalf("Expected legacy license to be valid, got error: %s", result.Error)
+	}
+}
+
+// TestLicenseTransfer tests that a transfer keeps the license's signature policy and format
+// and refuses expired licenses and license types the key no longer allows
+func TestLicenseTransfer(t *testing.T) {
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{
+		MasterKey:             masterKey,
+		MaxTransfersPerPeriod: 3,
+		TransferPeriod:        365 * 24 * time.Hour,
+	}), nil, false)
+
+	policy := &licenses.SignaturePolicy{
+		MinSignatures:  1,
+		RequiredKeyIDs: []string{licenses.RootSignerID},
+		AllowedKeyIDs:  []string{reseller.ID},
+	}
+	generate := func() string {
+		t.Helper()
+		var resp licenses.GenerateLicenseResponse
+		code := doJSON(router, http.MethodPost, "/licenses/generate", licenses.GenerateLicenseRequest{
+			KeyID:           key.ID,
+			LicenseType:     "enterprise",
+			Signers:         []string{licenses.RootSignerID, reseller.ID},
+			SignaturePolicy: policy,
+			Detached:        true,
+			Fingerprint:     "host-a",
+		}, &resp)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200 generating the license, got %d", code)
+		}
+		return resp.LicenseID
+	}
+
+	t.Run("keeps policy and format", func(t *testing.T) {
+		licenseID := generate()
+
+		var resp licenses.TransferLicenseResponse
+		if code := doJSON(router, http.MethodPost, "/licenses/"+licenseID+"/transfer", licenses.TransferLicenseRequest{Fingerprint: "host-b"}, &resp); code != http.StatusOK {
+			t.Fatalf("Expected 200 transferring the license, got %d", code)
+		}
+		if resp.SignatureFile == "" {
+			t.Error("Expected the replacement to keep its detached signature file")
+		}
+
+		record, err := store.GetLicense(resp.LicenseID)
+		if err != nil {
+			t.Fatalf("Failed to get replacement license: %v", err)
+		}
+		if !record.Detached || record.SignaturePolicy == nil || record.SignaturePolicy.MinSignatures != policy.MinSignatures {
+			t.Errorf("Expected the replacement to keep the signature policy and format, got detached=%v policy=%+v", record.Detached, record.SignaturePolicy)
+		}
+	})
+
+	t.Run("expired license", func(t *testing.T) {
+		licenseID := generate()
+		record, err := store.GetLicense(licenseID)
+		if err != nil {
+			t.Fatalf("Failed to get license: %v", err)
+		}
+		record.ExpiresAt = time.Now().UTC().Add(-time.Hour)
+		if err := store.StoreLicense(record); err != nil {
+			t.Fatalf("Failed to store license: %v", err)
+		}
+
+		if code := doJSON(router, http.MethodPost, "/licenses/"+licenseID+"/transfer", licenses.TransferLicenseRequest{Fingerprint: "host-b"}, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 transferring an expired license, got %d", code)
+		}
+		record, _ = store.GetLicense(licenseID)
+		if record.IsRevoked() || len(record.Transfers) != 0 {
+			t.Error("Expected a refused transfer not to revoke the license or use a transfer slot")
+		}
+	})
+
+	t.Run("usage policy", func(t *testing.T) {
+		licenseID := generate()
+		usage := storage.UsagePolicy{
+			AllowedOperations:   []storage.KeyOperation{storage.OperationLicenseIssue},
+			AllowedLicenseTypes: []string{"trial"},
+		}
+		if code := doJSON(router, http.MethodPut, "/keys/"+key.ID+"/usage-policy", usage, nil); code != http.StatusOK {
+			t.Fatalf("Failed to set usage policy: %d", code)
+		}
+
+		if code := doJSON(router, http.MethodPost, "/licenses/"+licenseID+"/transfer", licenses.TransferLicenseRequest{Fingerprint: "host-b"}, nil); code != http.StatusForbidden {
+			t.Errorf("Expected 403 transferring a license type the key no longer allows, got %d", code)
+		}
+	})
+}
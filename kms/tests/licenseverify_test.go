@@ -0,0 +1,243 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atprof/license-server/kms/internal/crypto"
+	"github.com/atprof/license-server/kms/internal/licenses"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/licensefile"
+	"github.com/atprof/license-server/kms/pkg/licenseverify"
+	"github.com/atprof/license-server/kms/pkg/signedresponse"
+)
+
+// TestClockRollbackDetection tests that the clock refuses to go backwards
+func TestClockRollbackDetection(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "clock.state")
+	clock := licenseverify.NewClock(statePath, []byte("site-secret"), 5*time.Minute)
+
+	current := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	clock.SetTimeSource(func() time.Time { return current })
+
+	if err := clock.Initialize(); err != nil {
+		t.Fatalf("Failed to initialise clock: %v", err)
+	}
+	if _, err := clock.Now(); err != nil {
+		t.Fatalf("Failed to read clock: %v", err)
+	}
+
+	// Small step back within tolerance is accepted and does not move time backwards
+	current = current.Add(-time.Minute)
+	now, err := clock.Now()
+	if err != nil {
+		t.Fatalf("Step back within tolerance should be accepted: %v", err)
+	}
+	if !now.Equal(current.Add(time.Minute)) {
+		t.Errorf("Expected trusted time to stay at %v, got %v", current.Add(time.Minute), now)
+	}
+
+	// Large step back is a rollback
+	current = current.Add(-24 * time.Hour)
+	if _, err := clock.Now(); err == nil {
+		t.Error("Expected clock rollback to be detected")
+	}
+}
+
+// TestClockStateTampering tests that edited state files are rejected
+func TestClockStateTampering(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "clock.state")
+	clock := licenseverify.NewClock(statePath, []byte("site-secret"), time.Minute)
+
+	if err := clock.Initialize(); err != nil {
+		t.Fatalf("Failed to initialise clock: %v", err)
+	}
+	if _, err := clock.Now(); err != nil {
+		t.Fatalf("Failed to read clock: %v", err)
+	}
+
+	// Reading the state with another key simulates a forged file
+	forged := licenseverify.NewClock(statePath, []byte("other-secret"), time.Minute)
+	if _, err := forged.Now(); err != licenseverify.ErrStateTampered {
+		t.Errorf("Expected tampered state error, got: %v", err)
+	}
+
+	if err := os.WriteFile(statePath, []byte(`{"state":"e30=","mac":"AAAA"}`), 0600); err != nil {
+		t.Fatalf("Failed to overwrite state: %v", err)
+	}
+	if _, err := clock.Now(); err != licenseverify.ErrStateTampered {
+		t.Errorf("Expected tampered state error, got: %v", err)
+	}
+}
+
+// TestClockStateMissing tests that removing the state file does not reset rollback detection
+func TestClockStateMissing(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "clock.state")
+	clock := licenseverify.NewClock(statePath, []byte("site-secret"), time.Minute)
+
+	if _, err := clock.Now(); err != licenseverify.ErrStateMissing {
+		t.Errorf("Expected missing state error before initialisation, got: %v", err)
+	}
+	if err := clock.Initialize(); err != nil {
+		t.Fatalf("Failed to initialise clock: %v", err)
+	}
+	if err := clock.Initialize(); err == nil {
+		t.Error("Expected initialising an existing clock to fail")
+	}
+
+	// Deleting the state file to forget the last seen time is an error, not a fresh start
+	if err := os.Remove(statePath); err != nil {
+		t.Fatalf("Failed to remove state: %v", err)
+	}
+	if _, err := clock.Now(); err != licenseverify.ErrStateMissing {
+		t.Errorf("Expected missing state error after removal, got: %v", err)
+	}
+}
+
+// TestClockTimeToken tests that server time tokens advance the trusted time
+func TestClockTimeToken(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	statePath := filepath.Join(t.TempDir(), "clock.state")
+	clock := licenseverify.NewClock(statePath, []byte("site-secret"), time.Minute)
+
+	serverTime := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	token, err := signedresponse.Sign(privateKey, "time-key", "n1", map[string]interface{}{"time": serverTime}, serverTime)
+	if err != nil {
+		t.Fatalf("Failed to sign time token: %v", err)
+	}
+
+	// System clock rolled back a month behind the server
+	clock.SetTimeSource(func() time.Time { return serverTime.Add(-30 * 24 * time.Hour) })
+	if err := clock.AcceptTimeToken(publicKey, token, "n1"); err == nil {
+		t.Error("Expected rollback to be detected against server time")
+	}
+
+	// System clock in sync with the server; the token creates the missing state
+	clock.SetTimeSource(func() time.Time { return serverTime })
+	if err := clock.AcceptTimeToken(publicKey, token, "n1"); err != nil {
+		t.Fatalf("Failed to accept time token: %v", err)
+	}
+
+	// Rolling back afterwards is detected from the stored server time
+	clock.SetTimeSource(func() time.Time { return serverTime.Add(-time.Hour) })
+	if _, err := clock.Now(); err == nil {
+		t.Error("Expected rollback to be detected after accepting time token")
+	}
+}
+
+// TestOfflineVerifierRotatedSigner tests that signatures by a signer version that is not pinned are untrusted
+func TestOfflineVerifierRotatedSigner(t *testing.T) {
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+
+	publicKey, privateKey, err := crypto.GenerateAsymmetricKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	encryptedPrivateKey, err := crypto.EncryptKey(masterKey, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt private key: %v", err)
+	}
+	rotated, err := store.RotateKey(reseller.ID, publicKey, encryptedPrivateKey, "")
+	if err != nil {
+		t.Fatalf("Failed to rotate signer: %v", err)
+	}
+
+	generated, err := licenses.GenerateSignedLicense(key, "site", nil, masterKey, licenses.GenerateOptions{
+		IncludeRoot: true,
+		Signers:     []*storage.Key{rotated},
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate license: %v", err)
+	}
+
+	// Only version 1 is pinned: the version 2 signature is skipped, not reported as forged
+	verifier := &licenseverify.Verifier{
+		TrustedKeys: map[string]ed25519.PublicKey{licensefile.KeyVersionID(reseller.ID, 1): reseller.PublicKey},
+	}
+	result, err := verifier.Verify(generated.LicenseBytes, nil)
+	if err != nil {
+		t.Fatalf("Failed to verify license: %v", err)
+	}
+	if result.Valid || result.Error != "license has 0 of 1 required trusted signatures" {
+		t.Errorf("Expected no trusted signatures, got valid=%v error=%q", result.Valid, result.Error)
+	}
+
+	// Pinning the new version trusts it
+	verifier.TrustedKeys[licensefile.KeyVersionID(reseller.ID, 2)] = ed25519.PublicKey(publicKey)
+	result, err = verifier.Verify(generated.LicenseBytes, nil)
+	if err != nil {
+		t.Fatalf("Failed to verify license: %v", err)
+	}
+	if !result.Valid {
+		t.Errorf("Expected the license to verify with version 2 pinned, got %q", result.Error)
+	}
+}
+
+// TestOfflineVerifier tests offline verification of a co-signed license
+func TestOfflineVerifier(t *testing.T) {
+	_, masterKey, key, reseller := newLicenseTestStore(t)
+
+	generated, err := licenses.GenerateSignedLicense(key, "site", nil, masterKey, licenses.GenerateOptions{
+		IncludeRoot: true,
+		Signers:     []*storage.Key{reseller},
+		Fingerprint: "host-1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate license: %v", err)
+	}
+
+	clock := licenseverify.NewClock(filepath.Join(t.TempDir(), "clock.state"), []byte("site-secret"), time.Minute)
+	if err := clock.Initialize(); err != nil {
+		t.Fatalf("Failed to initialise clock: %v", err)
+	}
+	verifier := &licenseverify.Verifier{
+		TrustedKeys: map[string]ed25519.PublicKey{licensefile.KeyVersionID(reseller.ID, 1): reseller.PublicKey},
+		Clock:       clock,
+		Fingerprint: "host-1",
+	}
+
+	result, err := verifier.Verify(generated.LicenseBytes, nil)
+	if err != nil {
+		t.Fatalf("Failed to verify license: %v", err)
+	}
+	if !result.Valid {
+		t.Fatalf("Expected valid license, got error: %s", result.Error)
+	}
+
+	// The node-locked license needs this host's fingerprint
+	for _, fingerprint := range []string{"", "host-2"} {
+		other := &licenseverify.Verifier{TrustedKeys: verifier.TrustedKeys, Fingerprint: fingerprint}
+		if result, _ := other.Verify(generated.LicenseBytes, nil); result.Valid {
+			t.Errorf("Expected the license to be invalid with fingerprint %q", fingerprint)
+		}
+	}
+
+	// Expired by trusted time once the clock has moved past expiry
+	clock.SetTimeSource(func() time.Time { return key.ExpiresAt.Add(time.Hour) })
+	result, err = verifier.Verify(generated.LicenseBytes, nil)
+	if err != nil {
+		t.Fatalf("Failed to verify license: %v", err)
+	}
+	if result.Valid || !result.Expired {
+		t.Error("Expected expired license")
+	}
+
+	// Rolling the clock back afterwards is a hard error
+	clock.SetTimeSource(func() time.Time { return time.Now() })
+	if _, err := verifier.Verify(generated.LicenseBytes, nil); err == nil {
+		t.Error("Expected clock rollback error")
+	}
+
+	// Untrusted signer only
+	otherPublicKey, _, _ := crypto.GenerateAsymmetricKeyPair()
+	untrusted := &licenseverify.Verifier{TrustedKeys: map[string]ed25519.PublicKey{licensefile.KeyVersionID("other", 1): otherPublicKey}}
+	result, _ = untrusted.Verify(generated.LicenseBytes, nil)
+	if result.Valid {
+		t.Error("License without trusted signatures should be invalid")
+	}
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/licenses"
+	"github.com/atprof/license-server/kms/internal/scheduler"
+	"github.com/atprof/license-server/kms/internal/storage"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// TestKeyLifecycleTransitions tests that only allowed state transitions are applied
+func TestKeyLifecycleTransitions(t *testing.T) {
+	store, masterKey, key, _ := newLicenseTestStore(t)
+	content := []byte("license payload")
+
+	sig, err := licenses.SignWithKey(content, key, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	disabled, err := store.DisableKey(key.ID, false)
+	if err != nil {
+		t.Fatalf("Failed to disable key: %v", err)
+	}
+	if disabled.IsValid() {
+		t.Error("Disabled key should not be valid")
+	}
+	if valid, _ := licenses.VerifyWithKey(content, *sig, disabled); valid {
+		t.Error("Disabled key should not verify signatures")
+	}
+	if _, err := store.DisableKey(key.ID, false); err != errors.ErrInvalidKeyState {
+		t.Errorf("Expected ErrInvalidKeyState disabling twice, got %v", err)
+	}
+
+	enabled, err := store.EnableKey(key.ID)
+	if err != nil {
+		t.Fatalf("Failed to enable key: %v", err)
+	}
+	if valid, _ := licenses.VerifyWithKey(content, *sig, enabled); !valid {
+		t.Error("Re-enabled key should verify signatures again")
+	}
+
+	if _, err := store.CancelKeyDeletion(key.ID); err != errors.ErrInvalidKeyState {
+		t.Errorf("Expected ErrInvalidKeyState cancelling a deletion that was never scheduled, got %v", err)
+	}
+	if _, err := store.ScheduleKeyDeletion(key.ID, time.Now().UTC().Add(time.Hour), false); err != nil {
+		t.Fatalf("Failed to schedule deletion: %v", err)
+	}
+	if _, err := store.EnableKey(key.ID); err != errors.ErrInvalidKeyState {
+		t.Errorf("Expected ErrInvalidKeyState enabling a key pending deletion, got %v", err)
+	}
+
+	cancelled, err := store.CancelKeyDeletion(key.ID)
+	if err != nil {
+		t.Fatalf("Failed to cancel deletion: %v", err)
+	}
+	if cancelled.Status != storage.KeyStatusDisabled || cancelled.DeletionDate != nil {
+		t.Errorf("Expected cancelled deletion to leave the key disabled, got %s", cancelled.Status)
+	}
+
+	if err := store.RevokeKey(key.ID); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+	if _, err := store.EnableKey(key.ID); err != errors.ErrInvalidKeyState {
+		t.Errorf("Expected ErrInvalidKeyState enabling a revoked key, got %v", err)
+	}
+
+	events, err := store.ListEvents(key.ID, 0)
+	if err != nil {
+		t.Fatalf("Failed to list events: %v", err)
+	}
+	if len(events) != 5 {
+		t.Errorf("Expected 5 status change events, got %d", len(events))
+	}
+}
+
+// TestCancelledDeletionKeepsKeyRevoked tests that revoke, schedule deletion, cancel and enable cannot revive a key
+func TestCancelledDeletionKeepsKeyRevoked(t *testing.T) {
+	store, _, key, _ := newLicenseTestStore(t)
+
+	if err := store.RevokeKey(key.ID); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+	if _, err := store.ScheduleKeyDeletion(key.ID, time.Now().UTC().Add(time.Hour), false); err != nil {
+		t.Fatalf("Failed to schedule deletion: %v", err)
+	}
+	if _, err := store.DisableKey(key.ID, false); err != errors.ErrInvalidKeyState {
+		t.Errorf("Expected ErrInvalidKeyState disabling a key pending deletion, got %v", err)
+	}
+
+	cancelled, err := store.CancelKeyDeletion(key.ID)
+	if err != nil {
+		t.Fatalf("Failed to cancel deletion: %v", err)
+	}
+	if cancelled.Status != storage.KeyStatusRevoked || cancelled.DeletionDate != nil {
+		t.Errorf("Expected cancelled deletion to leave the key revoked, got %s", cancelled.Status)
+	}
+
+	if _, err := store.EnableKey(key.ID); err != errors.ErrInvalidKeyState {
+		t.Errorf("Expected ErrInvalidKeyState enabling the revoked key, got %v", err)
+	}
+	if current, _ := store.GetKey(key.ID); current.Status != storage.KeyStatusRevoked || current.IsValid() {
+		t.Errorf("Expected the key to stay revoked, got %s", current.Status)
+	}
+}
+
+// TestDownloadRequiresActiveKey tests that only active keys can be downloaded
+func TestDownloadRequiresActiveKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey, AllowPlaintextExport: true}), nil, false)
+
+	if code := doJSON(router, http.MethodGet, "/keys/"+key.ID+"/download", nil, nil); code != http.StatusOK {
+		t.Fatalf("Expected 200 downloading an active key, got %d", code)
+	}
+
+	if _, err := store.DisableKey(key.ID, false); err != nil {
+		t.Fatalf("Failed to disable key: %v", err)
+	}
+	if code := doJSON(router, http.MethodGet, "/keys/"+key.ID+"/download", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 downloading a disabled key, got %d", code)
+	}
+	if _, err := store.ScheduleKeyDeletion(key.ID, time.Now().UTC().Add(time.Hour), false); err != nil {
+		t.Fatalf("Failed to schedule deletion: %v", err)
+	}
+	if code := doJSON(router, http.MethodGet, "/keys/"+key.ID+"/download", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 downloading a key pending deletion, got %d", code)
+	}
+
+	if err := store.RevokeKey(reseller.ID); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+	if code := doJSON(router, http.MethodGet, "/keys/"+reseller.ID+"/download", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 downloading a revoked key, got %d", code)
+	}
+}
+
+// TestScheduledKeyDestruction tests activation and destruction by the scheduler
+func TestScheduledKeyDestruction(t *testing.T) {
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+	now := time.Now().UTC()
+
+	activateAt := now.Add(time.Hour)
+	reseller.Status = storage.KeyStatusPendingActivation
+	reseller.ActivateAt = &activateAt
+	if err := store.StoreKey(reseller); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+
+	if _, err := store.ScheduleKeyDeletion(key.ID, now.Add(7*24*time.Hour), false); err != nil {
+		t.Fatalf("Failed to schedule deletion: %v", err)
+	}
+
+	sched := scheduler.New(store, masterKey, time.Minute, false)
+	if changed, _ := sched.RunOnce(now); changed != 0 {
+		t.Errorf("Expected nothing due yet, got %d changes", changed)
+	}
+
+	if changed, _ := sched.RunOnce(now.Add(8 * 24 * time.Hour)); changed != 2 {
+		t.Fatalf("Expected activation and destruction, got %d changes", changed)
+	}
+
+	activated, err := store.GetKey(reseller.ID)
+	if err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if activated.Status != storage.KeyStatusActive || activated.ActivateAt != nil {
+		t.Errorf("Expected key to be activated, got %s", activated.Status)
+	}
+
+	destroyed, err := store.GetKey(key.ID)
+	if err != nil {
+		t.Fatalf("Failed to get destroyed key: %v", err)
+	}
+	if !destroyed.IsDestroyed() || destroyed.DestroyedAt == nil {
+		t.Fatalf("Expected key to be destroyed, got %s", destroyed.Status)
+	}
+	if destroyed.EncryptedPrivateKey != nil || destroyed.PublicKey != nil {
+		t.Error("Destroyed key still holds material")
+	}
+	for _, v := range destroyed.Versions {
+		if v.EncryptedPrivateKey != nil {
+			t.Errorf("Destroyed key still holds material for version %d", v.Version)
+		}
+	}
+	if _, err := store.CancelKeyDeletion(key.ID); err != errors.ErrInvalidKeyState {
+		t.Errorf("Expected ErrInvalidKeyState cancelling after destruction, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/notify"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// startTestSMTPServer runs a minimal SMTP server that collects the messages it receives
+func startTestSMTPServer(t *testing.T) (string, func() []string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	var messages []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				io.WriteString(conn, "220 localhost ESMTP\r\n")
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch strings.ToUpper(strings.Fields(line + " x")[0]) {
+					case "DATA":
+						io.WriteString(conn, "354 End data with <CR><LF>.<CR><LF>\r\n")
+						var data strings.Builder
+						for {
+							line, err := reader.ReadString('\n')
+							if err != nil || line == ".\r\n" {
+								break
+							}
+							data.WriteString(line)
+						}
+						mu.Lock()
+						messages = append(messages, data.String())
+						mu.Unlock()
+						io.WriteString(conn, "250 OK\r\n")
+					case "QUIT":
+						io.WriteString(conn, "221 Bye\r\n")
+						return
+					default:
+						io.WriteString(conn, "250 OK\r\n")
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), messages...)
+	}
+}
+
+// TestExpiryNotifications tests expiry warnings over webhook and SMTP, deduplication, retries and history
+func TestExpiryNotifications(t *testing.T) {
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+	now := time.Now().UTC()
+
+	// Both test keys expire in 24 hours; the reseller key is pushed out of every threshold
+	if err := store.UpdateKeyExpiry(reseller.ID, now.Add(90*24*time.Hour)); err != nil {
+		t.Fatalf("Failed to update expiry: %v", err)
+	}
+	licenses := []*storage.LicenseRecord{
+		{ID: "license-soon", KeyID: key.ID, LicenseType: "standard", ExpiresAt: now.Add(5 * 24 * time.Hour), Status: storage.LicenseStatusActive},
+		{ID: "license-revoked", KeyID: key.ID, LicenseType: "standard", ExpiresAt: now.Add(2 * 24 * time.Hour), Status: storage.LicenseStatusRevoked},
+		{ID: "license-expired", KeyID: key.ID, LicenseType: "standard", ExpiresAt: now.Add(-time.Hour), Status: storage.LicenseStatusActive},
+	}
+	for _, license := range licenses {
+		store.StoreLicense(license)
+	}
+
+	secret := []byte("webhook-secret")
+	var mu sync.Mutex
+	var received []notify.Notice
+	failures := 1
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(notify.WebhookSignatureHeader) != notify.SignWebhook(secret, r.Header.Get(notify.WebhookTimestampHeader), body) {
+			t.Errorf("Webhook signature mismatch")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var notice notify.Notice
+		json.Unmarshal(body, &notice)
+		received = append(received, notice)
+	}))
+	defer webhook.Close()
+
+	smtpAddr, messages := startTestSMTPServer(t)
+	channels := notify.Channels(config.NotificationConfig{
+		WebhookURL:    webhook.URL,
+		WebhookSecret: string(secret),
+		SMTPAddr:      smtpAddr,
+		SMTPFrom:      "kms@example.com",
+		SMTPTo:        []string{"ops@example.com"},
+	})
+	notifier := notify.New(store, channels, []int{30, 7, 1}, time.Minute)
+
+	// The key is warned at 1 day and the license at 7 days; one webhook delivery fails
+	sent, err := notifier.RunOnce(now)
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+	if sent != 3 {
+		t.Errorf("Expected 3 deliveries, got %d", sent)
+	}
+
+	// The next sweep only retries the failed delivery
+	if sent, _ := notifier.RunOnce(now.Add(time.Minute)); sent != 1 {
+		t.Errorf("Expected only the failed delivery to be retried, got %d", sent)
+	}
+	if sent, _ := notifier.RunOnce(now.Add(2 * time.Minute)); sent != 0 {
+		t.Errorf("Expected no duplicate deliveries, got %d", sent)
+	}
+
+	thresholds := map[string]int{}
+	for _, notice := range received {
+		thresholds[notice.SubjectID] = notice.ThresholdDays
+	}
+	if len(received) != 2 || thresholds[key.ID] != 1 || thresholds["license-soon"] != 7 {
+		t.Errorf("Unexpected webhook notices %+v", received)
+	}
+	mails := messages()
+	if len(mails) != 2 || !strings.Contains(strings.Join(mails, ""), "Subject: [KMS] license license-soon expires within 7 days") {
+		t.Errorf("Unexpected emails %q", mails)
+	}
+
+	// Refreshing the key gives it a new expiry and so new warnings
+	store.UpdateKeyExpiry(key.ID, now.Add(20*24*time.Hour))
+	if sent, _ := notifier.RunOnce(now.Add(3 * time.Minute)); sent != 2 {
+		t.Errorf("Expected the refreshed key to be warned again, got %d", sent)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+	var history api.ListNotificationsResponse
+	doJSON(router, http.MethodGet, "/notifications?subject_id=license-soon", nil, &history)
+	if len(history.Notifications) != 2 {
+		t.Fatalf("Expected 2 notifications for the license, got %d", len(history.Notifications))
+	}
+	for _, notification := range history.Notifications {
+		if notification.Status != storage.NotificationStatusSent || notification.SentAt == nil {
+			t.Errorf("Expected %s notification to be sent, got %+v", notification.Channel, notification)
+		}
+	}
+}
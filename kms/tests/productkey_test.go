@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/atprof/license-server/kms/internal/licenses"
+	"github.com/atprof/license-server/kms/pkg/errors"
+)
+
+// TestProductKeyRoundTrip tests issuing and resolving a product key
+func TestProductKeyRoundTrip(t *testing.T) {
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+
+	licenseID := uuid.New().String()
+	productKey, err := licenses.EncodeProductKey(licenseID, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to encode product key: %v", err)
+	}
+
+	groups := strings.Split(productKey, "-")
+	if len(groups) != 8 {
+		t.Fatalf("Expected 8 groups, got %d (%s)", len(groups), productKey)
+	}
+
+	decoded, err := licenses.DecodeProductKey(productKey, masterKey)
+	if err != nil {
+		t.Fatalf("Failed to decode product key: %v", err)
+	}
+	if decoded != licenseID {
+		t.Errorf("Expected license ID %s, got %s", licenseID, decoded)
+	}
+
+	// Lowercase without dashes should still resolve
+	decoded, err = licenses.DecodeProductKey(strings.ToLower(strings.ReplaceAll(productKey, "-", "")), masterKey)
+	if err != nil || decoded != licenseID {
+		t.Errorf("Expected normalized product key to resolve, got %q, %v", decoded, err)
+	}
+}
+
+// TestProductKeyTypo tests that typos are caught by the checksum
+func TestProductKeyTypo(t *testing.T) {
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+
+	productKey, err := licenses.EncodeProductKey(uuid.New().String(), masterKey)
+	if err != nil {
+		t.Fatalf("Failed to encode product key: %v", err)
+	}
+
+	// Change a single character
+	typo := []byte(productKey)
+	if typo[3] == 'A' {
+		typo[3] = 'B'
+	} else {
+		typo[3] = 'A'
+	}
+
+	if _, err := licenses.DecodeProductKey(string(typo), masterKey); err != errors.ErrProductKeyChecksum {
+		t.Errorf("Expected checksum error, got: %v", err)
+	}
+
+	// Missing group
+	if _, err := licenses.DecodeProductKey(productKey[6:], masterKey); err != errors.ErrProductKeyChecksum {
+		t.Errorf("Expected checksum error for truncated key, got: %v", err)
+	}
+}
+
+// TestProductKeyWrongServer tests that keys issued with another master key are rejected
+func TestProductKeyWrongServer(t *testing.T) {
+	masterKey1 := make([]byte, 32)
+	rand.Read(masterKey1)
+	masterKey2 := make([]byte, 32)
+	rand.Read(masterKey2)
+
+	productKey, err := licenses.EncodeProductKey(uuid.New().String(), masterKey1)
+	if err != nil {
+		t.Fatalf("Failed to encode product key: %v", err)
+	}
+
+	if _, err := licenses.DecodeProductKey(productKey, masterKey2); err != errors.ErrInvalidProductKey {
+		t.Errorf("Expected invalid product key error, got: %v", err)
+	}
+}
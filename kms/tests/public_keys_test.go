@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestPublicKeyDistribution tests the JWKS and per-key public key endpoints
+func TestPublicKeyDistribution(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	do := func(method, path string, body interface{}, header map[string]string) *httptest.ResponseRecorder {
+		var payload bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&payload).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &payload)
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	register := func(algorithm string) api.RegisterKeyResponse {
+		var resp api.RegisterKeyResponse
+		json.Unmarshal(do(http.MethodPost, "/keys", map[string]string{"algorithm": algorithm}, nil).Body.Bytes(), &resp)
+		return resp
+	}
+
+	ed := register("ed25519")
+	ec := register("ecdsa-p256")
+	revoked := register("rsa-pss-2048")
+	symmetric := register("aes-256-gcm")
+	do(http.MethodDelete, "/keys/"+revoked.KeyID, nil, nil)
+	do(http.MethodPost, "/keys/"+ec.KeyID+"/rotate", nil, nil)
+
+	w := do(http.MethodGet, "/.well-known/jwks.json", nil, nil)
+	if w.Header().Get("Cache-Control") == "" || w.Header().Get("ETag") == "" {
+		t.Error("JWKS should carry cache headers")
+	}
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &jwks)
+
+	kids := map[string]map[string]string{}
+	for _, jwk := range jwks.Keys {
+		kids[jwk["kid"]] = jwk
+	}
+	for _, kid := range []string{ed.KeyID + ":1", ec.KeyID + ":1", ec.KeyID + ":2"} {
+		if kids[kid] == nil {
+			t.Errorf("Expected %s in the JWKS", kid)
+		}
+	}
+	if kids[revoked.KeyID+":1"] != nil || kids[symmetric.KeyID+":1"] != nil {
+		t.Error("Revoked and symmetric keys must not be published")
+	}
+	// The key set also holds the two keys created by newLicenseTestStore
+	if len(jwks.Keys) != 5 {
+		t.Errorf("Expected 5 published keys, got %d", len(jwks.Keys))
+	}
+
+	edJWK := kids[ed.KeyID+":1"]
+	x, _ := base64.RawURLEncoding.DecodeString(edJWK["x"])
+	if edJWK["kty"] != "OKP" || base64.StdEncoding.EncodeToString(x) != ed.PublicKey {
+		t.Errorf("Unexpected Ed25519 JWK %v", edJWK)
+	}
+	if kids[ec.KeyID+":2"]["kty"] != "EC" || kids[ec.KeyID+":2"]["crv"] != "P-256" {
+		t.Errorf("Unexpected EC JWK %v", kids[ec.KeyID+":2"])
+	}
+
+	// Per-key lookups return PEM as well and honour If-None-Match
+	w = do(http.MethodGet, "/keys/"+ec.KeyID+"/public", nil, nil)
+	var published api.PublicKeyResponse
+	json.Unmarshal(w.Body.Bytes(), &published)
+	if len(published.Versions) != 2 || !published.Versions[1].Primary || published.Versions[1].PEM == "" {
+		t.Errorf("Unexpected published versions %+v", published.Versions)
+	}
+	if w := do(http.MethodGet, "/keys/"+ec.KeyID+"/public", nil, map[string]string{"If-None-Match": w.Header().Get("ETag")}); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/keys/"+symmetric.KeyID+"/public", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a symmetric key, got %d", w.Code)
+	}
+
+	// Rotated versions drop out once the overlap period ends
+	key, _ := store.GetKey(ec.KeyID)
+	overlap := 24 * time.Hour
+	if versions := key.PublishedVersions(time.Now().Add(overlap+time.Hour), overlap); len(versions) != 1 || versions[0].Version != 2 {
+		t.Errorf("Expected only the primary version after the overlap, got %+v", versions)
+	}
+
+	// Retired versions stay published for the overlap too
+	store.RetireKeyVersion(ec.KeyID, 1)
+	key, _ = store.GetKey(ec.KeyID)
+	if versions := key.PublishedVersions(time.Now(), overlap); len(versions) != 2 || versions[0].Status != storage.KeyVersionStatusRetired {
+		t.Errorf("Expected the retired version within the overlap, got %+v", versions)
+	}
+}
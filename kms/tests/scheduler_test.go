@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/atprof/license-server/kms/internal/scheduler"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestSchedulerRotatesDueKeys tests policy based rotation, events and double-run protection
+func TestSchedulerRotatesDueKeys(t *testing.T) {
+	store, masterKey, key, reseller := newLicenseTestStore(t)
+	now := time.Now().UTC()
+
+	if _, err := store.SetRotationPolicy(key.ID, &storage.RotationPolicy{PeriodDays: 30}, now); err != nil {
+		t.Fatalf("Failed to set rotation policy: %v", err)
+	}
+	policy := &storage.RotationPolicy{DaysBeforeExpiry: 7, ValidityDays: 90}
+	updated, err := store.SetRotationPolicy(reseller.ID, policy, now)
+	if err != nil {
+		t.Fatalf("Failed to set rotation policy: %v", err)
+	}
+	// The reseller key expires in 24 hours, so it is already inside its rotation window
+	if !updated.NextRotationAt.Before(now) {
+		t.Fatalf("Expected reseller key rotation to be due, next rotation at %v", updated.NextRotationAt)
+	}
+
+	first := scheduler.New(store, masterKey, time.Minute, false)
+	second := scheduler.New(store, masterKey, time.Minute, false)
+
+	rotated, err := first.RunOnce(now)
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+	if rotated != 1 {
+		t.Fatalf("Expected 1 rotation, got %d", rotated)
+	}
+
+	// The same sweep again finds nothing due
+	if rotated, _ := first.RunOnce(now); rotated != 0 {
+		t.Errorf("Expected no rotations on repeated sweep, got %d", rotated)
+	}
+
+	reseller, err = store.GetKey(reseller.ID)
+	if err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if reseller.PrimaryVersionNumber() != 2 {
+		t.Errorf("Expected primary version 2, got %d", reseller.PrimaryVersionNumber())
+	}
+	if reseller.ExpiresAt.Before(now.Add(89 * 24 * time.Hour)) {
+		t.Errorf("Expected rotation to extend expiry, got %v", reseller.ExpiresAt)
+	}
+	if !reseller.NextRotationAt.After(now) {
+		t.Errorf("Expected next rotation in the future, got %v", reseller.NextRotationAt)
+	}
+
+	// Once the first instance's lease has lapsed another instance takes over
+	later := now.Add(31 * 24 * time.Hour)
+	if rotated, _ := second.RunOnce(later); rotated != 1 {
+		t.Errorf("Expected period rotation after 31 days, got %d", rotated)
+	}
+
+	events, err := store.ListEvents("", 0)
+	if err != nil {
+		t.Fatalf("Failed to list events: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 rotation events, got %d", len(events))
+	}
+	if events[0].KeyID != key.ID || events[1].KeyID != reseller.ID {
+		t.Errorf("Expected events newest first, got %s then %s", events[0].KeyID, events[1].KeyID)
+	}
+	for _, event := range events {
+		if event.Type != storage.EventKeyRotated || event.Actor != storage.ActorScheduler {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	}
+}
+
+// TestSchedulerLease tests that only one instance holds the scheduler lease at a time
+func TestSchedulerLease(t *testing.T) {
+	store, _, _, _ := newLicenseTestStore(t)
+	now := time.Now().UTC()
+
+	if ok, err := store.AcquireLease("job", "a", now, time.Minute); err != nil || !ok {
+		t.Fatalf("Expected first owner to acquire lease: %v", err)
+	}
+	if ok, _ := store.AcquireLease("job", "b", now.Add(30*time.Second), time.Minute); ok {
+		t.Error("Expected second owner to be locked out")
+	}
+	if ok, _ := store.AcquireLease("job", "a", now.Add(30*time.Second), time.Minute); !ok {
+		t.Error("Expected owner to renew its own lease")
+	}
+	if ok, _ := store.AcquireLease("job", "b", now.Add(2*time.Minute), time.Minute); !ok {
+		t.Error("Expected second owner to take over an expired lease")
+	}
+
+	if err := store.ReleaseLease("job", "b"); err != nil {
+		t.Fatalf("Failed to release lease: %v", err)
+	}
+	if ok, _ := store.AcquireLease("job", "a", now.Add(2*time.Minute), time.Minute); !ok {
+		t.Error("Expected lease to be free after release")
+	}
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/atprof/license-server/kms/pkg/signedresponse"
+)
+
+type testValidationResult struct {
+	Valid   bool `json:"valid"`
+	Revoked bool `json:"revoked"`
+}
+
+// TestSignedResponseRoundTrip tests signing and verifying a validation response
+func TestSignedResponseRoundTrip(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	envelope, err := signedresponse.Sign(privateKey, "signing-key", "nonce-123", testValidationResult{Valid: true}, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to sign response: %v", err)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"valid":              false, // Unsigned fields must not be trusted
+		"response_signature": envelope,
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal body: %v", err)
+	}
+
+	var result testValidationResult
+	if _, err := signedresponse.VerifyResponseBody(publicKey, body, "nonce-123", time.Minute, &result); err != nil {
+		t.Fatalf("Failed to verify response: %v", err)
+	}
+
+	if !result.Valid {
+		t.Error("Expected signed result to be valid")
+	}
+
+	// Wrong nonce - should fail
+	if _, err := signedresponse.Verify(publicKey, envelope, "other-nonce", time.Minute); err != signedresponse.ErrNonceMismatch {
+		t.Errorf("Expected nonce mismatch, got: %v", err)
+	}
+
+	// Wrong public key - should fail
+	otherPublicKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := signedresponse.Verify(otherPublicKey, envelope, "nonce-123", time.Minute); err == nil {
+		t.Error("Verification with wrong public key should fail")
+	}
+}
+
+// TestSignedResponseStale tests that old responses are rejected
+func TestSignedResponseStale(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	envelope, err := signedresponse.Sign(privateKey, "signing-key", "n", testValidationResult{Valid: true}, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to sign response: %v", err)
+	}
+
+	if _, err := signedresponse.Verify(publicKey, envelope, "n", time.Minute); err != signedresponse.ErrResponseStale {
+		t.Errorf("Expected stale response error, got: %v", err)
+	}
+}
+
+// TestSignedResponseMissing tests that unsigned bodies are rejected
+func TestSignedResponseMissing(t *testing.T) {
+	publicKey, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	if _, err := signedresponse.VerifyResponseBody(publicKey, []byte(`{"valid":true}`), "", time.Minute, nil); err != signedresponse.ErrMissingSignature {
+		t.Errorf("Expected missing signature error, got: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestSiteTokens tests site token issuance, introspection, rotation and revocation
+func TestSiteTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, _, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	register := func(body map[string]string) string {
+		body["key_type"] = "symmetric"
+		var resp api.RegisterKeyResponse
+		doJSON(router, http.MethodPost, "/keys", body, &resp)
+		return resp.KeyID
+	}
+	introspect := func(token string) api.IntrospectTokenResponse {
+		var resp api.IntrospectTokenResponse
+		doJSON(router, http.MethodPost, "/tokens/introspect", map[string]string{"token": token}, &resp)
+		return resp
+	}
+
+	hub := register(map[string]string{"level": "hub"})
+	enterprise := register(map[string]string{"level": "enterprise", "parent_key_id": hub})
+	site := register(map[string]string{"level": "site", "parent_key_id": enterprise, "site_mode": "prod", "owner": "site-42"})
+
+	if code := doJSON(router, http.MethodPost, "/keys/"+enterprise+"/tokens", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 issuing a token for an enterprise key, got %d", code)
+	}
+
+	var issued api.SiteTokenResponse
+	if code := doJSON(router, http.MethodPost, "/keys/"+site+"/tokens", nil, &issued); code != http.StatusCreated {
+		t.Fatalf("Failed to issue site token: %d", code)
+	}
+	if !strings.HasPrefix(issued.Token, "site_live_") || !strings.HasPrefix(issued.Token, issued.Hint) {
+		t.Errorf("Unexpected token %q with hint %q", issued.Token, issued.Hint)
+	}
+
+	// Only the hash is stored
+	stored, _ := store.GetSiteToken(issued.ID)
+	if stored.TokenHash != storage.HashSiteToken(issued.Token) || strings.Contains(stored.TokenHash, issued.Token) {
+		t.Error("Expected only the token hash to be stored")
+	}
+
+	resp := introspect(issued.Token)
+	if !resp.Active || resp.Site != "site-42" || resp.Mode != "prod" || resp.KeyID != site || resp.ExpiresAt == nil {
+		t.Errorf("Unexpected introspection %+v", resp)
+	}
+	for _, token := range []string{"", "site_live_forged", "not-a-token", issued.Token + "x"} {
+		if resp := introspect(token); resp.Active || resp.KeyID != "" {
+			t.Errorf("Expected %q to be inactive, got %+v", token, resp)
+		}
+	}
+
+	// Rotation revokes the old token and keeps the key
+	var rotated api.SiteTokenResponse
+	if code := doJSON(router, http.MethodPost, "/tokens/"+issued.ID+"/rotate", map[string]int{"ttl_seconds": 3600}, &rotated); code != http.StatusCreated {
+		t.Fatalf("Failed to rotate site token: %d", code)
+	}
+	if introspect(issued.Token).Active {
+		t.Error("Rotated token should be inactive")
+	}
+	resp = introspect(rotated.Token)
+	if !resp.Active || resp.KeyID != site || rotated.RotatedFrom != issued.ID || rotated.ExpiresAt == nil {
+		t.Errorf("Unexpected rotated token %+v, introspection %+v", rotated.SiteTokenInfo, resp)
+	}
+	if code := doJSON(router, http.MethodPost, "/tokens/"+issued.ID+"/rotate", nil, nil); code != http.StatusConflict {
+		t.Errorf("Expected 409 rotating a revoked token, got %d", code)
+	}
+
+	if code := doJSON(router, http.MethodPost, "/tokens/"+rotated.ID+"/revoke", nil, nil); code != http.StatusOK {
+		t.Errorf("Failed to revoke site token: %d", code)
+	}
+	if introspect(rotated.Token).Active {
+		t.Error("Revoked token should be inactive")
+	}
+	if code := doJSON(router, http.MethodPost, "/tokens/"+rotated.ID+"/revoke", nil, nil); code != http.StatusConflict {
+		t.Errorf("Expected 409 revoking twice, got %d", code)
+	}
+
+	var list api.SiteTokenListResponse
+	doJSON(router, http.MethodGet, "/keys/"+site+"/tokens", nil, &list)
+	if len(list.Tokens) != 2 || list.Tokens[0].ReplacedBy != rotated.ID || list.Tokens[1].Status != "revoked" {
+		t.Errorf("Unexpected token list %+v", list.Tokens)
+	}
+
+	// Tokens stop working when their key is disabled
+	var active api.SiteTokenResponse
+	doJSON(router, http.MethodPost, "/keys/"+site+"/tokens", nil, &active)
+	doJSON(router, http.MethodPost, "/keys/"+site+"/disable", nil, nil)
+	if introspect(active.Token).Active {
+		t.Error("Token of a disabled key should be inactive")
+	}
+
+	events, _ := store.ListEvents(site, 0)
+	issuedEvents := 0
+	for _, event := range events {
+		if event.Type == storage.EventSiteTokenIssued {
+			issuedEvents++
+		}
+	}
+	if issuedEvents != 3 {
+		t.Errorf("Expected 3 site_token.issued events, got %d", issuedEvents)
+	}
+}
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -159,3 +160,68 @@ func TestBoltStoreAsymmetricKey(t *testing.T) {
 	}
 }
 
+
+// TestBoltStoreLicenseTransfer tests storing license records and transferring them
+func TestBoltStoreLicenseTransfer(t *testing.T) {
+	store, err := storage.NewBoltStore(filepath.Join(t.TempDir(), "licenses.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now().UTC()
+	original := &storage.LicenseRecord{
+		ID:          "license-1",
+		KeyID:       "key-1",
+		LicenseType: "site",
+		Fingerprint: "old-host",
+		IssuedAt:    now,
+		ExpiresAt:   now.Add(24 * time.Hour),
+		Status:      storage.LicenseStatusActive,
+	}
+	if err := store.StoreLicense(original); err != nil {
+		t.Fatalf("Failed to store license: %v", err)
+	}
+
+	replacement := *original
+	replacement.ID = "license-2"
+	replacement.Fingerprint = "new-host"
+	replacement.TransferredFrom = original.ID
+	replacement.Transfers = []storage.LicenseTransfer{{
+		FromLicenseID:   original.ID,
+		ToLicenseID:     "license-2",
+		FromFingerprint: "old-host",
+		ToFingerprint:   "new-host",
+		TransferredAt:   now,
+	}}
+
+	if err := store.TransferLicense(original.ID, &replacement); err != nil {
+		t.Fatalf("Failed to transfer license: %v", err)
+	}
+
+	old, err := store.GetLicense(original.ID)
+	if err != nil {
+		t.Fatalf("Failed to get old license: %v", err)
+	}
+	if !old.IsRevoked() || old.ReplacedBy != "license-2" {
+		t.Errorf("Expected old license revoked and replaced by license-2, got status %s replaced by %q", old.Status, old.ReplacedBy)
+	}
+
+	current, err := store.GetLicense("license-2")
+	if err != nil {
+		t.Fatalf("Failed to get new license: %v", err)
+	}
+	if current.TransfersSince(now.Add(-time.Hour)) != 1 {
+		t.Errorf("Expected 1 transfer in period, got %d", current.TransfersSince(now.Add(-time.Hour)))
+	}
+	if current.TransfersSince(now.Add(time.Hour)) != 0 {
+		t.Error("Transfers before the period start should not be counted")
+	}
+
+	// Transferring a revoked license again must fail
+	again := replacement
+	again.ID = "license-3"
+	if err := store.TransferLicense(original.ID, &again); err != errors.ErrLicenseRevoked {
+		t.Errorf("Expected license revoked error, got: %v", err)
+	}
+}
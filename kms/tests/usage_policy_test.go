@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/atprof/license-server/kms/internal/api"
+	"github.com/atprof/license-server/kms/internal/config"
+	"github.com/atprof/license-server/kms/internal/storage"
+)
+
+// TestUsagePolicyPermits tests the usage policy checks on a key
+func TestUsagePolicyPermits(t *testing.T) {
+	key := &storage.Key{ID: "key"}
+	if !key.Permits(storage.OperationExport) || !key.PermitsDownload() || !key.PermitsLicenseType("any") {
+		t.Error("A key without a usage policy should allow everything")
+	}
+
+	key.UsagePolicy = &storage.UsagePolicy{
+		AllowedOperations:   []storage.KeyOperation{storage.OperationLicenseIssue, storage.OperationExport},
+		AllowedLicenseTypes: []string{"trial"},
+	}
+	if key.Permits(storage.OperationSign) || !key.Permits(storage.OperationLicenseIssue) {
+		t.Error("Usage policy operations not applied")
+	}
+	if key.PermitsDownload() {
+		t.Error("Download should need allow_download")
+	}
+	if !key.PermitsLicenseType("trial") || key.PermitsLicenseType("enterprise") {
+		t.Error("Usage policy license types not applied")
+	}
+}
+
+// TestUsagePolicyEnforcement tests that the API refuses operations the usage policy does not allow
+func TestUsagePolicyEnforcement(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, masterKey, key, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var payload bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&payload).Encode(body)
+		}
+		req := httptest.NewRequest(method, path, &payload)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	policy := storage.UsagePolicy{
+		AllowedOperations:      []storage.KeyOperation{storage.OperationLicenseIssue},
+		AllowedLicenseTypes:    []string{"trial"},
+		MaxLicenseValidityDays: 7,
+	}
+	if w := do(http.MethodPut, "/keys/"+key.ID+"/usage-policy", policy); w.Code != http.StatusOK {
+		t.Fatalf("Failed to set usage policy: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := do(http.MethodPost, "/keys/"+key.ID+"/sign", map[string]string{"message": "hi"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected sign to be forbidden, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/keys/"+key.ID+"/download", nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected download to be forbidden, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/licenses/generate", map[string]string{"key_id": key.ID, "license_type": "enterprise"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected enterprise license to be forbidden, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/licenses/generate", map[string]interface{}{"key_id": key.ID, "license_type": "trial", "expires_in_seconds": 30 * 24 * 3600}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected license beyond max validity to be forbidden, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/licenses/generate", map[string]string{"key_id": key.ID, "license_type": "trial"}); w.Code != http.StatusOK {
+		t.Errorf("Expected trial license to be issued, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := do(http.MethodDelete, "/keys/"+key.ID+"/usage-policy", nil); w.Code != http.StatusOK {
+		t.Fatalf("Failed to remove usage policy: %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/keys/"+key.ID+"/sign", map[string]string{"message": "hi"}); w.Code != http.StatusOK {
+		t.Errorf("Expected sign to be allowed without a policy, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// TestPolicyDayLimits tests that policy day counts are capped before they can overflow a duration
+func TestPolicyDayLimits(t *testing.T) {
+	store, masterKey, key, _ := newLicenseTestStore(t)
+	router := api.SetupRouter(api.NewHandler(store, &config.Config{MasterKey: masterKey}), nil, false)
+
+	tests := []struct {
+		name   string
+		path   string
+		policy func(days int) interface{}
+	}{
+		{"rotation period", "/rotation-policy", func(days int) interface{} {
+			return storage.RotationPolicy{PeriodDays: days}
+		}},
+		{"rotation validity", "/rotation-policy", func(days int) interface{} {
+			return storage.RotationPolicy{DaysBeforeExpiry: 7, ValidityDays: days}
+		}},
+		{"license validity", "/usage-policy", func(days int) interface{} {
+			return storage.UsagePolicy{AllowedOperations: []storage.KeyOperation{storage.OperationLicenseIssue}, MaxLicenseValidityDays: days}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/keys/" + key.ID + tt.path
+			if code := doJSON(router, http.MethodPut, path, tt.policy(storage.MaxPolicyDays), nil); code != http.StatusOK {
+				t.Errorf("Expected %d days to be accepted, got %d", storage.MaxPolicyDays, code)
+			}
+			if code := doJSON(router, http.MethodPut, path, tt.policy(storage.MaxPolicyDays+1), nil); code != http.StatusBadRequest {
+				t.Errorf("Expected %d days to be refused, got %d", storage.MaxPolicyDays+1, code)
+			}
+		})
+	}
+}